@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"auto-focus.app/cloud/handlers"
+)
+
+// runCommand runs an admin subcommand against the server's storage and
+// signing keys instead of starting the HTTP server.
+func runCommand(srv *handlers.Server, args []string, stdout io.Writer) error {
+	switch args[0] {
+	case "certificate":
+		return runCertificateCommand(srv, args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runCertificateCommand writes an offline license certificate, e.g.
+// `cloud certificate -key AFP-XXXXXXXX -out customer.afcert`.
+func runCertificateCommand(srv *handlers.Server, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("certificate", flag.ContinueOnError)
+	licenseKey := flags.String("key", "", "license key to certify")
+	deviceID := flags.String("device", "", "optional device ID to bind the certificate to")
+	out := flags.String("out", "", "file to write (defaults to stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *licenseKey == "" {
+		return fmt.Errorf("-key required")
+	}
+
+	certificate, err := srv.IssueCertificate(context.Background(), handlers.CertificateRequest{
+		LicenseKey: *licenseKey,
+		DeviceID:   *deviceID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to issue certificate: %w", err)
+	}
+
+	data, err := json.MarshalIndent(certificate, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate: %w", err)
+	}
+	data = append(data, '\n')
+
+	if *out == "" {
+		_, err = stdout.Write(data)
+		return err
+	}
+
+	return os.WriteFile(*out, data, 0600)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/handlers"
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+)
+
+func TestCertificateCommand(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+
+	storage := &storage.MemoryStorage{
+		Data: storage.Database{
+			"customer-1": models.Customer{ID: "customer-1", Email: "offline@example.com", Name: "Offline User"},
+		},
+		Licenses: map[string]models.License{
+			"license-1": {
+				ID:         "license-1",
+				Key:        "AFP-0ff11e01",
+				CustomerID: "customer-1",
+				Status:     models.StatusActive,
+				CreatedAt:  time.Now(),
+			},
+		},
+	}
+	server := newTestServer(t, storage)
+
+	var stdout bytes.Buffer
+	if err := runCommand(server, []string{"certificate", "-key", "AFP-0ff11e01"}, &stdout); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var certificate handlers.LicenseCertificate
+	if err := json.Unmarshal(stdout.Bytes(), &certificate); err != nil {
+		t.Fatalf("Expected certificate JSON on stdout, got error: %v", err)
+	}
+	if certificate.Certificate == "" {
+		t.Errorf("Expected signed certificate")
+	}
+
+	out := filepath.Join(t.TempDir(), "offline.afcert")
+	if err := runCommand(server, []string{"certificate", "-key", "AFP-0ff11e01", "-out", out}, &stdout); err != nil {
+		t.Fatalf("Expected no error writing file, got %v", err)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Errorf("Expected certificate file, got %v", err)
+	}
+
+	if err := runCommand(server, []string{"certificate", "-key", "AFP-0000dead"}, &stdout); err == nil {
+		t.Errorf("Expected error for unknown license")
+	}
+
+	if err := runCommand(server, []string{"unknown"}, &stdout); err == nil {
+		t.Errorf("Expected error for unknown command")
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/abuse"
+	"auto-focus.app/cloud/internal/email"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+)
+
+const (
+	defaultAbuseWindow       = 24 * time.Hour
+	defaultAbuseMaxDevices   = 10
+	defaultAbuseMaxIPs       = 20
+	defaultAbuseMaxCountries = 3
+)
+
+// checkKeySharing records where license was validated from and flags it
+// once it has been seen from more devices, IPs or countries than the
+// ABUSE_MAX_* thresholds allow within ABUSE_WINDOW. Only activated devices
+// count, so made-up device IDs cannot get a key flagged. Flagged keys are
+// reported to ADMIN_EMAIL and, with ABUSE_AUTO_SUSPEND, suspended on the
+// spot; license is updated in place so the current validation sees it.
+func (s *Server) checkKeySharing(ctx context.Context, r *http.Request, req LicenseRequest, license *models.License) error {
+	if license == nil {
+		return nil
+	}
+
+	deviceID, err := s.activatedDevice(ctx, license, req.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	counts := s.AbuseTracker.Observe(license.ID, abuse.Sighting{
+		DeviceID: deviceID,
+		IP:       clientIP(r),
+		Country:  requestCountry(r),
+	})
+
+	exceeded := abuseThresholds().Exceeded(counts)
+	if exceeded == nil || !s.AbuseTracker.Flag(license.ID) {
+		return nil
+	}
+
+	logger.Warn("Possible license key sharing", map[string]interface{}{
+		"license_id": license.ID,
+		"devices":    counts.Devices,
+		"ips":        counts.IPs,
+		"countries":  counts.Countries,
+	})
+
+	suspended := false
+	if os.Getenv("ABUSE_AUTO_SUSPEND") == "true" && license.Status == models.StatusActive {
+		// Only the status is written, and only if no one changed it meanwhile
+		update := *license
+		update.SetStatus(models.StatusSuspended, models.StatusReasonKeySharing, time.Now())
+
+		suspended, err = s.Storage.UpdateLicenseStatus(ctx, &update, models.StatusActive)
+		if err != nil {
+			return fmt.Errorf("failed to suspend license: %w", err)
+		}
+		if suspended {
+			*license = update
+			logger.Warn("License suspended for key sharing", map[string]interface{}{
+				"license_id": license.ID,
+			})
+		}
+	}
+
+	sendAbuseNotification(license, exceeded, suspended)
+	return nil
+}
+
+// activatedDevice returns deviceID if it is activated for license, or ""
+// so the sighting doesn't count it.
+func (s *Server) activatedDevice(ctx context.Context, license *models.License, deviceID string) (string, error) {
+	if deviceID == "" {
+		return "", nil
+	}
+
+	activation, err := s.Storage.FindActivation(ctx, license.ID, deviceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to find activation: %w", err)
+	}
+	if activation == nil {
+		return "", nil
+	}
+	return deviceID, nil
+}
+
+func sendAbuseNotification(license *models.License, exceeded []string, suspended bool) {
+	adminEmail := os.Getenv("ADMIN_EMAIL")
+	if adminEmail == "" {
+		logger.Debug("ADMIN_EMAIL not configured, skipping abuse notification")
+		return
+	}
+
+	action := "No action was taken; set ABUSE_AUTO_SUSPEND=true to suspend flagged keys automatically."
+	if suspended {
+		action = "The license has been suspended."
+	}
+
+	body := fmt.Sprintf(`Possible license key sharing detected!
+
+LICENSE
+License Key: %s
+License ID: %s
+Customer ID: %s
+Status: %s
+
+SEEN FROM (last %s)
+%s
+
+%s
+
+---
+Sent from Auto-Focus Cloud API`,
+		license.Key,
+		license.ID,
+		license.CustomerID,
+		license.Status,
+		abuseWindow(),
+		strings.Join(exceeded, "\n"),
+		action,
+	)
+
+	if err := email.Send(adminEmail, "⚠️ Auto-Focus+ key sharing detected", body); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to send abuse notification", map[string]interface{}{
+			"error":       err.Error(),
+			"admin_email": adminEmail,
+			"license_id":  license.ID,
+		})
+	}
+}
+
+// clientIP returns the caller's address: the CLIENT_IP_HEADER set by a
+// trusted proxy when configured, otherwise the connection's remote host.
+func clientIP(r *http.Request) string {
+	if header := os.Getenv("CLIENT_IP_HEADER"); header != "" {
+		if ip := strings.TrimSpace(r.Header.Get(header)); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func abuseWindow() time.Duration {
+	if window, err := time.ParseDuration(os.Getenv("ABUSE_WINDOW")); err == nil && window > 0 {
+		return window
+	}
+	return defaultAbuseWindow
+}
+
+func abuseThresholds() abuse.Thresholds {
+	return abuse.Thresholds{
+		MaxDevices:   abuseThreshold("ABUSE_MAX_DEVICES", defaultAbuseMaxDevices),
+		MaxIPs:       abuseThreshold("ABUSE_MAX_IPS", defaultAbuseMaxIPs),
+		MaxCountries: abuseThreshold("ABUSE_MAX_COUNTRIES", defaultAbuseMaxCountries),
+	}
+}
+
+func abuseThreshold(name string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n >= 0 {
+		return n
+	}
+	return fallback
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/models"
+)
+
+func validateFromIP(server *Server, key, ip, country string) ValidateResponse {
+	body, _ := json.Marshal(LicenseRequest{LicenseKey: key, AppVersion: "1.4.11"})
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	req.RemoteAddr = ip + ":54321"
+	req.Header.Set("CF-IPCountry", country)
+
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+
+	var response ValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+	return response
+}
+
+func TestKeySharing_AutoSuspend(t *testing.T) {
+	t.Setenv("ABUSE_MAX_IPS", "3")
+	t.Setenv("ABUSE_AUTO_SUSPEND", "true")
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	for i := 1; i <= 3; i++ {
+		if response := validateFromIP(server, "AFP-7a11d123", fmt.Sprintf("10.0.0.%d", i), "NO"); !response.Valid {
+			t.Fatalf("Expected validation %d under the threshold to pass, got '%s'", i, response.Message)
+		}
+	}
+
+	if response := validateFromIP(server, "AFP-7a11d123", "10.0.0.4", "NO"); response.Valid {
+		t.Errorf("Expected the validation crossing the threshold to be refused")
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusSuspended || license.StatusReason != models.StatusReasonKeySharing {
+		t.Errorf("Expected license suspended for key sharing, got '%s' (%s)", license.Status, license.StatusReason)
+	}
+
+	if len(storage.Revocations) == 0 {
+		t.Errorf("Expected the suspension on the revocation list")
+	}
+}
+
+func TestKeySharing_FlagOnlyByDefault(t *testing.T) {
+	t.Setenv("ABUSE_MAX_COUNTRIES", "2")
+	t.Setenv("COUNTRY_HEADER", "CF-IPCountry")
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	for i, country := range []string{"NO", "DE", "US", "BR"} {
+		if response := validateFromIP(server, "AFP-7a11d123", fmt.Sprintf("10.0.0.%d", i), country); !response.Valid {
+			t.Errorf("Expected validation from %s to pass without auto-suspend, got '%s'", country, response.Message)
+		}
+	}
+
+	if status := storage.Licenses["license-1"].Status; status != models.StatusActive {
+		t.Errorf("Expected license to stay active, got '%s'", status)
+	}
+
+	if server.AbuseTracker.Flag("license-1") {
+		t.Errorf("Expected license to have been flagged already")
+	}
+}
+
+func TestKeySharing_CountsActivatedDevicesOnly(t *testing.T) {
+	t.Setenv("ABUSE_MAX_DEVICES", "1")
+	t.Setenv("ABUSE_AUTO_SUSPEND", "true")
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+	ctx := context.Background()
+
+	// Made-up device IDs are refused by validation and must not count
+	for i := 1; i <= 3; i++ {
+		license, _ := storage.FindLicenseByKey(ctx, "AFP-7a11d123")
+		req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", nil)
+		if err := server.checkKeySharing(ctx, req, LicenseRequest{DeviceID: fmt.Sprintf("unknown-%d", i)}, license); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if status := storage.Licenses["license-1"].Status; status != models.StatusActive {
+		t.Errorf("Expected unknown devices not to get the license suspended, got '%s'", status)
+	}
+}
+
+func TestKeySharing_SuspendKeepsConcurrentChanges(t *testing.T) {
+	t.Setenv("ABUSE_MAX_IPS", "1")
+	t.Setenv("ABUSE_AUTO_SUSPEND", "true")
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+	ctx := context.Background()
+
+	license, _ := storage.FindLicenseByKey(ctx, "AFP-7a11d123")
+	stale := *license
+
+	// Revoked after the validation read the license
+	license.SetStatus(models.StatusRevoked, models.StatusReasonChargeback, time.Now())
+	_ = storage.SaveLicense(ctx, license)
+
+	for i := 1; i <= 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", nil)
+		req.RemoteAddr = fmt.Sprintf("10.0.0.%d:54321", i)
+		if err := server.checkKeySharing(ctx, req, LicenseRequest{}, &stale); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if stored := storage.Licenses["license-1"]; stored.Status != models.StatusRevoked || stored.StatusReason != models.StatusReasonChargeback {
+		t.Errorf("Expected the revocation to stay, got '%s' (%s)", stored.Status, stored.StatusReason)
+	}
+}
+
+func TestRequestCountry_RequiresConfiguredHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("CF-IPCountry", "no")
+
+	t.Setenv("COUNTRY_HEADER", "")
+	if country := requestCountry(req); country != "" {
+		t.Errorf("Expected no country without a configured header, got '%s'", country)
+	}
+
+	t.Setenv("COUNTRY_HEADER", "CF-IPCountry")
+	if country := requestCountry(req); country != "NO" {
+		t.Errorf("Expected 'NO' from the configured header, got '%s'", country)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("CF-Connecting-IP", "198.51.100.7")
+
+	if ip := clientIP(req); ip != "192.0.2.1" {
+		t.Errorf("Expected remote host without port, got '%s'", ip)
+	}
+
+	t.Setenv("CLIENT_IP_HEADER", "CF-Connecting-IP")
+	if ip := clientIP(req); ip != "198.51.100.7" {
+		t.Errorf("Expected address from the proxy header, got '%s'", ip)
+	}
+}
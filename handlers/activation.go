@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/licensekey"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+)
+
+const defaultActivationLimit = 3
+
+type ActivationRequest struct {
+	LicenseKey string `json:"license_key"`
+	DeviceID   string `json:"device_id"`
+	DeviceName string `json:"device_name"`
+}
+
+type ActivationResponse struct {
+	Activated       bool   `json:"activated"`
+	Message         string `json:"message"`
+	ActivationsUsed int    `json:"activations_used"`
+	MaxActivations  int    `json:"max_activations"`
+}
+
+func (s *Server) ActivateLicense(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	logger.Info("License activation received", map[string]interface{}{
+		"remote_addr": r.RemoteAddr,
+		"user_agent":  r.Header.Get("User-Agent"),
+		"method":      r.Method,
+	})
+
+	req, ok := decodeActivationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	license, ok := s.findActivatableLicense(w, r, req)
+	if !ok {
+		return
+	}
+
+	activations, err := s.Storage.FindActivationsByLicense(ctx, license.ID)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error while fetching activations", map[string]interface{}{
+			"error":      err.Error(),
+			"license_id": license.ID,
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	maxActivations := licenseActivationLimit(license)
+
+	var activation *models.Activation
+	for _, a := range activations {
+		if a.DeviceID == req.DeviceID {
+			activation = a
+			break
+		}
+	}
+
+	if activation == nil {
+		now := time.Now()
+		activation = &models.Activation{
+			ID:         uuid.Must(uuid.NewRandom()).String(),
+			LicenseID:  license.ID,
+			DeviceID:   req.DeviceID,
+			DeviceName: req.DeviceName,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+
+		// The limit is checked by the insert itself, so concurrent
+		// activations cannot both take the last one
+		err = s.Storage.CreateActivation(ctx, activation, maxActivations)
+		if errors.Is(err, storage.ErrActivationLimitReached) {
+			logger.Warn("Activation limit reached", map[string]interface{}{
+				"license_id":      license.ID,
+				"activations":     len(activations),
+				"max_activations": maxActivations,
+			})
+			writeErrorResponse(w, http.StatusConflict, "activation limit reached")
+			return
+		}
+		activations = append(activations, activation)
+	} else {
+		// Re-activating a known device refreshes its name and timestamp
+		activation.DeviceName = req.DeviceName
+		activation.UpdatedAt = time.Now()
+		err = s.Storage.SaveActivation(ctx, activation)
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to save activation", map[string]interface{}{
+			"error":      err.Error(),
+			"license_id": license.ID,
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	logger.Info("Device activated", map[string]interface{}{
+		"license_id":    license.ID,
+		"activation_id": activation.ID,
+	})
+
+	writeActivationResponse(w, ActivationResponse{
+		Activated:       true,
+		Message:         "device activated",
+		ActivationsUsed: len(activations),
+		MaxActivations:  maxActivations,
+	})
+}
+
+func (s *Server) DeactivateLicense(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	logger.Info("License deactivation received", map[string]interface{}{
+		"remote_addr": r.RemoteAddr,
+		"user_agent":  r.Header.Get("User-Agent"),
+		"method":      r.Method,
+	})
+
+	req, ok := decodeActivationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	license, err := s.Storage.FindLicenseByKey(ctx, req.LicenseKey)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error while fetch license", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if license == nil {
+		writeErrorResponse(w, http.StatusNotFound, "license not found")
+		return
+	}
+
+	activation, err := s.Storage.FindActivation(ctx, license.ID, req.DeviceID)
+	if err == nil && activation != nil {
+		err = s.Storage.DeleteActivation(ctx, license.ID, req.DeviceID)
+	}
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to deactivate device", map[string]interface{}{
+			"error":      err.Error(),
+			"license_id": license.ID,
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if activation == nil {
+		writeErrorResponse(w, http.StatusNotFound, "device not activated")
+		return
+	}
+
+	activations, err := s.Storage.FindActivationsByLicense(ctx, license.ID)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error while fetching activations", map[string]interface{}{
+			"error":      err.Error(),
+			"license_id": license.ID,
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	logger.Info("Device deactivated", map[string]interface{}{
+		"license_id":    license.ID,
+		"activation_id": activation.ID,
+	})
+
+	writeActivationResponse(w, ActivationResponse{
+		Activated:       false,
+		Message:         "device deactivated",
+		ActivationsUsed: len(activations),
+		MaxActivations:  licenseActivationLimit(license),
+	})
+}
+
+func decodeActivationRequest(w http.ResponseWriter, r *http.Request) (ActivationRequest, bool) {
+	var req ActivationRequest
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only POST allowed")
+		return req, false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "empty body")
+		return req, false
+	}
+
+	if err := req.validate(); err != nil {
+		logger.Info("Invalid activation request", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+
+	req.LicenseKey = licensekey.Normalize(req.LicenseKey)
+	return req, true
+}
+
+func (s *Server) findActivatableLicense(w http.ResponseWriter, r *http.Request, req ActivationRequest) (*models.License, bool) {
+	license, err := s.Storage.FindLicenseByKey(r.Context(), req.LicenseKey)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error while fetch license", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return nil, false
+	}
+
+	if license == nil {
+		writeErrorResponse(w, http.StatusNotFound, "license not found")
+		return nil, false
+	}
+
+	now := time.Now()
+
+	// A trial stays bound to the device it was issued to, which may
+	// re-activate it after deactivating
+	if license.Status == models.StatusTrial && !license.IsExpired(now) {
+		if req.DeviceID != license.TrialDeviceID {
+			writeErrorResponse(w, http.StatusForbidden, "trial is bound to another device")
+			return nil, false
+		}
+		return license, true
+	}
+
+	if license.Status != models.StatusActive {
+		writeErrorResponse(w, http.StatusForbidden, "license not active")
+		return nil, false
+	}
+
+	// The sweep only runs periodically, so check the expiry date directly too
+	if license.IsExpired(now) {
+		writeErrorResponse(w, http.StatusForbidden, "license expired")
+		return nil, false
+	}
+
+	if license.IsTeam() {
+		writeErrorResponse(w, http.StatusForbidden, "seat key required")
+		return nil, false
+	}
+
+	if license.IsSeat() {
+		rejection, err := s.checkTeamLicense(r.Context(), license)
+		if err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Error while checking team license", map[string]interface{}{
+				"error":      err.Error(),
+				"license_id": license.ID,
+			})
+			writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+			return nil, false
+		}
+		if rejection != nil {
+			writeErrorResponse(w, http.StatusForbidden, rejection.Message)
+			return nil, false
+		}
+	}
+
+	return license, true
+}
+
+func (ar ActivationRequest) validate() error {
+	if strings.TrimSpace(ar.LicenseKey) == "" {
+		return fmt.Errorf("license_key required")
+	}
+	if !licensekey.Valid(licensekey.Normalize(ar.LicenseKey)) {
+		return fmt.Errorf("malformed license_key")
+	}
+	if strings.TrimSpace(ar.DeviceID) == "" {
+		return fmt.Errorf("device_id required")
+	}
+	return nil
+}
+
+// licenseActivationLimit returns how many devices license may be activated
+// on: its own MaxActivations when set, otherwise its product's limit. A
+// negative limit means no limit.
+func licenseActivationLimit(license *models.License) int {
+	if license.MaxActivations != 0 {
+		return license.MaxActivations
+	}
+	return activationLimit(license.ProductID)
+}
+
+// activationLimit returns how many devices a license for productID may be
+// activated on. ACTIVATION_LIMITS holds per-product overrides in the form
+// "prod_a=3,prod_b=5"; DEFAULT_ACTIVATION_LIMIT applies to everything else.
+func activationLimit(productID string) int {
+	for _, entry := range strings.Split(os.Getenv("ACTIVATION_LIMITS"), ",") {
+		product, limit, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || product != productID {
+			continue
+		}
+		if n, err := strconv.Atoi(limit); err == nil {
+			return n
+		}
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("DEFAULT_ACTIVATION_LIMIT")); err == nil {
+		return n
+	}
+
+	return defaultActivationLimit
+}
+
+func writeActivationResponse(w http.ResponseWriter, response ActivationResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode activation response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
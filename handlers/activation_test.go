@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/models"
+)
+
+func makeActivationRequest(t *testing.T, handler http.HandlerFunc, licenseKey, deviceID string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(ActivationRequest{
+		LicenseKey: licenseKey,
+		DeviceID:   deviceID,
+		DeviceName: "Test Mac",
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/activate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	return w
+}
+
+func TestActivateLicense_Success(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	w := makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", "device-1")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response ActivationResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if !response.Activated {
+		t.Errorf("Expected device to be activated")
+	}
+
+	if response.ActivationsUsed != 1 {
+		t.Errorf("Expected 1 activation used, got %d", response.ActivationsUsed)
+	}
+
+	if response.MaxActivations != defaultActivationLimit {
+		t.Errorf("Expected max activations %d, got %d", defaultActivationLimit, response.MaxActivations)
+	}
+
+	if len(storage.Activations) != 1 {
+		t.Errorf("Expected 1 stored activation, got %d", len(storage.Activations))
+	}
+}
+
+func TestActivateLicense_SameDeviceTwice(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", "device-1")
+	w := makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", "device-1")
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if len(storage.Activations) != 1 {
+		t.Errorf("Expected re-activation to reuse the activation, got %d", len(storage.Activations))
+	}
+}
+
+func TestActivateLicense_LimitReached(t *testing.T) {
+	t.Setenv("ACTIVATION_LIMITS", "prod_other=5, prod_test123=2")
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	for _, deviceID := range []string{"device-1", "device-2"} {
+		w := makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", deviceID)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d activating %s, got %d", http.StatusOK, deviceID, w.Code)
+		}
+	}
+
+	w := makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", "device-3")
+
+	var response map[string]string
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	if response["error"] != "activation limit reached" {
+		t.Errorf("Expected error 'activation limit reached', got '%s'", response["error"])
+	}
+}
+
+func TestActivateLicense_LicenseLimit(t *testing.T) {
+	t.Setenv("DEFAULT_ACTIVATION_LIMIT", "5")
+
+	storage := createTestStorage()
+	license := storage.Licenses["license-1"]
+	license.MaxActivations = 1
+	storage.Licenses["license-1"] = license
+	server := newTestServer(t, storage)
+
+	if w := makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", "device-1"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if w := makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", "device-2"); w.Code != http.StatusConflict {
+		t.Errorf("Expected the license's own limit of 1 to apply, got status %d", w.Code)
+	}
+}
+
+func TestActivateLicense_Trial(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	trial := startTestTrial(t, server, "trial@example.com", "trial-device")
+
+	if w := makeActivationRequest(t, server.ActivateLicense, trial.LicenseKey, "trial-device"); w.Code != http.StatusOK {
+		t.Errorf("Expected the trial device to activate, got status %d: %s", w.Code, w.Body.String())
+	}
+
+	w := makeActivationRequest(t, server.ActivateLicense, trial.LicenseKey, "other-device")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for another device, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestActivateLicense_Errors(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	tests := []struct {
+		name           string
+		licenseKey     string
+		deviceID       string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "missing device id",
+			licenseKey:     "AFP-7a11d123",
+			deviceID:       "",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "device_id required",
+		},
+		{
+			name:           "unknown license",
+			licenseKey:     "AFP-00000404",
+			deviceID:       "device-1",
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "license not found",
+		},
+		{
+			name:           "suspended license",
+			licenseKey:     "AFP-5a5bed00",
+			deviceID:       "device-1",
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "license not active",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := makeActivationRequest(t, server.ActivateLicense, tt.licenseKey, tt.deviceID)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			var response map[string]string
+			_ = json.NewDecoder(w.Body).Decode(&response)
+
+			if response["error"] != tt.expectedError {
+				t.Errorf("Expected error '%s', got '%s'", tt.expectedError, response["error"])
+			}
+		})
+	}
+}
+
+func TestActivateLicense_ExpiredBeforeSweep(t *testing.T) {
+	storage := createTestStorage()
+	license := storage.Licenses["license-1"]
+	past := time.Now().Add(-time.Hour)
+	license.ExpiresAt = &past
+	storage.Licenses["license-1"] = license
+
+	server := newTestServer(t, storage)
+
+	w := makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", "device-1")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a license past its expiry, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestActivateLicense_SeatOfSuspendedTeam(t *testing.T) {
+	storage := createTeamTestStorage(2)
+	server := newTestServer(t, storage)
+
+	response := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "member@example.com"}))
+	seatKey := response.Assignments[0].LicenseKey
+
+	team := storage.Licenses["team-1"]
+	team.SetStatus(models.StatusSuspended, models.StatusReasonRefunded, time.Now())
+	storage.Licenses["team-1"] = team
+
+	w := makeActivationRequest(t, server.ActivateLicense, seatKey, "device-1")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a seat of a suspended team, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestDeactivateLicense(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", "device-1")
+
+	w := makeActivationRequest(t, server.DeactivateLicense, "AFP-7a11d123", "device-1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response ActivationResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if response.Activated {
+		t.Errorf("Expected device to be deactivated")
+	}
+
+	if response.ActivationsUsed != 0 {
+		t.Errorf("Expected 0 activations used, got %d", response.ActivationsUsed)
+	}
+
+	w = makeActivationRequest(t, server.DeactivateLicense, "AFP-7a11d123", "device-1")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d deactivating twice, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestValidateLicense_DeviceActivation(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", "device-1")
+
+	tests := []struct {
+		name          string
+		deviceID      string
+		requireDevice string
+		expectedValid bool
+		expectedCode  string
+	}{
+		{
+			name:          "activated device",
+			deviceID:      "device-1",
+			expectedValid: true,
+			expectedCode:  CodeValid,
+		},
+		{
+			name:          "unknown device",
+			deviceID:      "device-2",
+			expectedValid: false,
+			expectedCode:  CodeDeviceNotActivated,
+		},
+		{
+			name:          "no device from legacy client",
+			deviceID:      "",
+			expectedValid: true,
+			expectedCode:  CodeValid,
+		},
+		{
+			name:          "no device when activation is required",
+			deviceID:      "",
+			requireDevice: "true",
+			expectedValid: false,
+			expectedCode:  CodeDeviceNotActivated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REQUIRE_DEVICE_ACTIVATION", tt.requireDevice)
+
+			body, _ := json.Marshal(LicenseRequest{
+				LicenseKey:     "AFP-7a11d123",
+				AppVersion:     "1.0.0",
+				DeviceID:       tt.deviceID,
+				PayloadVersion: payloadVersionCodes,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			server.ValidateLicense(w, req)
+
+			var response ValidateResponse
+			_ = json.NewDecoder(w.Body).Decode(&response)
+
+			if response.Valid != tt.expectedValid {
+				t.Errorf("Expected valid=%v, got valid=%v", tt.expectedValid, response.Valid)
+			}
+
+			if response.Code != tt.expectedCode {
+				t.Errorf("Expected code '%s', got '%s'", tt.expectedCode, response.Code)
+			}
+		})
+	}
+}
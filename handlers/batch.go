@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"auto-focus.app/cloud/internal/licensekey"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+)
+
+const defaultBatchValidateLimit = 50
+
+type BatchValidateRequest struct {
+	Licenses []LicenseRequest `json:"licenses"`
+}
+
+// BatchValidateResult is a regular signed validation response tagged with
+// the key it answers for.
+type BatchValidateResult struct {
+	LicenseKey string `json:"license_key"`
+	ValidateResponse
+}
+
+type BatchValidateResponse struct {
+	Results []BatchValidateResult `json:"results"`
+}
+
+// BatchValidateLicenses validates several keys in one request and one license
+// lookup, for support tooling and managed fleets. Every result is signed
+// exactly as /v1/licenses/validate would sign it. It requires the admin token
+// or a fleet token so it cannot be used to test many keys per rate-limited
+// request.
+func (s *Server) BatchValidateLicenses(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != "POST" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only POST allowed")
+		return
+	}
+
+	var req BatchValidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if len(req.Licenses) == 0 {
+		writeErrorResponse(w, http.StatusBadRequest, "licenses required")
+		return
+	}
+
+	limit := batchValidateLimit()
+	if len(req.Licenses) > limit {
+		writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("at most %d licenses per batch", limit))
+		return
+	}
+
+	keys := make([]string, 0, len(req.Licenses))
+	for _, item := range req.Licenses {
+		// Malformed keys are answered without being looked up
+		if item.validate() == nil {
+			keys = append(keys, licensekey.Normalize(item.LicenseKey))
+		}
+	}
+
+	licenses, err := s.Storage.FindLicensesByKeys(ctx, keys)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error while fetch licenses", map[string]interface{}{
+			"error": err.Error(),
+			"count": len(keys),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	byKey := make(map[string]*models.License, len(licenses))
+	for _, license := range licenses {
+		byKey[license.Key] = license
+	}
+
+	response := BatchValidateResponse{Results: make([]BatchValidateResult, 0, len(req.Licenses))}
+	for _, item := range req.Licenses {
+		// Leases are only handed out by the single-key endpoint
+		item.Lease = false
+
+		// Batch lookups come from support tooling and fleet managers, not
+		// the apps themselves, so they are neither counted as usage nor fed
+		// to key sharing detection
+		license := byKey[licensekey.Normalize(item.LicenseKey)]
+
+		result, err := s.evaluateBatchItem(r, item, license)
+		if err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Error while validating license", map[string]interface{}{
+				"error": err.Error(),
+			})
+			writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+			return
+		}
+
+		response.Results = append(response.Results, BatchValidateResult{
+			LicenseKey:       item.LicenseKey,
+			ValidateResponse: s.signedValidationResponse(item, result),
+		})
+	}
+
+	logger.Info("Batch validation completed", map[string]interface{}{
+		"remote_addr": r.RemoteAddr,
+		"count":       len(response.Results),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode batch validation response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// evaluateBatchItem applies the single-key request checks to one batch entry,
+// reporting rejections as results instead of failing the whole batch.
+func (s *Server) evaluateBatchItem(r *http.Request, item LicenseRequest, license *models.License) (ValidateResponse, error) {
+	if err := item.validate(); err != nil {
+		return ValidateResponse{Valid: false, Message: "invalid license", Code: CodeInvalidRequest}, nil
+	}
+
+	if item.Nonce != "" {
+		if err := s.checkNonce(item); err != nil {
+			logger.Warn("Nonce rejected", map[string]interface{}{
+				"error":       err.Error(),
+				"remote_addr": r.RemoteAddr,
+			})
+			return ValidateResponse{Valid: false, Message: err.Error(), Code: CodeNonceRejected}, nil
+		}
+	}
+
+	return s.evaluateLicense(r.Context(), item, license)
+}
+
+func batchValidateLimit() int {
+	if limit, err := strconv.Atoi(os.Getenv("BATCH_VALIDATE_LIMIT")); err == nil && limit > 0 {
+		return limit
+	}
+	return defaultBatchValidateLimit
+}
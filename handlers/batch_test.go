@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"auto-focus.app/cloud/internal/abuse"
+	"auto-focus.app/cloud/internal/signing"
+)
+
+func makeBatchRequest(t *testing.T, server *Server, token string, reqBody BatchValidateRequest) *httptest.ResponseRecorder {
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate:batch", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	server.Mux.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestBatchValidateLicenses_Success(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	t.Setenv("HMAC_SECRET", "test-secret")
+	server := newTestServer(t, createTestStorage())
+
+	w := makeBatchRequest(t, server, "admin-secret", BatchValidateRequest{Licenses: []LicenseRequest{
+		{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11"},
+		{LicenseKey: "AFP-5a5bed00", AppVersion: "1.4.11"},
+		{LicenseKey: "AFP-0000beef", AppVersion: "1.4.11"},
+		{LicenseKey: "", AppVersion: "1.4.11"},
+	}})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response BatchValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	want := []struct {
+		key     string
+		valid   bool
+		message string
+	}{
+		{"AFP-7a11d123", true, "license valid"},
+		{"AFP-5a5bed00", false, "license not active"},
+		{"AFP-0000beef", false, "license not found"},
+		{"", false, "invalid license"},
+	}
+
+	if len(response.Results) != len(want) {
+		t.Fatalf("Expected %d results, got %d", len(want), len(response.Results))
+	}
+
+	signer := signing.NewHMACSigner([]byte("test-secret"))
+	for i, tt := range want {
+		result := response.Results[i]
+		if result.LicenseKey != tt.key || result.Valid != tt.valid || result.Message != tt.message {
+			t.Errorf("Result %d: expected %s/%t/%s, got %s/%t/%s", i, tt.key, tt.valid, tt.message, result.LicenseKey, result.Valid, result.Message)
+		}
+
+		payload := fmt.Sprintf("%t|%s|%d", result.Valid, result.Message, result.Timestamp)
+		if result.Signature != signer.Sign([]byte(payload)) {
+			t.Errorf("Result %d: expected individually signed response", i)
+		}
+	}
+}
+
+func TestBatchValidateLicenses_TooMany(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	t.Setenv("BATCH_VALIDATE_LIMIT", "2")
+	server := newTestServer(t, createTestStorage())
+
+	w := makeBatchRequest(t, server, "admin-secret", BatchValidateRequest{Licenses: []LicenseRequest{
+		{LicenseKey: "AFP-1"}, {LicenseKey: "AFP-2"}, {LicenseKey: "AFP-3"},
+	}})
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestBatchValidateLicenses_Empty(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	server := newTestServer(t, createTestStorage())
+
+	w := makeBatchRequest(t, server, "admin-secret", BatchValidateRequest{})
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestBatchValidateLicenses_StorageError(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	server := newTestServer(t, &mockStorageWithErrors{})
+
+	w := makeBatchRequest(t, server, "admin-secret", BatchValidateRequest{Licenses: []LicenseRequest{
+		{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11"},
+	}})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+}
+
+func TestBatchValidateLicenses_Unauthorized(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	server := newTestServer(t, createTestStorage())
+
+	for _, token := range []string{"", "wrong-secret"} {
+		w := makeBatchRequest(t, server, token, BatchValidateRequest{Licenses: []LicenseRequest{
+			{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11"},
+		}})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d for token '%s', got %d", http.StatusUnauthorized, token, w.Code)
+		}
+	}
+}
+
+func TestBatchValidateLicenses_FleetToken(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	t.Setenv("FLEET_API_TOKENS", "fleet-one, fleet-two")
+	server := newTestServer(t, createTestStorage())
+
+	w := makeBatchRequest(t, server, "fleet-two", BatchValidateRequest{Licenses: []LicenseRequest{
+		{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11"},
+	}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for a fleet token, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// Fleet tokens only open batch validation, not the admin endpoints
+	if w := makeUsageRequest(server, "fleet-two", "AFP-7a11d123"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d on admin endpoints, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestBatchValidateLicenses_SkipsUsageAndAbuseTracking(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	t.Setenv("COUNTRY_HEADER", "CF-IPCountry")
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	w := makeBatchRequest(t, server, "admin-secret", BatchValidateRequest{Licenses: []LicenseRequest{
+		{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11", DeviceID: "device-1"},
+	}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if flushed, err := server.Usage.Flush(context.Background(), storage); err != nil || flushed != 0 {
+		t.Errorf("Expected no usage recorded for a batch lookup, got %d (%v)", flushed, err)
+	}
+
+	if counts := server.AbuseTracker.Observe("license-1", abuse.Sighting{IP: "203.0.113.9"}); counts.IPs != 1 {
+		t.Errorf("Expected the batch caller not to be tracked for key sharing, got %d IPs", counts.IPs)
+	}
+}
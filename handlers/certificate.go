@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/licensekey"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/internal/signing"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+)
+
+const certificateFormat = "auto-focus-license-certificate/1"
+
+var (
+	ErrCertificateLicenseNotFound  = errors.New("license not found")
+	ErrCertificateLicenseNotActive = errors.New("license not active")
+	ErrCertificateSeatKeyRequired  = errors.New("seat key required")
+	ErrCertificateSigningDisabled  = errors.New("no active ed25519 signing key")
+)
+
+type CertificateRequest struct {
+	LicenseKey string `json:"license_key"`
+	DeviceID   string `json:"device_id,omitempty"`
+}
+
+// CertificateClaims are the signed contents of an offline certificate.
+type CertificateClaims struct {
+	LicenseID     string   `json:"license_id"`
+	LicenseKey    string   `json:"license_key"`
+	CustomerName  string   `json:"customer_name"`
+	CustomerEmail string   `json:"customer_email"`
+	ProductID     string   `json:"product_id"`
+	ProductName   string   `json:"product_name"`
+	Version       string   `json:"version"`
+	Entitlements  []string `json:"entitlements,omitempty"`
+	DeviceID      string   `json:"device_id,omitempty"`
+	IssuedAt      int64    `json:"iat"`
+	ExpiresAt     int64    `json:"exp,omitempty"`
+	Algorithm     string   `json:"alg"`
+	KeyID         string   `json:"kid"`
+}
+
+// LicenseCertificate is the file imported by apps that can never reach the
+// API. Certificate is "<claims>.<signature>", verified with the Ed25519 key
+// published under KeyID at /v1/keys.
+type LicenseCertificate struct {
+	Format      string `json:"format"`
+	KeyID       string `json:"kid"`
+	Algorithm   string `json:"algorithm"`
+	Certificate string `json:"certificate"`
+}
+
+// IssueCertificate builds a signed offline certificate for an active license.
+// Certificates cannot be revoked once handed out, so they always expire with
+// the license and are only issued by admins.
+func (s *Server) IssueCertificate(ctx context.Context, req CertificateRequest) (*LicenseCertificate, error) {
+	// HMAC secrets are never published, so only Ed25519 is verifiable offline
+	key, ok := s.Keyring.Active(signing.AlgorithmEd25519)
+	if !ok {
+		return nil, ErrCertificateSigningDisabled
+	}
+
+	license, err := s.Storage.FindLicenseByKey(ctx, licensekey.Normalize(req.LicenseKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find license: %w", err)
+	}
+
+	if license == nil {
+		return nil, ErrCertificateLicenseNotFound
+	}
+
+	now := time.Now()
+	if license.Status != models.StatusActive || license.IsExpired(now) {
+		return nil, ErrCertificateLicenseNotActive
+	}
+
+	// Team keys only hand out seats; they never unlock the app themselves
+	if license.IsTeam() {
+		return nil, ErrCertificateSeatKeyRequired
+	}
+
+	if license.IsSeat() {
+		rejection, err := s.checkTeamLicense(ctx, license)
+		if err != nil {
+			return nil, err
+		}
+		if rejection != nil {
+			return nil, ErrCertificateLicenseNotActive
+		}
+	}
+
+	customer, err := s.Storage.GetCustomer(ctx, license.CustomerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get customer: %w", err)
+	}
+
+	claims := CertificateClaims{
+		LicenseID:    license.ID,
+		LicenseKey:   license.Key,
+		ProductID:    license.ProductID,
+		ProductName:  license.ProductName,
+		Version:      license.Version,
+		Entitlements: license.Entitlements,
+		DeviceID:     req.DeviceID,
+		IssuedAt:     now.Unix(),
+		Algorithm:    key.Signer.Algorithm(),
+		KeyID:        key.ID,
+	}
+
+	if customer != nil {
+		claims.CustomerName = customer.Name
+		claims.CustomerEmail = customer.Email
+	}
+
+	if license.ExpiresAt != nil {
+		claims.ExpiresAt = license.ExpiresAt.Unix()
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate claims: %w", err)
+	}
+
+	logger.Info("License certificate issued", map[string]interface{}{
+		"license_id": license.ID,
+		"kid":        key.ID,
+		"device_id":  req.DeviceID,
+	})
+
+	return &LicenseCertificate{
+		Format:      certificateFormat,
+		KeyID:       key.ID,
+		Algorithm:   key.Signer.Algorithm(),
+		Certificate: key.Token(payload),
+	}, nil
+}
+
+// Certificate returns an offline certificate file for an admin to hand to a
+// customer on an air-gapped Mac.
+func (s *Server) Certificate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only POST allowed")
+		return
+	}
+
+	var req CertificateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if strings.TrimSpace(req.LicenseKey) == "" {
+		writeErrorResponse(w, http.StatusBadRequest, "license_key required")
+		return
+	}
+
+	certificate, err := s.IssueCertificate(r.Context(), req)
+	switch {
+	case errors.Is(err, ErrCertificateLicenseNotFound):
+		writeErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, ErrCertificateLicenseNotActive), errors.Is(err, ErrCertificateSeatKeyRequired):
+		writeErrorResponse(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, ErrCertificateSigningDisabled):
+		logger.Error("Certificate requested without an Ed25519 signing key", map[string]interface{}{})
+		writeErrorResponse(w, http.StatusServiceUnavailable, "certificate signing not configured")
+		return
+	case err != nil:
+		sentry.CaptureException(err)
+		logger.Error("Failed to issue certificate", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", req.LicenseKey+".afcert"))
+	if err := json.NewEncoder(w).Encode(certificate); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode certificate response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/models"
+)
+
+func makeCertificateRequest(server *Server, token string, reqBody CertificateRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(reqBody)
+	req := httptest.NewRequest(http.MethodPost, "/v1/admin/certificates", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	server.Mux.ServeHTTP(w, req)
+
+	return w
+}
+
+func setupCertificateSigning(t *testing.T) ed25519.PrivateKey {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	return privateKey
+}
+
+func TestCertificate_Success(t *testing.T) {
+	privateKey := setupCertificateSigning(t)
+
+	storage := createTestStorage()
+	expiresAt := time.Now().Add(365 * 24 * time.Hour).Truncate(time.Second)
+	license := storage.Licenses["license-1"]
+	license.ExpiresAt = &expiresAt
+	storage.Licenses["license-1"] = license
+
+	server := newTestServer(t, storage)
+
+	w := makeCertificateRequest(server, "admin-secret", CertificateRequest{LicenseKey: "AFP-7a11d123", DeviceID: "device-1"})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, "AFP-7a11d123.afcert") {
+		t.Errorf("Expected certificate attachment, got '%s'", disposition)
+	}
+
+	var certificate LicenseCertificate
+	_ = json.NewDecoder(w.Body).Decode(&certificate)
+
+	if certificate.Format != certificateFormat || certificate.KeyID != legacyEd25519KeyID {
+		t.Errorf("Expected format '%s' and kid '%s', got '%s'/'%s'", certificateFormat, legacyEd25519KeyID, certificate.Format, certificate.KeyID)
+	}
+
+	parts := strings.Split(certificate.Certificate, ".")
+	if len(parts) != 2 {
+		t.Fatalf("Expected claims.signature certificate, got '%s'", certificate.Certificate)
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if !ed25519.Verify(privateKey.Public().(ed25519.PublicKey), []byte(parts[0]), signature) {
+		t.Errorf("Expected certificate to verify with the published key")
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	var claims CertificateClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("Failed to decode claims: %v", err)
+	}
+
+	if claims.LicenseKey != "AFP-7a11d123" || claims.CustomerEmail != "test@example.com" || claims.ProductID != "prod_test123" {
+		t.Errorf("Expected license and customer details in claims, got %+v", claims)
+	}
+
+	if claims.DeviceID != "device-1" {
+		t.Errorf("Expected certificate bound to device-1, got '%s'", claims.DeviceID)
+	}
+
+	if claims.ExpiresAt != expiresAt.Unix() {
+		t.Errorf("Expected certificate to expire with the license at %d, got %d", expiresAt.Unix(), claims.ExpiresAt)
+	}
+}
+
+func TestCertificate_RequiresAdminToken(t *testing.T) {
+	setupCertificateSigning(t)
+	server := newTestServer(t, createTestStorage())
+
+	for _, token := range []string{"", "wrong-secret"} {
+		w := makeCertificateRequest(server, token, CertificateRequest{LicenseKey: "AFP-7a11d123"})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d for token '%s', got %d", http.StatusUnauthorized, token, w.Code)
+		}
+	}
+}
+
+func TestCertificate_AdminDisabledWithoutToken(t *testing.T) {
+	setupCertificateSigning(t)
+	t.Setenv("ADMIN_API_TOKEN", "")
+	server := newTestServer(t, createTestStorage())
+
+	w := makeCertificateRequest(server, "", CertificateRequest{LicenseKey: "AFP-7a11d123"})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestCertificate_Errors(t *testing.T) {
+	setupCertificateSigning(t)
+	server := newTestServer(t, createTestStorage())
+
+	tests := []struct {
+		name       string
+		licenseKey string
+		wantStatus int
+	}{
+		{"missing key", "", http.StatusBadRequest},
+		{"unknown license", "AFP-0000dead", http.StatusNotFound},
+		{"suspended license", "AFP-5a5bed00", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := makeCertificateRequest(server, "admin-secret", CertificateRequest{LicenseKey: tt.licenseKey})
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestIssueCertificate_TeamLicenses(t *testing.T) {
+	setupCertificateSigning(t)
+	storage := createTeamTestStorage(2)
+	server := newTestServer(t, storage)
+
+	response := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "member@example.com"}))
+	seatKey := response.Assignments[0].LicenseKey
+
+	if _, err := server.IssueCertificate(context.Background(), CertificateRequest{LicenseKey: "AFP-7ea40001"}); !errors.Is(err, ErrCertificateSeatKeyRequired) {
+		t.Errorf("Expected ErrCertificateSeatKeyRequired for the team key, got %v", err)
+	}
+
+	if _, err := server.IssueCertificate(context.Background(), CertificateRequest{LicenseKey: seatKey}); err != nil {
+		t.Fatalf("Expected a certificate for a seat of an active team, got %v", err)
+	}
+
+	team := storage.Licenses["team-1"]
+	team.SetStatus(models.StatusSuspended, models.StatusReasonRefunded, time.Now())
+	storage.Licenses["team-1"] = team
+
+	if _, err := server.IssueCertificate(context.Background(), CertificateRequest{LicenseKey: seatKey}); !errors.Is(err, ErrCertificateLicenseNotActive) {
+		t.Errorf("Expected ErrCertificateLicenseNotActive for a seat of a refunded team, got %v", err)
+	}
+}
+
+func TestIssueCertificate_RequiresEd25519Key(t *testing.T) {
+	t.Setenv("ED25519_PRIVATE_KEY", "")
+	server := newTestServer(t, createTestStorage())
+
+	_, err := server.IssueCertificate(context.Background(), CertificateRequest{LicenseKey: "AFP-7a11d123"})
+	if !errors.Is(err, ErrCertificateSigningDisabled) {
+		t.Errorf("Expected ErrCertificateSigningDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"os"
+
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/checkout/session"
+	"github.com/stripe/stripe-go/v82/invoicepayment"
+)
+
+// CheckoutSessions is the part of the Stripe Checkout API the server calls
+// outside of webhooks, so tests can stand in for Stripe.
+type CheckoutSessions interface {
+	// SessionForPayment finds the session a payment was made through, for
+	// licenses saved before their payment intent was recorded.
+	SessionForPayment(ctx context.Context, paymentIntentID string) (string, error)
+	// SubscriptionForPayment finds the subscription an invoice payment was
+	// for, as renewals are paid without a checkout session.
+	SubscriptionForPayment(ctx context.Context, paymentIntentID string) (string, error)
+	// Create opens a checkout session, such as the one for an upgrade offer.
+	Create(ctx context.Context, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error)
+	Get(ctx context.Context, id string) (*stripe.CheckoutSession, error)
+	// LineItems lists what a session sold, which webhook payloads leave out.
+	LineItems(ctx context.Context, id string) (*stripe.LineItemList, error)
+}
+
+type stripeCheckoutSessions struct{}
+
+func (stripeCheckoutSessions) SessionForPayment(ctx context.Context, paymentIntentID string) (string, error) {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	params := &stripe.CheckoutSessionListParams{PaymentIntent: stripe.String(paymentIntentID)}
+	params.Context = ctx
+
+	iter := session.List(params)
+	if iter.Next() {
+		return iter.CheckoutSession().ID, nil
+	}
+	return "", iter.Err()
+}
+
+func (stripeCheckoutSessions) SubscriptionForPayment(ctx context.Context, paymentIntentID string) (string, error) {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	params := &stripe.InvoicePaymentListParams{
+		Payment: &stripe.InvoicePaymentListPaymentParams{
+			Type:          stripe.String("payment_intent"),
+			PaymentIntent: stripe.String(paymentIntentID),
+		},
+	}
+	params.AddExpand("data.invoice")
+	params.Context = ctx
+
+	iter := invoicepayment.List(params)
+	for iter.Next() {
+		if invoice := iter.InvoicePayment().Invoice; invoice != nil {
+			if details := invoiceSubscription(invoice); details != nil {
+				return details.Subscription.ID, nil
+			}
+		}
+	}
+	return "", iter.Err()
+}
+
+func (stripeCheckoutSessions) Create(ctx context.Context, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	params.Context = ctx
+	return session.New(params)
+}
+
+func (stripeCheckoutSessions) Get(ctx context.Context, id string) (*stripe.CheckoutSession, error) {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	params := &stripe.CheckoutSessionParams{}
+	params.Context = ctx
+	return session.Get(id, params)
+}
+
+func (stripeCheckoutSessions) LineItems(ctx context.Context, id string) (*stripe.LineItemList, error) {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	params := &stripe.CheckoutSessionListLineItemsParams{Session: stripe.String(id)}
+	params.Context = ctx
+
+	items := &stripe.LineItemList{}
+	iter := session.ListLineItems(params)
+	for iter.Next() {
+		items.Data = append(items.Data, iter.LineItem())
+	}
+	return items, iter.Err()
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"auto-focus.app/cloud/internal/email"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/stripe/stripe-go/v82"
+)
+
+// handleDispute moves the disputed license through the chargeback: it is
+// suspended while the dispute is open, reactivated if we win, and revoked
+// for good if we lose. eventType is the charge.dispute.* event received and
+// created the time Stripe created it; events older than the last one applied
+// to the license are ignored, so a late redelivery cannot undo the outcome.
+func (s *Server) handleDispute(ctx context.Context, eventType string, created time.Time, dispute *stripe.Dispute) error {
+	if dispute.PaymentIntent == nil {
+		logger.Warn("Dispute has no payment intent", map[string]interface{}{
+			"dispute_id": dispute.ID,
+		})
+		return nil
+	}
+
+	license, err := s.findLicenseByPayment(ctx, dispute.PaymentIntent.ID)
+	if err != nil || license == nil {
+		return err
+	}
+
+	if license.DisputeUpdatedAt != nil && created.Before(*license.DisputeUpdatedAt) {
+		logger.Info("Ignoring stale dispute event", map[string]interface{}{
+			"license_id":     license.ID,
+			"dispute_id":     dispute.ID,
+			"dispute_status": dispute.Status,
+			"event_type":     eventType,
+		})
+		return nil
+	}
+
+	now := time.Now()
+	disputeChanged := license.DisputeStatus != string(dispute.Status)
+
+	switch dispute.Status {
+	case stripe.DisputeStatusLost:
+		if license.Status != models.StatusRevoked {
+			license.SetStatus(models.StatusRevoked, models.StatusReasonChargeback, now)
+		}
+	case stripe.DisputeStatusWon, stripe.DisputeStatusWarningClosed:
+		if license.Status == models.StatusSuspended && license.StatusReason == models.StatusReasonDisputed {
+			// Winning the dispute doesn't undo a refund made meanwhile
+			if refundSuspends(license) {
+				license.SetStatus(models.StatusSuspended, models.StatusReasonRefunded, now)
+			} else {
+				license.SetStatus(models.StatusActive, "", now)
+			}
+		}
+	default:
+		if license.Status == models.StatusActive {
+			license.SetStatus(models.StatusSuspended, models.StatusReasonDisputed, now)
+		}
+	}
+
+	license.DisputeStatus = string(dispute.Status)
+	license.DisputeUpdatedAt = &created
+	if err := s.Storage.SaveLicense(ctx, license); err != nil {
+		return fmt.Errorf("failed to save disputed license: %w", err)
+	}
+
+	logger.Info("License dispute processed", map[string]interface{}{
+		"license_id":     license.ID,
+		"dispute_id":     dispute.ID,
+		"dispute_status": dispute.Status,
+		"event_type":     eventType,
+		"status":         license.Status,
+	})
+
+	// Stripe sends several events per status, e.g. funds_withdrawn
+	if disputeChanged {
+		sendDisputeNotification(eventType, dispute, license)
+	}
+	return nil
+}
+
+func sendDisputeNotification(eventType string, dispute *stripe.Dispute, license *models.License) {
+	adminEmail := os.Getenv("ADMIN_EMAIL")
+	if adminEmail == "" {
+		logger.Debug("ADMIN_EMAIL not configured, skipping dispute notification")
+		return
+	}
+
+	dueBy := "n/a"
+	if dispute.EvidenceDetails != nil && dispute.EvidenceDetails.DueBy > 0 {
+		dueBy = time.Unix(dispute.EvidenceDetails.DueBy, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+	}
+
+	body := fmt.Sprintf(`Stripe dispute update: %s
+
+DISPUTE
+Dispute ID: %s
+Status: %s
+Reason: %s
+Amount: %s
+Evidence Due: %s
+
+LICENSE
+License Key: %s
+License ID: %s
+Customer ID: %s
+License Status: %s
+
+---
+Sent from Auto-Focus Cloud API`,
+		eventType,
+		dispute.ID,
+		dispute.Status,
+		dispute.Reason,
+		formatPrice(dispute.Amount, string(dispute.Currency)),
+		dueBy,
+		license.Key,
+		license.ID,
+		license.CustomerID,
+		license.Status,
+	)
+
+	if err := email.Send(adminEmail, "⚠️ Auto-Focus+ payment disputed", body); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to send dispute notification", map[string]interface{}{
+			"error":       err.Error(),
+			"admin_email": adminEmail,
+			"dispute_id":  dispute.ID,
+			"license_id":  license.ID,
+		})
+	}
+}
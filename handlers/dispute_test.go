@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"auto-focus.app/cloud/models"
+)
+
+func createMockDisputeEvent(eventType, status string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   "evt_" + eventType + "_" + status,
+		"type": eventType,
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":             "dp_test",
+				"amount":         2999,
+				"currency":       "usd",
+				"reason":         "fraudulent",
+				"status":         status,
+				"charge":         "ch_refund",
+				"payment_intent": "pi_refund",
+				"evidence_details": map[string]interface{}{
+					"due_by": 1767225600,
+				},
+			},
+		},
+	}
+}
+
+func TestStripeWebhook_DisputeLifecycle(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	tests := []struct {
+		name       string
+		outcome    string
+		wantStatus string
+		wantReason string
+	}{
+		{name: "won", outcome: "won", wantStatus: models.StatusActive, wantReason: ""},
+		{name: "inquiry closed", outcome: "warning_closed", wantStatus: models.StatusActive, wantReason: ""},
+		{name: "lost", outcome: "lost", wantStatus: models.StatusRevoked, wantReason: models.StatusReasonChargeback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := createRefundTestStorage()
+			server := newTestServer(t, storage)
+
+			for _, event := range []map[string]interface{}{
+				createMockDisputeEvent("charge.dispute.created", "needs_response"),
+				createMockDisputeEvent("charge.dispute.funds_withdrawn", "needs_response"),
+			} {
+				if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+				}
+
+				license := storage.Licenses["license-1"]
+				if license.Status != models.StatusSuspended || license.StatusReason != models.StatusReasonDisputed {
+					t.Fatalf("Expected license suspended while disputed, got %s/%s", license.Status, license.StatusReason)
+				}
+			}
+
+			if response := validateLicenseKey(t, server, "AFP-7a11d123"); response.Code != CodeLicenseDisputed {
+				t.Errorf("Expected %s while disputed, got %s", CodeLicenseDisputed, response.Code)
+			}
+
+			if code := deliverStripeEvent(t, server, createMockDisputeEvent("charge.dispute.closed", tt.outcome)); code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+			}
+
+			license := storage.Licenses["license-1"]
+			if license.Status != tt.wantStatus || license.StatusReason != tt.wantReason {
+				t.Errorf("Expected %s/%s after dispute %s, got %s/%s", tt.wantStatus, tt.wantReason, tt.outcome, license.Status, license.StatusReason)
+			}
+		})
+	}
+}
+
+func TestStripeWebhook_DisputeWonKeepsOtherSuspensions(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createRefundTestStorage()
+	server := newTestServer(t, storage)
+
+	// Refunded while the dispute was open: winning the dispute must not
+	// bring the license back
+	if code := deliverStripeEvent(t, server, createMockChargeRefundedEvent("pi_refund", 2999, true)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := deliverStripeEvent(t, server, createMockDisputeEvent("charge.dispute.closed", "won")); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusSuspended || license.StatusReason != models.StatusReasonRefunded {
+		t.Errorf("Expected refund suspension to stay, got %s/%s", license.Status, license.StatusReason)
+	}
+}
+
+func TestStripeWebhook_DisputeWonAfterRefund(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createRefundTestStorage()
+	server := newTestServer(t, storage)
+
+	// Refunded while the license was already suspended for the dispute
+	for _, event := range []map[string]interface{}{
+		createMockDisputeEvent("charge.dispute.created", "needs_response"),
+		createMockChargeRefundedEvent("pi_refund", 2999, true),
+		createMockDisputeEvent("charge.dispute.closed", "won"),
+	} {
+		if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+		}
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusSuspended || license.StatusReason != models.StatusReasonRefunded {
+		t.Errorf("Expected license to stay suspended for the refund, got %s/%s", license.Status, license.StatusReason)
+	}
+}
+
+func TestStripeWebhook_DisputeIgnoresStaleEvents(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createRefundTestStorage()
+	server := newTestServer(t, storage)
+
+	closed := createMockDisputeEvent("charge.dispute.closed", "won")
+	closed["created"] = 1767225600
+	created := createMockDisputeEvent("charge.dispute.created", "needs_response")
+	created["created"] = 1767225600 - 3600
+
+	// The dispute opening is delivered late, after it was won
+	for _, event := range []map[string]interface{}{closed, created} {
+		if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+		}
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusActive {
+		t.Errorf("Expected stale dispute event to be ignored, got %s/%s", license.Status, license.StatusReason)
+	}
+	if license.DisputeStatus != "won" {
+		t.Errorf("Expected last applied dispute status 'won', got '%s'", license.DisputeStatus)
+	}
+}
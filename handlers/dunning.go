@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/email"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/stripe/stripe-go/v82"
+)
+
+const defaultDunningGracePeriod = 14 * 24 * time.Hour
+
+// Reminders go out this long after the first failed payment
+var defaultDunningReminders = []time.Duration{3 * 24 * time.Hour, 7 * 24 * time.Hour}
+
+// handleInvoicePaymentFailed starts dunning for the subscription a renewal
+// failed for: the customer is emailed a link to update their payment method
+// and the license stays valid through the grace period. Stripe reports
+// every failed retry, but the schedule runs from the first failure. Only an
+// active license gets a grace period, and only once per invoice.
+func (s *Server) handleInvoicePaymentFailed(ctx context.Context, invoice *stripe.Invoice) error {
+	details := invoiceSubscription(invoice)
+	if details == nil {
+		logger.Debug("Failed invoice is not for a subscription", map[string]interface{}{
+			"invoice_id": invoice.ID,
+		})
+		return nil
+	}
+
+	license, err := s.Storage.FindLicenseBySubscription(ctx, details.Subscription.ID)
+	if err != nil {
+		return fmt.Errorf("failed to look up license for subscription: %w", err)
+	}
+	if license == nil || license.Status == models.StatusRevoked {
+		logger.Warn("No license to dun for failed invoice", map[string]interface{}{
+			"invoice_id":      invoice.ID,
+			"subscription_id": details.Subscription.ID,
+		})
+		return nil
+	}
+
+	now := time.Now()
+
+	dunning, err := s.Storage.GetDunning(ctx, details.Subscription.ID)
+	if err != nil {
+		return fmt.Errorf("failed to look up dunning: %w", err)
+	}
+	if dunning != nil && dunning.EndedAt == nil {
+		dunning.InvoiceID = invoice.ID
+		if url := paymentUpdateURL(invoice); url != "" {
+			dunning.PaymentURL = url
+		}
+		dunning.UpdatedAt = now
+
+		if err := s.Storage.SaveDunning(ctx, dunning); err != nil {
+			return fmt.Errorf("failed to save dunning: %w", err)
+		}
+		return nil
+	}
+
+	if dunning != nil && dunning.InvoiceID == invoice.ID {
+		logger.Info("Grace period already ended for failed invoice", map[string]interface{}{
+			"license_id":      license.ID,
+			"subscription_id": details.Subscription.ID,
+			"invoice_id":      invoice.ID,
+		})
+		return nil
+	}
+
+	// An expired license stays expired until the invoice is paid, and a
+	// suspended one is not lifted by a failed payment
+	if license.Status != models.StatusActive {
+		logger.Info("No grace period for inactive license", map[string]interface{}{
+			"license_id":      license.ID,
+			"subscription_id": details.Subscription.ID,
+			"invoice_id":      invoice.ID,
+			"status":          license.Status,
+		})
+		return nil
+	}
+
+	dunning = &models.Dunning{
+		SubscriptionID: details.Subscription.ID,
+		LicenseID:      license.ID,
+		InvoiceID:      invoice.ID,
+		Email:          invoice.CustomerEmail,
+		PaymentURL:     paymentUpdateURL(invoice),
+		GraceEndsAt:    now.Add(dunningGracePeriod()),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	dunning.NextReminderAt = nextDunningReminder(dunning)
+
+	// The failed renewal is due at the end of the paid period, so without
+	// this the expiry sweep would end the license before the grace period.
+	// The license is active, so this only moves its expiry
+	if err := s.renewSubscriptionLicense(ctx, license, dunning.GraceEndsAt, now); err != nil {
+		return err
+	}
+
+	if err := s.Storage.SaveDunning(ctx, dunning); err != nil {
+		return fmt.Errorf("failed to save dunning: %w", err)
+	}
+
+	logger.Info("Dunning started", map[string]interface{}{
+		"license_id":      license.ID,
+		"subscription_id": dunning.SubscriptionID,
+		"invoice_id":      invoice.ID,
+		"grace_ends_at":   dunning.GraceEndsAt.Format(time.RFC3339),
+	})
+
+	s.sendDunningEmail(ctx, dunning, license, "Auto-Focus+ payment failed",
+		"We couldn't process the latest payment for your Auto-Focus+ subscription.")
+	return nil
+}
+
+// resolveDunning stops dunning for subscriptionID, once its invoice is paid
+// or the subscription has ended.
+func (s *Server) resolveDunning(ctx context.Context, subscriptionID string) error {
+	dunning, err := s.Storage.GetDunning(ctx, subscriptionID)
+	if err != nil {
+		return fmt.Errorf("failed to look up dunning: %w", err)
+	}
+	if dunning == nil {
+		return nil
+	}
+
+	if err := s.Storage.DeleteDunning(ctx, subscriptionID); err != nil {
+		return err
+	}
+
+	logger.Info("Dunning resolved", map[string]interface{}{
+		"license_id":      dunning.LicenseID,
+		"subscription_id": subscriptionID,
+		"reminders_sent":  dunning.RemindersSent,
+	})
+	return nil
+}
+
+// RunDunning sends due payment reminders and ends expired grace periods
+// every interval until ctx is cancelled.
+func (s *Server) RunDunning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.ProcessDunnings(ctx, time.Now()); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Dunning run failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDunnings sends every reminder due at now and expires the licenses
+// whose grace period has ended, returning how many dunnings it handled. A
+// dunning that fails is logged and retried on the next run.
+func (s *Server) ProcessDunnings(ctx context.Context, now time.Time) (int, error) {
+	dunnings, err := s.Storage.FindDueDunnings(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, dunning := range dunnings {
+		if err := s.processDunning(ctx, dunning, now); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to process dunning", map[string]interface{}{
+				"error":           err.Error(),
+				"license_id":      dunning.LicenseID,
+				"subscription_id": dunning.SubscriptionID,
+			})
+			continue
+		}
+		processed++
+	}
+
+	if processed > 0 {
+		logger.Info("Dunning run finished", map[string]interface{}{
+			"processed": processed,
+		})
+	}
+
+	return processed, nil
+}
+
+func (s *Server) processDunning(ctx context.Context, dunning *models.Dunning, now time.Time) error {
+	license, err := s.Storage.GetLicense(ctx, dunning.LicenseID)
+	if err != nil {
+		return fmt.Errorf("failed to look up license: %w", err)
+	}
+
+	// A revoked license won't come back by paying, so stop chasing it
+	if license == nil || license.Status == models.StatusRevoked {
+		return s.Storage.DeleteDunning(ctx, dunning.SubscriptionID)
+	}
+	if !now.Before(dunning.GraceEndsAt) {
+		return s.endDunning(ctx, dunning, license, now)
+	}
+	return s.sendDunningReminder(ctx, dunning, license, now)
+}
+
+func (s *Server) sendDunningReminder(ctx context.Context, dunning *models.Dunning, license *models.License, now time.Time) error {
+	dunning.RemindersSent++
+	dunning.NextReminderAt = nextDunningReminder(dunning)
+	dunning.UpdatedAt = now
+
+	if err := s.Storage.SaveDunning(ctx, dunning); err != nil {
+		return fmt.Errorf("failed to save dunning: %w", err)
+	}
+
+	// A suspended license keeps its schedule, but isn't asked to pay for a
+	// license it can't use
+	if license.Status != models.StatusActive {
+		return nil
+	}
+
+	days := int(math.Ceil(dunning.GraceEndsAt.Sub(now).Hours() / 24))
+	s.sendDunningEmail(ctx, dunning, license, "Reminder: Auto-Focus+ payment failed",
+		fmt.Sprintf("The latest payment for your Auto-Focus+ subscription is still outstanding. Your license stays active for %d more day(s).", days))
+	return nil
+}
+
+// endDunning expires the license of a subscription that stayed unpaid
+// through its grace period. Paying the invoice later reactivates it. The
+// dunning is kept, marked ended, until then.
+func (s *Server) endDunning(ctx context.Context, dunning *models.Dunning, license *models.License, now time.Time) error {
+	if license.Status != models.StatusRevoked {
+		if err := s.endSubscriptionLicense(ctx, license, dunning.GraceEndsAt, now); err != nil {
+			return err
+		}
+	}
+
+	dunning.EndedAt = &now
+	dunning.NextReminderAt = nil
+	dunning.UpdatedAt = now
+	if err := s.Storage.SaveDunning(ctx, dunning); err != nil {
+		return fmt.Errorf("failed to save dunning: %w", err)
+	}
+
+	logger.Info("Dunning grace period ended", map[string]interface{}{
+		"license_id":      license.ID,
+		"subscription_id": dunning.SubscriptionID,
+		"status":          license.Status,
+	})
+
+	if license.Status == models.StatusExpired {
+		s.sendDunningEmail(ctx, dunning, license, "Your Auto-Focus+ license has expired",
+			"We still couldn't process the payment for your Auto-Focus+ subscription, so your license has expired.")
+	}
+	return nil
+}
+
+func (s *Server) sendDunningEmail(ctx context.Context, dunning *models.Dunning, license *models.License, subject, message string) {
+	customerName := "there"
+	customerEmail := dunning.Email
+
+	customer, err := s.Storage.GetCustomer(ctx, license.CustomerID)
+	if err == nil && customer != nil {
+		if customer.Name != "" {
+			customerName = strings.Split(customer.Name, " ")[0] // Use first name only
+		}
+		if customerEmail == "" {
+			customerEmail = customer.Email
+		}
+	}
+
+	if customerEmail == "" {
+		logger.Warn("No email address for dunning email", map[string]interface{}{
+			"license_id":      license.ID,
+			"subscription_id": dunning.SubscriptionID,
+		})
+		return
+	}
+
+	body := fmt.Sprintf(`Hello %s,
+
+%s
+
+License Key: %s
+
+UPDATE YOUR PAYMENT METHOD
+%s
+
+Once the payment goes through, your license keeps working without any
+changes in the app. If you have any questions, reply to this email or
+contact us at help@auto-focus.app
+
+Best regards,
+The Auto-Focus Team`,
+		customerName,
+		message,
+		license.Key,
+		dunning.PaymentURL)
+
+	if err := email.Send(customerEmail, subject, body); err != nil {
+		logger.Error("Failed to send dunning email", map[string]interface{}{
+			"error":           err.Error(),
+			"license_id":      license.ID,
+			"subscription_id": dunning.SubscriptionID,
+		})
+		return
+	}
+
+	logger.Info("Dunning email sent", map[string]interface{}{
+		"license_id":      license.ID,
+		"subscription_id": dunning.SubscriptionID,
+		"reminders_sent":  dunning.RemindersSent,
+	})
+}
+
+// nextDunningReminder returns when the reminder after those already sent is
+// due, or nil if the schedule has none left before the grace period ends.
+func nextDunningReminder(dunning *models.Dunning) *time.Time {
+	schedule := dunningReminderSchedule()
+	if dunning.RemindersSent >= len(schedule) {
+		return nil
+	}
+
+	at := dunning.CreatedAt.Add(schedule[dunning.RemindersSent])
+	if !at.Before(dunning.GraceEndsAt) {
+		return nil
+	}
+	return &at
+}
+
+// paymentUpdateURL links to the invoice's hosted page, where the customer
+// can pay with a new card, or to PAYMENT_UPDATE_URL when it has none.
+func paymentUpdateURL(invoice *stripe.Invoice) string {
+	if invoice.HostedInvoiceURL != "" {
+		return invoice.HostedInvoiceURL
+	}
+	return os.Getenv("PAYMENT_UPDATE_URL")
+}
+
+func dunningGracePeriod() time.Duration {
+	if period, err := time.ParseDuration(os.Getenv("DUNNING_GRACE_PERIOD")); err == nil && period > 0 {
+		return period
+	}
+	return defaultDunningGracePeriod
+}
+
+// dunningReminderSchedule reads DUNNING_REMINDERS, a comma-separated list of
+// delays after the first failure ("72h,168h").
+func dunningReminderSchedule() []time.Duration {
+	value := os.Getenv("DUNNING_REMINDERS")
+	if value == "" {
+		return defaultDunningReminders
+	}
+
+	var schedule []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		delay, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || delay <= 0 {
+			logger.Warn("Ignoring invalid dunning reminder delay", map[string]interface{}{
+				"value": part,
+			})
+			continue
+		}
+		schedule = append(schedule, delay)
+	}
+
+	slices.Sort(schedule)
+	return schedule
+}
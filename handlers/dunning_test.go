@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+)
+
+func createMockInvoicePaymentFailedEvent(id, subscriptionID string) map[string]interface{} {
+	event := createMockInvoicePaidEvent(id, subscriptionID, time.Now())
+	event["id"] = "evt_failed_" + id
+	event["type"] = "invoice.payment_failed"
+
+	invoice := event["data"].(map[string]interface{})["object"].(map[string]interface{})
+	invoice["status"] = "open"
+	invoice["amount_paid"] = 0
+	invoice["hosted_invoice_url"] = "https://invoice.stripe.com/i/" + id
+
+	return event
+}
+
+func TestStripeWebhook_InvoicePaymentFailedStartsDunning(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+	t.Setenv("DUNNING_GRACE_PERIOD", "240h")
+	t.Setenv("DUNNING_REMINDERS", "168h,48h")
+
+	storage := createSubscriptionTestStorage(time.Now().Add(time.Hour))
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockInvoicePaymentFailedEvent("in_failed", "sub_test")); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	dunning, exists := storage.Dunnings["sub_test"]
+	if !exists {
+		t.Fatal("Expected dunning started for sub_test")
+	}
+	if dunning.LicenseID != "license-1" || dunning.PaymentURL != "https://invoice.stripe.com/i/in_failed" {
+		t.Errorf("Unexpected dunning: %+v", dunning)
+	}
+	if want := dunning.CreatedAt.Add(240 * time.Hour); !dunning.GraceEndsAt.Equal(want) {
+		t.Errorf("Expected grace period to end at %v, got %v", want, dunning.GraceEndsAt)
+	}
+	if dunning.NextReminderAt == nil || !dunning.NextReminderAt.Equal(dunning.CreatedAt.Add(48*time.Hour)) {
+		t.Errorf("Expected first reminder after 48h, got %v", dunning.NextReminderAt)
+	}
+
+	// The license keeps working through the grace period
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusActive || license.ExpiresAt == nil || !license.ExpiresAt.Equal(dunning.GraceEndsAt) {
+		t.Errorf("Expected active license until %v, got %s until %v", dunning.GraceEndsAt, license.Status, license.ExpiresAt)
+	}
+
+	// Stripe retrying the payment must not restart the schedule
+	if code := deliverStripeEvent(t, server, createMockInvoicePaymentFailedEvent("in_retry", "sub_test")); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	retried := storage.Dunnings["sub_test"]
+	if !retried.CreatedAt.Equal(dunning.CreatedAt) || !retried.GraceEndsAt.Equal(dunning.GraceEndsAt) {
+		t.Errorf("Expected schedule kept on retry, got %+v", retried)
+	}
+	if retried.InvoiceID != "in_retry" {
+		t.Errorf("Expected latest invoice recorded, got %s", retried.InvoiceID)
+	}
+}
+
+func TestStripeWebhook_InvoicePaidResolvesDunning(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createSubscriptionTestStorage(time.Now().Add(time.Hour))
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockInvoicePaymentFailedEvent("in_failed", "sub_test")); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	periodEnd := time.Now().AddDate(0, 1, 0).Truncate(time.Second)
+	if code := deliverStripeEvent(t, server, createMockInvoicePaidEvent("in_failed", "sub_test", periodEnd)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if _, exists := storage.Dunnings["sub_test"]; exists {
+		t.Error("Expected dunning resolved once the invoice was paid")
+	}
+	if license := storage.Licenses["license-1"]; !license.ExpiresAt.Equal(periodEnd) {
+		t.Errorf("Expected license to expire at %v, got %v", periodEnd, license.ExpiresAt)
+	}
+}
+
+func TestProcessDunnings(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+	t.Setenv("DUNNING_GRACE_PERIOD", "3h")
+	t.Setenv("DUNNING_REMINDERS", "1h,2h,5h")
+
+	ctx := context.Background()
+	storage := createSubscriptionTestStorage(time.Now().Add(time.Hour))
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockInvoicePaymentFailedEvent("in_failed", "sub_test")); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	start := storage.Dunnings["sub_test"].CreatedAt
+
+	steps := []struct {
+		after         time.Duration
+		wantProcessed int
+		wantReminders int
+	}{
+		{after: 30 * time.Minute, wantProcessed: 0, wantReminders: 0},
+		{after: time.Hour, wantProcessed: 1, wantReminders: 1},
+		{after: 90 * time.Minute, wantProcessed: 0, wantReminders: 1},
+		{after: 2 * time.Hour, wantProcessed: 1, wantReminders: 2},
+	}
+
+	for _, step := range steps {
+		processed, err := server.ProcessDunnings(ctx, start.Add(step.after))
+		if err != nil {
+			t.Fatalf("Unexpected error after %v: %v", step.after, err)
+		}
+		if processed != step.wantProcessed {
+			t.Errorf("Expected %d processed after %v, got %d", step.wantProcessed, step.after, processed)
+		}
+		if sent := storage.Dunnings["sub_test"].RemindersSent; sent != step.wantReminders {
+			t.Errorf("Expected %d reminders after %v, got %d", step.wantReminders, step.after, sent)
+		}
+	}
+
+	// The last reminder would fall after the grace period
+	if next := storage.Dunnings["sub_test"].NextReminderAt; next != nil {
+		t.Errorf("Expected no reminder left, got %v", next)
+	}
+
+	if storage.Licenses["license-1"].Status != models.StatusActive {
+		t.Fatalf("Expected license active during grace period, got %s", storage.Licenses["license-1"].Status)
+	}
+
+	if processed, err := server.ProcessDunnings(ctx, start.Add(3*time.Hour)); err != nil || processed != 1 {
+		t.Fatalf("Expected grace period end processed, got %d (%v)", processed, err)
+	}
+
+	if dunning := storage.Dunnings["sub_test"]; dunning.EndedAt == nil {
+		t.Error("Expected dunning marked ended after grace period")
+	}
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusExpired || license.ExpiredAt == nil {
+		t.Errorf("Expected license expired after grace period, got %s", license.Status)
+	}
+
+	if processed, err := server.ProcessDunnings(ctx, start.Add(4*time.Hour)); err != nil || processed != 0 {
+		t.Errorf("Expected nothing left to process once ended, got %d (%v)", processed, err)
+	}
+}
+
+func TestStripeWebhook_InvoicePaymentFailedAfterGracePeriod(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+	t.Setenv("DUNNING_GRACE_PERIOD", "3h")
+
+	storage := createSubscriptionTestStorage(time.Now().Add(time.Hour))
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockInvoicePaymentFailedEvent("in_failed", "sub_test")); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	ended := storage.Dunnings["sub_test"]
+	if _, err := server.ProcessDunnings(context.Background(), ended.GraceEndsAt); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ended = storage.Dunnings["sub_test"]
+	expiresAt := *storage.Licenses["license-1"].ExpiresAt
+
+	// Stripe retrying the same invoice must not grant another grace period
+	event := createMockInvoicePaymentFailedEvent("in_failed", "sub_test")
+	event["id"] = "evt_failed_retry"
+	if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusExpired || !license.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected license to stay expired at %v, got %s until %v", expiresAt, license.Status, license.ExpiresAt)
+	}
+	if dunning := storage.Dunnings["sub_test"]; dunning.EndedAt == nil || !dunning.CreatedAt.Equal(ended.CreatedAt) {
+		t.Errorf("Expected the ended dunning kept, got %+v", dunning)
+	}
+
+	// Paying the invoice still brings the license back
+	periodEnd := time.Now().AddDate(0, 1, 0).Truncate(time.Second)
+	if code := deliverStripeEvent(t, server, createMockInvoicePaidEvent("in_failed", "sub_test", periodEnd)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if _, exists := storage.Dunnings["sub_test"]; exists {
+		t.Error("Expected dunning resolved once the invoice was paid")
+	}
+	if status := storage.Licenses["license-1"].Status; status != models.StatusActive {
+		t.Errorf("Expected license reactivated by the payment, got %s", status)
+	}
+}
+
+func TestStripeWebhook_InvoicePaymentFailedInactiveLicense(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	for _, status := range []string{models.StatusExpired, models.StatusSuspended} {
+		t.Run(status, func(t *testing.T) {
+			storage := createSubscriptionTestStorage(time.Now().Add(-time.Hour))
+			license := storage.Licenses["license-1"]
+			license.SetStatus(status, "", time.Now())
+			storage.Licenses["license-1"] = license
+			expiresAt := *license.ExpiresAt
+			server := newTestServer(t, storage)
+
+			if code := deliverStripeEvent(t, server, createMockInvoicePaymentFailedEvent("in_failed", "sub_test")); code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+			}
+
+			if _, exists := storage.Dunnings["sub_test"]; exists {
+				t.Errorf("Expected no grace period for a %s license", status)
+			}
+			license = storage.Licenses["license-1"]
+			if license.Status != status || !license.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("Expected license left %s until %v, got %s until %v", status, expiresAt, license.Status, license.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestStripeWebhook_SubscriptionEndedResolvesDunning(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createSubscriptionTestStorage(time.Now().Add(time.Hour))
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockInvoicePaymentFailedEvent("in_failed", "sub_test")); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := deliverStripeEvent(t, server, createMockSubscriptionEvent("evt_sub_deleted", "customer.subscription.deleted", "canceled", time.Now())); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if _, exists := storage.Dunnings["sub_test"]; exists {
+		t.Error("Expected dunning resolved once the subscription ended")
+	}
+	if status := storage.Licenses["license-1"].Status; status != models.StatusExpired {
+		t.Errorf("Expected license expired with its subscription, got %s", status)
+	}
+}
+
+// failingLicenseStorage fails to look up one license.
+type failingLicenseStorage struct {
+	*storage.MemoryStorage
+	failing string
+}
+
+func (s *failingLicenseStorage) GetLicense(ctx context.Context, id string) (*models.License, error) {
+	if id == s.failing {
+		return nil, context.DeadlineExceeded
+	}
+	return s.MemoryStorage.GetLicense(ctx, id)
+}
+
+func TestProcessDunnings_SkipsRevokedAndFailedLicenses(t *testing.T) {
+	now := time.Now()
+	reminderAt := now.Add(-time.Minute)
+
+	memory := createTestStorage()
+	for _, id := range []string{"license-1", "license-2"} {
+		license := memory.Licenses[id]
+		license.Status = models.StatusRevoked
+		memory.Licenses[id] = license
+	}
+	active := memory.Licenses["license-1"]
+	active.ID = "license-3"
+	active.Key = "AFP-3a3a3a3a"
+	active.Status = models.StatusActive
+	memory.Licenses["license-3"] = active
+
+	memory.Dunnings = map[string]models.Dunning{}
+	for i, licenseID := range []string{"license-1", "license-2", "license-3"} {
+		subscriptionID := fmt.Sprintf("sub_%d", i+1)
+		memory.Dunnings[subscriptionID] = models.Dunning{
+			SubscriptionID: subscriptionID,
+			LicenseID:      licenseID,
+			NextReminderAt: &reminderAt,
+			GraceEndsAt:    now.Add(time.Hour),
+			CreatedAt:      now.Add(-time.Hour),
+		}
+	}
+
+	server := newTestServer(t, &failingLicenseStorage{MemoryStorage: memory, failing: "license-2"})
+
+	processed, err := server.ProcessDunnings(context.Background(), now)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if processed != 2 {
+		t.Errorf("Expected 2 dunnings processed past the failed one, got %d", processed)
+	}
+
+	if _, exists := memory.Dunnings["sub_1"]; exists {
+		t.Errorf("Expected the revoked license's dunning dropped")
+	}
+	if dunning := memory.Dunnings["sub_2"]; dunning.RemindersSent != 0 {
+		t.Errorf("Expected the failed dunning kept for the next run, got %+v", dunning)
+	}
+	if dunning := memory.Dunnings["sub_3"]; dunning.RemindersSent != 1 {
+		t.Errorf("Expected a reminder for the active license, got %+v", dunning)
+	}
+}
+
+func TestStripeWebhook_InvoicePaymentFailedExtendsSeats(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createTeamTestStorage(2)
+	team := storage.Licenses["team-1"]
+	team.StripeSubscriptionID = "sub_team"
+	expiresAt := time.Now().Add(time.Hour)
+	team.ExpiresAt = &expiresAt
+	storage.Licenses["team-1"] = team
+	server := newTestServer(t, storage)
+
+	assigned := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "one@example.com"}))
+	seatKey := assigned.Assignments[0].LicenseKey
+
+	if code := deliverStripeEvent(t, server, createMockInvoicePaymentFailedEvent("in_team", "sub_team")); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	graceEndsAt := storage.Dunnings["sub_team"].GraceEndsAt
+	seat, _ := storage.FindLicenseByKey(context.Background(), seatKey)
+	if seat.ExpiresAt == nil || !seat.ExpiresAt.Equal(graceEndsAt) {
+		t.Errorf("Expected seat to last the grace period until %v, got %v", graceEndsAt, seat.ExpiresAt)
+	}
+}
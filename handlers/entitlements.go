@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/licensekey"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+)
+
+type EntitlementsRequest struct {
+	LicenseKey   string   `json:"license_key"`
+	Entitlements []string `json:"entitlements"`
+}
+
+type EntitlementsResponse struct {
+	LicenseKey   string   `json:"license_key"`
+	Entitlements []string `json:"entitlements"`
+}
+
+// LicenseEntitlements lets admins read (GET ?license_key=) or replace (POST)
+// the features a license unlocks.
+func (s *Server) LicenseEntitlements(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req EntitlementsRequest
+	switch r.Method {
+	case "GET":
+		req.LicenseKey = r.URL.Query().Get("license_key")
+	case "POST":
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+	default:
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only GET and POST allowed")
+		return
+	}
+
+	if strings.TrimSpace(req.LicenseKey) == "" {
+		writeErrorResponse(w, http.StatusBadRequest, "license_key required")
+		return
+	}
+
+	license, err := s.Storage.FindLicenseByKey(ctx, licensekey.Normalize(req.LicenseKey))
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error while fetch license", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if license == nil {
+		writeErrorResponse(w, http.StatusNotFound, "license not found")
+		return
+	}
+
+	if r.Method == "POST" {
+		now := time.Now()
+		previous := license.Entitlements
+		license.Entitlements = models.NormalizeEntitlements(req.Entitlements)
+		license.UpdatedAt = now
+
+		err := s.Storage.SaveLicense(ctx, license)
+		if err == nil {
+			// Seats copy a team's entitlements, so they change along with it
+			err = s.syncSeats(ctx, license, now)
+		}
+		if err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to save entitlements", map[string]interface{}{
+				"error":      err.Error(),
+				"license_id": license.ID,
+			})
+			writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+			return
+		}
+
+		logger.Info("License entitlements updated", map[string]interface{}{
+			"license_id": license.ID,
+			"previous":   previous,
+			"current":    license.Entitlements,
+		})
+	}
+
+	response := EntitlementsResponse{
+		LicenseKey:   license.Key,
+		Entitlements: license.Entitlements,
+	}
+	if response.Entitlements == nil {
+		response.Entitlements = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode entitlements response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// productEntitlements returns the entitlements a purchase of productID
+// grants. PRODUCT_ENTITLEMENTS holds them in the form
+// "prod_a=slack_sync|advanced_stats,prod_b=slack_sync".
+func productEntitlements(productID string) []string {
+	for _, entry := range strings.Split(os.Getenv("PRODUCT_ENTITLEMENTS"), ",") {
+		product, entitlements, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || product != productID {
+			continue
+		}
+		return models.NormalizeEntitlements(strings.Split(entitlements, "|"))
+	}
+
+	return nil
+}
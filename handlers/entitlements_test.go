@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"auto-focus.app/cloud/internal/signing"
+)
+
+func makeEntitlementsRequest(server *Server, method, token string, reqBody EntitlementsRequest) *httptest.ResponseRecorder {
+	var req *http.Request
+	if method == http.MethodGet {
+		req = httptest.NewRequest(method, "/v1/admin/licenses/entitlements?license_key="+reqBody.LicenseKey, nil)
+	} else {
+		body, _ := json.Marshal(reqBody)
+		req = httptest.NewRequest(method, "/v1/admin/licenses/entitlements", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	server.Mux.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestLicenseEntitlements_Update(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	w := makeEntitlementsRequest(server, http.MethodPost, "admin-secret", EntitlementsRequest{
+		LicenseKey:   "AFP-7a11d123",
+		Entitlements: []string{"slack_sync", " advanced_stats", "slack_sync", ""},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	expected := []string{"advanced_stats", "slack_sync"}
+	if got := storage.Licenses["license-1"].Entitlements; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected stored entitlements %v, got %v", expected, got)
+	}
+
+	w = makeEntitlementsRequest(server, http.MethodGet, "admin-secret", EntitlementsRequest{LicenseKey: "AFP-7a11d123"})
+	var response EntitlementsResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+	if !reflect.DeepEqual(response.Entitlements, expected) {
+		t.Errorf("Expected entitlements %v, got %v", expected, response.Entitlements)
+	}
+}
+
+func TestLicenseEntitlements_UpdateReachesSeats(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+
+	storage := createTeamTestStorage(2)
+	server := newTestServer(t, storage)
+
+	response := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "member@example.com"}))
+	seatKey := response.Assignments[0].LicenseKey
+
+	w := makeEntitlementsRequest(server, http.MethodPost, "admin-secret", EntitlementsRequest{
+		LicenseKey:   "AFP-7ea40001",
+		Entitlements: []string{"slack_sync"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	seat, _ := storage.FindLicenseByKey(context.Background(), seatKey)
+	if !reflect.DeepEqual(seat.Entitlements, []string{"slack_sync"}) {
+		t.Errorf("Expected the seat to get the team's new entitlements, got %v", seat.Entitlements)
+	}
+}
+
+func TestLicenseEntitlements_Errors(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+
+	server := newTestServer(t, createTestStorage())
+
+	if w := makeEntitlementsRequest(server, http.MethodGet, "", EntitlementsRequest{LicenseKey: "AFP-7a11d123"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	if w := makeEntitlementsRequest(server, http.MethodGet, "admin-secret", EntitlementsRequest{LicenseKey: "AFP-00000404"}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for unknown key, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if w := makeEntitlementsRequest(server, http.MethodPost, "admin-secret", EntitlementsRequest{}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d without key, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestValidateLicense_SignedEntitlements(t *testing.T) {
+	t.Setenv("HMAC_SECRET", "test-secret")
+
+	storage := createTestStorage()
+	license := storage.Licenses["license-1"]
+	license.Entitlements = []string{"advanced_stats", "slack_sync"}
+	storage.Licenses["license-1"] = license
+
+	server := newTestServer(t, storage)
+
+	validate := func(payloadVersion int) ValidateResponse {
+		body, _ := json.Marshal(LicenseRequest{
+			LicenseKey:     "AFP-7a11d123",
+			AppVersion:     "1.4.11",
+			PayloadVersion: payloadVersion,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		server.ValidateLicense(w, req)
+
+		var response ValidateResponse
+		_ = json.NewDecoder(w.Body).Decode(&response)
+		return response
+	}
+
+	signer := signing.NewHMACSigner([]byte("test-secret"))
+
+	// Old clients keep receiving the payload they verify today
+	response := validate(payloadVersionLegacy)
+	legacy := fmt.Sprintf("%t|%s|%d", response.Valid, response.Message, response.Timestamp)
+	if response.Signature != signer.Sign([]byte(legacy)) {
+		t.Errorf("Expected legacy signature for clients without payload_version")
+	}
+	if response.Entitlements != nil {
+		t.Errorf("Expected no unsigned entitlements, got %v", response.Entitlements)
+	}
+
+	response = validate(payloadVersionEntitlements)
+	if response.PayloadVersion != payloadVersionEntitlements {
+		t.Errorf("Expected payload_version %d, got %d", payloadVersionEntitlements, response.PayloadVersion)
+	}
+	if !reflect.DeepEqual(response.Entitlements, license.Entitlements) {
+		t.Errorf("Expected entitlements %v, got %v", license.Entitlements, response.Entitlements)
+	}
+
+	payload := fmt.Sprintf("%t|%s|%d|1.4.11|advanced_stats,slack_sync", response.Valid, response.Message, response.Timestamp)
+	if response.Signature != signer.Sign([]byte(payload)) {
+		t.Errorf("Expected signature to cover entitlements")
+	}
+}
+
+func TestJoinEntitlements_EscapesSeparators(t *testing.T) {
+	joined := joinEntitlements([]string{"slack,sync", "stats|pro", `back\slash`})
+	if expected := `slack\,sync,stats\|pro,back\\slash`; joined != expected {
+		t.Errorf("Expected %s, got %s", expected, joined)
+	}
+
+	// Without escaping these two lists would sign the same payload
+	if joinEntitlements([]string{"a,b"}) == joinEntitlements([]string{"a", "b"}) {
+		t.Errorf("Expected distinct entitlement lists to join differently")
+	}
+}
+
+func TestProductEntitlements(t *testing.T) {
+	t.Setenv("PRODUCT_ENTITLEMENTS", "prod_pro=slack_sync|advanced_stats, prod_basic=slack_sync")
+
+	tests := map[string][]string{
+		"prod_pro":   {"advanced_stats", "slack_sync"},
+		"prod_basic": {"slack_sync"},
+		"prod_other": nil,
+	}
+
+	for productID, expected := range tests {
+		if got := productEntitlements(productID); !reflect.DeepEqual(got, expected) {
+			t.Errorf("productEntitlements(%q) = %v, expected %v", productID, got, expected)
+		}
+	}
+}
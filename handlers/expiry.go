@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+)
+
+// RunExpirySweep expires licenses past their ExpiresAt every interval until
+// ctx is cancelled.
+func (s *Server) RunExpirySweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.SweepExpiredLicenses(ctx, time.Now()); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("License expiry sweep failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepExpiredLicenses moves every active license that expired before now
+// to StatusExpired and returns how many were updated. A license renewed or
+// changed since it was found is left alone, and one that fails to save is
+// logged and retried on the next sweep.
+func (s *Server) SweepExpiredLicenses(ctx context.Context, now time.Time) (int, error) {
+	licenses, err := s.Storage.FindExpiredLicenses(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, license := range licenses {
+		previousStatus := license.Status
+		license.SetStatus(models.StatusExpired, "", now)
+		license.ExpiredAt = &now
+
+		updated, err := s.Storage.UpdateLicenseStatus(ctx, license, previousStatus)
+		if err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to expire license", map[string]interface{}{
+				"error":      err.Error(),
+				"license_id": license.ID,
+			})
+			continue
+		}
+		if !updated {
+			continue
+		}
+		expired++
+
+		logger.Info("License expired", map[string]interface{}{
+			"license_id": license.ID,
+			"expires_at": license.ExpiresAt.Format(time.RFC3339),
+		})
+	}
+
+	if expired > 0 {
+		logger.Info("License expiry sweep finished", map[string]interface{}{
+			"expired": expired,
+		})
+	}
+
+	return expired, nil
+}
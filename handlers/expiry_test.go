@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+)
+
+func TestValidateLicense_PastExpiryBeforeSweep(t *testing.T) {
+	storage := createTestStorage()
+	expired := storage.Licenses["license-1"]
+	expiresAt := time.Now().Add(-time.Hour)
+	expired.ExpiresAt = &expiresAt
+	storage.Licenses["license-1"] = expired
+	server := newTestServer(t, storage)
+
+	body, _ := json.Marshal(LicenseRequest{
+		LicenseKey:     "AFP-7a11d123",
+		AppVersion:     "1.0.0",
+		PayloadVersion: payloadVersionCodes,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+
+	var response ValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if response.Valid {
+		t.Errorf("Expected expired license to be invalid")
+	}
+
+	if response.Code != CodeLicenseExpired {
+		t.Errorf("Expected code '%s', got '%s'", CodeLicenseExpired, response.Code)
+	}
+
+	// Status is still active until the sweep runs
+	if storage.Licenses["license-1"].Status != models.StatusActive {
+		t.Errorf("Expected validation not to change license status")
+	}
+}
+
+func TestSweepExpiredLicenses(t *testing.T) {
+	storage := createTestStorage()
+	now := time.Now()
+
+	past := now.Add(-time.Minute)
+	expiring := storage.Licenses["license-1"]
+	expiring.ExpiresAt = &past
+	storage.Licenses["license-1"] = expiring
+
+	future := now.Add(24 * time.Hour)
+	storage.Licenses["license-3"] = models.License{
+		ID:         "license-3",
+		Key:        "AFP-f07e0000",
+		CustomerID: "test-customer-1",
+		ProductID:  "prod_test123",
+		Version:    "1.0.0",
+		Status:     models.StatusActive,
+		ExpiresAt:  &future,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	server := newTestServer(t, storage)
+
+	expired, err := server.SweepExpiredLicenses(context.Background(), now)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if expired != 1 {
+		t.Errorf("Expected 1 expired license, got %d", expired)
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusExpired {
+		t.Errorf("Expected status %s, got %s", models.StatusExpired, license.Status)
+	}
+	if license.ExpiredAt == nil || !license.ExpiredAt.Equal(now) {
+		t.Errorf("Expected ExpiredAt to record the sweep time, got %v", license.ExpiredAt)
+	}
+
+	if storage.Licenses["license-3"].Status != models.StatusActive {
+		t.Errorf("Expected license with future expiry to stay active")
+	}
+
+	// A second sweep has nothing left to do
+	expired, _ = server.SweepExpiredLicenses(context.Background(), now)
+	if expired != 0 {
+		t.Errorf("Expected 0 expired licenses on second sweep, got %d", expired)
+	}
+}
+
+// renewingStorage renews a license right after the sweep found it expired
+// and fails to update another.
+type renewingStorage struct {
+	*storage.MemoryStorage
+	renew   string
+	failing string
+}
+
+func (s *renewingStorage) FindExpiredLicenses(ctx context.Context, now time.Time) ([]*models.License, error) {
+	licenses, err := s.MemoryStorage.FindExpiredLicenses(ctx, now)
+
+	renewed := s.Licenses[s.renew]
+	expiresAt := now.Add(30 * 24 * time.Hour)
+	renewed.ExpiresAt = &expiresAt
+	s.Licenses[s.renew] = renewed
+
+	return licenses, err
+}
+
+func (s *renewingStorage) UpdateLicenseStatus(ctx context.Context, license *models.License, from string) (bool, error) {
+	if license.ID == s.failing {
+		return false, context.DeadlineExceeded
+	}
+	return s.MemoryStorage.UpdateLicenseStatus(ctx, license, from)
+}
+
+func TestSweepExpiredLicenses_SkipsRenewedAndFailedLicenses(t *testing.T) {
+	memory := createTestStorage()
+	now := time.Now()
+	past := now.Add(-time.Minute)
+
+	for _, id := range []string{"license-1", "license-3", "license-4"} {
+		memory.Licenses[id] = models.License{
+			ID:         id,
+			Key:        "AFP-" + id,
+			CustomerID: "test-customer-1",
+			ProductID:  "prod_test123",
+			Status:     models.StatusActive,
+			ExpiresAt:  &past,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+	}
+
+	server := newTestServer(t, &renewingStorage{MemoryStorage: memory, renew: "license-1", failing: "license-3"})
+
+	expired, err := server.SweepExpiredLicenses(context.Background(), now)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if expired != 1 {
+		t.Errorf("Expected 1 expired license, got %d", expired)
+	}
+
+	for id, want := range map[string]string{
+		"license-1": models.StatusActive,
+		"license-3": models.StatusActive,
+		"license-4": models.StatusExpired,
+	} {
+		if status := memory.Licenses[id].Status; status != want {
+			t.Errorf("Expected %s to be %s, got %s", id, want, status)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/internal/signing"
+	"github.com/getsentry/sentry-go"
+)
+
+const (
+	legacyHMACKeyID    = "hmac-legacy"
+	legacyEd25519KeyID = "ed25519-legacy"
+)
+
+type PublicKey struct {
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"algorithm"`
+	Status    string `json:"status"`
+	PublicKey string `json:"public_key"`
+}
+
+type PublicKeysResponse struct {
+	Keys []PublicKey `json:"keys"`
+}
+
+// PublicKeys publishes the keys apps use to verify asymmetric signatures,
+// including retired ones that may still sign cached responses.
+func (s *Server) PublicKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	response := PublicKeysResponse{Keys: []PublicKey{}}
+	for _, key := range s.Keyring.Keys() {
+		publicKey := key.PublicKey()
+		if publicKey == nil {
+			continue
+		}
+		response.Keys = append(response.Keys, PublicKey{
+			KeyID:     key.ID,
+			Algorithm: key.Signer.Algorithm(),
+			Status:    key.Status,
+			PublicKey: base64.StdEncoding.EncodeToString(publicKey),
+		})
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode public keys response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// LoadKeyring reads signing keys from SIGNING_KEYS_FILE or SIGNING_KEYS.
+// Without either it falls back to HMAC_SECRET and ED25519_PRIVATE_KEY, so
+// existing deployments keep signing exactly as before. A configured keyring
+// that cannot be loaded is an error rather than a silent fallback, which
+// could mean signing with the default HMAC secret.
+func LoadKeyring() (*signing.Keyring, error) {
+	var keyring *signing.Keyring
+	var err error
+
+	if path := os.Getenv("SIGNING_KEYS_FILE"); path != "" {
+		keyring, err = signing.LoadKeyring(path)
+	} else if data := os.Getenv("SIGNING_KEYS"); data != "" {
+		keyring, err = signing.ParseKeyring([]byte(data))
+	} else {
+		keyring, err = legacyKeyring()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	// Validation responses are HMAC signed unless asked otherwise. A keyring
+	// that already holds the legacy kid, say as a retired key, has moved off
+	// it on purpose and signs with its other active keys instead.
+	if _, ok := keyring.Active(signing.AlgorithmHMAC); !ok {
+		if _, taken := keyring.Lookup(legacyHMACKeyID); !taken {
+			keyring, err = signing.NewKeyring(append(keyring.Keys(), legacyHMACKey())...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add legacy HMAC key to keyring: %w", err)
+			}
+		}
+	}
+
+	if _, ok := anyActiveKey(keyring); !ok {
+		return nil, fmt.Errorf("failed to load signing keys: no active key")
+	}
+
+	return keyring, nil
+}
+
+// anyActiveKey returns the first active key in keyring.
+func anyActiveKey(keyring *signing.Keyring) (signing.Key, bool) {
+	for _, key := range keyring.Keys() {
+		if key.Status == signing.StatusActive {
+			return key, true
+		}
+	}
+	return signing.Key{}, false
+}
+
+func legacyKeyring() (*signing.Keyring, error) {
+	keys := []signing.Key{legacyHMACKey()}
+
+	if encoded := os.Getenv("ED25519_PRIVATE_KEY"); encoded != "" {
+		privateKey, err := signing.ParseEd25519PrivateKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ED25519_PRIVATE_KEY: %w", err)
+		}
+		keys = append(keys, signing.Key{
+			ID:     legacyEd25519KeyID,
+			Status: signing.StatusActive,
+			Signer: signing.NewEd25519Signer(privateKey),
+		})
+	}
+
+	return signing.NewKeyring(keys...)
+}
+
+func legacyHMACKey() signing.Key {
+	// Get secret from environment variable
+	secret := os.Getenv("HMAC_SECRET")
+	if secret == "" {
+		// Default secret for development - change this in production!
+		secret = "auto-focus-hmac-secret-2025"
+		logger.Warn("Using default HMAC secret", map[string]interface{}{})
+	}
+
+	return signing.Key{
+		ID:     legacyHMACKeyID,
+		Status: signing.StatusActive,
+		Signer: signing.NewHMACSigner([]byte(secret)),
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"auto-focus.app/cloud/internal/signing"
+)
+
+func TestPublicKeys_WithoutEd25519Key(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/keys", nil)
+	w := httptest.NewRecorder()
+	server.Mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response PublicKeysResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if len(response.Keys) != 0 {
+		t.Errorf("Expected no published keys, got %d", len(response.Keys))
+	}
+}
+
+func TestPublicKeys_PublishesEd25519Key(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+
+	server := newTestServer(t, createTestStorage())
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/keys", nil)
+	w := httptest.NewRecorder()
+	server.Mux.ServeHTTP(w, req)
+
+	var response PublicKeysResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if len(response.Keys) != 1 {
+		t.Fatalf("Expected 1 published key, got %d", len(response.Keys))
+	}
+
+	key := response.Keys[0]
+	if key.KeyID != legacyEd25519KeyID {
+		t.Errorf("Expected kid '%s', got '%s'", legacyEd25519KeyID, key.KeyID)
+	}
+
+	if key.Algorithm != signing.AlgorithmEd25519 {
+		t.Errorf("Expected algorithm '%s', got '%s'", signing.AlgorithmEd25519, key.Algorithm)
+	}
+
+	expected := base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+	if key.PublicKey != expected {
+		t.Errorf("Expected public key '%s', got '%s'", expected, key.PublicKey)
+	}
+}
+
+func TestPublicKeys_InvalidMethod(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/keys", nil)
+	w := httptest.NewRecorder()
+	server.PublicKeys(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	_, retiredKey, _ := ed25519.GenerateKey(nil)
+	_, activeKey, _ := ed25519.GenerateKey(nil)
+
+	t.Setenv("SIGNING_KEYS", fmt.Sprintf(`[
+		{"kid": "ed-1", "algorithm": "ed25519", "key": "%s", "status": "retired"},
+		{"kid": "ed-2", "algorithm": "ed25519", "key": "%s", "status": "active"},
+		{"kid": "hmac-1", "algorithm": "hmac-sha256", "key": "old-secret", "status": "retired"},
+		{"kid": "hmac-2", "algorithm": "hmac-sha256", "key": "new-secret", "status": "active"}
+	]`, base64.StdEncoding.EncodeToString(retiredKey.Seed()), base64.StdEncoding.EncodeToString(activeKey.Seed())))
+
+	server := newTestServer(t, createTestStorage())
+
+	// Both Ed25519 keys are published so older responses still verify
+	req := httptest.NewRequest(http.MethodGet, "/v1/keys", nil)
+	w := httptest.NewRecorder()
+	server.PublicKeys(w, req)
+
+	var keys PublicKeysResponse
+	_ = json.NewDecoder(w.Body).Decode(&keys)
+
+	if len(keys.Keys) != 2 {
+		t.Fatalf("Expected 2 published keys, got %d", len(keys.Keys))
+	}
+
+	statuses := map[string]string{}
+	for _, key := range keys.Keys {
+		statuses[key.KeyID] = key.Status
+	}
+	if statuses["ed-1"] != signing.StatusRetired || statuses["ed-2"] != signing.StatusActive {
+		t.Errorf("Expected ed-1 retired and ed-2 active, got %v", statuses)
+	}
+
+	// New responses are signed with the active keys
+	for algorithm, expectedKid := range map[string]string{
+		signing.AlgorithmEd25519: "ed-2",
+		signing.AlgorithmHMAC:    "hmac-2",
+	} {
+		body, _ := json.Marshal(LicenseRequest{
+			LicenseKey:         "AFP-7a11d123",
+			AppVersion:         "1.0.0",
+			SignatureAlgorithm: algorithm,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		server.ValidateLicense(w, req)
+
+		var response ValidateResponse
+		_ = json.NewDecoder(w.Body).Decode(&response)
+
+		if response.KeyID != expectedKid {
+			t.Errorf("Expected %s response signed with kid '%s', got '%s'", algorithm, expectedKid, response.KeyID)
+		}
+	}
+}
+
+func TestKeyring_InvalidConfigFailsToLoad(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	seed := base64.StdEncoding.EncodeToString(privateKey.Seed())
+
+	tests := map[string]map[string]string{
+		"malformed keys":         {"SIGNING_KEYS": `not json`},
+		"missing keys file":      {"SIGNING_KEYS_FILE": filepath.Join(t.TempDir(), "missing.json")},
+		"invalid legacy ed25519": {"ED25519_PRIVATE_KEY": "not a key"},
+		"no active key":          {"SIGNING_KEYS": fmt.Sprintf(`[{"kid": "%s", "algorithm": "ed25519", "key": "%s", "status": "retired"}]`, legacyHMACKeyID, seed)},
+	}
+
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("HMAC_SECRET", "legacy-secret")
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+
+			if keyring, err := LoadKeyring(); err == nil {
+				t.Errorf("Expected an error, got keyring with %d keys", len(keyring.Keys()))
+			}
+		})
+	}
+}
+
+func TestKeyring_AddsLegacyHMACWhenMissing(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("SIGNING_KEYS", fmt.Sprintf(`[{"kid": "ed-1", "algorithm": "ed25519", "key": "%s", "status": "active"}]`,
+		base64.StdEncoding.EncodeToString(privateKey.Seed())))
+
+	keyring, err := LoadKeyring()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, ok := keyring.Active(signing.AlgorithmHMAC); !ok {
+		t.Errorf("Expected an active HMAC key to be added")
+	}
+	if _, ok := keyring.Active(signing.AlgorithmEd25519); !ok {
+		t.Errorf("Expected the configured Ed25519 key to stay active")
+	}
+}
+
+func TestKeyring_KeepsRetiredLegacyHMACKey(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("SIGNING_KEYS", fmt.Sprintf(`[
+		{"kid": "%s", "algorithm": "hmac-sha256", "key": "old-secret", "status": "retired"},
+		{"kid": "ed-1", "algorithm": "ed25519", "key": "%s", "status": "active"}
+	]`, legacyHMACKeyID, base64.StdEncoding.EncodeToString(privateKey.Seed())))
+
+	keyring, err := LoadKeyring()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if key, ok := keyring.Lookup(legacyHMACKeyID); !ok || key.Status != signing.StatusRetired {
+		t.Errorf("Expected the retired legacy HMAC key to be kept as is, got %+v", key)
+	}
+
+	server := newTestServer(t, createTestStorage())
+	if key := server.signingKey(signing.AlgorithmHMAC); key.ID != "ed-1" {
+		t.Errorf("Expected responses signed with the active key, got kid '%s'", key.ID)
+	}
+}
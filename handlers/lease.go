@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/internal/signing"
+	"auto-focus.app/cloud/models"
+	"github.com/google/uuid"
+)
+
+const defaultLeaseDuration = 14 * 24 * time.Hour
+
+// LeaseToken lets the app keep working offline until ExpiresAt.
+type LeaseToken struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// LeaseClaims are the signed contents of a lease token.
+type LeaseClaims struct {
+	LeaseID   string `json:"lid"`
+	LicenseID string `json:"license_id"` // Matches revocation list entries
+	KeyHash   string `json:"key_hash"`
+	DeviceID  string `json:"device_id"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// issueLease records a lease for the requesting device and returns the
+// signed token, as a JWT when asJWT is set. Renewals reuse the device's
+// lease record, so the server always knows the latest lease handed out per
+// license and device.
+func (s *Server) issueLease(ctx context.Context, req LicenseRequest, license *models.License, asJWT bool) (*LeaseToken, error) {
+	now := time.Now()
+
+	expiresAt := now.Add(leaseDuration())
+	if license.ExpiresAt != nil && license.ExpiresAt.Before(expiresAt) {
+		expiresAt = *license.ExpiresAt
+	}
+
+	lease, err := s.Storage.FindLease(ctx, license.ID, req.DeviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find lease: %w", err)
+	}
+
+	if lease == nil {
+		lease = &models.Lease{
+			ID:        uuid.Must(uuid.NewRandom()).String(),
+			LicenseID: license.ID,
+			DeviceID:  req.DeviceID,
+			CreatedAt: now,
+		}
+	}
+
+	lease.IssuedAt = now
+	lease.ExpiresAt = expiresAt
+	lease.UpdatedAt = now
+
+	if err := s.Storage.SaveLease(ctx, lease); err != nil {
+		return nil, fmt.Errorf("failed to save lease: %w", err)
+	}
+
+	key := s.signingKey(req.SignatureAlgorithm)
+	claims := LeaseClaims{
+		LeaseID:   lease.ID,
+		LicenseID: license.ID,
+		KeyHash:   models.HashLicenseKey(license.Key),
+		DeviceID:  lease.DeviceID,
+		IssuedAt:  lease.IssuedAt.Unix(),
+		ExpiresAt: lease.ExpiresAt.Unix(),
+		Algorithm: key.Signer.Algorithm(),
+		KeyID:     key.ID,
+	}
+
+	token, err := leaseToken(key, claims, asJWT)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Lease issued", map[string]interface{}{
+		"license_id": license.ID,
+		"lease_id":   lease.ID,
+		"expires_at": lease.ExpiresAt.Format(time.RFC3339),
+	})
+
+	return &LeaseToken{
+		Token:     token,
+		ExpiresAt: lease.ExpiresAt.Unix(),
+	}, nil
+}
+
+func leaseToken(key signing.Key, claims LeaseClaims, asJWT bool) (string, error) {
+	if asJWT {
+		return key.JWT(claims)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode lease claims: %w", err)
+	}
+	return key.Token(payload), nil
+}
+
+// signingKey returns the active key for algorithm, falling back to the
+// HMAC key when no such key is configured and to any active key after that.
+func (s *Server) signingKey(algorithm string) signing.Key {
+	if key, ok := s.Keyring.Active(algorithm); ok {
+		return key
+	}
+
+	if key, ok := s.Keyring.Active(signing.AlgorithmHMAC); ok {
+		return key
+	}
+
+	// LoadKeyring guarantees at least one active key
+	key, _ := anyActiveKey(s.Keyring)
+	return key
+}
+
+func leaseDuration() time.Duration {
+	if duration, err := time.ParseDuration(os.Getenv("LEASE_DURATION")); err == nil && duration > 0 {
+		return duration
+	}
+	return defaultLeaseDuration
+}
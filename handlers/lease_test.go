@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/internal/signing"
+	"auto-focus.app/cloud/models"
+)
+
+func makeLeaseRequest(t *testing.T, server *Server, reqBody LicenseRequest) ValidateResponse {
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response ValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	return response
+}
+
+func activateTestDevice(t *testing.T, server *Server, deviceID string) {
+	if w := makeActivationRequest(t, server.ActivateLicense, "AFP-7a11d123", deviceID); w.Code != http.StatusOK {
+		t.Fatalf("Failed to activate device: %d", w.Code)
+	}
+}
+
+func decodeLeaseToken(t *testing.T, token string) (LeaseClaims, []byte, []byte) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		t.Fatalf("Expected claims.signature token, got '%s'", token)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatalf("Failed to decode claims: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("Failed to decode signature: %v", err)
+	}
+
+	var claims LeaseClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("Failed to unmarshal claims: %v", err)
+	}
+
+	return claims, []byte(parts[0]), signature
+}
+
+func TestValidateLicense_IssuesLease(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+	activateTestDevice(t, server, "device-1")
+
+	response := makeLeaseRequest(t, server, LicenseRequest{
+		LicenseKey:         "AFP-7a11d123",
+		AppVersion:         "1.4.11",
+		DeviceID:           "device-1",
+		SignatureAlgorithm: signing.AlgorithmEd25519,
+		Lease:              true,
+	})
+
+	if response.Lease == nil {
+		t.Fatalf("Expected a lease in the response")
+	}
+
+	claims, signed, signature := decodeLeaseToken(t, response.Lease.Token)
+
+	if !ed25519.Verify(privateKey.Public().(ed25519.PublicKey), signed, signature) {
+		t.Errorf("Expected lease signature to verify with the published key")
+	}
+
+	if claims.KeyHash != models.HashLicenseKey("AFP-7a11d123") {
+		t.Errorf("Expected claims bound to the license key hash")
+	}
+
+	if claims.LicenseID != "license-1" {
+		t.Errorf("Expected claims to name license-1 for revocation checks, got '%s'", claims.LicenseID)
+	}
+
+	if claims.DeviceID != "device-1" {
+		t.Errorf("Expected claims bound to device-1, got '%s'", claims.DeviceID)
+	}
+
+	if claims.KeyID != legacyEd25519KeyID || claims.Algorithm != signing.AlgorithmEd25519 {
+		t.Errorf("Expected Ed25519 kid and alg, got '%s'/'%s'", claims.KeyID, claims.Algorithm)
+	}
+
+	if got := time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second; got != defaultLeaseDuration {
+		t.Errorf("Expected lease duration %v, got %v", defaultLeaseDuration, got)
+	}
+
+	if claims.ExpiresAt != response.Lease.ExpiresAt {
+		t.Errorf("Expected response expiry to match claims")
+	}
+
+	if len(storage.Leases) != 1 {
+		t.Errorf("Expected 1 stored lease, got %d", len(storage.Leases))
+	}
+}
+
+func TestValidateLicense_RenewsLease(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+	activateTestDevice(t, server, "device-1")
+
+	reqBody := LicenseRequest{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11", DeviceID: "device-1", Lease: true}
+	first := makeLeaseRequest(t, server, reqBody)
+	second := makeLeaseRequest(t, server, reqBody)
+
+	if first.Lease == nil || second.Lease == nil {
+		t.Fatalf("Expected leases on both validations")
+	}
+
+	firstClaims, _, _ := decodeLeaseToken(t, first.Lease.Token)
+	secondClaims, _, _ := decodeLeaseToken(t, second.Lease.Token)
+
+	if firstClaims.LeaseID != secondClaims.LeaseID {
+		t.Errorf("Expected renewal to reuse lease '%s', got '%s'", firstClaims.LeaseID, secondClaims.LeaseID)
+	}
+
+	if len(storage.Leases) != 1 {
+		t.Errorf("Expected 1 stored lease after renewal, got %d", len(storage.Leases))
+	}
+}
+
+func TestValidateLicense_LeaseCappedAtLicenseExpiry(t *testing.T) {
+	storage := createTestStorage()
+	expiresAt := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	license := storage.Licenses["license-1"]
+	license.ExpiresAt = &expiresAt
+	storage.Licenses["license-1"] = license
+
+	server := newTestServer(t, storage)
+	activateTestDevice(t, server, "device-1")
+
+	response := makeLeaseRequest(t, server, LicenseRequest{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11", DeviceID: "device-1", Lease: true})
+
+	if response.Lease == nil {
+		t.Fatalf("Expected a lease in the response")
+	}
+
+	if response.Lease.ExpiresAt != expiresAt.Unix() {
+		t.Errorf("Expected lease to expire with the license at %d, got %d", expiresAt.Unix(), response.Lease.ExpiresAt)
+	}
+}
+
+func TestValidateLicense_NoLeaseWithoutRequest(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+	activateTestDevice(t, server, "device-1")
+
+	response := makeLeaseRequest(t, server, LicenseRequest{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11", DeviceID: "device-1"})
+	if response.Lease != nil {
+		t.Errorf("Expected no lease unless requested")
+	}
+
+	// Leases are bound to a device, so none without one
+	response = makeLeaseRequest(t, server, LicenseRequest{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11", Lease: true})
+	if response.Lease != nil {
+		t.Errorf("Expected no lease without a device ID")
+	}
+}
+
+func TestValidateLicense_NoLeaseForSuspendedLicense(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+	activateTestDevice(t, server, "device-1")
+
+	makeLeaseRequest(t, server, LicenseRequest{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11", DeviceID: "device-1", Lease: true})
+
+	license := storage.Licenses["license-1"]
+	license.Status = models.StatusSuspended
+	storage.Licenses["license-1"] = license
+
+	response := makeLeaseRequest(t, server, LicenseRequest{LicenseKey: "AFP-7a11d123", AppVersion: "1.4.11", DeviceID: "device-1", Lease: true})
+
+	if response.Valid {
+		t.Errorf("Expected suspended license to be invalid")
+	}
+
+	if response.Lease != nil {
+		t.Errorf("Expected no lease renewal for a suspended license")
+	}
+}
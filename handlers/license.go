@@ -1,9 +1,7 @@
 package handlers
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -11,7 +9,10 @@ import (
 	"strings"
 	"time"
 
+	"auto-focus.app/cloud/internal/licensekey"
 	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/internal/signing"
+	"auto-focus.app/cloud/internal/version"
 	"auto-focus.app/cloud/models"
 	"github.com/getsentry/sentry-go"
 )
@@ -19,15 +20,97 @@ import (
 type LicenseRequest struct {
 	LicenseKey string `json:"license_key"`
 	AppVersion string `json:"app_version"`
+	DeviceID   string `json:"device_id,omitempty"`
+	// SignatureAlgorithm lets newer apps ask for an Ed25519 signed response;
+	// older builds leave it empty and keep getting HMAC signatures.
+	SignatureAlgorithm string `json:"signature_algorithm,omitempty"`
+	// Nonce and Timestamp bind the signed response to this request. Clients
+	// that send no nonce get the unbound signature they always had.
+	Nonce     string `json:"nonce,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	// Lease asks for an offline lease token bound to DeviceID
+	Lease bool `json:"lease,omitempty"`
+	// PayloadVersion opts into signed payloads covering newer response
+	// fields; responses leave out whatever the payload does not sign. See
+	// signValidationResponse.
+	PayloadVersion int `json:"payload_version,omitempty"`
 }
 
 type ValidateResponse struct {
-	Valid     bool   `json:"valid"`
-	Message   string `json:"message"`
-	Timestamp int64  `json:"timestamp"`
-	Signature string `json:"signature"`
+	Valid              bool        `json:"valid"`
+	Message            string      `json:"message"`
+	Code               string      `json:"code,omitempty"`
+	LicensedMajor      int         `json:"licensed_major,omitempty"`
+	UpgradeURL         string      `json:"upgrade_url,omitempty"`
+	TrialDaysRemaining int         `json:"trial_days_remaining,omitempty"`
+	Entitlements       []string    `json:"entitlements,omitempty"`
+	PayloadVersion     int         `json:"payload_version,omitempty"`
+	Lease              *LeaseToken `json:"lease,omitempty"`
+	Timestamp          int64       `json:"timestamp"`
+	SignatureAlgorithm string      `json:"signature_algorithm"`
+	KeyID              string      `json:"kid"`
+	Signature          string      `json:"signature"`
 }
 
+// Codes tell apps the outcome of a validation without parsing Message. They
+// are part of the API: add new ones, never rename or reuse them.
+const (
+	CodeValid              = "valid"
+	CodeTrial              = "trial"
+	CodeInvalidRequest     = "invalid_request"
+	CodeLicenseNotFound    = "license_not_found"
+	CodeLicenseExpired     = "license_expired"
+	CodeLicenseNotActive   = "license_not_active"
+	CodeLicenseSuspended   = "license_suspended"
+	CodeLicenseRevoked     = "license_revoked"
+	CodeLicenseRefunded    = "license_refunded"
+	CodeLicenseDisputed    = "license_disputed"
+	CodeLicenseChargeback  = "license_chargeback"
+	CodeLicenseKeySharing  = "license_key_sharing"
+	CodeSeatReclaimed      = "seat_reclaimed"
+	CodeLicenseUpgraded    = "license_upgraded"
+	CodeSeatKeyRequired    = "seat_key_required"
+	CodeVersionMismatch    = "version_mismatch"
+	CodeInvalidAppVersion  = "invalid_app_version"
+	CodeDeviceNotActivated = "device_not_activated"
+	CodeNonceRejected      = "nonce_rejected"
+)
+
+// reasonCodes maps status reasons to the code reported while a license is
+// out of service for that reason.
+var reasonCodes = map[string]string{
+	models.StatusReasonRefunded:      CodeLicenseRefunded,
+	models.StatusReasonDisputed:      CodeLicenseDisputed,
+	models.StatusReasonChargeback:    CodeLicenseChargeback,
+	models.StatusReasonKeySharing:    CodeLicenseKeySharing,
+	models.StatusReasonSeatReclaimed: CodeSeatReclaimed,
+	models.StatusReasonUpgraded:      CodeLicenseUpgraded,
+}
+
+// Licenses created from checkouts without license_version metadata have no
+// Version. LEGACY_LICENSE_VERSION decides how those are treated: a version
+// string pins them to that major, "any" accepts every app version. Requests
+// without an app_version are checked as LEGACY_APP_VERSION the same way.
+const (
+	defaultLegacyLicenseVersion = "1.0.0"
+	legacyLicenseVersionAny     = "any"
+	defaultUpgradeURL           = "https://auto-focus.app/upgrade"
+)
+
+// Payload versions a client can ask to be signed. Builds that predate a
+// version keep verifying the payload they were shipped with. Every version
+// after the legacy one signs the app version the response was issued for.
+const (
+	payloadVersionLegacy       = 0
+	payloadVersionEntitlements = 1 // appends the app version and the comma separated entitlements
+	payloadVersionCodes        = 2 // also appends the code, licensed major, upgrade URL and trial days remaining
+)
+
+const (
+	nonceWindow    = 5 * time.Minute
+	maxNonceLength = 128
+)
+
 func (s *Server) ValidateLicense(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -61,7 +144,18 @@ func (s *Server) ValidateLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	license, err := s.Storage.FindLicenseByKey(ctx, req.LicenseKey)
+	if req.Nonce != "" {
+		if err := s.checkNonce(req); err != nil {
+			logger.Warn("Nonce rejected", map[string]interface{}{
+				"error":       err.Error(),
+				"remote_addr": r.RemoteAddr,
+			})
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	license, err := s.Storage.FindLicenseByKey(ctx, licensekey.Normalize(req.LicenseKey))
 	if err != nil {
 		sentry.CaptureException(err)
 		logger.Error("Error while fetch license", map[string]interface{}{
@@ -71,34 +165,272 @@ func (s *Server) ValidateLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.recordUsage(r, license, req.AppVersion)
+
+	if err := s.checkKeySharing(ctx, r, req, license); err != nil {
+		// Detection is best effort; it must not take validation down with it
+		sentry.CaptureException(err)
+		logger.Error("Key sharing check failed", map[string]interface{}{
+			"error":      err.Error(),
+			"license_id": license.ID,
+		})
+	}
+
+	response, err := s.evaluateLicense(ctx, req, license)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error while validating license", map[string]interface{}{
+			"error":      err.Error(),
+			"license_id": license.ID,
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	asJWT := wantsJWT(r)
+
+	if response.Valid && req.Lease && req.DeviceID != "" {
+		lease, err := s.issueLease(ctx, req, license, asJWT)
+		if err != nil {
+			// The license is valid either way; the app just stays online-only
+			sentry.CaptureException(err)
+			logger.Error("Failed to issue lease", map[string]interface{}{
+				"error":      err.Error(),
+				"license_id": license.ID,
+			})
+		}
+		response.Lease = lease
+	}
+
+	if asJWT {
+		s.writeValidationToken(w, req, license, response)
+		return
+	}
+
+	s.writeValidationResponse(w, req, response)
+}
+
+// evaluateLicense decides the unsigned validation outcome for req against
+// license, which is nil when the key is unknown. Errors are storage failures.
+func (s *Server) evaluateLicense(ctx context.Context, req LicenseRequest, license *models.License) (ValidateResponse, error) {
 	if license == nil {
 		logger.Warn("License not found", map[string]interface{}{
 			"license":     req.LicenseKey,
 			"app_version": req.AppVersion,
 		})
-		respondWithValidation(w, false, "license not found")
-		return
+		return ValidateResponse{
+			Valid:   false,
+			Message: "license not found",
+			Code:    CodeLicenseNotFound,
+		}, nil
 	}
 
-	if license.Status != models.StatusActive {
-		respondWithValidation(w, false, "license not active")
-		return
+	if rejection := checkLicenseStatus(license); rejection != nil {
+		return *rejection, nil
+	}
+
+	if license.IsTeam() {
+		return ValidateResponse{
+			Valid:   false,
+			Message: "seat key required",
+			Code:    CodeSeatKeyRequired,
+		}, nil
+	}
+
+	if license.IsSeat() {
+		rejection, err := s.checkTeamLicense(ctx, license)
+		if err != nil {
+			return ValidateResponse{}, err
+		}
+		if rejection != nil {
+			return *rejection, nil
+		}
 	}
 
-	respondWithValidation(w, true, "license valid")
+	if rejection := checkLicenseVersion(license, req); rejection != nil {
+		return *rejection, nil
+	}
+
+	// Clients that predate activations send no device ID; they are only
+	// turned away once REQUIRE_DEVICE_ACTIVATION is switched on.
+	if req.DeviceID != "" || os.Getenv("REQUIRE_DEVICE_ACTIVATION") == "true" {
+		activation, err := s.Storage.FindActivation(ctx, license.ID, req.DeviceID)
+		if err != nil {
+			return ValidateResponse{}, fmt.Errorf("failed to fetch activation: %w", err)
+		}
+
+		if activation == nil {
+			logger.Info("Device not activated", map[string]interface{}{
+				"license_id": license.ID,
+			})
+			return ValidateResponse{
+				Valid:   false,
+				Message: "device not activated",
+				Code:    CodeDeviceNotActivated,
+			}, nil
+		}
+	}
+
+	if license.Status == models.StatusTrial {
+		return ValidateResponse{
+			Valid:              true,
+			Message:            "trial valid",
+			Code:               CodeTrial,
+			TrialDaysRemaining: trialDaysRemaining(license, time.Now()),
+			Entitlements:       license.Entitlements,
+		}, nil
+	}
+
+	return ValidateResponse{
+		Valid:        true,
+		Message:      "license valid",
+		Code:         CodeValid,
+		Entitlements: license.Entitlements,
+	}, nil
 }
 
-func respondWithValidation(w http.ResponseWriter, valid bool, message string) {
-	timestamp := time.Now().Unix()
+// checkTeamLicense returns the rejection for a seat whose team license has
+// lapsed or been disabled, or nil while the team license is in good standing.
+func (s *Server) checkTeamLicense(ctx context.Context, seat *models.License) (*ValidateResponse, error) {
+	team, err := s.Storage.GetLicense(ctx, seat.TeamLicenseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch team license: %w", err)
+	}
 
-	response := ValidateResponse{
-		Valid:     valid,
-		Message:   message,
-		Timestamp: timestamp,
+	if team == nil {
+		return &ValidateResponse{
+			Valid:   false,
+			Message: "license not active",
+			Code:    CodeLicenseNotActive,
+		}, nil
 	}
 
-	// Generate HMAC signature
-	response.Signature = generateHMACSignature(valid, message, timestamp)
+	return checkLicenseStatus(team), nil
+}
+
+// checkLicenseStatus returns the rejection for a license that is expired or
+// out of service, or nil while it is active or a running trial.
+func checkLicenseStatus(license *models.License) *ValidateResponse {
+	// The sweep only runs periodically, so check the expiry date directly too
+	if license.Status == models.StatusExpired || license.IsExpired(time.Now()) {
+		return &ValidateResponse{
+			Valid:   false,
+			Message: "license expired",
+			Code:    CodeLicenseExpired,
+		}
+	}
+
+	if license.Status == models.StatusActive || license.Status == models.StatusTrial {
+		return nil
+	}
+
+	return &ValidateResponse{
+		Valid:   false,
+		Message: "license not active",
+		Code:    inactiveLicenseCode(license),
+	}
+}
+
+// inactiveLicenseCode explains why a license is out of service, preferring
+// the recorded reason over the bare status.
+func inactiveLicenseCode(license *models.License) string {
+	if code, ok := reasonCodes[license.StatusReason]; ok {
+		return code
+	}
+
+	switch license.Status {
+	case models.StatusSuspended:
+		return CodeLicenseSuspended
+	case models.StatusRevoked:
+		return CodeLicenseRevoked
+	default:
+		return CodeLicenseNotActive
+	}
+}
+
+// checkLicenseVersion returns the rejection for an app whose major version is
+// not covered by the license, or nil when it is.
+func checkLicenseVersion(license *models.License, req LicenseRequest) *ValidateResponse {
+	licenseVersion := effectiveLicenseVersion(license)
+	appVersion := effectiveAppVersion(req)
+	if licenseVersion == legacyLicenseVersionAny || appVersion == legacyLicenseVersionAny {
+		return nil
+	}
+
+	compatible, err := version.IsCompatible(licenseVersion, appVersion)
+	if err != nil {
+		logger.Warn("Version compatibility check failed", map[string]interface{}{
+			"error":           err.Error(),
+			"license_id":      license.ID,
+			"license_version": licenseVersion,
+			"app_version":     req.AppVersion,
+		})
+		return &ValidateResponse{
+			Valid:   false,
+			Message: "invalid app version",
+			Code:    CodeInvalidAppVersion,
+		}
+	}
+
+	if !compatible {
+		// IsCompatible succeeded, so the license version parses
+		licensedMajor, _ := version.ExtractMajorVersion(licenseVersion)
+		logger.Info("License version mismatch", map[string]interface{}{
+			"license_id":      license.ID,
+			"license_version": licenseVersion,
+			"app_version":     req.AppVersion,
+		})
+		return &ValidateResponse{
+			Valid:         false,
+			Message:       "license not valid for this app version",
+			Code:          CodeVersionMismatch,
+			LicensedMajor: licensedMajor,
+			UpgradeURL:    upgradeURL(),
+		}
+	}
+
+	return nil
+}
+
+// effectiveLicenseVersion returns the version a license is checked against,
+// applying the legacy policy to licenses saved without one.
+func effectiveLicenseVersion(license *models.License) string {
+	if license.Version != "" {
+		return license.Version
+	}
+
+	legacyVersion := os.Getenv("LEGACY_LICENSE_VERSION")
+	if legacyVersion == "" {
+		legacyVersion = defaultLegacyLicenseVersion
+	}
+
+	return legacyVersion
+}
+
+// effectiveAppVersion returns the app version a request is checked as. Apps
+// that predate version checks send none and get the legacy app version.
+func effectiveAppVersion(req LicenseRequest) string {
+	if req.AppVersion != "" {
+		return req.AppVersion
+	}
+
+	legacyVersion := os.Getenv("LEGACY_APP_VERSION")
+	if legacyVersion == "" {
+		legacyVersion = defaultLegacyLicenseVersion
+	}
+
+	return legacyVersion
+}
+
+func upgradeURL() string {
+	if url := os.Getenv("UPGRADE_URL"); url != "" {
+		return url
+	}
+	return defaultUpgradeURL
+}
+
+func (s *Server) writeValidationResponse(w http.ResponseWriter, req LicenseRequest, response ValidateResponse) {
+	response = s.signedValidationResponse(req, response)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -109,31 +441,106 @@ func respondWithValidation(w http.ResponseWriter, valid bool, message string) {
 	}
 }
 
-func generateHMACSignature(valid bool, message string, timestamp int64) string {
-	// Get secret from environment variable
-	secret := os.Getenv("HMAC_SECRET")
-	if secret == "" {
-		// Default secret for development - change this in production!
-		secret = "auto-focus-hmac-secret-2025"
-		logger.Warn("Using default HMAC secret", map[string]interface{}{})
+func (s *Server) signedValidationResponse(req LicenseRequest, response ValidateResponse) ValidateResponse {
+	response.Timestamp = time.Now().Unix()
+	s.signValidationResponse(req, &response)
+	return response
+}
+
+func (s *Server) signValidationResponse(req LicenseRequest, response *ValidateResponse) {
+	// Legacy payload: valid|message|timestamp
+	algorithm := signing.AlgorithmHMAC
+	payload := fmt.Sprintf("%t|%s|%d", response.Valid, response.Message, response.Timestamp)
+
+	key := s.signingKey(req.SignatureAlgorithm)
+	if key.Signer.Algorithm() == signing.AlgorithmEd25519 {
+		// Binding key and app version stops a response from being replayed
+		// for another license or app build
+		algorithm = signing.AlgorithmEd25519
+		payload = fmt.Sprintf("%t|%s|%s|%s|%d", response.Valid, response.Message, req.LicenseKey, req.AppVersion, response.Timestamp)
 	}
 
-	// Create payload: valid|message|timestamp
-	payload := fmt.Sprintf("%t|%s|%d", valid, message, timestamp)
+	switch {
+	case req.PayloadVersion >= payloadVersionCodes:
+		response.PayloadVersion = payloadVersionCodes
+		payload = fmt.Sprintf("%s|%s|%s|%s|%d|%s|%d", payload, escapePayloadField(req.AppVersion), joinEntitlements(response.Entitlements),
+			escapePayloadField(response.Code), response.LicensedMajor, escapePayloadField(response.UpgradeURL), response.TrialDaysRemaining)
+	case req.PayloadVersion >= payloadVersionEntitlements:
+		response.PayloadVersion = payloadVersionEntitlements
+		payload = fmt.Sprintf("%s|%s|%s", payload, escapePayloadField(req.AppVersion), joinEntitlements(response.Entitlements))
+		response.Code = ""
+		response.LicensedMajor = 0
+		response.UpgradeURL = ""
+		response.TrialDaysRemaining = 0
+	default:
+		// Fields the signature does not cover are left out, so nobody can
+		// slip entitlements or codes into a response the client trusts
+		response.PayloadVersion = payloadVersionLegacy
+		response.Entitlements = nil
+		response.Code = ""
+		response.LicensedMajor = 0
+		response.UpgradeURL = ""
+		response.TrialDaysRemaining = 0
+	}
 
-	// Generate HMAC
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(payload))
-	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	response.SignatureAlgorithm = algorithm
+	response.KeyID = key.ID
+	response.Signature = key.Signer.Sign([]byte(nonceBoundPayload(payload, req)))
+}
 
-	return signature
+// payloadFieldEscaper escapes the separators of signed payloads so a field
+// value can never be read as two fields or two entitlements.
+var payloadFieldEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, `,`, `\,`)
+
+func escapePayloadField(field string) string {
+	return payloadFieldEscaper.Replace(field)
+}
+
+func joinEntitlements(entitlements []string) string {
+	escaped := make([]string, len(entitlements))
+	for i, entitlement := range entitlements {
+		escaped[i] = escapePayloadField(entitlement)
+	}
+	return strings.Join(escaped, ",")
+}
+
+// nonceBoundPayload appends the request nonce, key hash, device ID and app
+// version so a captured response is useless for any other request, key,
+// machine or app build.
+func nonceBoundPayload(payload string, req LicenseRequest) string {
+	if req.Nonce == "" {
+		return payload
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%s", payload, req.Nonce, models.HashLicenseKey(req.LicenseKey), req.DeviceID, escapePayloadField(req.AppVersion))
+}
+
+// checkNonce rejects nonces whose timestamp is outside the replay window or
+// that have already been used within it.
+func (s *Server) checkNonce(req LicenseRequest) error {
+	timestamp := time.Unix(req.Timestamp, 0)
+	age := time.Since(timestamp)
+	if age > nonceWindow || age < -nonceWindow {
+		return fmt.Errorf("stale nonce")
+	}
+
+	if !s.NonceCache.Use(req.Nonce, timestamp) {
+		return fmt.Errorf("nonce already used")
+	}
+
+	return nil
 }
 
 func (lr LicenseRequest) validate() error {
 	if strings.TrimSpace(lr.LicenseKey) == "" {
 		return fmt.Errorf("license_key required")
 	}
-	// Empty app_version will be caught by version validation logic
+	// Typos and guesses are turned away without a database lookup
+	if !licensekey.Valid(licensekey.Normalize(lr.LicenseKey)) {
+		return fmt.Errorf("malformed license_key")
+	}
+	if len(lr.Nonce) > maxNonceLength {
+		return fmt.Errorf("nonce too long")
+	}
 	return nil
 }
 
@@ -3,12 +3,18 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"auto-focus.app/cloud/internal/licensekey"
+	"auto-focus.app/cloud/internal/signing"
 	"auto-focus.app/cloud/models"
 	"auto-focus.app/cloud/storage"
 )
@@ -38,7 +44,7 @@ func createTestStorage() *storage.MemoryStorage {
 	// Add test licenses
 	storage.Licenses["license-1"] = models.License{
 		ID:         "license-1",
-		Key:        "AFP-VALID123",
+		Key:        "AFP-7a11d123",
 		CustomerID: "test-customer-1",
 		ProductID:  "prod_test123",
 		Version:    "1.0.0",
@@ -49,7 +55,7 @@ func createTestStorage() *storage.MemoryStorage {
 
 	storage.Licenses["license-2"] = models.License{
 		ID:         "license-2",
-		Key:        "AFP-SUSPENDED",
+		Key:        "AFP-5a5bed00",
 		CustomerID: "test-customer-1",
 		ProductID:  "prod_test123",
 		Version:    "1.0.0",
@@ -63,10 +69,10 @@ func createTestStorage() *storage.MemoryStorage {
 
 func TestValidateLicense_Success(t *testing.T) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	reqBody := LicenseRequest{
-		LicenseKey: "AFP-VALID123",
+		LicenseKey: "AFP-7a11d123",
 		AppVersion: "1.4.11",
 	}
 
@@ -99,10 +105,10 @@ func TestValidateLicense_Success(t *testing.T) {
 
 func TestValidateLicense_LicenseNotFound(t *testing.T) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	reqBody := LicenseRequest{
-		LicenseKey: "AFP-NOTFOUND",
+		LicenseKey: "AFP-00000404",
 		AppVersion: "1.0.0",
 	}
 
@@ -131,10 +137,10 @@ func TestValidateLicense_LicenseNotFound(t *testing.T) {
 
 func TestValidateLicense_LicenseNotActive(t *testing.T) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	reqBody := LicenseRequest{
-		LicenseKey: "AFP-SUSPENDED",
+		LicenseKey: "AFP-5a5bed00",
 		AppVersion: "1.0.0",
 	}
 
@@ -161,9 +167,353 @@ func TestValidateLicense_LicenseNotActive(t *testing.T) {
 	}
 }
 
+func TestValidateLicense_VersionMismatch(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	reqBody := LicenseRequest{
+		LicenseKey:     "AFP-7a11d123",
+		AppVersion:     "2.0.0",
+		PayloadVersion: payloadVersionCodes,
+	}
+
+	body, _ := json.Marshal(reqBody)
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response ValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if response.Valid {
+		t.Errorf("Expected invalid license for a different major version")
+	}
+
+	if response.Code != CodeVersionMismatch {
+		t.Errorf("Expected code '%s', got '%s'", CodeVersionMismatch, response.Code)
+	}
+
+	if response.LicensedMajor != 1 {
+		t.Errorf("Expected licensed major 1, got %d", response.LicensedMajor)
+	}
+
+	if response.UpgradeURL != defaultUpgradeURL {
+		t.Errorf("Expected upgrade URL '%s', got '%s'", defaultUpgradeURL, response.UpgradeURL)
+	}
+}
+
+func TestValidateLicense_InvalidAppVersion(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	tests := []struct {
+		name             string
+		appVersion       string
+		legacyAppVersion string
+		expectedValid    bool
+		expectedCode     string
+	}{
+		// Apps that predate version checks send no app_version and are
+		// checked as the legacy app version
+		{name: "missing", appVersion: "", expectedValid: true, expectedCode: CodeValid},
+		{name: "missing_newer_legacy", appVersion: "", legacyAppVersion: "2.0.0", expectedValid: false, expectedCode: CodeVersionMismatch},
+		{name: "missing_any", appVersion: "", legacyAppVersion: legacyLicenseVersionAny, expectedValid: true, expectedCode: CodeValid},
+		{name: "malformed", appVersion: "latest", expectedValid: false, expectedCode: CodeInvalidAppVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LEGACY_APP_VERSION", tt.legacyAppVersion)
+
+			body, _ := json.Marshal(LicenseRequest{
+				LicenseKey:     "AFP-7a11d123",
+				AppVersion:     tt.appVersion,
+				PayloadVersion: payloadVersionCodes,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			server.ValidateLicense(w, req)
+
+			var response ValidateResponse
+			_ = json.NewDecoder(w.Body).Decode(&response)
+
+			if response.Valid != tt.expectedValid {
+				t.Errorf("Expected valid=%v for app version '%s', got valid=%v", tt.expectedValid, tt.appVersion, response.Valid)
+			}
+
+			if response.Code != tt.expectedCode {
+				t.Errorf("Expected code '%s', got '%s'", tt.expectedCode, response.Code)
+			}
+		})
+	}
+}
+
+func TestValidateLicense_LegacyLicenseVersion(t *testing.T) {
+	tests := []struct {
+		name          string
+		legacyVersion string
+		appVersion    string
+		expectedValid bool
+	}{
+		{
+			name:          "default policy accepts v1 app",
+			legacyVersion: "",
+			appVersion:    "1.2.0",
+			expectedValid: true,
+		},
+		{
+			name:          "default policy rejects v2 app",
+			legacyVersion: "",
+			appVersion:    "2.0.0",
+			expectedValid: false,
+		},
+		{
+			name:          "pinned policy accepts v2 app",
+			legacyVersion: "2.0.0",
+			appVersion:    "2.1.0",
+			expectedValid: true,
+		},
+		{
+			name:          "any policy accepts every app",
+			legacyVersion: legacyLicenseVersionAny,
+			appVersion:    "3.0.0",
+			expectedValid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LEGACY_LICENSE_VERSION", tt.legacyVersion)
+
+			storage := createTestStorage()
+			legacy := storage.Licenses["license-1"]
+			legacy.Version = ""
+			storage.Licenses["license-1"] = legacy
+			server := newTestServer(t, storage)
+
+			body, _ := json.Marshal(LicenseRequest{
+				LicenseKey: "AFP-7a11d123",
+				AppVersion: tt.appVersion,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			server.ValidateLicense(w, req)
+
+			var response ValidateResponse
+			_ = json.NewDecoder(w.Body).Decode(&response)
+
+			if response.Valid != tt.expectedValid {
+				t.Errorf("Expected valid=%v, got valid=%v (message '%s')", tt.expectedValid, response.Valid, response.Message)
+			}
+		})
+	}
+}
+
+func TestValidateLicense_Ed25519Signature(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	reqBody := LicenseRequest{
+		LicenseKey:         "AFP-7a11d123",
+		AppVersion:         "1.4.11",
+		SignatureAlgorithm: signing.AlgorithmEd25519,
+	}
+
+	body, _ := json.Marshal(reqBody)
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+
+	var response ValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if response.SignatureAlgorithm != signing.AlgorithmEd25519 {
+		t.Fatalf("Expected algorithm '%s', got '%s'", signing.AlgorithmEd25519, response.SignatureAlgorithm)
+	}
+
+	if response.KeyID != legacyEd25519KeyID {
+		t.Errorf("Expected kid '%s', got '%s'", legacyEd25519KeyID, response.KeyID)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(response.Signature)
+	if err != nil {
+		t.Fatalf("Expected base64 signature, got error: %v", err)
+	}
+
+	payload := fmt.Sprintf("%t|%s|%s|%s|%d", response.Valid, response.Message, reqBody.LicenseKey, reqBody.AppVersion, response.Timestamp)
+	if !ed25519.Verify(privateKey.Public().(ed25519.PublicKey), []byte(payload), signature) {
+		t.Errorf("Expected signature to verify against key, app version and timestamp")
+	}
+
+	otherKey := fmt.Sprintf("%t|%s|%s|%s|%d", response.Valid, response.Message, "AFP-07e40000", reqBody.AppVersion, response.Timestamp)
+	if ed25519.Verify(privateKey.Public().(ed25519.PublicKey), []byte(otherKey), signature) {
+		t.Errorf("Expected signature not to verify for a different license key")
+	}
+}
+
+func TestValidateLicense_HMACSignatureByDefault(t *testing.T) {
+	t.Setenv("HMAC_SECRET", "test-secret")
+
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	body, _ := json.Marshal(LicenseRequest{
+		LicenseKey: "AFP-7a11d123",
+		AppVersion: "1.4.11",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+
+	var response ValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if response.SignatureAlgorithm != signing.AlgorithmHMAC {
+		t.Errorf("Expected algorithm '%s', got '%s'", signing.AlgorithmHMAC, response.SignatureAlgorithm)
+	}
+
+	if response.KeyID != legacyHMACKeyID {
+		t.Errorf("Expected kid '%s', got '%s'", legacyHMACKeyID, response.KeyID)
+	}
+
+	payload := fmt.Sprintf("%t|%s|%d", response.Valid, response.Message, response.Timestamp)
+	expected := signing.NewHMACSigner([]byte("test-secret")).Sign([]byte(payload))
+	if response.Signature != expected {
+		t.Errorf("Expected legacy HMAC signature '%s', got '%s'", expected, response.Signature)
+	}
+}
+
+func makeNonceRequest(server *Server, nonce string, timestamp int64) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(LicenseRequest{
+		LicenseKey: "AFP-7a11d123",
+		AppVersion: "1.4.11",
+		Nonce:      nonce,
+		Timestamp:  timestamp,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+	return w
+}
+
+func TestValidateLicense_NonceBoundSignature(t *testing.T) {
+	t.Setenv("HMAC_SECRET", "test-secret")
+
+	server := newTestServer(t, createTestStorage())
+
+	w := makeNonceRequest(server, "nonce-abc", time.Now().Unix())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response ValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	payload := fmt.Sprintf("%t|%s|%d|%s|%s|%s|%s", response.Valid, response.Message, response.Timestamp, "nonce-abc", models.HashLicenseKey("AFP-7a11d123"), "", "1.4.11")
+	expected := signing.NewHMACSigner([]byte("test-secret")).Sign([]byte(payload))
+	if response.Signature != expected {
+		t.Errorf("Expected nonce-bound signature '%s', got '%s'", expected, response.Signature)
+	}
+
+	legacy := signing.NewHMACSigner([]byte("test-secret")).Sign([]byte(fmt.Sprintf("%t|%s|%d", response.Valid, response.Message, response.Timestamp)))
+	if response.Signature == legacy {
+		t.Errorf("Expected nonce-bound signature to differ from the legacy signature")
+	}
+}
+
+func TestValidateLicense_NonceRejected(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	tests := []struct {
+		name      string
+		nonce     string
+		timestamp int64
+		wantErr   string
+	}{
+		{
+			name:      "stale timestamp",
+			nonce:     "nonce-stale",
+			timestamp: time.Now().Add(-10 * time.Minute).Unix(),
+			wantErr:   "stale nonce",
+		},
+		{
+			name:      "future timestamp",
+			nonce:     "nonce-future",
+			timestamp: time.Now().Add(10 * time.Minute).Unix(),
+			wantErr:   "stale nonce",
+		},
+		{
+			name:      "missing timestamp",
+			nonce:     "nonce-missing",
+			timestamp: 0,
+			wantErr:   "stale nonce",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := makeNonceRequest(server, tt.nonce, tt.timestamp)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+
+			var response map[string]string
+			_ = json.NewDecoder(w.Body).Decode(&response)
+
+			if response["error"] != tt.wantErr {
+				t.Errorf("Expected error '%s', got '%s'", tt.wantErr, response["error"])
+			}
+		})
+	}
+}
+
+func TestValidateLicense_NonceReuse(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+	now := time.Now().Unix()
+
+	if w := makeNonceRequest(server, "nonce-once", now); w.Code != http.StatusOK {
+		t.Fatalf("Expected first request to succeed, got %d", w.Code)
+	}
+
+	w := makeNonceRequest(server, "nonce-once", now)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for reused nonce, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	var response map[string]string
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if response["error"] != "nonce already used" {
+		t.Errorf("Expected error 'nonce already used', got '%s'", response["error"])
+	}
+}
+
 func TestValidateLicense_InvalidMethods(t *testing.T) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	methods := []string{"GET", "PUT", "DELETE", "PATCH"}
 
@@ -190,7 +540,7 @@ func TestValidateLicense_InvalidMethods(t *testing.T) {
 
 func TestValidateLicense_InvalidJSON(t *testing.T) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	tests := []struct {
 		name    string
@@ -243,7 +593,7 @@ func TestValidateLicense_InvalidJSON(t *testing.T) {
 
 func TestValidateLicense_RequestValidation(t *testing.T) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	tests := []struct {
 		name        string
@@ -301,10 +651,10 @@ func TestValidateLicense_RequestValidation(t *testing.T) {
 func TestValidateLicense_DatabaseError(t *testing.T) {
 	// Create a storage that will return errors
 	storage := &mockStorageWithErrors{}
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	reqBody := LicenseRequest{
-		LicenseKey: "AFP-TEST123",
+		LicenseKey: "AFP-7e570123",
 		AppVersion: "1.0.0",
 	}
 
@@ -329,10 +679,10 @@ func TestValidateLicense_DatabaseError(t *testing.T) {
 
 func TestValidateLicense_ContentTypeAndHeaders(t *testing.T) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	reqBody := LicenseRequest{
-		LicenseKey: "AFP-VALID123",
+		LicenseKey: "AFP-7a11d123",
 		AppVersion: "1.0.0",
 	}
 
@@ -352,7 +702,7 @@ func TestValidateLicense_ContentTypeAndHeaders(t *testing.T) {
 
 func TestValidateLicense_LargePayload(t *testing.T) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	// Create a large license key
 	largeLicenseKey := make([]byte, 10000)
@@ -372,13 +722,15 @@ func TestValidateLicense_LargePayload(t *testing.T) {
 	w := httptest.NewRecorder()
 	server.ValidateLicense(w, req)
 
-	// Should still process (though license won't be found)
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status %d for large payload, got %d", http.StatusOK, w.Code)
+	// Rejected as malformed without a license lookup
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for large payload, got %d", http.StatusBadRequest, w.Code)
 	}
 }
 
 func TestLicenseRequest_Validate(t *testing.T) {
+	checksummedKey := licensekey.Generate("AFP")
+
 	tests := []struct {
 		name    string
 		request LicenseRequest
@@ -387,7 +739,7 @@ func TestLicenseRequest_Validate(t *testing.T) {
 		{
 			name: "valid request",
 			request: LicenseRequest{
-				LicenseKey: "AFP-VALID123",
+				LicenseKey: "AFP-7a11d123",
 				AppVersion: "1.0.0",
 			},
 			wantErr: false,
@@ -403,11 +755,35 @@ func TestLicenseRequest_Validate(t *testing.T) {
 		{
 			name: "missing app version is ok",
 			request: LicenseRequest{
-				LicenseKey: "AFP-VALID123",
+				LicenseKey: "AFP-7a11d123",
 				AppVersion: "",
 			},
 			wantErr: false,
 		},
+		{
+			name: "checksummed key",
+			request: LicenseRequest{
+				LicenseKey: checksummedKey,
+				AppVersion: "1.0.0",
+			},
+			wantErr: false,
+		},
+		{
+			name: "checksummed key typed in lowercase",
+			request: LicenseRequest{
+				LicenseKey: strings.ToLower(checksummedKey),
+				AppVersion: "1.0.0",
+			},
+			wantErr: false,
+		},
+		{
+			name: "malformed license key",
+			request: LicenseRequest{
+				LicenseKey: "AFP-NOT-A-KEY",
+				AppVersion: "1.0.0",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -420,7 +796,7 @@ func TestLicenseRequest_Validate(t *testing.T) {
 	}
 }
 
-func TestRespondWithValidation(t *testing.T) {
+func TestWriteValidationResponse(t *testing.T) {
 	tests := []struct {
 		name    string
 		valid   bool
@@ -440,8 +816,9 @@ func TestRespondWithValidation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, createTestStorage())
 			w := httptest.NewRecorder()
-			respondWithValidation(w, tt.valid, tt.message)
+			server.writeValidationResponse(w, LicenseRequest{}, ValidateResponse{Valid: tt.valid, Message: tt.message})
 
 			var response ValidateResponse
 			_ = json.NewDecoder(w.Body).Decode(&response)
@@ -536,10 +913,118 @@ func (m *mockStorageWithErrors) FindLicensesByCustomer(ctx context.Context, cust
 	return nil, context.DeadlineExceeded
 }
 
+func (m *mockStorageWithErrors) FindLicensesByKeys(ctx context.Context, keys []string) ([]*models.License, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindLicenseByStripeSession(ctx context.Context, sessionID string) (*models.License, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindLicenseByPaymentIntent(ctx context.Context, paymentIntentID string) (*models.License, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindLicenseBySubscription(ctx context.Context, subscriptionID string) (*models.License, error) {
+	return nil, context.DeadlineExceeded
+}
+
 func (m *mockStorageWithErrors) SaveLicense(ctx context.Context, license *models.License) error {
 	return context.DeadlineExceeded
 }
 
+func (m *mockStorageWithErrors) UpdateLicenseStatus(ctx context.Context, license *models.License, from string) (bool, error) {
+	return false, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindExpiredLicenses(ctx context.Context, now time.Time) ([]*models.License, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindTrialByDevice(ctx context.Context, deviceID string) (*models.License, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindSeats(ctx context.Context, teamLicenseID string) ([]*models.License, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) CreateSeat(ctx context.Context, seat *models.License, limit int) error {
+	return context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) RecordUsage(ctx context.Context, usage []*models.LicenseUsage) error {
+	return context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) GetLicenseUsage(ctx context.Context, licenseID string) (*models.LicenseUsage, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) GetStripeEvent(ctx context.Context, id string) (*models.StripeEvent, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) SaveStripeEvent(ctx context.Context, event *models.StripeEvent) error {
+	return context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) ClaimStripeEvent(ctx context.Context, event *models.StripeEvent, staleBefore time.Time) (bool, error) {
+	return false, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) GetDunning(ctx context.Context, subscriptionID string) (*models.Dunning, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindDueDunnings(ctx context.Context, now time.Time) ([]*models.Dunning, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) SaveDunning(ctx context.Context, dunning *models.Dunning) error {
+	return context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) DeleteDunning(ctx context.Context, subscriptionID string) error {
+	return context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindActivation(ctx context.Context, licenseID, deviceID string) (*models.Activation, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindActivationsByLicense(ctx context.Context, licenseID string) ([]*models.Activation, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) SaveActivation(ctx context.Context, activation *models.Activation) error {
+	return context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) CreateActivation(ctx context.Context, activation *models.Activation, limit int) error {
+	return context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) DeleteActivation(ctx context.Context, licenseID, deviceID string) error {
+	return context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindLease(ctx context.Context, licenseID, deviceID string) (*models.Lease, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindLeasesByLicense(ctx context.Context, licenseID string) ([]*models.Lease, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) SaveLease(ctx context.Context, lease *models.Lease) error {
+	return context.DeadlineExceeded
+}
+
+func (m *mockStorageWithErrors) FindRevocationsSince(ctx context.Context, sequence int64, limit int) ([]*models.Revocation, error) {
+	return nil, context.DeadlineExceeded
+}
+
 func (m *mockStorageWithErrors) Close() error {
 	return nil
 }
@@ -547,10 +1032,10 @@ func (m *mockStorageWithErrors) Close() error {
 // Benchmark tests
 func BenchmarkValidateLicense_Success(b *testing.B) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(b, storage)
 
 	reqBody := LicenseRequest{
-		LicenseKey: "AFP-VALID123",
+		LicenseKey: "AFP-7a11d123",
 		AppVersion: "1.0.0",
 	}
 
@@ -572,10 +1057,10 @@ func BenchmarkValidateLicense_Success(b *testing.B) {
 
 func BenchmarkValidateLicense_NotFound(b *testing.B) {
 	storage := createTestStorage()
-	server := NewHttpServer(storage)
+	server := newTestServer(b, storage)
 
 	reqBody := LicenseRequest{
-		LicenseKey: "AFP-NOTFOUND",
+		LicenseKey: "AFP-00000404",
 		AppVersion: "1.0.0",
 	}
 
@@ -594,3 +1079,105 @@ func BenchmarkValidateLicense_NotFound(b *testing.B) {
 		}
 	}
 }
+
+func TestValidateLicense_StatusCodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		reason       string
+		expectedCode string
+	}{
+		{"active", models.StatusActive, "", CodeValid},
+		{"suspended by admin", models.StatusSuspended, "", CodeLicenseSuspended},
+		{"revoked", models.StatusRevoked, "", CodeLicenseRevoked},
+		{"refunded", models.StatusSuspended, models.StatusReasonRefunded, CodeLicenseRefunded},
+		{"open dispute", models.StatusSuspended, models.StatusReasonDisputed, CodeLicenseDisputed},
+		{"lost dispute", models.StatusRevoked, models.StatusReasonChargeback, CodeLicenseChargeback},
+		{"key sharing", models.StatusSuspended, models.StatusReasonKeySharing, CodeLicenseKeySharing},
+		{"expired", models.StatusExpired, "", CodeLicenseExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := createTestStorage()
+			license := storage.Licenses["license-1"]
+			license.SetStatus(tt.status, tt.reason, time.Now())
+			storage.Licenses["license-1"] = license
+
+			server := newTestServer(t, storage)
+			response := validateLicenseKey(t, server, "AFP-7a11d123")
+
+			if response.Code != tt.expectedCode {
+				t.Errorf("Expected code '%s', got '%s'", tt.expectedCode, response.Code)
+			}
+		})
+	}
+
+	server := newTestServer(t, createTestStorage())
+	if response := validateLicenseKey(t, server, "AFP-00000404"); response.Code != CodeLicenseNotFound {
+		t.Errorf("Expected code '%s' for unknown key, got '%s'", CodeLicenseNotFound, response.Code)
+	}
+}
+
+func TestValidateLicense_SignedCode(t *testing.T) {
+	t.Setenv("HMAC_SECRET", "test-secret")
+
+	server := newTestServer(t, createTestStorage())
+
+	body, _ := json.Marshal(LicenseRequest{
+		LicenseKey:     "AFP-5a5bed00",
+		AppVersion:     "1.4.11",
+		PayloadVersion: payloadVersionCodes,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+
+	var response ValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if response.PayloadVersion != payloadVersionCodes || response.Code != CodeLicenseSuspended {
+		t.Fatalf("Expected payload_version %d with code '%s', got %d/'%s'", payloadVersionCodes, CodeLicenseSuspended, response.PayloadVersion, response.Code)
+	}
+
+	payload := fmt.Sprintf("%t|%s|%d|1.4.11||%s|0||0", response.Valid, response.Message, response.Timestamp, response.Code)
+	expected := signing.NewHMACSigner([]byte("test-secret")).Sign([]byte(payload))
+	if response.Signature != expected {
+		t.Errorf("Expected signature to cover the code")
+	}
+}
+
+func TestValidateLicense_SignedVersionMismatch(t *testing.T) {
+	t.Setenv("HMAC_SECRET", "test-secret")
+	t.Setenv("UPGRADE_URL", "https://example.com/upgrade?from=1|2")
+
+	server := newTestServer(t, createTestStorage())
+
+	validate := func(payloadVersion int) ValidateResponse {
+		body, _ := json.Marshal(LicenseRequest{
+			LicenseKey:     "AFP-7a11d123",
+			AppVersion:     "2.0.0",
+			PayloadVersion: payloadVersion,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		server.ValidateLicense(w, req)
+
+		var response ValidateResponse
+		_ = json.NewDecoder(w.Body).Decode(&response)
+		return response
+	}
+
+	// Unsigned fields are never sent, so they cannot be tampered with
+	response := validate(payloadVersionLegacy)
+	if response.Code != "" || response.LicensedMajor != 0 || response.UpgradeURL != "" {
+		t.Errorf("Expected no unsigned fields for legacy clients, got %+v", response)
+	}
+
+	response = validate(payloadVersionCodes)
+	payload := fmt.Sprintf("%t|%s|%d|2.0.0||%s|1|%s|0", response.Valid, response.Message, response.Timestamp, CodeVersionMismatch, `https://example.com/upgrade?from=1\|2`)
+	expected := signing.NewHMACSigner([]byte("test-secret")).Sign([]byte(payload))
+	if response.Signature != expected {
+		t.Errorf("Expected signature to cover the licensed major and escaped upgrade URL")
+	}
+}
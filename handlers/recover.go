@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"auto-focus.app/cloud/internal/email"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+)
+
+// recoveryMessage is returned for every accepted recovery request, whether or
+// not the address belongs to a customer.
+const recoveryMessage = "if that email address has licenses, we've sent them to it"
+
+type RecoverRequest struct {
+	Email string `json:"email"`
+}
+
+type RecoverResponse struct {
+	Message string `json:"message"`
+}
+
+// RecoverLicenses emails a customer all of their license keys. The response
+// never reveals whether the address is known, and each address can only be
+// mailed a few times an hour.
+func (s *Server) RecoverLicenses(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != "POST" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only POST allowed")
+		return
+	}
+
+	var req RecoverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	// One address, however it is capitalised, shares a single rate limit
+	address := strings.ToLower(strings.TrimSpace(req.Email))
+	if !strings.Contains(address, "@") {
+		writeErrorResponse(w, http.StatusBadRequest, "valid email required")
+		return
+	}
+
+	if !s.RecoveryLimitter.Allow(address) {
+		logger.Warn("Recovery rate limit exceeded", map[string]interface{}{
+			"remote_addr": r.RemoteAddr,
+		})
+		writeErrorResponse(w, http.StatusTooManyRequests, "too many recovery requests")
+		return
+	}
+
+	customer, licenses, err := s.findRecoverableLicenses(ctx, address)
+	if err != nil {
+		// Still answer as usual so failures don't stand out from unknown
+		// addresses
+		sentry.CaptureException(err)
+		logger.Error("Failed to look up licenses for recovery", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	if len(licenses) > 0 {
+		// Sending in the background keeps SMTP latency from telling
+		// customers apart from everyone else
+		go sendRecoveryEmail(customer, licenses)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(RecoverResponse{Message: recoveryMessage}); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode recovery response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// findRecoverableLicenses returns the customer at address with the licenses
+// they hold directly. Seats handed out from a team license are left out;
+// their keys were sent to the members.
+func (s *Server) findRecoverableLicenses(ctx context.Context, address string) (*models.Customer, []*models.License, error) {
+	customer, err := s.Storage.FindCustomerByEmailAddress(ctx, address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find customer: %w", err)
+	}
+	if customer == nil {
+		return nil, nil, nil
+	}
+
+	licenses, err := s.Storage.FindLicensesByCustomer(ctx, customer.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find licenses: %w", err)
+	}
+
+	var recoverable []*models.License
+	for _, license := range licenses {
+		if !license.IsSeat() {
+			recoverable = append(recoverable, license)
+		}
+	}
+
+	return customer, recoverable, nil
+}
+
+func sendRecoveryEmail(customer *models.Customer, licenses []*models.License) {
+	if err := email.Send(customer.Email, "Your Auto-Focus+ License Keys", recoveryEmailBody(customer, licenses)); err != nil {
+		logger.Error("Failed to send recovery email", map[string]interface{}{
+			"error":       err.Error(),
+			"customer_id": customer.ID,
+		})
+		return
+	}
+
+	logger.Info("Recovery email sent", map[string]interface{}{
+		"customer_id": customer.ID,
+		"licenses":    len(licenses),
+	})
+}
+
+func recoveryEmailBody(customer *models.Customer, licenses []*models.License) string {
+	customerName := "there"
+	if customer.Name != "" {
+		customerName = strings.Split(customer.Name, " ")[0] // Use first name only
+	}
+
+	var keys strings.Builder
+	for _, license := range licenses {
+		product := license.ProductName
+		if product == "" {
+			product = license.ProductID
+		}
+		fmt.Fprintf(&keys, "License Key: %s\nProduct: Auto-Focus+ (%s)\nStatus: %s\n", license.Key, product, license.Status)
+		if license.IsTeam() {
+			fmt.Fprintf(&keys, "Team Seats: %d\n", license.Seats)
+		}
+		keys.WriteString("\n")
+	}
+
+	return fmt.Sprintf(`Hello %s,
+
+We received a request to send you your Auto-Focus+ license keys.
+
+YOUR LICENSES
+%s
+If you didn't ask for this email, you can safely ignore it.
+
+NEED HELP?
+If you have any questions, reply to this email or contact us at help@auto-focus.app
+
+Best regards,
+The Auto-Focus Team`,
+		customerName,
+		keys.String())
+}
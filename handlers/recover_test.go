@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func makeRecoverRequest(server *Server, address string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RecoverRequest{Email: address})
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/recover", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.Mux.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestRecoverLicenses_SameResponseForUnknownEmail(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	known := makeRecoverRequest(server, "test@example.com")
+	unknown := makeRecoverRequest(server, "stranger@example.com")
+
+	if known.Code != http.StatusAccepted || unknown.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d for both, got %d and %d", http.StatusAccepted, known.Code, unknown.Code)
+	}
+
+	if known.Body.String() != unknown.Body.String() {
+		t.Errorf("Expected identical responses, got '%s' and '%s'", known.Body.String(), unknown.Body.String())
+	}
+}
+
+func TestRecoverLicenses_RateLimitPerEmail(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	for i := 0; i < 3; i++ {
+		if w := makeRecoverRequest(server, "test@example.com"); w.Code != http.StatusAccepted {
+			t.Fatalf("Expected request %d to be accepted, got %d", i+1, w.Code)
+		}
+	}
+
+	if w := makeRecoverRequest(server, " TEST@example.com"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d once the address is used up, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	if w := makeRecoverRequest(server, "other@example.com"); w.Code != http.StatusAccepted {
+		t.Errorf("Expected other addresses to be unaffected, got %d", w.Code)
+	}
+}
+
+func TestRecoverLicenses_InvalidEmail(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	if w := makeRecoverRequest(server, "not-an-email"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestFindRecoverableLicenses(t *testing.T) {
+	storage := createTeamTestStorage(2)
+	server := newTestServer(t, storage)
+
+	decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "member@example.com"}))
+
+	customer, licenses, err := server.findRecoverableLicenses(context.Background(), "test@example.com")
+	if err != nil || customer == nil {
+		t.Fatalf("Expected customer, got %v (%v)", customer, err)
+	}
+
+	if len(licenses) != 3 {
+		t.Errorf("Expected the two individual licenses and the team license, got %d", len(licenses))
+	}
+
+	body := recoveryEmailBody(customer, licenses)
+	for _, key := range []string{"AFP-7a11d123", "AFP-5a5bed00", "AFP-7ea40001"} {
+		if !strings.Contains(body, key) {
+			t.Errorf("Expected recovery email to include %s", key)
+		}
+	}
+
+	if !strings.Contains(body, "Team Seats: 2") {
+		t.Errorf("Expected recovery email to list team seats")
+	}
+
+	for _, license := range licenses {
+		if license.IsSeat() {
+			t.Errorf("Expected seats to be left out, got %s", license.Key)
+		}
+	}
+
+	if customer, _, _ := server.findRecoverableLicenses(context.Background(), "Test@Example.com"); customer == nil {
+		t.Errorf("Expected the address to match regardless of case")
+	}
+
+	_, licenses, err = server.findRecoverableLicenses(context.Background(), "stranger@example.com")
+	if err != nil || licenses != nil {
+		t.Errorf("Expected no licenses for an unknown address, got %d (%v)", len(licenses), err)
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/email"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/stripe/stripe-go/v82"
+)
+
+// handleChargeRefunded applies a full or partial refund of a charge. Stripe
+// sends the charge's total refunded amount, so redeliveries and several
+// partial refunds add up correctly.
+func (s *Server) handleChargeRefunded(ctx context.Context, charge *stripe.Charge) error {
+	if charge.PaymentIntent == nil {
+		logger.Warn("Refunded charge has no payment intent", map[string]interface{}{
+			"charge_id": charge.ID,
+		})
+		return nil
+	}
+
+	license, err := s.findLicenseByPayment(ctx, charge.PaymentIntent.ID)
+	if err != nil || license == nil {
+		return err
+	}
+
+	return s.applyRefund(ctx, license, charge.AmountRefunded)
+}
+
+// handleRefund applies refund.* events. A refund that fails or is canceled
+// gives the money back to us, so its amount is taken off again.
+func (s *Server) handleRefund(ctx context.Context, refund *stripe.Refund) error {
+	if refund.PaymentIntent == nil {
+		logger.Warn("Refund has no payment intent", map[string]interface{}{
+			"refund_id": refund.ID,
+		})
+		return nil
+	}
+
+	license, err := s.findLicenseByPayment(ctx, refund.PaymentIntent.ID)
+	if err != nil || license == nil {
+		return err
+	}
+
+	refunded := license.RefundedAmount
+	switch refund.Status {
+	case stripe.RefundStatusFailed, stripe.RefundStatusCanceled:
+		refunded = max(refunded-refund.Amount, 0)
+	case stripe.RefundStatusRequiresAction:
+		return nil
+	default:
+		// charge.refunded carries the exact total; this refund alone is a
+		// lower bound that doesn't double count redeliveries
+		refunded = max(refunded, refund.Amount)
+	}
+
+	return s.applyRefund(ctx, license, refunded)
+}
+
+// findLicenseByPayment returns the license bought with the payment intent,
+// or nil when it isn't one of ours. Subscription renewals are matched
+// through the subscription their invoice belongs to.
+func (s *Server) findLicenseByPayment(ctx context.Context, paymentIntentID string) (*models.License, error) {
+	license, err := s.Storage.FindLicenseByPaymentIntent(ctx, paymentIntentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find license by payment: %w", err)
+	}
+	if license != nil {
+		return license, nil
+	}
+
+	// Older licenses only know their checkout session
+	sessionID, err := s.CheckoutSessions.SessionForPayment(ctx, paymentIntentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find checkout session for payment: %w", err)
+	}
+	if sessionID != "" {
+		license, err = s.Storage.FindLicenseByStripeSession(ctx, sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find license by session: %w", err)
+		}
+	}
+
+	if license == nil {
+		subscriptionID, err := s.CheckoutSessions.SubscriptionForPayment(ctx, paymentIntentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find subscription for payment: %w", err)
+		}
+		if subscriptionID != "" {
+			license, err = s.Storage.FindLicenseBySubscription(ctx, subscriptionID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find license by subscription: %w", err)
+			}
+		}
+	}
+
+	if license == nil {
+		logger.Warn("No license found for payment", map[string]interface{}{
+			"payment_intent_id": paymentIntentID,
+		})
+		return nil, nil
+	}
+
+	// Refunds are tracked for the payment last refunded, such as the latest
+	// renewal of a subscription
+	if license.StripePaymentIntentID != paymentIntentID {
+		license.RefundedAmount = 0
+	}
+	license.StripePaymentIntentID = paymentIntentID
+	return license, nil
+}
+
+// applyRefund records the total refunded for license. A full refund, or
+// any refund with SUSPEND_ON_PARTIAL_REFUND, suspends an active license;
+// once the refund no longer qualifies, a license suspended for it is
+// reactivated.
+func (s *Server) applyRefund(ctx context.Context, license *models.License, refunded int64) error {
+	now := time.Now()
+	license.RefundedAmount = refunded
+	license.UpdatedAt = now
+
+	suspend := refundSuspends(license)
+
+	var suspended bool
+	switch {
+	case suspend && license.Status == models.StatusActive:
+		license.SetStatus(models.StatusSuspended, models.StatusReasonRefunded, now)
+		suspended = true
+	case !suspend && license.Status == models.StatusSuspended && license.StatusReason == models.StatusReasonRefunded:
+		license.SetStatus(models.StatusActive, "", now)
+	}
+
+	if err := s.Storage.SaveLicense(ctx, license); err != nil {
+		return fmt.Errorf("failed to save refunded license: %w", err)
+	}
+
+	logger.Info("License refund recorded", map[string]interface{}{
+		"license_id":      license.ID,
+		"refunded_amount": refunded,
+		"price_paid":      license.PricePaid,
+		"status":          license.Status,
+	})
+
+	if suspended && os.Getenv("REFUND_NOTIFY_CUSTOMER") == "true" {
+		s.sendRefundEmail(ctx, license)
+	}
+
+	return nil
+}
+
+// refundSuspends reports whether the amount refunded on license is enough to
+// keep it suspended.
+func refundSuspends(license *models.License) bool {
+	refunded := license.RefundedAmount
+	full := refunded > 0 && refunded >= license.PricePaid
+	return full || (refunded > 0 && os.Getenv("SUSPEND_ON_PARTIAL_REFUND") == "true")
+}
+
+func (s *Server) sendRefundEmail(ctx context.Context, license *models.License) {
+	customer, err := s.Storage.GetCustomer(ctx, license.CustomerID)
+	if err != nil || customer == nil {
+		logger.Warn("Could not find customer for refund email", map[string]interface{}{
+			"license_id":  license.ID,
+			"customer_id": license.CustomerID,
+		})
+		return
+	}
+
+	customerName := "there"
+	if customer.Name != "" {
+		customerName = strings.Split(customer.Name, " ")[0] // Use first name only
+	}
+
+	body := fmt.Sprintf(`Hello %s,
+
+Your refund of %s for Auto-Focus+ has been processed.
+
+License Key: %s
+
+As the purchase was refunded, this license key has been deactivated. If you
+think this is a mistake, reply to this email or contact us at help@auto-focus.app
+
+Best regards,
+The Auto-Focus Team`,
+		customerName,
+		formatPrice(license.RefundedAmount, license.Currency),
+		license.Key)
+
+	if err := email.Send(customer.Email, "Your Auto-Focus+ Refund", body); err != nil {
+		logger.Error("Failed to send refund email", map[string]interface{}{
+			"error":       err.Error(),
+			"customer_id": customer.ID,
+			"license_id":  license.ID,
+		})
+		return
+	}
+
+	logger.Info("Refund email sent", map[string]interface{}{
+		"customer_id": customer.ID,
+		"license_id":  license.ID,
+	})
+}
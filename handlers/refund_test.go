@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+	"github.com/stripe/stripe-go/v82"
+)
+
+func createRefundTestStorage() *storage.MemoryStorage {
+	storage := createTestStorage()
+	license := storage.Licenses["license-1"]
+	license.PricePaid = 2999
+	license.Currency = "usd"
+	license.StripeSessionID = "cs_refund"
+	license.StripePaymentIntentID = "pi_refund"
+	storage.Licenses["license-1"] = license
+	return storage
+}
+
+func createMockChargeRefundedEvent(paymentIntentID string, amountRefunded int64, full bool) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   "evt_charge_refunded",
+		"type": "charge.refunded",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":              "ch_refund",
+				"amount":          2999,
+				"amount_refunded": amountRefunded,
+				"refunded":        full,
+				"payment_intent":  paymentIntentID,
+			},
+		},
+	}
+}
+
+func createMockRefundEvent(eventType, status string, amount int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   "evt_" + eventType + "_" + status,
+		"type": eventType,
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":             "re_refund",
+				"amount":         amount,
+				"status":         status,
+				"payment_intent": "pi_refund",
+			},
+		},
+	}
+}
+
+func chargeRefunded(paymentIntentID string, amountRefunded int64) *stripe.Charge {
+	return &stripe.Charge{
+		ID:             "ch_refund",
+		Amount:         2999,
+		AmountRefunded: amountRefunded,
+		PaymentIntent:  &stripe.PaymentIntent{ID: paymentIntentID},
+	}
+}
+
+func TestStripeWebhook_FullRefundSuspends(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createRefundTestStorage()
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockChargeRefundedEvent("pi_refund", 2999, true)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusSuspended || license.StatusReason != models.StatusReasonRefunded {
+		t.Errorf("Expected license suspended for refund, got %s/%s", license.Status, license.StatusReason)
+	}
+	if license.RefundedAmount != 2999 {
+		t.Errorf("Expected refunded amount 2999, got %d", license.RefundedAmount)
+	}
+
+	response := validateLicenseKey(t, server, "AFP-7a11d123")
+	if response.Valid || response.Code != CodeLicenseRefunded {
+		t.Errorf("Expected refunded license to be rejected with %s, got %v/%s", CodeLicenseRefunded, response.Valid, response.Code)
+	}
+}
+
+func TestStripeWebhook_PartialRefund(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createRefundTestStorage()
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockChargeRefundedEvent("pi_refund", 1000, false)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusActive || license.RefundedAmount != 1000 {
+		t.Errorf("Expected active license with 1000 refunded, got %s/%d", license.Status, license.RefundedAmount)
+	}
+
+	t.Setenv("SUSPEND_ON_PARTIAL_REFUND", "true")
+	event := createMockChargeRefundedEvent("pi_refund", 1500, false)
+	event["id"] = "evt_second_partial_refund"
+	if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license = storage.Licenses["license-1"]
+	if license.Status != models.StatusSuspended || license.RefundedAmount != 1500 {
+		t.Errorf("Expected suspended license with 1500 refunded, got %s/%d", license.Status, license.RefundedAmount)
+	}
+}
+
+func TestStripeWebhook_FailedRefundReactivates(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createRefundTestStorage()
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockRefundEvent("refund.created", "succeeded", 2999)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if license := storage.Licenses["license-1"]; license.Status != models.StatusSuspended {
+		t.Fatalf("Expected license suspended after refund, got %s", license.Status)
+	}
+
+	if code := deliverStripeEvent(t, server, createMockRefundEvent("refund.failed", "failed", 2999)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusActive || license.StatusReason != "" || license.RefundedAmount != 0 {
+		t.Errorf("Expected license reactivated after failed refund, got %s/%s/%d", license.Status, license.StatusReason, license.RefundedAmount)
+	}
+}
+
+func TestStripeWebhook_RefundLeavesOtherStatuses(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createRefundTestStorage()
+	license := storage.Licenses["license-1"]
+	license.SetStatus(models.StatusSuspended, models.StatusReasonKeySharing, time.Now())
+	storage.Licenses["license-1"] = license
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockRefundEvent("refund.failed", "failed", 2999)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if license := storage.Licenses["license-1"]; license.StatusReason != models.StatusReasonKeySharing {
+		t.Errorf("Expected key sharing suspension to stay, got %s/%s", license.Status, license.StatusReason)
+	}
+}
+
+func TestFindLicenseByPayment_CheckoutSessionFallback(t *testing.T) {
+	storage := createRefundTestStorage()
+	license := storage.Licenses["license-1"]
+	license.StripePaymentIntentID = ""
+	storage.Licenses["license-1"] = license
+
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{payments: map[string]string{"pi_legacy": "cs_refund"}}
+
+	if err := server.handleChargeRefunded(context.Background(), chargeRefunded("pi_legacy", 2999)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	license = storage.Licenses["license-1"]
+	if license.Status != models.StatusSuspended || license.StripePaymentIntentID != "pi_legacy" {
+		t.Errorf("Expected license found through its session and backfilled, got %s/'%s'", license.Status, license.StripePaymentIntentID)
+	}
+
+	// Payments for anything else are acknowledged and ignored
+	if err := server.handleChargeRefunded(context.Background(), chargeRefunded("pi_unknown", 100)); err != nil {
+		t.Errorf("Expected unknown payment to be ignored, got %v", err)
+	}
+}
+
+func TestFindLicenseByPayment_SubscriptionRenewal(t *testing.T) {
+	storage := createSubscriptionTestStorage(time.Now().Add(time.Hour))
+	license := storage.Licenses["license-1"]
+	license.PricePaid = 999
+	license.StripeSessionID = ""
+	license.StripePaymentIntentID = "pi_first"
+	license.RefundedAmount = 500
+	storage.Licenses["license-1"] = license
+
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{subscriptions: map[string]string{"pi_renewal": "sub_test"}}
+
+	if err := server.handleChargeRefunded(context.Background(), chargeRefunded("pi_renewal", 999)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	license = storage.Licenses["license-1"]
+	if license.Status != models.StatusSuspended || license.StripePaymentIntentID != "pi_renewal" || license.RefundedAmount != 999 {
+		t.Errorf("Expected renewal refund to suspend the subscription's license, got %s/'%s'/%d", license.Status, license.StripePaymentIntentID, license.RefundedAmount)
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auto-focus.app/cloud/internal/logger"
+	"github.com/getsentry/sentry-go"
+)
+
+const revocationPageSize = 1000
+
+// RevocationEntry names the license by ID rather than a hash of its key:
+// license keys are short enough to brute-force from an unsalted hash.
+// Clients learn their license ID from the sub claim of validation tokens or
+// the license_id of leases.
+type RevocationEntry struct {
+	Sequence  int64  `json:"seq"`
+	LicenseID string `json:"license_id"`
+	Status    string `json:"status"`
+	Revoked   bool   `json:"revoked"` // false when the key was reinstated
+	ChangedAt int64  `json:"changed_at"`
+}
+
+// RevocationListClaims are the signed contents of a revocation list page.
+// Clients store Sequence and pass it as ?since= on the next sync, fetching
+// again straight away while More is set.
+type RevocationListClaims struct {
+	Since       int64             `json:"since"`
+	Sequence    int64             `json:"seq"`
+	More        bool              `json:"more"`
+	Revocations []RevocationEntry `json:"revocations"`
+	IssuedAt    int64             `json:"iat"`
+	Algorithm   string            `json:"alg"`
+	KeyID       string            `json:"kid"`
+}
+
+// RevocationListResponse carries the list as "<claims>.<signature>".
+type RevocationListResponse struct {
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"algorithm"`
+	List      string `json:"list"`
+}
+
+// Revocations publishes the signed list of licenses that have been revoked
+// or suspended, so offline and leased clients can drop them.
+func (s *Server) Revocations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	var since int64
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			writeErrorResponse(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+		since = parsed
+	}
+
+	// One extra entry tells us whether another page follows
+	revocations, err := s.Storage.FindRevocationsSince(r.Context(), since, revocationPageSize+1)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to fetch revocations", map[string]interface{}{
+			"error": err.Error(),
+			"since": since,
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	key := s.signingKey(r.URL.Query().Get("signature_algorithm"))
+	claims := RevocationListClaims{
+		Since:       since,
+		Sequence:    since,
+		More:        len(revocations) > revocationPageSize,
+		Revocations: []RevocationEntry{},
+		IssuedAt:    time.Now().Unix(),
+		Algorithm:   key.Signer.Algorithm(),
+		KeyID:       key.ID,
+	}
+
+	if claims.More {
+		revocations = revocations[:revocationPageSize]
+	}
+
+	for _, revocation := range revocations {
+		claims.Revocations = append(claims.Revocations, RevocationEntry{
+			Sequence:  revocation.Sequence,
+			LicenseID: revocation.LicenseID,
+			Status:    revocation.Status,
+			Revoked:   revocation.Revoked(),
+			ChangedAt: revocation.CreatedAt.Unix(),
+		})
+		claims.Sequence = revocation.Sequence
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode revocation list", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	response := RevocationListResponse{
+		KeyID:     key.ID,
+		Algorithm: key.Signer.Algorithm(),
+		List:      key.Token(payload),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode revocations response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
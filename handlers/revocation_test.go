@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"auto-focus.app/cloud/internal/signing"
+	"auto-focus.app/cloud/models"
+)
+
+func fetchRevocations(t *testing.T, server *Server, query string) (RevocationListResponse, RevocationListClaims) {
+	req := httptest.NewRequest(http.MethodGet, "/v1/licenses/revocations"+query, nil)
+	w := httptest.NewRecorder()
+	server.Revocations(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response RevocationListResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	parts := strings.Split(response.List, ".")
+	if len(parts) != 2 {
+		t.Fatalf("Expected claims.signature list, got '%s'", response.List)
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	var claims RevocationListClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("Failed to decode claims: %v", err)
+	}
+
+	return response, claims
+}
+
+func setLicenseStatus(t *testing.T, server *Server, id, status string) {
+	license, _ := server.Storage.GetLicense(context.Background(), id)
+	license.Status = status
+	if err := server.Storage.SaveLicense(context.Background(), license); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
+	}
+}
+
+func TestRevocations_TracksStatusChanges(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	setLicenseStatus(t, server, "license-1", models.StatusSuspended)
+	setLicenseStatus(t, server, "license-1", models.StatusActive)
+
+	_, claims := fetchRevocations(t, server, "")
+
+	if len(claims.Revocations) != 2 {
+		t.Fatalf("Expected 2 revocation entries, got %d", len(claims.Revocations))
+	}
+
+	suspended := claims.Revocations[0]
+	if suspended.LicenseID != "license-1" || !suspended.Revoked || suspended.Status != models.StatusSuspended {
+		t.Errorf("Expected suspension of license-1 first, got %+v", suspended)
+	}
+
+	if claims.Revocations[1].Revoked {
+		t.Errorf("Expected reactivation to reinstate the key")
+	}
+
+	if claims.Sequence != claims.Revocations[1].Sequence || claims.More {
+		t.Errorf("Expected seq %d and no more pages, got %d/%t", claims.Revocations[1].Sequence, claims.Sequence, claims.More)
+	}
+}
+
+func TestRevocations_Incremental(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	setLicenseStatus(t, server, "license-1", models.StatusSuspended)
+	_, first := fetchRevocations(t, server, "")
+
+	setLicenseStatus(t, server, "license-1", models.StatusRevoked)
+	_, second := fetchRevocations(t, server, "?since="+strconv.FormatInt(first.Sequence, 10))
+
+	if len(second.Revocations) != 1 || second.Revocations[0].Status != models.StatusRevoked {
+		t.Errorf("Expected only the newer revocation, got %+v", second.Revocations)
+	}
+
+	_, empty := fetchRevocations(t, server, "?since="+strconv.FormatInt(second.Sequence, 10))
+	if len(empty.Revocations) != 0 || empty.Sequence != second.Sequence {
+		t.Errorf("Expected empty page at seq %d, got %+v", second.Sequence, empty)
+	}
+}
+
+func TestRevocations_IgnoresUnrelatedStatusChanges(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	setLicenseStatus(t, server, "license-1", models.StatusExpired)
+
+	_, claims := fetchRevocations(t, server, "")
+	if len(claims.Revocations) != 0 {
+		t.Errorf("Expected expiry not to be listed, got %+v", claims.Revocations)
+	}
+
+	// A suspended license that expires stays off
+	setLicenseStatus(t, server, "license-2", models.StatusExpired)
+
+	_, claims = fetchRevocations(t, server, "")
+	if len(claims.Revocations) != 0 {
+		t.Errorf("Expected expiry of a suspended license not to reinstate it, got %+v", claims.Revocations)
+	}
+}
+
+func TestRevocations_ListsTeamSeats(t *testing.T) {
+	storage := createTeamTestStorage(2)
+	server := newTestServer(t, storage)
+
+	decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "one@example.com"}))
+	decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "two@example.com"}))
+	reclaimed := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/reclaim", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "two@example.com"}))
+	_, before := fetchRevocations(t, server, "")
+
+	setLicenseStatus(t, server, "team-1", models.StatusRevoked)
+	_, claims := fetchRevocations(t, server, "?since="+strconv.FormatInt(before.Sequence, 10))
+
+	listed := map[string]bool{}
+	for _, entry := range claims.Revocations {
+		listed[entry.LicenseID] = entry.Revoked
+	}
+	seat, _ := storage.FindLicenseByKey(context.Background(), reclaimed.Assignments[0].LicenseKey)
+	if len(listed) != 2 || !listed["team-1"] || !listed[seat.ID] {
+		t.Errorf("Expected the team and its assigned seat revoked, got %+v", claims.Revocations)
+	}
+}
+
+func TestRevocations_Ed25519Signature(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+
+	server := newTestServer(t, createTestStorage())
+	setLicenseStatus(t, server, "license-1", models.StatusSuspended)
+
+	response, claims := fetchRevocations(t, server, "?signature_algorithm="+signing.AlgorithmEd25519)
+
+	if response.KeyID != legacyEd25519KeyID || claims.KeyID != legacyEd25519KeyID {
+		t.Errorf("Expected kid '%s', got '%s'", legacyEd25519KeyID, response.KeyID)
+	}
+
+	parts := strings.Split(response.List, ".")
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if !ed25519.Verify(privateKey.Public().(ed25519.PublicKey), []byte(parts[0]), signature) {
+		t.Errorf("Expected revocation list to verify with the published key")
+	}
+}
+
+func TestRevocations_InvalidSince(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	for _, query := range []string{"?since=abc", "?since=-1"} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/licenses/revocations"+query, nil)
+		w := httptest.NewRecorder()
+		server.Revocations(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for '%s', got %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"auto-focus.app/cloud/internal/abuse"
 	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/internal/nonce"
 	"auto-focus.app/cloud/internal/ratelimit"
+	"auto-focus.app/cloud/internal/signing"
 	"auto-focus.app/cloud/storage"
 	"github.com/getsentry/sentry-go"
 )
@@ -16,23 +21,58 @@ type Server struct {
 	Mux          *http.ServeMux
 	Storage      storage.Storage
 	RateLimitter ratelimit.RateLimit
+	// RecoveryLimitter caps license recovery emails per address
+	RecoveryLimitter ratelimit.RateLimit
+	NonceCache       nonce.Cache
+	Keyring          *signing.Keyring
+	Usage            *UsageRecorder
+	AbuseTracker     abuse.Tracker
+	CheckoutSessions CheckoutSessions
 }
 
-func NewHttpServer(db storage.Storage) *Server {
+// NewHttpServer wires up the routes on db. It fails when the configured
+// signing keys cannot be loaded.
+func NewHttpServer(db storage.Storage) (*Server, error) {
 	mux := http.NewServeMux()
 
+	keyring, err := LoadKeyring()
+	if err != nil {
+		return nil, err
+	}
+
 	s := &Server{
-		Mux:          mux,
-		Storage:      db,
-		RateLimitter: ratelimit.New(10, time.Minute),
+		Mux:              mux,
+		Storage:          db,
+		RateLimitter:     ratelimit.New(10, time.Minute),
+		RecoveryLimitter: ratelimit.New(3, time.Hour),
+		NonceCache:       nonce.New(nonceWindow),
+		Keyring:          keyring,
+		Usage:            NewUsageRecorder(),
+		AbuseTracker:     abuse.New(abuseWindow()),
+		CheckoutSessions: stripeCheckoutSessions{},
 	}
 
 	mux.Handle("/v1/health", http.HandlerFunc(s.Health))
+	mux.Handle("/v1/keys", s.chain(s.withLogging)(http.HandlerFunc(s.PublicKeys)))
 	// mux.Handle("/v1/licenses", http.HandlerFunc(db.list))
 	mux.Handle("/v1/licenses/validate", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.ValidateLicense)))
+	mux.Handle("/v2/licenses/validate", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.ValidateLicense)))
+	mux.Handle("/v1/licenses/validate:batch", s.chain(s.withLogging, s.withRateLimit, s.withBatchAuth)(http.HandlerFunc(s.BatchValidateLicenses)))
+	mux.Handle("/v1/licenses/revocations", s.chain(s.withLogging, s.withRateLimit)(http.HandlerFunc(s.Revocations)))
+	mux.Handle("/v1/licenses/recover", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.RecoverLicenses)))
+	mux.Handle("/v1/licenses/upgrade", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.UpgradeLicense)))
+	mux.Handle("/v1/licenses/activate", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.ActivateLicense)))
+	mux.Handle("/v1/licenses/deactivate", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.DeactivateLicense)))
+	mux.Handle("/v1/admin/certificates", s.chain(s.withLogging, s.withRateLimit, s.withAdminAuth)(http.HandlerFunc(s.Certificate)))
+	mux.Handle("/v1/trials", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.StartTrial)))
+	mux.Handle("/v1/admin/licenses/entitlements", s.chain(s.withLogging, s.withRateLimit, s.withAdminAuth)(http.HandlerFunc(s.LicenseEntitlements)))
+	mux.Handle("/v1/admin/licenses/usage", s.chain(s.withLogging, s.withRateLimit, s.withAdminAuth)(http.HandlerFunc(s.LicenseUsage)))
+	mux.Handle("/v1/teams/seats", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.TeamSeats)))
+	mux.Handle("/v1/teams/seats/assign", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.AssignTeamSeat)))
+	mux.Handle("/v1/teams/seats/reclaim", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.ReclaimTeamSeat)))
 	mux.Handle("/v1/webhooks/stripe", s.chain(s.withCORS, s.withLogging, s.withRateLimit)(http.HandlerFunc(s.Stripe)))
 
-	return s
+	return s, nil
 }
 
 type HealthResponse struct {
@@ -125,6 +165,59 @@ func (s *Server) withRateLimit(next http.Handler) http.Handler {
 	})
 }
 
+// withAdminAuth only lets through requests bearing ADMIN_API_TOKEN. Admin
+// endpoints stay closed when no token is configured.
+func (s *Server) withAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearerToken(r, os.Getenv("ADMIN_API_TOKEN")) {
+			logger.Warn("Unauthorized admin request", map[string]interface{}{
+				"remote_addr": r.RemoteAddr,
+				"path":        r.URL.Path,
+			})
+			writeErrorResponse(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withBatchAuth lets through requests bearing ADMIN_API_TOKEN or one of the
+// comma separated FLEET_API_TOKENS handed to customers who manage their own
+// fleets, so fleets never hold the admin token.
+func (s *Server) withBatchAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens := append([]string{os.Getenv("ADMIN_API_TOKEN")}, strings.Split(os.Getenv("FLEET_API_TOKENS"), ",")...)
+		if !hasBearerToken(r, tokens...) {
+			logger.Warn("Unauthorized batch request", map[string]interface{}{
+				"remote_addr": r.RemoteAddr,
+				"path":        r.URL.Path,
+			})
+			writeErrorResponse(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasBearerToken reports whether r carries one of tokens. Empty tokens never
+// match, so an unset variable keeps its endpoints closed.
+func hasBearerToken(r *http.Request, tokens ...string) bool {
+	provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	for _, token := range tokens {
+		token = strings.TrimSpace(token)
+		if token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) withLogging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -11,6 +11,18 @@ import (
 	"auto-focus.app/cloud/storage"
 )
 
+// newTestServer creates a server on db, failing the test when the signing
+// keys in the environment cannot be loaded.
+func newTestServer(t testing.TB, db storage.Storage) *Server {
+	t.Helper()
+
+	server, err := NewHttpServer(db)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return server
+}
+
 func TestNewHttpServer(t *testing.T) {
 	db := &storage.MemoryStorage{
 		Data: storage.Database{
@@ -21,7 +33,7 @@ func TestNewHttpServer(t *testing.T) {
 		},
 	}
 
-	server := NewHttpServer(db)
+	server := newTestServer(t, db)
 
 	if server == nil {
 		t.Fatalf("Expected server to be created, got nil")
@@ -53,7 +65,7 @@ func TestNewHttpServer(t *testing.T) {
 
 func TestServer_HealthEndpoint(t *testing.T) {
 	db := &storage.MemoryStorage{Data: storage.Database{}}
-	server := NewHttpServer(db)
+	server := newTestServer(t, db)
 
 	req := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
 	w := httptest.NewRecorder()
@@ -99,7 +111,7 @@ func TestServer_HealthEndpoint(t *testing.T) {
 
 func TestServer_RoutingConfiguration(t *testing.T) {
 	db := &storage.MemoryStorage{Data: storage.Database{}}
-	server := NewHttpServer(db)
+	server := newTestServer(t, db)
 
 	tests := []struct {
 		name           string
@@ -3,16 +3,20 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"auto-focus.app/cloud/internal/email"
+	"auto-focus.app/cloud/internal/licensekey"
 	"auto-focus.app/cloud/internal/logger"
 	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
 	"github.com/getsentry/sentry-go"
 	"github.com/google/uuid"
 	"github.com/stripe/stripe-go/v82"
@@ -91,6 +95,83 @@ func (s *Server) Stripe(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("Webhook signature verified")
 	}
 
+	now := time.Now()
+	record := &models.StripeEvent{ID: event.ID, Type: string(event.Type), ReceivedAt: now, UpdatedAt: now}
+
+	claimed, err := s.Storage.ClaimStripeEvent(ctx, record, now.Add(-stripeEventProcessingTimeout))
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to record Stripe event", map[string]interface{}{
+			"error":    err.Error(),
+			"event_id": event.ID,
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !claimed {
+		existing, err := s.Storage.GetStripeEvent(ctx, event.ID)
+		if err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to look up Stripe event", map[string]interface{}{
+				"error":    err.Error(),
+				"event_id": event.ID,
+			})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Stripe redelivers events until it sees a 2xx, including ones we
+		// have already handled
+		if existing != nil && existing.Status == models.StripeEventProcessed {
+			logger.Info("Skipping already processed Stripe event", map[string]interface{}{
+				"event_type": event.Type,
+				"event_id":   event.ID,
+			})
+			writeWebhookReceived(w)
+			return
+		}
+
+		// Another delivery is still working on it; Stripe will try again
+		logger.Warn("Stripe event is already being processed", map[string]interface{}{
+			"event_type": event.Type,
+			"event_id":   event.ID,
+		})
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	if status, err := s.handleStripeEvent(ctx, event); err != nil {
+		record.Status = models.StripeEventFailed
+		record.Error = err.Error()
+		record.UpdatedAt = time.Now()
+		s.saveStripeEvent(ctx, record)
+
+		w.WriteHeader(status)
+		return
+	}
+
+	processedAt := time.Now()
+	record.Status = models.StripeEventProcessed
+	record.ProcessedAt = &processedAt
+	record.UpdatedAt = processedAt
+	s.saveStripeEvent(ctx, record)
+
+	logger.Info("Webhook processed successfully", map[string]interface{}{
+		"event_type": event.Type,
+		"event_id":   event.ID,
+	})
+
+	writeWebhookReceived(w)
+}
+
+// stripeEventProcessingTimeout is how long a delivery may hold an event
+// before a redelivery assumes it crashed and processes the event again.
+const stripeEventProcessingTimeout = 5 * time.Minute
+
+// handleStripeEvent runs the handler for event's type. On failure it
+// returns the status that tells Stripe whether to retry.
+func (s *Server) handleStripeEvent(ctx context.Context, event stripe.Event) (int, error) {
 	switch event.Type {
 	case "checkout.session.completed":
 		logger.Info("Processing checkout session completed event", map[string]interface{}{
@@ -105,8 +186,7 @@ func (s *Server) Stripe(w http.ResponseWriter, r *http.Request) {
 				"error":    err.Error(),
 				"event_id": event.ID,
 			})
-			w.WriteHeader(http.StatusBadRequest)
-			return
+			return http.StatusBadRequest, err
 		}
 
 		if err = s.handleCheckoutComplete(ctx, &checkoutSession); err != nil {
@@ -115,8 +195,121 @@ func (s *Server) Stripe(w http.ResponseWriter, r *http.Request) {
 				"error":      err.Error(),
 				"session_id": checkoutSession.ID,
 			})
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return http.StatusInternalServerError, err
+		}
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to unmarshal charge", map[string]interface{}{
+				"error":    err.Error(),
+				"event_id": event.ID,
+			})
+			return http.StatusBadRequest, err
+		}
+
+		if err := s.handleChargeRefunded(ctx, &charge); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to handle charge refund", map[string]interface{}{
+				"error":     err.Error(),
+				"charge_id": charge.ID,
+			})
+			return http.StatusInternalServerError, err
+		}
+	case "refund.created", "refund.updated", "refund.failed", "charge.refund.updated":
+		var refund stripe.Refund
+		if err := json.Unmarshal(event.Data.Raw, &refund); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to unmarshal refund", map[string]interface{}{
+				"error":    err.Error(),
+				"event_id": event.ID,
+			})
+			return http.StatusBadRequest, err
+		}
+
+		if err := s.handleRefund(ctx, &refund); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to handle refund", map[string]interface{}{
+				"error":     err.Error(),
+				"refund_id": refund.ID,
+			})
+			return http.StatusInternalServerError, err
+		}
+	case "charge.dispute.created", "charge.dispute.funds_withdrawn", "charge.dispute.closed":
+		var dispute stripe.Dispute
+		if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to unmarshal dispute", map[string]interface{}{
+				"error":    err.Error(),
+				"event_id": event.ID,
+			})
+			return http.StatusBadRequest, err
+		}
+
+		if err := s.handleDispute(ctx, string(event.Type), time.Unix(event.Created, 0), &dispute); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to handle dispute", map[string]interface{}{
+				"error":      err.Error(),
+				"dispute_id": dispute.ID,
+			})
+			return http.StatusInternalServerError, err
+		}
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var subscription stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &subscription); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to unmarshal subscription", map[string]interface{}{
+				"error":    err.Error(),
+				"event_id": event.ID,
+			})
+			return http.StatusBadRequest, err
+		}
+
+		if err := s.handleSubscription(ctx, string(event.Type), &subscription); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to handle subscription", map[string]interface{}{
+				"error":           err.Error(),
+				"subscription_id": subscription.ID,
+			})
+			return http.StatusInternalServerError, err
+		}
+	case "invoice.paid":
+		var invoice stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to unmarshal invoice", map[string]interface{}{
+				"error":    err.Error(),
+				"event_id": event.ID,
+			})
+			return http.StatusBadRequest, err
+		}
+
+		if err := s.handleInvoicePaid(ctx, &invoice); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to handle paid invoice", map[string]interface{}{
+				"error":      err.Error(),
+				"invoice_id": invoice.ID,
+			})
+			return http.StatusInternalServerError, err
+		}
+	case "invoice.payment_failed":
+		var invoice stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to unmarshal invoice", map[string]interface{}{
+				"error":    err.Error(),
+				"event_id": event.ID,
+			})
+			return http.StatusBadRequest, err
+		}
+
+		if err := s.handleInvoicePaymentFailed(ctx, &invoice); err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Failed to handle failed invoice payment", map[string]interface{}{
+				"error":      err.Error(),
+				"invoice_id": invoice.ID,
+			})
+			return http.StatusInternalServerError, err
 		}
 	default:
 		logger.Info("Unhandled webhook event type", map[string]interface{}{
@@ -125,11 +318,24 @@ func (s *Server) Stripe(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	logger.Info("Webhook processed successfully", map[string]interface{}{
-		"event_type": event.Type,
-		"event_id":   event.ID,
-	})
+	return http.StatusOK, nil
+}
 
+// saveStripeEvent updates the event log after processing. A failure here
+// is only logged: the outcome has already happened, and a redelivery is
+// caught by the license's unique checkout session.
+func (s *Server) saveStripeEvent(ctx context.Context, record *models.StripeEvent) {
+	if err := s.Storage.SaveStripeEvent(ctx, record); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to update Stripe event", map[string]interface{}{
+			"error":    err.Error(),
+			"event_id": record.ID,
+			"status":   record.Status,
+		})
+	}
+}
+
+func writeWebhookReceived(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]string{"received": "true"}); err != nil {
 		logger.Error("Failed to encode webhook response", map[string]interface{}{
@@ -170,7 +376,68 @@ func (s *Server) handleCheckoutComplete(ctx context.Context, session *stripe.Che
 		})
 	}
 
+	// Redelivered events are normally skipped by the event log; this also
+	// covers the same session arriving in two different events. Sessions
+	// standing in for a subscription invoice have no ID.
+	if session.ID != "" {
+		existing, err := s.Storage.FindLicenseByStripeSession(ctx, session.ID)
+		if err != nil {
+			return fmt.Errorf("failed to look up license for session: %w", err)
+		}
+		if existing != nil {
+			logger.Info("License already created for checkout session", map[string]interface{}{
+				"license_id": existing.ID,
+				"session_id": session.ID,
+			})
+			return nil
+		}
+	}
+
+	if session.Subscription != nil {
+		existing, err := s.findSubscriptionLicense(ctx, session)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			logger.Info("License already created for subscription", map[string]interface{}{
+				"license_id":      existing.ID,
+				"session_id":      session.ID,
+				"subscription_id": session.Subscription.ID,
+			})
+			return nil
+		}
+	}
+
+	// Webhook payloads leave out the line items, whose quantity is the
+	// number of seats a team bought
+	if session.LineItems == nil && session.ID != "" {
+		items, err := s.CheckoutSessions.LineItems(ctx, session.ID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch line items for session: %w", err)
+		}
+		session.LineItems = items
+	}
+
 	customer, license, err := s.createLicensedUser(ctx, session, customerEmail)
+	if errors.Is(err, storage.ErrDuplicateStripeSession) {
+		// A concurrent delivery of the same session got there first
+		logger.Info("License already created for checkout session", map[string]interface{}{
+			"session_id": session.ID,
+		})
+		return nil
+	}
+	if errors.Is(err, storage.ErrDuplicateStripeSubscription) {
+		// The subscription's first invoice created the license meanwhile;
+		// record the session on it
+		if _, err := s.findSubscriptionLicense(ctx, session); err != nil {
+			return err
+		}
+		logger.Info("License already created for subscription", map[string]interface{}{
+			"session_id":      session.ID,
+			"subscription_id": session.Subscription.ID,
+		})
+		return nil
+	}
 	if err != nil {
 		sentry.CaptureException(err)
 		logger.Error("Failed to create licensed user", map[string]interface{}{
@@ -197,6 +464,11 @@ func (s *Server) handleCheckoutComplete(ctx context.Context, session *stripe.Che
 
 	formattedPrice := formatPrice(license.PricePaid, license.Currency)
 
+	if license.IsTeam() {
+		sendTeamPurchaseEmail(customerEmail, customerName, license, formattedPrice)
+		return nil
+	}
+
 	body := fmt.Sprintf(`Hello %s,
 
 Thank you for purchasing Auto-Focus+! Your purchase has been processed successfully.
@@ -245,6 +517,29 @@ The Auto-Focus Team`,
 	return nil
 }
 
+// findSubscriptionLicense returns the license already created for the
+// session's subscription by its first invoice, recording the session on it.
+func (s *Server) findSubscriptionLicense(ctx context.Context, session *stripe.CheckoutSession) (*models.License, error) {
+	license, err := s.Storage.FindLicenseBySubscription(ctx, session.Subscription.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up license for subscription: %w", err)
+	}
+	if license == nil || license.StripeSessionID != "" || session.ID == "" {
+		return license, nil
+	}
+
+	license.StripeSessionID = session.ID
+	if session.PaymentIntent != nil && license.StripePaymentIntentID == "" {
+		license.StripePaymentIntentID = session.PaymentIntent.ID
+	}
+	license.UpdatedAt = time.Now()
+
+	if err := s.Storage.SaveLicense(ctx, license); err != nil {
+		return nil, fmt.Errorf("failed to save subscription license: %w", err)
+	}
+	return license, nil
+}
+
 func (s *Server) createLicensedUser(ctx context.Context, session *stripe.CheckoutSession, customerEmail string) (*models.Customer, *models.License, error) {
 	logger.Debug("Creating licensed user", map[string]interface{}{
 		"session_id": session.ID,
@@ -267,6 +562,33 @@ func (s *Server) createLicensedUser(ctx context.Context, session *stripe.Checkou
 	})
 
 	license := createLicese(customer, session)
+
+	previous, err := s.findUpgradedLicense(ctx, session)
+	if err != nil {
+		return nil, nil, err
+	}
+	if previous != nil {
+		license.UpgradedFromID = previous.ID
+	}
+
+	// A team key cannot unlock the app, so team purchases leave the trial
+	// alone rather than converting it
+	var trial *models.License
+	if !license.IsTeam() {
+		trial, err = s.findConvertibleTrial(ctx, customer, session.Metadata)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if trial != nil {
+		convertTrial(trial, license)
+		logger.Info("Converting trial to paid license", map[string]interface{}{
+			"license_id":  license.ID,
+			"customer_id": customer.ID,
+			"session_id":  session.ID,
+		})
+	}
+
 	logger.Info("License generated", map[string]interface{}{
 		"license_key": license.Key,
 		"version":     license.Version,
@@ -274,7 +596,7 @@ func (s *Server) createLicensedUser(ctx context.Context, session *stripe.Checkou
 		"customer_id": customer.ID,
 	})
 
-	err = s.Storage.SaveLicense(ctx, license)
+	err = s.saveNewLicense(ctx, license)
 	if err != nil {
 		logger.Error("Failed to save license", map[string]interface{}{
 			"error":       err.Error(),
@@ -289,6 +611,12 @@ func (s *Server) createLicensedUser(ctx context.Context, session *stripe.Checkou
 		"customer_id": customer.ID,
 	})
 
+	if previous != nil {
+		if err := s.completeUpgrade(ctx, previous, license); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	return customer, license, nil
 }
 
@@ -388,24 +716,90 @@ func createLicese(customer *models.Customer, session *stripe.CheckoutSession) *m
 		productName = "v1"
 	}
 
+	var paymentIntentID string
+	if session.PaymentIntent != nil {
+		paymentIntentID = session.PaymentIntent.ID
+	}
+
+	var subscriptionID string
+	if session.Subscription != nil {
+		subscriptionID = session.Subscription.ID
+	}
+
+	// Time-boxed products carry their duration in days; others never expire
+	var expiresAt *time.Time
+	if days, err := strconv.Atoi(session.Metadata["license_duration_days"]); err == nil && days > 0 {
+		expiry := time.Now().AddDate(0, 0, days)
+		expiresAt = &expiry
+	}
+
+	// Products may cap activations per license, e.g. single-Mac editions;
+	// others use the product's ACTIVATION_LIMITS entry
+	var maxActivations int
+	if n, err := strconv.Atoi(session.Metadata["max_activations"]); err == nil {
+		maxActivations = n
+	}
+
+	// Buying more than one seat makes a team license whose key assigns
+	// seats to members instead of unlocking the app
+	var seats int
+	if n := checkoutSeats(session); n > 1 {
+		seats = n
+	}
+
 	return &models.License{
-		ID:              uuid.Must(uuid.NewRandom()).String(),
-		Key:             generateLicenseKey(),
-		CustomerID:      customer.ID,
-		ProductID:       session.Metadata["product_id"],
-		ProductName:     productName,
-		PricePaid:       session.AmountTotal,
-		Currency:        string(session.Currency),
-		Version:         session.Metadata["license_version"],
-		Status:          models.StatusActive,
-		StripeSessionID: session.ID,
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
+		ID:                    uuid.Must(uuid.NewRandom()).String(),
+		Key:                   generateLicenseKey(session.Metadata["key_prefix"]),
+		CustomerID:            customer.ID,
+		ProductID:             session.Metadata["product_id"],
+		Entitlements:          productEntitlements(session.Metadata["product_id"]),
+		ProductName:           productName,
+		PricePaid:             session.AmountTotal,
+		Currency:              string(session.Currency),
+		Version:               session.Metadata["license_version"],
+		Seats:                 seats,
+		MaxActivations:        maxActivations,
+		Status:                models.StatusActive,
+		StripeSessionID:       session.ID,
+		StripePaymentIntentID: paymentIntentID,
+		StripeSubscriptionID:  subscriptionID,
+		ExpiresAt:             expiresAt,
+		CreatedAt:             time.Now(),
+		UpdatedAt:             time.Now(),
 	}
 }
 
-func generateLicenseKey() string {
-	return fmt.Sprintf("AFP-%s", uuid.Must(uuid.NewRandom()).String()[:8])
+// generateLicenseKey returns a checksummed key starting with the product's
+// prefix, or AFP when the product doesn't set a valid one.
+func generateLicenseKey(prefix string) string {
+	return licensekey.Generate(prefix)
+}
+
+const maxLicenseKeyAttempts = 5
+
+// saveNewLicense saves license, generating a fresh key whenever the current
+// one turns out to be taken.
+func (s *Server) saveNewLicense(ctx context.Context, license *models.License) error {
+	return saveWithUniqueKey(license, func() error {
+		return s.Storage.SaveLicense(ctx, license)
+	})
+}
+
+// saveWithUniqueKey calls save, generating a new key for license each time
+// it collides with an existing one.
+func saveWithUniqueKey(license *models.License, save func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := save()
+		if !errors.Is(err, storage.ErrDuplicateLicenseKey) || attempt == maxLicenseKeyAttempts {
+			return err
+		}
+
+		logger.Warn("License key collision, generating a new key", map[string]interface{}{
+			"license_id": license.ID,
+			"attempt":    attempt,
+		})
+		license.Key = generateLicenseKey(licensekey.Prefix(license.Key))
+	}
 }
 
 func formatPrice(amountCents int64, currency string) string {
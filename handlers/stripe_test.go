@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"auto-focus.app/cloud/internal/licensekey"
 	"auto-focus.app/cloud/models"
 	"auto-focus.app/cloud/storage"
 	"github.com/stripe/stripe-go/v82"
@@ -21,6 +23,56 @@ func createTestStorageForStripe() *storage.MemoryStorage {
 	}
 }
 
+// fakeCheckoutSessions stands in for the Stripe Checkout API.
+type fakeCheckoutSessions struct {
+	payments      map[string]string // Session ID by payment intent
+	subscriptions map[string]string // Subscription ID by renewal payment intent
+	sessions      map[string]*stripe.CheckoutSession
+	created       []*stripe.CheckoutSessionParams
+	seats         map[string]int64 // Line item quantity by session ID
+}
+
+func (f *fakeCheckoutSessions) SessionForPayment(ctx context.Context, paymentIntentID string) (string, error) {
+	return f.payments[paymentIntentID], nil
+}
+
+func (f *fakeCheckoutSessions) SubscriptionForPayment(ctx context.Context, paymentIntentID string) (string, error) {
+	return f.subscriptions[paymentIntentID], nil
+}
+
+func (f *fakeCheckoutSessions) Create(ctx context.Context, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	if f.sessions == nil {
+		f.sessions = make(map[string]*stripe.CheckoutSession)
+	}
+	f.created = append(f.created, params)
+
+	session := &stripe.CheckoutSession{
+		ID:        fmt.Sprintf("cs_fake_%d", len(f.created)),
+		Status:    stripe.CheckoutSessionStatusOpen,
+		Metadata:  params.Metadata,
+		ExpiresAt: *params.ExpiresAt,
+	}
+	session.URL = "https://checkout.stripe.com/c/pay/" + session.ID
+	f.sessions[session.ID] = session
+	return session, nil
+}
+
+func (f *fakeCheckoutSessions) Get(ctx context.Context, id string) (*stripe.CheckoutSession, error) {
+	session, exists := f.sessions[id]
+	if !exists {
+		return nil, fmt.Errorf("no such checkout session: %s", id)
+	}
+	return session, nil
+}
+
+func (f *fakeCheckoutSessions) LineItems(ctx context.Context, id string) (*stripe.LineItemList, error) {
+	quantity, exists := f.seats[id]
+	if !exists {
+		quantity = 1
+	}
+	return &stripe.LineItemList{Data: []*stripe.LineItem{{Quantity: quantity}}}, nil
+}
+
 func createMockStripeEvent(eventType string, sessionData map[string]interface{}) map[string]interface{} {
 	return map[string]interface{}{
 		"id":   "evt_test123",
@@ -55,7 +107,8 @@ func createMockCheckoutSession(customerEmail, sessionID string, hasCustomer bool
 
 func TestStripeWebhook_CheckoutSessionCompleted_Success(t *testing.T) {
 	storage := createTestStorageForStripe()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{}
 
 	// Create mock Stripe event
 	sessionData := createMockCheckoutSession("test@example.com", "cs_test123", true)
@@ -97,9 +150,100 @@ func TestStripeWebhook_CheckoutSessionCompleted_Success(t *testing.T) {
 	}
 }
 
+func deliverStripeEvent(t *testing.T, server *Server, event map[string]interface{}) int {
+	payload, _ := json.Marshal(event)
+	req := httptest.NewRequest(http.MethodPost, "/v1/webhooks/stripe", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Stripe-Signature", "test-signature")
+
+	w := httptest.NewRecorder()
+	server.Stripe(w, req)
+
+	return w.Code
+}
+
+func TestStripeWebhook_RedeliveredEvent(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createTestStorageForStripe()
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{}
+	event := createMockStripeEvent("checkout.session.completed", createMockCheckoutSession("test@example.com", "cs_redelivered", true))
+
+	for i := 0; i < 2; i++ {
+		if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+			t.Fatalf("Expected status %d on delivery %d, got %d", http.StatusOK, i+1, code)
+		}
+	}
+
+	if len(storage.Licenses) != 1 {
+		t.Errorf("Expected 1 license after redelivery, got %d", len(storage.Licenses))
+	}
+
+	record := storage.StripeEvents["evt_test123"]
+	if record.Status != models.StripeEventProcessed || record.Attempts != 1 || record.ProcessedAt == nil {
+		t.Errorf("Expected event processed once, got %+v", record)
+	}
+}
+
+func TestStripeWebhook_SessionInTwoEvents(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createTestStorageForStripe()
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{}
+	session := createMockCheckoutSession("test@example.com", "cs_twice", true)
+
+	for _, id := range []string{"evt_first", "evt_second"} {
+		event := createMockStripeEvent("checkout.session.completed", session)
+		event["id"] = id
+		if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+			t.Fatalf("Expected status %d for %s, got %d", http.StatusOK, id, code)
+		}
+	}
+
+	if len(storage.Licenses) != 1 {
+		t.Errorf("Expected 1 license for the session, got %d", len(storage.Licenses))
+	}
+}
+
+func TestStripeWebhook_EventInProgressOrFailed(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createTestStorageForStripe()
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{}
+	event := createMockStripeEvent("checkout.session.completed", createMockCheckoutSession("test@example.com", "cs_retry", true))
+
+	// A delivery still working on the event makes Stripe come back later
+	storage.StripeEvents = map[string]models.StripeEvent{
+		"evt_test123": {ID: "evt_test123", Status: models.StripeEventProcessing, Attempts: 1, UpdatedAt: time.Now()},
+	}
+	if code := deliverStripeEvent(t, server, event); code != http.StatusConflict {
+		t.Errorf("Expected status %d while in progress, got %d", http.StatusConflict, code)
+	}
+
+	// A failed attempt is processed again
+	storage.StripeEvents["evt_test123"] = models.StripeEvent{ID: "evt_test123", Status: models.StripeEventFailed, Attempts: 1, Error: "boom", UpdatedAt: time.Now()}
+	if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+		t.Fatalf("Expected status %d on retry, got %d", http.StatusOK, code)
+	}
+
+	record := storage.StripeEvents["evt_test123"]
+	if record.Status != models.StripeEventProcessed || record.Attempts != 2 || record.Error != "" {
+		t.Errorf("Expected event processed on second attempt, got %+v", record)
+	}
+	if len(storage.Licenses) != 1 {
+		t.Errorf("Expected 1 license, got %d", len(storage.Licenses))
+	}
+}
+
 func TestStripeWebhook_InvalidJSON(t *testing.T) {
 	storage := createTestStorageForStripe()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	req := httptest.NewRequest(http.MethodPost, "/v1/webhooks/stripe", bytes.NewBufferString("invalid json"))
 	req.Header.Set("Content-Type", "application/json")
@@ -117,7 +261,7 @@ func TestStripeWebhook_InvalidJSON(t *testing.T) {
 
 func TestStripeWebhook_UnhandledEventType(t *testing.T) {
 	storage := createTestStorageForStripe()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	event := map[string]interface{}{
 		"id":   "evt_test123",
@@ -147,7 +291,8 @@ func TestStripeWebhook_UnhandledEventType(t *testing.T) {
 
 func TestHandleCheckoutComplete_NewCustomer(t *testing.T) {
 	storage := createTestStorageForStripe()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{}
 
 	session := &stripe.CheckoutSession{
 		ID:            "cs_test123",
@@ -210,7 +355,8 @@ func TestHandleCheckoutComplete_NewCustomer(t *testing.T) {
 
 func TestHandleCheckoutComplete_ExistingCustomer(t *testing.T) {
 	storage := createTestStorageForStripe()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{}
 
 	// Add existing customer
 	existingCustomer := models.Customer{
@@ -313,7 +459,8 @@ func TestCreateLicense(t *testing.T) {
 	}
 
 	session := &stripe.CheckoutSession{
-		ID: "cs_license_test",
+		ID:            "cs_license_test",
+		PaymentIntent: &stripe.PaymentIntent{ID: "pi_license_test"},
 		Metadata: map[string]string{
 			"product_id":      "prod_license123",
 			"license_version": "3.0.0",
@@ -342,6 +489,10 @@ func TestCreateLicense(t *testing.T) {
 		t.Errorf("Expected session ID 'cs_license_test', got '%s'", license.StripeSessionID)
 	}
 
+	if license.StripePaymentIntentID != "pi_license_test" {
+		t.Errorf("Expected payment intent 'pi_license_test', got '%s'", license.StripePaymentIntentID)
+	}
+
 	if license.ID == "" {
 		t.Errorf("Expected license ID to be generated")
 	}
@@ -351,34 +502,114 @@ func TestCreateLicense(t *testing.T) {
 	}
 }
 
+func TestCreateLicense_WithDuration(t *testing.T) {
+	customer := &models.Customer{ID: "test-customer"}
+
+	session := &stripe.CheckoutSession{
+		ID: "cs_duration_test",
+		Metadata: map[string]string{
+			"product_id":            "prod_yearly",
+			"license_version":       "1.0.0",
+			"license_duration_days": "365",
+		},
+	}
+
+	license := createLicese(customer, session)
+
+	if license.ExpiresAt == nil {
+		t.Fatalf("Expected license with duration to have an expiry")
+	}
+
+	expected := time.Now().AddDate(0, 0, 365)
+	if license.ExpiresAt.Sub(expected).Abs() > time.Minute {
+		t.Errorf("Expected expiry around %v, got %v", expected, license.ExpiresAt)
+	}
+
+	session.Metadata["license_duration_days"] = ""
+	if license := createLicese(customer, session); license.ExpiresAt != nil {
+		t.Errorf("Expected perpetual license without duration, got expiry %v", license.ExpiresAt)
+	}
+}
+
+func TestCreateLicense_WithEntitlements(t *testing.T) {
+	t.Setenv("PRODUCT_ENTITLEMENTS", "prod_pro=slack_sync|advanced_stats")
+
+	customer := &models.Customer{ID: "test-customer"}
+	session := &stripe.CheckoutSession{
+		ID:       "cs_entitlements_test",
+		Metadata: map[string]string{"product_id": "prod_pro"},
+	}
+
+	license := createLicese(customer, session)
+	if len(license.Entitlements) != 2 || license.Entitlements[0] != "advanced_stats" {
+		t.Errorf("Expected product entitlements on new license, got %v", license.Entitlements)
+	}
+
+	session.Metadata["product_id"] = "prod_basic"
+	if license := createLicese(customer, session); license.Entitlements != nil {
+		t.Errorf("Expected no entitlements for unmapped product, got %v", license.Entitlements)
+	}
+}
+
 func TestGenerateLicenseKey(t *testing.T) {
 	// Generate multiple keys to ensure uniqueness and format
 	keys := make(map[string]bool)
 
 	for i := 0; i < 100; i++ {
-		key := generateLicenseKey()
+		key := generateLicenseKey("")
 
 		// Check format
-		if len(key) != 12 { // "AFP-" + 8 characters
-			t.Errorf("Expected key length 12, got %d for key '%s'", len(key), key)
+		if len(key) != 27 { // "AFP-" + 4 groups of 5 characters
+			t.Errorf("Expected key length 27, got %d for key '%s'", len(key), key)
 		}
 
 		if key[:4] != "AFP-" {
 			t.Errorf("Expected key to start with 'AFP-', got '%s'", key)
 		}
 
+		if !licensekey.Valid(key) {
+			t.Errorf("Expected key '%s' to pass its checksum", key)
+		}
+
 		// Check uniqueness
 		if keys[key] {
 			t.Errorf("Generated duplicate key: %s", key)
 		}
 		keys[key] = true
 	}
+
+	if key := generateLicenseKey("AFT"); key[:4] != "AFT-" {
+		t.Errorf("Expected product prefix 'AFT-', got '%s'", key)
+	}
+}
+
+func TestSaveNewLicense_RetriesKeyCollision(t *testing.T) {
+	storage := createTestStorageForStripe()
+	storage.Data["customer-1"] = models.Customer{ID: "customer-1", Email: "test@example.com"}
+
+	takenKey := generateLicenseKey("AFP")
+	storage.Licenses["existing"] = models.License{ID: "existing", Key: takenKey, CustomerID: "customer-1", Status: models.StatusActive}
+
+	server := newTestServer(t, storage)
+
+	license := &models.License{ID: "new", Key: takenKey, CustomerID: "customer-1", Status: models.StatusActive}
+	if err := server.saveNewLicense(context.Background(), license); err != nil {
+		t.Fatalf("Expected collision to be retried, got %v", err)
+	}
+
+	if license.Key == takenKey || !licensekey.Valid(license.Key) {
+		t.Errorf("Expected a fresh valid key, got '%s'", license.Key)
+	}
+
+	if storage.Licenses["existing"].Key != takenKey {
+		t.Errorf("Expected existing license to keep its key")
+	}
 }
 
 func TestFindOrCreateCustomer_DatabaseError(t *testing.T) {
 	// Use error storage
 	storage := &mockStorageWithErrors{}
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	session := &stripe.CheckoutSession{
 		ID:            "cs_error_test",
@@ -394,7 +625,7 @@ func TestFindOrCreateCustomer_DatabaseError(t *testing.T) {
 func TestCreateLicensedUser_SaveLicenseError(t *testing.T) {
 	// Create storage that fails on license save
 	storage := &mockStoragePartialErrors{}
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	session := &stripe.CheckoutSession{
 		ID:            "cs_save_error",
@@ -413,7 +644,7 @@ func TestCreateLicensedUser_SaveLicenseError(t *testing.T) {
 
 func TestStripeWebhook_EmptyBody(t *testing.T) {
 	storage := createTestStorageForStripe()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	req := httptest.NewRequest(http.MethodPost, "/v1/webhooks/stripe", bytes.NewBuffer([]byte{}))
 	req.Header.Set("Content-Type", "application/json")
@@ -431,7 +662,7 @@ func TestStripeWebhook_EmptyBody(t *testing.T) {
 
 func TestStripeWebhook_LargePayload(t *testing.T) {
 	storage := createTestStorageForStripe()
-	server := NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	// Create a very large payload
 	largeEvent := map[string]interface{}{
@@ -498,10 +729,118 @@ func (m *mockStoragePartialErrors) FindLicensesByCustomer(ctx context.Context, c
 	return nil, nil
 }
 
+func (m *mockStoragePartialErrors) FindLicensesByKeys(ctx context.Context, keys []string) ([]*models.License, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) FindLicenseByStripeSession(ctx context.Context, sessionID string) (*models.License, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) FindLicenseByPaymentIntent(ctx context.Context, paymentIntentID string) (*models.License, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) FindLicenseBySubscription(ctx context.Context, subscriptionID string) (*models.License, error) {
+	return nil, nil
+}
+
 func (m *mockStoragePartialErrors) SaveLicense(ctx context.Context, license *models.License) error {
 	return context.DeadlineExceeded // Fail on license save
 }
 
+func (m *mockStoragePartialErrors) UpdateLicenseStatus(ctx context.Context, license *models.License, from string) (bool, error) {
+	return false, context.DeadlineExceeded
+}
+
+func (m *mockStoragePartialErrors) FindExpiredLicenses(ctx context.Context, now time.Time) ([]*models.License, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) FindTrialByDevice(ctx context.Context, deviceID string) (*models.License, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) FindSeats(ctx context.Context, teamLicenseID string) ([]*models.License, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) CreateSeat(ctx context.Context, seat *models.License, limit int) error {
+	return nil
+}
+
+func (m *mockStoragePartialErrors) RecordUsage(ctx context.Context, usage []*models.LicenseUsage) error {
+	return nil
+}
+
+func (m *mockStoragePartialErrors) GetLicenseUsage(ctx context.Context, licenseID string) (*models.LicenseUsage, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) GetStripeEvent(ctx context.Context, id string) (*models.StripeEvent, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) SaveStripeEvent(ctx context.Context, event *models.StripeEvent) error {
+	return nil
+}
+
+func (m *mockStoragePartialErrors) ClaimStripeEvent(ctx context.Context, event *models.StripeEvent, staleBefore time.Time) (bool, error) {
+	return true, nil
+}
+
+func (m *mockStoragePartialErrors) GetDunning(ctx context.Context, subscriptionID string) (*models.Dunning, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) FindDueDunnings(ctx context.Context, now time.Time) ([]*models.Dunning, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) SaveDunning(ctx context.Context, dunning *models.Dunning) error {
+	return nil
+}
+
+func (m *mockStoragePartialErrors) DeleteDunning(ctx context.Context, subscriptionID string) error {
+	return nil
+}
+
+func (m *mockStoragePartialErrors) FindActivation(ctx context.Context, licenseID, deviceID string) (*models.Activation, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) FindActivationsByLicense(ctx context.Context, licenseID string) ([]*models.Activation, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) SaveActivation(ctx context.Context, activation *models.Activation) error {
+	return nil
+}
+
+func (m *mockStoragePartialErrors) CreateActivation(ctx context.Context, activation *models.Activation, limit int) error {
+	return nil
+}
+
+func (m *mockStoragePartialErrors) DeleteActivation(ctx context.Context, licenseID, deviceID string) error {
+	return nil
+}
+
+func (m *mockStoragePartialErrors) FindLease(ctx context.Context, licenseID, deviceID string) (*models.Lease, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) FindLeasesByLicense(ctx context.Context, licenseID string) ([]*models.Lease, error) {
+	return nil, nil
+}
+
+func (m *mockStoragePartialErrors) SaveLease(ctx context.Context, lease *models.Lease) error {
+	return nil
+}
+
+func (m *mockStoragePartialErrors) FindRevocationsSince(ctx context.Context, sequence int64, limit int) ([]*models.Revocation, error) {
+	return nil, nil
+}
+
 func (m *mockStoragePartialErrors) Close() error {
 	return nil
 }
@@ -509,7 +848,7 @@ func (m *mockStoragePartialErrors) Close() error {
 // Benchmark tests for Stripe webhook performance
 func BenchmarkStripeWebhook_CheckoutCompleted(b *testing.B) {
 	storage := createTestStorageForStripe()
-	server := NewHttpServer(storage)
+	server := newTestServer(b, storage)
 
 	b.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
 	b.Setenv("STRIPE_WEBHOOK_SECRET", "whsec_test")
@@ -537,6 +876,6 @@ func BenchmarkStripeWebhook_CheckoutCompleted(b *testing.B) {
 func BenchmarkGenerateLicenseKey(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = generateLicenseKey()
+		_ = generateLicenseKey(licensekey.DefaultPrefix)
 	}
 }
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/stripe/stripe-go/v82"
+)
+
+// handleInvoicePaid renews the license of the subscription an invoice paid
+// for up to the end of the invoiced period. The first invoice can arrive
+// before, or instead of, the checkout session, so it creates the license
+// when there is none yet.
+func (s *Server) handleInvoicePaid(ctx context.Context, invoice *stripe.Invoice) error {
+	details := invoiceSubscription(invoice)
+	if details == nil {
+		logger.Debug("Paid invoice is not for a subscription", map[string]interface{}{
+			"invoice_id": invoice.ID,
+		})
+		return nil
+	}
+
+	license, err := s.Storage.FindLicenseBySubscription(ctx, details.Subscription.ID)
+	if err != nil {
+		return fmt.Errorf("failed to look up license for subscription: %w", err)
+	}
+
+	if license == nil {
+		logger.Info("Creating license from subscription invoice", map[string]interface{}{
+			"invoice_id":      invoice.ID,
+			"subscription_id": details.Subscription.ID,
+		})
+
+		if err := s.handleCheckoutComplete(ctx, checkoutFromInvoice(invoice, details)); err != nil {
+			return err
+		}
+
+		license, err = s.Storage.FindLicenseBySubscription(ctx, details.Subscription.ID)
+		if err != nil {
+			return fmt.Errorf("failed to look up license for subscription: %w", err)
+		}
+		if license == nil {
+			return fmt.Errorf("no license created for subscription %s", details.Subscription.ID)
+		}
+	}
+
+	if err := s.resolveDunning(ctx, details.Subscription.ID); err != nil {
+		return err
+	}
+
+	return s.renewSubscriptionLicense(ctx, license, invoicePeriodEnd(invoice), time.Now())
+}
+
+// handleSubscription keeps a subscription's license in step with it: an
+// active or trialing subscription extends the license to the end of its
+// current period, and an ended one expires it. eventType is the
+// customer.subscription.* event received.
+func (s *Server) handleSubscription(ctx context.Context, eventType string, sub *stripe.Subscription) error {
+	license, err := s.Storage.FindLicenseBySubscription(ctx, sub.ID)
+	if err != nil {
+		return fmt.Errorf("failed to look up license for subscription: %w", err)
+	}
+	if license == nil {
+		// The license is created by the checkout session or first invoice
+		logger.Info("No license for subscription yet", map[string]interface{}{
+			"subscription_id": sub.ID,
+			"status":          sub.Status,
+			"event_type":      eventType,
+		})
+		return nil
+	}
+
+	if license.Status == models.StatusRevoked {
+		return nil
+	}
+
+	now := time.Now()
+
+	switch {
+	case eventType == "customer.subscription.deleted", subscriptionEnded(sub.Status):
+		endedAt := now
+		if sub.EndedAt > 0 {
+			endedAt = time.Unix(sub.EndedAt, 0)
+		}
+
+		// No point asking for a payment the subscription no longer takes
+		if err := s.resolveDunning(ctx, sub.ID); err != nil {
+			return err
+		}
+		return s.endSubscriptionLicense(ctx, license, endedAt, now)
+	case sub.Status == stripe.SubscriptionStatusActive, sub.Status == stripe.SubscriptionStatusTrialing:
+		return s.renewSubscriptionLicense(ctx, license, subscriptionPeriodEnd(sub), now)
+	default:
+		// Incomplete and past due subscriptions wait for their invoice to
+		// be paid or given up on
+		logger.Info("Subscription awaiting payment", map[string]interface{}{
+			"license_id":      license.ID,
+			"subscription_id": sub.ID,
+			"status":          sub.Status,
+		})
+		return nil
+	}
+}
+
+// renewSubscriptionLicense extends license to periodEnd, reactivating it
+// if it had expired. The expiry never moves back, so events delivered out
+// of order cannot shorten a period already paid for.
+func (s *Server) renewSubscriptionLicense(ctx context.Context, license *models.License, periodEnd, now time.Time) error {
+	if periodEnd.IsZero() {
+		return nil
+	}
+
+	changed := false
+	if license.ExpiresAt == nil || license.ExpiresAt.Before(periodEnd) {
+		license.ExpiresAt = &periodEnd
+		license.UpdatedAt = now
+		changed = true
+	}
+
+	// Suspensions for refunds, disputes or abuse are left alone
+	if license.Status == models.StatusExpired && !license.IsExpired(now) {
+		license.SetStatus(models.StatusActive, "", now)
+		license.ExpiredAt = nil
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if err := s.Storage.SaveLicense(ctx, license); err != nil {
+		return fmt.Errorf("failed to save renewed license: %w", err)
+	}
+	if err := s.syncSeats(ctx, license, now); err != nil {
+		return err
+	}
+
+	logger.Info("Subscription license renewed", map[string]interface{}{
+		"license_id":      license.ID,
+		"subscription_id": license.StripeSubscriptionID,
+		"expires_at":      license.ExpiresAt.Format(time.RFC3339),
+		"status":          license.Status,
+	})
+	return nil
+}
+
+// endSubscriptionLicense makes license expire when its subscription ended,
+// expiring it right away once that has passed.
+func (s *Server) endSubscriptionLicense(ctx context.Context, license *models.License, endedAt, now time.Time) error {
+	changed := false
+	if license.ExpiresAt == nil || license.ExpiresAt.After(endedAt) {
+		license.ExpiresAt = &endedAt
+		license.UpdatedAt = now
+		changed = true
+	}
+
+	if license.Status == models.StatusActive && license.IsExpired(now) {
+		license.SetStatus(models.StatusExpired, "", now)
+		license.ExpiredAt = &now
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if err := s.Storage.SaveLicense(ctx, license); err != nil {
+		return fmt.Errorf("failed to save ended license: %w", err)
+	}
+	if err := s.syncSeats(ctx, license, now); err != nil {
+		return err
+	}
+
+	logger.Info("Subscription license ended", map[string]interface{}{
+		"license_id":      license.ID,
+		"subscription_id": license.StripeSubscriptionID,
+		"expires_at":      license.ExpiresAt.Format(time.RFC3339),
+		"status":          license.Status,
+	})
+	return nil
+}
+
+// syncSeats gives the seats of a team license its expiry and entitlements,
+// which they copy when assigned, so renewals, grace periods and entitlement
+// changes reach them too. Seats follow the team between active and expired;
+// other statuses are left alone.
+func (s *Server) syncSeats(ctx context.Context, team *models.License, now time.Time) error {
+	if !team.IsTeam() {
+		return nil
+	}
+
+	seats, err := s.Storage.FindSeats(ctx, team.ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch seats: %w", err)
+	}
+
+	for _, seat := range assignedSeats(seats) {
+		changed := false
+		if !sameTime(seat.ExpiresAt, team.ExpiresAt) {
+			seat.ExpiresAt = copyTime(team.ExpiresAt)
+			changed = true
+		}
+
+		if !slices.Equal(seat.Entitlements, team.Entitlements) {
+			seat.Entitlements = slices.Clone(team.Entitlements)
+			changed = true
+		}
+
+		switch {
+		case seat.Status == models.StatusExpired && team.Status == models.StatusActive && !seat.IsExpired(now):
+			seat.SetStatus(models.StatusActive, "", now)
+			seat.ExpiredAt = nil
+			changed = true
+		case seat.Status == models.StatusActive && team.Status == models.StatusExpired:
+			seat.SetStatus(models.StatusExpired, "", now)
+			seat.ExpiredAt = &now
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+
+		seat.UpdatedAt = now
+		if err := s.Storage.SaveLicense(ctx, seat); err != nil {
+			return fmt.Errorf("failed to save seat: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+// subscriptionEnded reports whether status stops the subscription from
+// being billed, so its license should not outlive it.
+func subscriptionEnded(status stripe.SubscriptionStatus) bool {
+	switch status {
+	case stripe.SubscriptionStatusCanceled,
+		stripe.SubscriptionStatusUnpaid,
+		stripe.SubscriptionStatusIncompleteExpired,
+		stripe.SubscriptionStatusPaused:
+		return true
+	}
+	return false
+}
+
+// subscriptionPeriodEnd returns the latest current period end across the
+// subscription's items, or the zero time if it has none.
+func subscriptionPeriodEnd(sub *stripe.Subscription) time.Time {
+	var end int64
+	if sub.Items != nil {
+		for _, item := range sub.Items.Data {
+			end = max(end, item.CurrentPeriodEnd)
+		}
+	}
+	if end == 0 {
+		return time.Time{}
+	}
+	return time.Unix(end, 0)
+}
+
+// invoicePeriodEnd returns the latest period end across the invoice's
+// lines, or the zero time if it has none.
+func invoicePeriodEnd(invoice *stripe.Invoice) time.Time {
+	var end int64
+	if invoice.Lines != nil {
+		for _, line := range invoice.Lines.Data {
+			if line.Period != nil {
+				end = max(end, line.Period.End)
+			}
+		}
+	}
+	if end == 0 {
+		return time.Time{}
+	}
+	return time.Unix(end, 0)
+}
+
+// invoiceSubscription returns the subscription an invoice was issued for,
+// or nil for one-off invoices.
+func invoiceSubscription(invoice *stripe.Invoice) *stripe.InvoiceParentSubscriptionDetails {
+	if invoice.Parent == nil || invoice.Parent.SubscriptionDetails == nil || invoice.Parent.SubscriptionDetails.Subscription == nil {
+		return nil
+	}
+	return invoice.Parent.SubscriptionDetails
+}
+
+// checkoutFromInvoice describes a subscription's first invoice as the
+// checkout session it stands in for. Products sold by subscription carry
+// their license metadata on the subscription itself.
+func checkoutFromInvoice(invoice *stripe.Invoice, details *stripe.InvoiceParentSubscriptionDetails) *stripe.CheckoutSession {
+	return &stripe.CheckoutSession{
+		Customer: invoice.Customer,
+		CustomerDetails: &stripe.CheckoutSessionCustomerDetails{
+			Email:   invoice.CustomerEmail,
+			Name:    invoice.CustomerName,
+			Address: invoice.CustomerAddress,
+		},
+		Metadata:     details.Metadata,
+		AmountTotal:  invoice.AmountPaid,
+		Currency:     invoice.Currency,
+		Subscription: details.Subscription,
+	}
+}
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+)
+
+func createMockInvoicePaidEvent(id, subscriptionID string, periodEnd time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   "evt_" + id,
+		"type": "invoice.paid",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":             id,
+				"customer":       "cus_test123",
+				"customer_email": "subscriber@example.com",
+				"customer_name":  "Sam Subscriber",
+				"amount_paid":    499,
+				"currency":       "usd",
+				"status":         "paid",
+				"parent": map[string]interface{}{
+					"type": "subscription_details",
+					"subscription_details": map[string]interface{}{
+						"subscription": subscriptionID,
+						"metadata": map[string]interface{}{
+							"product_id":      "prod_monthly",
+							"license_version": "1.0.0",
+						},
+					},
+				},
+				"lines": map[string]interface{}{
+					"data": []interface{}{
+						map[string]interface{}{
+							"id": "il_" + id,
+							"period": map[string]interface{}{
+								"start": periodEnd.AddDate(0, -1, 0).Unix(),
+								"end":   periodEnd.Unix(),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func createMockSubscriptionEvent(eventID, eventType, status string, periodEnd time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   eventID,
+		"type": eventType,
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":       "sub_test",
+				"customer": "cus_test123",
+				"status":   status,
+				"items": map[string]interface{}{
+					"data": []interface{}{
+						map[string]interface{}{
+							"id":                 "si_test",
+							"current_period_end": periodEnd.Unix(),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createSubscriptionTestStorage returns storage holding license-1 as the
+// license of subscription sub_test, paid up until expiresAt.
+func createSubscriptionTestStorage(expiresAt time.Time) *storage.MemoryStorage {
+	storage := createTestStorage()
+	license := storage.Licenses["license-1"]
+	license.StripeSubscriptionID = "sub_test"
+	license.ExpiresAt = &expiresAt
+	storage.Licenses["license-1"] = license
+	return storage
+}
+
+func findSubscriptionLicense(t *testing.T, licenses map[string]models.License, subscriptionID string) models.License {
+	t.Helper()
+
+	var found []models.License
+	for _, license := range licenses {
+		if license.StripeSubscriptionID == subscriptionID {
+			found = append(found, license)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("Expected 1 license for %s, got %d", subscriptionID, len(found))
+	}
+	return found[0]
+}
+
+func TestStripeWebhook_InvoicePaidCreatesLicense(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createTestStorageForStripe()
+	server := newTestServer(t, storage)
+	periodEnd := time.Now().AddDate(0, 1, 0).Truncate(time.Second)
+
+	if code := deliverStripeEvent(t, server, createMockInvoicePaidEvent("in_first", "sub_new", periodEnd)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license := findSubscriptionLicense(t, storage.Licenses, "sub_new")
+	if license.Status != models.StatusActive {
+		t.Errorf("Expected active license, got %s", license.Status)
+	}
+	if license.ExpiresAt == nil || !license.ExpiresAt.Equal(periodEnd) {
+		t.Errorf("Expected license to expire at %v, got %v", periodEnd, license.ExpiresAt)
+	}
+	if license.ProductID != "prod_monthly" || license.PricePaid != 499 {
+		t.Errorf("Expected license from subscription metadata, got %s at %d", license.ProductID, license.PricePaid)
+	}
+
+	// The checkout session completing afterwards must not create a second
+	// license, only record itself on the existing one
+	session := createMockCheckoutSession("subscriber@example.com", "cs_subscription", true)
+	session["subscription"] = "sub_new"
+	if code := deliverStripeEvent(t, server, createMockStripeEvent("checkout.session.completed", session)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if len(storage.Licenses) != 1 {
+		t.Fatalf("Expected 1 license after checkout, got %d", len(storage.Licenses))
+	}
+	if license := findSubscriptionLicense(t, storage.Licenses, "sub_new"); license.StripeSessionID != "cs_subscription" {
+		t.Errorf("Expected checkout session recorded on license, got %q", license.StripeSessionID)
+	}
+}
+
+func TestStripeWebhook_InvoicePaidRenewsLicense(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createTestStorageForStripe()
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{}
+
+	session := createMockCheckoutSession("subscriber@example.com", "cs_subscription", true)
+	session["subscription"] = "sub_checkout"
+	if code := deliverStripeEvent(t, server, createMockStripeEvent("checkout.session.completed", session)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	firstEnd := time.Now().AddDate(0, 1, 0).Truncate(time.Second)
+	secondEnd := firstEnd.AddDate(0, 1, 0)
+
+	for _, event := range []map[string]interface{}{
+		createMockInvoicePaidEvent("in_first", "sub_checkout", firstEnd),
+		createMockInvoicePaidEvent("in_second", "sub_checkout", secondEnd),
+		// An old invoice delivered late must not shorten the license
+		createMockInvoicePaidEvent("in_late", "sub_checkout", firstEnd),
+	} {
+		if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+		}
+	}
+
+	if len(storage.Licenses) != 1 {
+		t.Fatalf("Expected 1 license, got %d", len(storage.Licenses))
+	}
+	license := findSubscriptionLicense(t, storage.Licenses, "sub_checkout")
+	if license.ExpiresAt == nil || !license.ExpiresAt.Equal(secondEnd) {
+		t.Errorf("Expected license to expire at %v, got %v", secondEnd, license.ExpiresAt)
+	}
+}
+
+func TestStripeWebhook_InvoicePaidReactivatesExpiredLicense(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	now := time.Now()
+	storage := createSubscriptionTestStorage(now.Add(-time.Hour))
+	license := storage.Licenses["license-1"]
+	license.SetStatus(models.StatusExpired, "", now)
+	license.ExpiredAt = &now
+	storage.Licenses["license-1"] = license
+	server := newTestServer(t, storage)
+
+	periodEnd := now.AddDate(0, 1, 0).Truncate(time.Second)
+	if code := deliverStripeEvent(t, server, createMockInvoicePaidEvent("in_renewal", "sub_test", periodEnd)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license = storage.Licenses["license-1"]
+	if license.Status != models.StatusActive || license.ExpiredAt != nil {
+		t.Errorf("Expected license reactivated, got %s (expired at %v)", license.Status, license.ExpiredAt)
+	}
+	if license.ExpiresAt == nil || !license.ExpiresAt.Equal(periodEnd) {
+		t.Errorf("Expected license to expire at %v, got %v", periodEnd, license.ExpiresAt)
+	}
+}
+
+// staleSubscriptionStorage misses the license of a subscription on the
+// first lookups, as a concurrent event creating it would
+type staleSubscriptionStorage struct {
+	*storage.MemoryStorage
+	misses int
+}
+
+func (s *staleSubscriptionStorage) FindLicenseBySubscription(ctx context.Context, subscriptionID string) (*models.License, error) {
+	if s.misses > 0 {
+		s.misses--
+		return nil, nil
+	}
+	return s.MemoryStorage.FindLicenseBySubscription(ctx, subscriptionID)
+}
+
+func TestStripeWebhook_CheckoutRacesFirstInvoice(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	memory := createSubscriptionTestStorage(time.Now().Add(time.Hour))
+	server := newTestServer(t, &staleSubscriptionStorage{MemoryStorage: memory, misses: 1})
+	server.CheckoutSessions = &fakeCheckoutSessions{}
+
+	session := createMockCheckoutSession("subscriber@example.com", "cs_subscription", true)
+	session["subscription"] = "sub_test"
+	if code := deliverStripeEvent(t, server, createMockStripeEvent("checkout.session.completed", session)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license := findSubscriptionLicense(t, memory.Licenses, "sub_test")
+	if license.ID != "license-1" || license.StripeSessionID != "cs_subscription" {
+		t.Errorf("Expected the session recorded on the existing license, got %s/%s", license.ID, license.StripeSessionID)
+	}
+}
+
+func TestStripeWebhook_InvoicePaidRenewsSeats(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	now := time.Now()
+	storage := createTeamTestStorage(2)
+	team := storage.Licenses["team-1"]
+	team.StripeSubscriptionID = "sub_team"
+	storage.Licenses["team-1"] = team
+	server := newTestServer(t, storage)
+
+	assigned := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "one@example.com"}))
+	seatKey := assigned.Assignments[0].LicenseKey
+
+	// The sweep expired the team and its seat at the end of the period
+	for id, license := range storage.Licenses {
+		if license.ID == "team-1" || license.Key == seatKey {
+			license.ExpiresAt = &now
+			license.SetStatus(models.StatusExpired, "", now)
+			license.ExpiredAt = &now
+			storage.Licenses[id] = license
+		}
+	}
+
+	periodEnd := now.AddDate(0, 1, 0).Truncate(time.Second)
+	if code := deliverStripeEvent(t, server, createMockInvoicePaidEvent("in_team", "sub_team", periodEnd)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	for _, license := range storage.Licenses {
+		if license.Key != seatKey {
+			continue
+		}
+		if license.Status != models.StatusActive || license.ExpiresAt == nil || !license.ExpiresAt.Equal(periodEnd) {
+			t.Errorf("Expected seat active until %v, got %s until %v", periodEnd, license.Status, license.ExpiresAt)
+		}
+	}
+	if response := validateLicenseKey(t, server, seatKey); !response.Valid {
+		t.Errorf("Expected renewed seat to validate, got %+v", response)
+	}
+}
+
+func TestStripeWebhook_InvoicePaidWithoutSubscription(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createTestStorageForStripe()
+	server := newTestServer(t, storage)
+
+	event := createMockInvoicePaidEvent("in_one_off", "sub_unused", time.Now())
+	delete(event["data"].(map[string]interface{})["object"].(map[string]interface{}), "parent")
+
+	if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if len(storage.Licenses) != 0 {
+		t.Errorf("Expected no license for a one-off invoice, got %d", len(storage.Licenses))
+	}
+}
+
+func TestStripeWebhook_SubscriptionLifecycle(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	now := time.Now()
+	storage := createSubscriptionTestStorage(now.Add(time.Hour))
+	server := newTestServer(t, storage)
+
+	periodEnd := now.AddDate(0, 1, 0).Truncate(time.Second)
+	if code := deliverStripeEvent(t, server, createMockSubscriptionEvent("evt_sub_updated", "customer.subscription.updated", "active", periodEnd)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license := storage.Licenses["license-1"]
+	if license.Status != models.StatusActive || license.ExpiresAt == nil || !license.ExpiresAt.Equal(periodEnd) {
+		t.Fatalf("Expected active license until %v, got %s until %v", periodEnd, license.Status, license.ExpiresAt)
+	}
+
+	// Past due waits for the outcome of the invoice
+	if code := deliverStripeEvent(t, server, createMockSubscriptionEvent("evt_sub_past_due", "customer.subscription.updated", "past_due", periodEnd.AddDate(0, 1, 0))); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if license := storage.Licenses["license-1"]; !license.ExpiresAt.Equal(periodEnd) {
+		t.Errorf("Expected past due subscription to leave expiry at %v, got %v", periodEnd, license.ExpiresAt)
+	}
+
+	if code := deliverStripeEvent(t, server, createMockSubscriptionEvent("evt_sub_deleted", "customer.subscription.deleted", "canceled", periodEnd)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license = storage.Licenses["license-1"]
+	if license.Status != models.StatusExpired || license.ExpiredAt == nil {
+		t.Errorf("Expected license expired after cancellation, got %s", license.Status)
+	}
+	if license.ExpiresAt == nil || license.ExpiresAt.After(time.Now()) {
+		t.Errorf("Expected expiry moved to cancellation, got %v", license.ExpiresAt)
+	}
+
+	if response := validateLicenseKey(t, server, "AFP-7a11d123"); response.Valid {
+		t.Errorf("Expected license of canceled subscription to be invalid")
+	}
+}
+
+func TestStripeWebhook_SubscriptionKeepsSuspensions(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	now := time.Now()
+	storage := createSubscriptionTestStorage(now.Add(-time.Hour))
+	license := storage.Licenses["license-1"]
+	license.SetStatus(models.StatusRevoked, models.StatusReasonChargeback, now)
+	storage.Licenses["license-1"] = license
+	server := newTestServer(t, storage)
+
+	if code := deliverStripeEvent(t, server, createMockSubscriptionEvent("evt_sub_active", "customer.subscription.updated", "active", now.AddDate(0, 1, 0))); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if license := storage.Licenses["license-1"]; license.Status != models.StatusRevoked || !license.ExpiresAt.Before(now) {
+		t.Errorf("Expected revoked license untouched, got %s until %v", license.Status, license.ExpiresAt)
+	}
+}
+
+func TestStripeWebhook_SubscriptionWithoutLicense(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createTestStorageForStripe()
+	server := newTestServer(t, storage)
+
+	event := createMockSubscriptionEvent("evt_sub_created", "customer.subscription.created", "incomplete", time.Now().AddDate(0, 1, 0))
+	if code := deliverStripeEvent(t, server, event); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if len(storage.Licenses) != 0 {
+		t.Errorf("Expected no license created from a subscription event, got %d", len(storage.Licenses))
+	}
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/email"
+	"auto-focus.app/cloud/internal/licensekey"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v82"
+)
+
+var (
+	ErrTeamLicenseNotFound  = errors.New("team license not found")
+	ErrTeamLicenseNotActive = errors.New("team license not active")
+	ErrNoSeatsAvailable     = errors.New("no seats available")
+	ErrSeatAlreadyAssigned  = errors.New("seat already assigned")
+	ErrSeatNotAssigned      = errors.New("seat not assigned")
+)
+
+// TeamSeatsRequest authenticates the team owner by the team license key,
+// which is only ever sent to the owner; members get their own seat keys.
+type TeamSeatsRequest struct {
+	TeamKey string `json:"team_key"`
+	Email   string `json:"email,omitempty"`
+}
+
+type SeatAssignment struct {
+	Email      string `json:"email"`
+	LicenseKey string `json:"license_key"`
+	AssignedAt int64  `json:"assigned_at"`
+}
+
+type TeamSeatsResponse struct {
+	Seats       int              `json:"seats"`
+	SeatsUsed   int              `json:"seats_used"`
+	Assignments []SeatAssignment `json:"assignments"`
+}
+
+// TeamSeats lists the seats of a team license.
+func (s *Server) TeamSeats(w http.ResponseWriter, r *http.Request) {
+	s.handleTeamSeats(w, r, false, nil)
+}
+
+// AssignTeamSeat gives the member at email a seat key of their own.
+func (s *Server) AssignTeamSeat(w http.ResponseWriter, r *http.Request) {
+	s.handleTeamSeats(w, r, true, s.assignSeat)
+}
+
+// ReclaimTeamSeat revokes the seat key of the member at email, freeing the
+// seat for someone else.
+func (s *Server) ReclaimTeamSeat(w http.ResponseWriter, r *http.Request) {
+	s.handleTeamSeats(w, r, true, s.reclaimSeat)
+}
+
+func (s *Server) handleTeamSeats(w http.ResponseWriter, r *http.Request, requireEmail bool, change func(context.Context, *models.License, string) error) {
+	ctx := r.Context()
+
+	if r.Method != "POST" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only POST allowed")
+		return
+	}
+
+	var req TeamSeatsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if err := req.validate(requireEmail); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	team, err := s.findTeamLicense(ctx, req.TeamKey)
+	if err == nil && change != nil {
+		err = change(ctx, team, normalizeSeatEmail(req.Email))
+	}
+
+	switch {
+	case errors.Is(err, ErrTeamLicenseNotFound), errors.Is(err, ErrSeatNotAssigned):
+		writeErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, ErrTeamLicenseNotActive):
+		writeErrorResponse(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, ErrNoSeatsAvailable), errors.Is(err, ErrSeatAlreadyAssigned):
+		writeErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		sentry.CaptureException(err)
+		logger.Error("Failed to update team seats", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	seats, err := s.Storage.FindSeats(ctx, team.ID)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error while fetching seats", map[string]interface{}{
+			"error":      err.Error(),
+			"license_id": team.ID,
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	response := TeamSeatsResponse{Seats: team.Seats, Assignments: []SeatAssignment{}}
+	for _, seat := range assignedSeats(seats) {
+		response.Assignments = append(response.Assignments, SeatAssignment{
+			Email:      seat.SeatEmail,
+			LicenseKey: seat.Key,
+			AssignedAt: seat.CreatedAt.Unix(),
+		})
+	}
+	response.SeatsUsed = len(response.Assignments)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode team seats response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+func (s *Server) findTeamLicense(ctx context.Context, key string) (*models.License, error) {
+	team, err := s.Storage.FindLicenseByKey(ctx, licensekey.Normalize(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find license: %w", err)
+	}
+
+	if team == nil || !team.IsTeam() {
+		return nil, ErrTeamLicenseNotFound
+	}
+
+	return team, nil
+}
+
+func (s *Server) assignSeat(ctx context.Context, team *models.License, seatEmail string) error {
+	if team.Status != models.StatusActive || team.IsExpired(time.Now()) {
+		return ErrTeamLicenseNotActive
+	}
+
+	seats, err := s.Storage.FindSeats(ctx, team.ID)
+	if err != nil {
+		return fmt.Errorf("failed to find seats: %w", err)
+	}
+
+	assigned := assignedSeats(seats)
+	for _, seat := range assigned {
+		if seat.SeatEmail == seatEmail {
+			return ErrSeatAlreadyAssigned
+		}
+	}
+
+	if len(assigned) >= team.Seats {
+		return ErrNoSeatsAvailable
+	}
+
+	now := time.Now()
+	seat := &models.License{
+		ID:            uuid.Must(uuid.NewRandom()).String(),
+		Key:           generateLicenseKey(licensekey.Prefix(team.Key)),
+		CustomerID:    team.CustomerID,
+		ProductID:     team.ProductID,
+		ProductName:   team.ProductName,
+		Version:       team.Version,
+		Entitlements:  team.Entitlements,
+		Status:        models.StatusActive,
+		TeamLicenseID: team.ID,
+		SeatEmail:     seatEmail,
+		ExpiresAt:     team.ExpiresAt,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	// The count above is checked again as the seat is saved, in case of
+	// concurrent assignments
+	err = saveWithUniqueKey(seat, func() error {
+		return s.Storage.CreateSeat(ctx, seat, team.Seats)
+	})
+	if errors.Is(err, storage.ErrSeatLimitReached) {
+		return ErrNoSeatsAvailable
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save seat: %w", err)
+	}
+
+	logger.Info("Team seat assigned", map[string]interface{}{
+		"license_id":      seat.ID,
+		"team_license_id": team.ID,
+	})
+
+	sendSeatEmail(seat)
+	return nil
+}
+
+func (s *Server) reclaimSeat(ctx context.Context, team *models.License, seatEmail string) error {
+	seats, err := s.Storage.FindSeats(ctx, team.ID)
+	if err != nil {
+		return fmt.Errorf("failed to find seats: %w", err)
+	}
+
+	for _, seat := range assignedSeats(seats) {
+		if seat.SeatEmail != seatEmail {
+			continue
+		}
+
+		// Revoking puts the seat key on the revocation list so offline
+		// clients drop it too
+		seat.SetStatus(models.StatusRevoked, models.StatusReasonSeatReclaimed, time.Now())
+		if err := s.Storage.SaveLicense(ctx, seat); err != nil {
+			return fmt.Errorf("failed to save seat: %w", err)
+		}
+
+		logger.Info("Team seat reclaimed", map[string]interface{}{
+			"license_id":      seat.ID,
+			"team_license_id": team.ID,
+		})
+		return nil
+	}
+
+	return ErrSeatNotAssigned
+}
+
+// assignedSeats drops reclaimed seats, which no longer count against the
+// team's seat count.
+func assignedSeats(seats []*models.License) []*models.License {
+	var assigned []*models.License
+	for _, seat := range seats {
+		if seat.Status != models.StatusRevoked {
+			assigned = append(assigned, seat)
+		}
+	}
+	return assigned
+}
+
+func sendTeamPurchaseEmail(customerEmail, customerName string, team *models.License, formattedPrice string) {
+	body := fmt.Sprintf(`Hello %s,
+
+Thank you for purchasing Auto-Focus+ for your team! Your purchase has been processed successfully.
+
+TEAM LICENSE DETAILS
+Team Key: %s
+Product: Auto-Focus+ (%s)
+Seats: %d
+Amount Paid: %s
+
+ASSIGNING SEATS
+Keep the team key to yourself: it is used to assign and reclaim seats, not to unlock the app.
+Each team member you assign a seat to receives their own license key by email.
+
+NEED HELP?
+If you have any questions, reply to this email or contact us at help@auto-focus.app
+
+Best regards,
+The Auto-Focus Team`,
+		customerName,
+		team.Key,
+		team.ProductName,
+		team.Seats,
+		formattedPrice)
+
+	if err := email.Send(customerEmail, "Auto-Focus+ Team License", body); err != nil {
+		logger.Error("Failed to send team license email", map[string]interface{}{
+			"error":       err.Error(),
+			"email":       customerEmail,
+			"license_id":  team.ID,
+			"customer_id": team.CustomerID,
+		})
+	}
+}
+
+func sendSeatEmail(seat *models.License) {
+	body := fmt.Sprintf(`Hello,
+
+You have been given a seat on your team's Auto-Focus+ license.
+
+License Key: %s
+
+GETTING STARTED
+1. Open Auto-Focus on your Mac
+2. Go to Settings → License
+3. Enter your license key: %s
+
+Best regards,
+The Auto-Focus Team`,
+		seat.Key,
+		seat.Key)
+
+	if err := email.Send(seat.SeatEmail, "Your Auto-Focus+ License Key", body); err != nil {
+		logger.Error("Failed to send seat email", map[string]interface{}{
+			"error":      err.Error(),
+			"license_id": seat.ID,
+		})
+	}
+}
+
+// checkoutSeats returns how many seats a checkout bought: the summed line
+// item quantities when Stripe includes them, or the seats metadata.
+func checkoutSeats(session *stripe.CheckoutSession) int {
+	var seats int64
+	if session.LineItems != nil {
+		for _, item := range session.LineItems.Data {
+			seats += item.Quantity
+		}
+	}
+
+	if seats == 0 {
+		if n, err := strconv.Atoi(session.Metadata["seats"]); err == nil {
+			seats = int64(n)
+		}
+	}
+
+	return int(seats)
+}
+
+func normalizeSeatEmail(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+func (tr TeamSeatsRequest) validate(requireEmail bool) error {
+	if strings.TrimSpace(tr.TeamKey) == "" {
+		return fmt.Errorf("team_key required")
+	}
+	if !licensekey.Valid(licensekey.Normalize(tr.TeamKey)) {
+		return fmt.Errorf("malformed team_key")
+	}
+	if requireEmail && !strings.Contains(tr.Email, "@") {
+		return fmt.Errorf("valid email required")
+	}
+	return nil
+}
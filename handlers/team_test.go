@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+	"github.com/stripe/stripe-go/v82"
+)
+
+func createTeamTestStorage(seats int) *storage.MemoryStorage {
+	storage := createTestStorage()
+	storage.Licenses["team-1"] = models.License{
+		ID:         "team-1",
+		Key:        "AFP-7ea40001",
+		CustomerID: "test-customer-1",
+		ProductID:  "prod_test123",
+		Version:    "1.0.0",
+		Seats:      seats,
+		Status:     models.StatusActive,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	return storage
+}
+
+func makeTeamRequest(server *Server, path string, reqBody TeamSeatsRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(reqBody)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.Mux.ServeHTTP(w, req)
+
+	return w
+}
+
+func decodeTeamSeats(t *testing.T, w *httptest.ResponseRecorder) TeamSeatsResponse {
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response TeamSeatsResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+	return response
+}
+
+func TestTeamSeats_AssignAndValidate(t *testing.T) {
+	storage := createTeamTestStorage(2)
+	server := newTestServer(t, storage)
+
+	response := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: " Member@Example.com"}))
+
+	if response.Seats != 2 || response.SeatsUsed != 1 || len(response.Assignments) != 1 {
+		t.Fatalf("Expected 1 of 2 seats used, got %+v", response)
+	}
+
+	assignment := response.Assignments[0]
+	if assignment.Email != "member@example.com" {
+		t.Errorf("Expected normalised member email, got '%s'", assignment.Email)
+	}
+
+	seat, _ := storage.FindLicenseByKey(context.Background(), assignment.LicenseKey)
+	if seat == nil || seat.TeamLicenseID != "team-1" || seat.CustomerID != "test-customer-1" {
+		t.Fatalf("Expected seat license owned by the team, got %+v", seat)
+	}
+
+	seatResponse := validateLicenseKey(t, server, assignment.LicenseKey)
+	if !seatResponse.Valid {
+		t.Errorf("Expected seat key to validate, got '%s'", seatResponse.Message)
+	}
+
+	teamResponse := validateLicenseKey(t, server, "AFP-7ea40001")
+	if teamResponse.Valid || teamResponse.Code != CodeSeatKeyRequired {
+		t.Errorf("Expected team key to be refused with '%s', got %+v", CodeSeatKeyRequired, teamResponse)
+	}
+}
+
+func TestTeamSeats_Limits(t *testing.T) {
+	server := newTestServer(t, createTeamTestStorage(1))
+
+	decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "one@example.com"}))
+
+	if w := makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "one@example.com"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a repeat assignment, got %d", http.StatusConflict, w.Code)
+	}
+
+	if w := makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "two@example.com"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d with no seats left, got %d", http.StatusConflict, w.Code)
+	}
+
+	if w := makeTeamRequest(server, "/v1/teams/seats", TeamSeatsRequest{TeamKey: "AFP-7a11d123"}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an individual license, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if w := makeTeamRequest(server, "/v1/teams/seats/reclaim", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "nobody@example.com"}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unassigned email, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestTeamSeats_Reclaim(t *testing.T) {
+	storage := createTeamTestStorage(1)
+	server := newTestServer(t, storage)
+
+	assigned := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "one@example.com"}))
+	seatKey := assigned.Assignments[0].LicenseKey
+
+	reclaimed := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/reclaim", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "one@example.com"}))
+	if reclaimed.SeatsUsed != 0 {
+		t.Errorf("Expected the seat to be freed, got %d used", reclaimed.SeatsUsed)
+	}
+
+	if response := validateLicenseKey(t, server, seatKey); response.Valid {
+		t.Errorf("Expected reclaimed seat key to be refused")
+	}
+
+	seat, _ := storage.FindLicenseByKey(context.Background(), seatKey)
+	if len(storage.Revocations) == 0 || storage.Revocations[len(storage.Revocations)-1].LicenseID != seat.ID {
+		t.Errorf("Expected reclaimed seat on the revocation list")
+	}
+
+	decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "two@example.com"}))
+}
+
+func TestTeamSeats_SuspendedTeam(t *testing.T) {
+	storage := createTeamTestStorage(2)
+	server := newTestServer(t, storage)
+
+	assigned := decodeTeamSeats(t, makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "one@example.com"}))
+
+	team := storage.Licenses["team-1"]
+	team.Status = models.StatusSuspended
+	storage.Licenses["team-1"] = team
+
+	if response := validateLicenseKey(t, server, assigned.Assignments[0].LicenseKey); response.Valid {
+		t.Errorf("Expected seats of a suspended team to be refused")
+	}
+
+	if w := makeTeamRequest(server, "/v1/teams/seats/assign", TeamSeatsRequest{TeamKey: "AFP-7ea40001", Email: "two@example.com"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d assigning from a suspended team, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestStripeWebhook_TeamSeatsFromLineItems(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("TEST_MODE", "true")
+
+	storage := createTestStorageForStripe()
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = &fakeCheckoutSessions{seats: map[string]int64{"cs_team": 5}}
+
+	session := createMockCheckoutSession("owner@example.com", "cs_team", true)
+	if code := deliverStripeEvent(t, server, createMockStripeEvent("checkout.session.completed", session)); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	license, _ := storage.FindLicenseByStripeSession(context.Background(), "cs_team")
+	if license == nil || !license.IsTeam() || license.Seats != 5 {
+		t.Errorf("Expected team license with 5 seats from the line items, got %+v", license)
+	}
+}
+
+func TestCreateLicense_TeamQuantity(t *testing.T) {
+	customer := &models.Customer{ID: "test-customer"}
+
+	session := &stripe.CheckoutSession{
+		ID:       "cs_team_test",
+		Metadata: map[string]string{"product_id": "prod_test"},
+		LineItems: &stripe.LineItemList{
+			Data: []*stripe.LineItem{{Quantity: 5}},
+		},
+	}
+
+	if license := createLicese(customer, session); !license.IsTeam() || license.Seats != 5 {
+		t.Errorf("Expected team license with 5 seats, got %d", license.Seats)
+	}
+
+	session.LineItems.Data[0].Quantity = 1
+	if license := createLicese(customer, session); license.IsTeam() {
+		t.Errorf("Expected individual license for a single seat, got %d seats", license.Seats)
+	}
+
+	session.LineItems = nil
+	session.Metadata["seats"] = "3"
+	if license := createLicese(customer, session); license.Seats != 3 {
+		t.Errorf("Expected seats from metadata, got %d", license.Seats)
+	}
+}
+
+func validateLicenseKey(t *testing.T, server *Server, key string) ValidateResponse {
+	body, _ := json.Marshal(LicenseRequest{LicenseKey: key, AppVersion: "1.4.11", PayloadVersion: payloadVersionCodes})
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response ValidateResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+	return response
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+)
+
+const (
+	jwtMediaType               = "application/jwt"
+	tokenIssuer                = "auto-focus.app"
+	defaultValidationTokenTTL  = 24 * time.Hour
+	validationTokenPathVersion = "/v2/"
+)
+
+// ValidationClaims are the contents of a JWT validation response. Standard
+// claims keep their registered names; sub is the license ID.
+type ValidationClaims struct {
+	Issuer             string   `json:"iss"`
+	Subject            string   `json:"sub,omitempty"`
+	IssuedAt           int64    `json:"iat"`
+	ExpiresAt          int64    `json:"exp"`
+	Valid              bool     `json:"valid"`
+	Code               string   `json:"code"`
+	Message            string   `json:"message"`
+	Status             string   `json:"status,omitempty"`
+	ProductID          string   `json:"product_id,omitempty"`
+	Entitlements       []string `json:"entitlements,omitempty"`
+	Version            string   `json:"version,omitempty"`
+	LicensedMajor      int      `json:"licensed_major,omitempty"`
+	UpgradeURL         string   `json:"upgrade_url,omitempty"`
+	TrialDaysRemaining int      `json:"trial_days_remaining,omitempty"`
+	KeyHash            string   `json:"key_hash"`
+	AppVersion         string   `json:"app_version,omitempty"`
+	DeviceID           string   `json:"device_id,omitempty"`
+	Nonce              string   `json:"nonce,omitempty"`
+}
+
+type ValidationTokenResponse struct {
+	Token string      `json:"token"`
+	Lease *LeaseToken `json:"lease,omitempty"`
+}
+
+// wantsJWT reports whether the caller asked for a JWT instead of the
+// pipe-delimited signature, either by sending "Accept: application/jwt" or
+// by calling the v2 endpoint.
+func wantsJWT(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, validationTokenPathVersion) {
+		return true
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accepted); err == nil && mediaType == jwtMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) writeValidationToken(w http.ResponseWriter, req LicenseRequest, license *models.License, response ValidateResponse) {
+	now := time.Now()
+	claims := ValidationClaims{
+		Issuer:             tokenIssuer,
+		IssuedAt:           now.Unix(),
+		ExpiresAt:          now.Add(validationTokenTTL()).Unix(),
+		Valid:              response.Valid,
+		Code:               response.Code,
+		Message:            response.Message,
+		Entitlements:       response.Entitlements,
+		LicensedMajor:      response.LicensedMajor,
+		UpgradeURL:         response.UpgradeURL,
+		TrialDaysRemaining: response.TrialDaysRemaining,
+		KeyHash:            models.HashLicenseKey(req.LicenseKey),
+		AppVersion:         req.AppVersion,
+		DeviceID:           req.DeviceID,
+		Nonce:              req.Nonce,
+	}
+
+	if license != nil {
+		claims.Subject = license.ID
+		claims.Status = license.Status
+		claims.ProductID = license.ProductID
+		claims.Version = effectiveLicenseVersion(license)
+		claims.KeyHash = models.HashLicenseKey(license.Key)
+
+		// A token never outlives the license it vouches for
+		if license.ExpiresAt != nil && license.ExpiresAt.Unix() < claims.ExpiresAt {
+			claims.ExpiresAt = license.ExpiresAt.Unix()
+		}
+	}
+
+	token, err := s.signingKey(req.SignatureAlgorithm).JWT(claims)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to sign validation token", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ValidationTokenResponse{Token: token, Lease: response.Lease}); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode validation token response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+func validationTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("VALIDATION_TOKEN_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultValidationTokenTTL
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/internal/signing"
+	"auto-focus.app/cloud/models"
+)
+
+func makeTokenRequest(t *testing.T, server *Server, path, accept string, reqBody LicenseRequest) ValidationTokenResponse {
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	w := httptest.NewRecorder()
+	server.ValidateLicense(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response ValidationTokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Token == "" {
+		t.Fatalf("Expected a token in the response")
+	}
+
+	return response
+}
+
+// decodeJWT splits a compact JWT, checks its header and signature, and
+// unmarshals its claims into v.
+func decodeJWT(t *testing.T, token string, publicKey ed25519.PublicKey, v interface{}) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected header.claims.signature token, got '%s'", token)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		Type      string `json:"typ"`
+		KeyID     string `json:"kid"`
+	}
+	rawHeader, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		t.Fatalf("Failed to unmarshal header: %v", err)
+	}
+	if header.Algorithm != "EdDSA" || header.Type != "JWT" || header.KeyID != legacyEd25519KeyID {
+		t.Errorf("Unexpected JWT header %+v", header)
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	if !ed25519.Verify(publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		t.Errorf("Expected JWT signature to verify with the published key")
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if err := json.Unmarshal(payload, v); err != nil {
+		t.Fatalf("Failed to unmarshal claims: %v", err)
+	}
+}
+
+func TestValidateLicense_JWT(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+	}{
+		{name: "accept header", path: "/v1/licenses/validate", accept: "application/json, application/jwt"},
+		{name: "v2 endpoint", path: "/v2/licenses/validate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, createTestStorage())
+
+			response := makeTokenRequest(t, server, tt.path, tt.accept, LicenseRequest{
+				LicenseKey:         "AFP-7a11d123",
+				AppVersion:         "1.4.11",
+				Nonce:              "token-" + tt.name,
+				Timestamp:          time.Now().Unix(),
+				SignatureAlgorithm: signing.AlgorithmEd25519,
+			})
+
+			var claims ValidationClaims
+			decodeJWT(t, response.Token, publicKey, &claims)
+
+			if !claims.Valid || claims.Code != CodeValid {
+				t.Errorf("Expected a valid claim, got %v/'%s'", claims.Valid, claims.Code)
+			}
+			if claims.Subject != "license-1" || claims.Status != models.StatusActive {
+				t.Errorf("Expected license-1 active, got '%s'/'%s'", claims.Subject, claims.Status)
+			}
+			if claims.KeyHash != models.HashLicenseKey("AFP-7a11d123") {
+				t.Errorf("Expected claims bound to the license key hash")
+			}
+			if claims.Nonce != "token-"+tt.name {
+				t.Errorf("Expected nonce to be echoed, got '%s'", claims.Nonce)
+			}
+			if got := time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second; got != defaultValidationTokenTTL {
+				t.Errorf("Expected token lifetime %v, got %v", defaultValidationTokenTTL, got)
+			}
+		})
+	}
+}
+
+func TestValidateLicense_JWTRejection(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+
+	server := newTestServer(t, createTestStorage())
+	response := makeTokenRequest(t, server, "/v2/licenses/validate", "", LicenseRequest{
+		LicenseKey:         "AFP-5a5bed00",
+		AppVersion:         "1.4.11",
+		SignatureAlgorithm: signing.AlgorithmEd25519,
+	})
+
+	var claims ValidationClaims
+	decodeJWT(t, response.Token, privateKey.Public().(ed25519.PublicKey), &claims)
+
+	if claims.Valid || claims.Code != CodeLicenseSuspended {
+		t.Errorf("Expected suspended rejection, got %v/'%s'", claims.Valid, claims.Code)
+	}
+}
+
+func TestValidateLicense_JWTLease(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	t.Setenv("ED25519_PRIVATE_KEY", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+
+	server := newTestServer(t, createTestStorage())
+	activateTestDevice(t, server, "device-1")
+
+	response := makeTokenRequest(t, server, "/v2/licenses/validate", "", LicenseRequest{
+		LicenseKey:         "AFP-7a11d123",
+		AppVersion:         "1.4.11",
+		DeviceID:           "device-1",
+		SignatureAlgorithm: signing.AlgorithmEd25519,
+		Lease:              true,
+	})
+
+	if response.Lease == nil {
+		t.Fatalf("Expected a lease in the response")
+	}
+
+	var claims LeaseClaims
+	decodeJWT(t, response.Lease.Token, privateKey.Public().(ed25519.PublicKey), &claims)
+
+	if claims.DeviceID != "device-1" || claims.ExpiresAt != response.Lease.ExpiresAt {
+		t.Errorf("Unexpected lease claims %+v", claims)
+	}
+}
+
+func TestWantsJWT(t *testing.T) {
+	tests := []struct {
+		path   string
+		accept string
+		want   bool
+	}{
+		{path: "/v1/licenses/validate", want: false},
+		{path: "/v1/licenses/validate", accept: "application/json", want: false},
+		{path: "/v1/licenses/validate", accept: "application/jwt", want: true},
+		{path: "/v1/licenses/validate", accept: "text/html, application/jwt;q=0.9", want: true},
+		{path: "/v2/licenses/validate", want: true},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if got := wantsJWT(req); got != tt.want {
+			t.Errorf("wantsJWT(%s, %q) = %v, want %v", tt.path, tt.accept, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/licensekey"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/internal/version"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultTrialDays      = 14
+	defaultTrialProductID = "trial"
+)
+
+type TrialRequest struct {
+	Email      string `json:"email"`
+	DeviceID   string `json:"device_id"`
+	DeviceName string `json:"device_name,omitempty"`
+	AppVersion string `json:"app_version,omitempty"`
+}
+
+type TrialResponse struct {
+	LicenseKey         string `json:"license_key"`
+	ExpiresAt          int64  `json:"expires_at"`
+	TrialDaysRemaining int    `json:"trial_days_remaining"`
+}
+
+// StartTrial issues a time-limited trial key bound to one device. Each
+// device and each email address gets a single trial, so reinstalling the
+// app no longer resets it.
+func (s *Server) StartTrial(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != "POST" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only POST allowed")
+		return
+	}
+
+	var req TrialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	used, err := s.trialUsed(ctx, req)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to check previous trials", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if used {
+		logger.Info("Repeat trial refused", map[string]interface{}{
+			"remote_addr": r.RemoteAddr,
+		})
+		writeErrorResponse(w, http.StatusConflict, "trial already used")
+		return
+	}
+
+	license, err := s.createTrial(ctx, req)
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to create trial", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	logger.Info("Trial started", map[string]interface{}{
+		"license_id":  license.ID,
+		"customer_id": license.CustomerID,
+		"expires_at":  license.ExpiresAt.Format(time.RFC3339),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(TrialResponse{
+		LicenseKey:         license.Key,
+		ExpiresAt:          license.ExpiresAt.Unix(),
+		TrialDaysRemaining: trialDaysRemaining(license, time.Now()),
+	}); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode trial response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// trialUsed reports whether the device or the email address already had a
+// trial, converted or not.
+func (s *Server) trialUsed(ctx context.Context, req TrialRequest) (bool, error) {
+	trial, err := s.Storage.FindTrialByDevice(ctx, req.DeviceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to find trial by device: %w", err)
+	}
+	if trial != nil {
+		return true, nil
+	}
+
+	customer, err := s.Storage.FindCustomerByEmailAddress(ctx, req.Email)
+	if err != nil {
+		return false, fmt.Errorf("failed to find customer: %w", err)
+	}
+	if customer == nil {
+		return false, nil
+	}
+
+	licenses, err := s.Storage.FindLicensesByCustomer(ctx, customer.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to find licenses: %w", err)
+	}
+
+	for _, license := range licenses {
+		if license.TrialDeviceID != "" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *Server) createTrial(ctx context.Context, req TrialRequest) (*models.License, error) {
+	now := time.Now()
+
+	customer, err := s.Storage.FindCustomerByEmailAddress(ctx, req.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find customer: %w", err)
+	}
+
+	if customer == nil {
+		customer = &models.Customer{
+			ID:        uuid.Must(uuid.NewRandom()).String(),
+			Email:     req.Email,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := s.Storage.SaveCustomer(ctx, customer); err != nil {
+			return nil, fmt.Errorf("failed to save customer: %w", err)
+		}
+	}
+
+	expiresAt := now.AddDate(0, 0, trialDays())
+	license := &models.License{
+		ID:            uuid.Must(uuid.NewRandom()).String(),
+		Key:           generateLicenseKey(licensekey.DefaultPrefix),
+		CustomerID:    customer.ID,
+		ProductID:     trialProductID(),
+		Entitlements:  productEntitlements(trialProductID()),
+		ProductName:   "trial",
+		Version:       req.AppVersion,
+		Status:        models.StatusTrial,
+		TrialDeviceID: req.DeviceID,
+		ExpiresAt:     &expiresAt,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := s.saveNewLicense(ctx, license); err != nil {
+		return nil, fmt.Errorf("failed to save license: %w", err)
+	}
+
+	// The trial device counts as activated so device-bound validation passes
+	activation := &models.Activation{
+		ID:         uuid.Must(uuid.NewRandom()).String(),
+		LicenseID:  license.ID,
+		DeviceID:   req.DeviceID,
+		DeviceName: req.DeviceName,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.Storage.SaveActivation(ctx, activation); err != nil {
+		return nil, fmt.Errorf("failed to save activation: %w", err)
+	}
+
+	return license, nil
+}
+
+// findConvertibleTrial returns the customer's trial named in the checkout's
+// trial_license_key metadata, which only the app holding the trial knows.
+// Trials are never picked by email alone: nobody verifies the address a trial
+// is started with, so whoever started it may not be the buyer. A key
+// belonging to someone else's trial is ignored as well.
+func (s *Server) findConvertibleTrial(ctx context.Context, customer *models.Customer, metadata map[string]string) (*models.License, error) {
+	key := metadata["trial_license_key"]
+	if key == "" {
+		return nil, nil
+	}
+
+	license, err := s.Storage.FindLicenseByKey(ctx, licensekey.Normalize(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find trial license: %w", err)
+	}
+	if license == nil || !license.IsTrial() || license.CustomerID != customer.ID {
+		return nil, nil
+	}
+
+	return license, nil
+}
+
+// convertTrial turns paid into the upgrade of trial, keeping the trial's key
+// so the app stays licensed without entering a new one.
+func convertTrial(trial, paid *models.License) {
+	paid.ID = trial.ID
+	paid.Key = trial.Key
+	paid.TrialDeviceID = trial.TrialDeviceID
+	paid.CreatedAt = trial.CreatedAt
+
+	now := time.Now()
+	paid.StatusChangedAt = &now
+}
+
+// trialDaysRemaining rounds up, so a trial reports 1 day left until it ends.
+func trialDaysRemaining(license *models.License, now time.Time) int {
+	if license.ExpiresAt == nil || !now.Before(*license.ExpiresAt) {
+		return 0
+	}
+	return int(math.Ceil(license.ExpiresAt.Sub(now).Hours() / 24))
+}
+
+func (tr TrialRequest) validate() error {
+	if !strings.Contains(tr.Email, "@") {
+		return fmt.Errorf("valid email required")
+	}
+	if strings.TrimSpace(tr.DeviceID) == "" {
+		return fmt.Errorf("device_id required")
+	}
+	// The trial license is issued for this version
+	if tr.AppVersion != "" {
+		if _, err := version.ExtractMajorVersion(tr.AppVersion); err != nil {
+			return fmt.Errorf("invalid app_version")
+		}
+	}
+	return nil
+}
+
+func trialDays() int {
+	if days, err := strconv.Atoi(os.Getenv("TRIAL_DAYS")); err == nil && days > 0 {
+		return days
+	}
+	return defaultTrialDays
+}
+
+func trialProductID() string {
+	if productID := os.Getenv("TRIAL_PRODUCT_ID"); productID != "" {
+		return productID
+	}
+	return defaultTrialProductID
+}
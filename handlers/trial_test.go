@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/internal/signing"
+	"auto-focus.app/cloud/models"
+	"github.com/stripe/stripe-go/v82"
+)
+
+func makeTrialRequest(t *testing.T, server *Server, reqBody TrialRequest) *httptest.ResponseRecorder {
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/trials", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.StartTrial(w, req)
+
+	return w
+}
+
+func startTestTrial(t *testing.T, server *Server, email, deviceID string) TrialResponse {
+	w := makeTrialRequest(t, server, TrialRequest{Email: email, DeviceID: deviceID, AppVersion: "1.4.11"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response TrialResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+	return response
+}
+
+func TestStartTrial_Success(t *testing.T) {
+	t.Setenv("TRIAL_DAYS", "7")
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	response := startTestTrial(t, server, "trial@example.com", "device-trial")
+
+	if response.TrialDaysRemaining != 7 {
+		t.Errorf("Expected 7 trial days remaining, got %d", response.TrialDaysRemaining)
+	}
+
+	license, _ := storage.FindLicenseByKey(context.Background(), response.LicenseKey)
+	if license == nil {
+		t.Fatalf("Expected trial license to be stored")
+	}
+
+	if license.Status != models.StatusTrial || license.TrialDeviceID != "device-trial" || license.ExpiresAt == nil {
+		t.Errorf("Expected trial license bound to device-trial with expiry, got %+v", license)
+	}
+
+	activation, _ := storage.FindActivation(context.Background(), license.ID, "device-trial")
+	if activation == nil {
+		t.Errorf("Expected trial device to be activated")
+	}
+}
+
+func TestStartTrial_RefusesRepeatTrials(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	startTestTrial(t, server, "trial@example.com", "device-trial")
+
+	tests := []struct {
+		name     string
+		email    string
+		deviceID string
+	}{
+		{"same device", "other@example.com", "device-trial"},
+		{"same email", "trial@example.com", "device-other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := makeTrialRequest(t, server, TrialRequest{Email: tt.email, DeviceID: tt.deviceID})
+			if w.Code != http.StatusConflict {
+				t.Errorf("Expected status %d, got %d", http.StatusConflict, w.Code)
+			}
+		})
+	}
+}
+
+func TestStartTrial_InvalidRequest(t *testing.T) {
+	server := newTestServer(t, createTestStorage())
+
+	for _, req := range []TrialRequest{
+		{Email: "", DeviceID: "device-1"},
+		{Email: "not-an-email", DeviceID: "device-1"},
+		{Email: "trial@example.com", DeviceID: " "},
+		{Email: "trial@example.com", DeviceID: "device-1", AppVersion: "latest"},
+	} {
+		if w := makeTrialRequest(t, server, req); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %+v, got %d", http.StatusBadRequest, req, w.Code)
+		}
+	}
+}
+
+func TestValidateLicense_TrialDaysRemaining(t *testing.T) {
+	t.Setenv("TRIAL_DAYS", "10")
+	t.Setenv("HMAC_SECRET", "test-secret")
+	server := newTestServer(t, createTestStorage())
+
+	trial := startTestTrial(t, server, "trial@example.com", "device-trial")
+
+	response := makeLeaseRequest(t, server, LicenseRequest{LicenseKey: trial.LicenseKey, AppVersion: "1.4.11", DeviceID: "device-trial", PayloadVersion: payloadVersionCodes})
+
+	if !response.Valid || response.Message != "trial valid" {
+		t.Errorf("Expected valid trial, got %t/%s", response.Valid, response.Message)
+	}
+
+	if response.TrialDaysRemaining != 10 {
+		t.Errorf("Expected 10 trial days remaining, got %d", response.TrialDaysRemaining)
+	}
+
+	payload := fmt.Sprintf("%t|%s|%d|1.4.11|%s|%s|0||10", response.Valid, response.Message, response.Timestamp, joinEntitlements(response.Entitlements), CodeTrial)
+	if response.Signature != signing.NewHMACSigner([]byte("test-secret")).Sign([]byte(payload)) {
+		t.Errorf("Expected signature to cover the trial days remaining")
+	}
+
+	// Payloads that do not sign the days left leave them out
+	response = makeLeaseRequest(t, server, LicenseRequest{LicenseKey: trial.LicenseKey, AppVersion: "1.4.11", DeviceID: "device-trial"})
+	if response.TrialDaysRemaining != 0 {
+		t.Errorf("Expected no unsigned trial days remaining, got %d", response.TrialDaysRemaining)
+	}
+}
+
+func TestValidateLicense_ExpiredTrial(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	trial := startTestTrial(t, server, "trial@example.com", "device-trial")
+
+	license, _ := storage.FindLicenseByKey(context.Background(), trial.LicenseKey)
+	past := time.Now().Add(-time.Hour)
+	license.ExpiresAt = &past
+	_ = storage.SaveLicense(context.Background(), license)
+
+	response := makeLeaseRequest(t, server, LicenseRequest{LicenseKey: trial.LicenseKey, AppVersion: "1.4.11", DeviceID: "device-trial", PayloadVersion: payloadVersionCodes})
+
+	if response.Valid || response.Code != CodeLicenseExpired {
+		t.Errorf("Expected expired trial, got %t/%s", response.Valid, response.Code)
+	}
+}
+
+func TestCreateLicensedUser_ConvertsTrial(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	trial := startTestTrial(t, server, "trial@example.com", "device-trial")
+
+	session := &stripe.CheckoutSession{
+		ID:              "cs_convert",
+		AmountTotal:     1900,
+		Currency:        "usd",
+		CustomerDetails: &stripe.CheckoutSessionCustomerDetails{Email: "trial@example.com"},
+		Metadata: map[string]string{
+			"product_id":        "prod_test123",
+			"license_version":   "1.0.0",
+			"trial_license_key": trial.LicenseKey,
+		},
+	}
+
+	_, license, err := server.createLicensedUser(context.Background(), session, "trial@example.com")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if license.Key != trial.LicenseKey {
+		t.Errorf("Expected converted license to keep key '%s', got '%s'", trial.LicenseKey, license.Key)
+	}
+
+	stored, _ := storage.FindLicenseByKey(context.Background(), trial.LicenseKey)
+	if stored.Status != models.StatusActive || stored.ExpiresAt != nil || stored.StripeSessionID != "cs_convert" || stored.IsTrial() {
+		t.Errorf("Expected active perpetual paid license, got %+v", stored)
+	}
+
+	if w := makeTrialRequest(t, server, TrialRequest{Email: "new@example.com", DeviceID: "device-trial"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected converted trial device to stay ineligible, got %d", w.Code)
+	}
+}
+
+func TestCreateLicensedUser_TrialNeedsKeyInMetadata(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	// Anyone can start a trial with someone else's address
+	planted := startTestTrial(t, server, "victim@example.com", "device-attacker")
+
+	session := &stripe.CheckoutSession{
+		ID:              "cs_victim",
+		AmountTotal:     1900,
+		Currency:        "usd",
+		CustomerDetails: &stripe.CheckoutSessionCustomerDetails{Email: "victim@example.com"},
+		Metadata: map[string]string{
+			"product_id":      "prod_test123",
+			"license_version": "1.0.0",
+		},
+	}
+
+	_, license, err := server.createLicensedUser(context.Background(), session, "victim@example.com")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if license.Key == planted.LicenseKey {
+		t.Errorf("Expected a fresh key, not the trial started with the buyer's address")
+	}
+	if stored, _ := storage.FindLicenseByKey(context.Background(), planted.LicenseKey); !stored.IsTrial() {
+		t.Errorf("Expected the trial left alone, got %s", stored.Status)
+	}
+}
+
+func TestCreateLicensedUser_TrialKeyMustBelongToBuyer(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	victim := startTestTrial(t, server, "victim@example.com", "device-victim")
+	own := startTestTrial(t, server, "buyer@example.com", "device-buyer")
+
+	checkout := func(id, trialKey string) *models.License {
+		session := &stripe.CheckoutSession{
+			ID:              id,
+			AmountTotal:     1900,
+			Currency:        "usd",
+			CustomerDetails: &stripe.CheckoutSessionCustomerDetails{Email: "buyer@example.com"},
+			Metadata: map[string]string{
+				"product_id":        "prod_test123",
+				"license_version":   "1.0.0",
+				"trial_license_key": trialKey,
+			},
+		}
+		_, license, err := server.createLicensedUser(context.Background(), session, "buyer@example.com")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return license
+	}
+
+	// Someone else's trial key is ignored and the buyer gets a fresh key
+	if license := checkout("cs_other", victim.LicenseKey); license.Key == victim.LicenseKey || license.Key == own.LicenseKey {
+		t.Errorf("Expected a fresh key, got '%s'", license.Key)
+	}
+	if stored, _ := storage.FindLicenseByKey(context.Background(), victim.LicenseKey); !stored.IsTrial() {
+		t.Errorf("Expected the other customer's trial left alone, got %s", stored.Status)
+	}
+}
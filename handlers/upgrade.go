@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"auto-focus.app/cloud/internal/licensekey"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/internal/signing"
+	"auto-focus.app/cloud/internal/version"
+	"auto-focus.app/cloud/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/stripe/stripe-go/v82"
+)
+
+var (
+	ErrUpgradeLicenseNotFound = errors.New("license not found")
+	ErrUpgradeNotEligible     = errors.New("license not eligible for upgrade")
+	ErrAlreadyUpgraded        = errors.New("license already upgraded")
+	ErrAlreadyLatestVersion   = errors.New("license already covers the latest version")
+	ErrUpgradePending         = errors.New("upgrade already paid, license pending")
+)
+
+const (
+	// upgradeTokenMetadata is the checkout session metadata key the upgrade
+	// token is passed back in
+	upgradeTokenMetadata = "upgrade_token"
+	upgradeTokenAudience = "license-upgrade"
+	upgradeTokenKeyID    = "upgrade"
+
+	// Stripe only lets checkout sessions expire between 30 minutes and 24
+	// hours after they are created
+	defaultUpgradeOfferTTL = 24 * time.Hour
+	minUpgradeOfferTTL     = 30 * time.Minute
+)
+
+type UpgradeRequest struct {
+	LicenseKey string `json:"license_key"`
+}
+
+// UpgradeOfferResponse links to the discounted checkout opened for the
+// upgrade, which stays payable until ExpiresAt.
+type UpgradeOfferResponse struct {
+	CheckoutURL    string `json:"checkout_url"`
+	ExpiresAt      int64  `json:"expires_at"`
+	LicenseVersion string `json:"license_version,omitempty"`
+}
+
+// UpgradeClaims bind an upgrade offer to the license being upgraded.
+type UpgradeClaims struct {
+	Audience  string `json:"aud"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// UpgradeLicense checks that a license may be upgraded and opens the
+// discounted upgrade checkout for it. Asking again while that checkout is
+// open returns the same one, and once the upgrade is bought the license is
+// no longer eligible, so the discount is bought at most once per license.
+func (s *Server) UpgradeLicense(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != "POST" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only POST allowed")
+		return
+	}
+
+	var req UpgradeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if strings.TrimSpace(req.LicenseKey) == "" {
+		writeErrorResponse(w, http.StatusBadRequest, "license_key required")
+		return
+	}
+
+	tokenKey, ok := upgradeTokenKey()
+	if !ok || os.Getenv("UPGRADE_PRICE_ID") == "" {
+		writeErrorResponse(w, http.StatusServiceUnavailable, "upgrades not available")
+		return
+	}
+
+	license, err := s.Storage.FindLicenseByKey(ctx, licensekey.Normalize(req.LicenseKey))
+	if err == nil {
+		err = checkUpgradeEligible(license)
+	}
+
+	var checkout *stripe.CheckoutSession
+	if err == nil {
+		checkout, err = s.openUpgradeCheckout(ctx, license, tokenKey)
+	}
+
+	switch {
+	case errors.Is(err, ErrUpgradeLicenseNotFound):
+		writeErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, ErrUpgradeNotEligible):
+		writeErrorResponse(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, ErrAlreadyUpgraded), errors.Is(err, ErrAlreadyLatestVersion), errors.Is(err, ErrUpgradePending):
+		writeErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		sentry.CaptureException(err)
+		logger.Error("Error while fetch license", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	logger.Info("Upgrade offer issued", map[string]interface{}{
+		"license_id": license.ID,
+		"session_id": checkout.ID,
+		"version":    effectiveLicenseVersion(license),
+	})
+
+	response := UpgradeOfferResponse{
+		CheckoutURL:    checkout.URL,
+		ExpiresAt:      checkout.ExpiresAt,
+		LicenseVersion: os.Getenv("UPGRADE_LICENSE_VERSION"),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode upgrade response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// openUpgradeCheckout returns the license's open upgrade checkout, opening
+// a new one with the upgrade discount when there is none. The session is
+// recorded on the license, and only that session completes the upgrade.
+func (s *Server) openUpgradeCheckout(ctx context.Context, license *models.License, tokenKey signing.Key) (*stripe.CheckoutSession, error) {
+	if license.UpgradeSessionID != "" {
+		existing, err := s.CheckoutSessions.Get(ctx, license.UpgradeSessionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get upgrade checkout: %w", err)
+		}
+
+		switch existing.Status {
+		case stripe.CheckoutSessionStatusOpen:
+			return existing, nil
+		case stripe.CheckoutSessionStatusComplete:
+			// Paid, but its webhook hasn't linked the new license yet
+			return nil, ErrUpgradePending
+		}
+	}
+
+	customer, err := s.Storage.GetCustomer(ctx, license.CustomerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get customer: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(upgradeOfferTTL())
+	token, err := tokenKey.JWT(UpgradeClaims{
+		Audience:  upgradeTokenAudience,
+		Subject:   license.ID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign upgrade token: %w", err)
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		Mode: stripe.String(string(stripe.CheckoutSessionModePayment)),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{Price: stripe.String(os.Getenv("UPGRADE_PRICE_ID")), Quantity: stripe.Int64(1)},
+		},
+		SuccessURL: stripe.String(upgradeURL()),
+		ExpiresAt:  stripe.Int64(expiresAt.Unix()),
+		Metadata: map[string]string{
+			"product_id":         license.ProductID,
+			"license_version":    os.Getenv("UPGRADE_LICENSE_VERSION"),
+			"key_prefix":         licensekey.Prefix(license.Key),
+			upgradeTokenMetadata: token,
+		},
+	}
+	if coupon := os.Getenv("UPGRADE_COUPON_ID"); coupon != "" {
+		params.Discounts = []*stripe.CheckoutSessionDiscountParams{{Coupon: stripe.String(coupon)}}
+	}
+	if customer != nil && customer.StripeCustomerID != "" {
+		params.Customer = stripe.String(customer.StripeCustomerID)
+	} else if customer != nil {
+		params.CustomerEmail = stripe.String(customer.Email)
+	}
+
+	checkout, err := s.CheckoutSessions.Create(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upgrade checkout: %w", err)
+	}
+
+	license.UpgradeSessionID = checkout.ID
+	license.UpdatedAt = now
+	if err := s.Storage.SaveLicense(ctx, license); err != nil {
+		return nil, fmt.Errorf("failed to save upgrade checkout: %w", err)
+	}
+
+	return checkout, nil
+}
+
+// checkUpgradeEligible reports why license cannot be upgraded, if it can't.
+// Only paid, active, individual licenses below UPGRADE_LICENSE_VERSION
+// qualify.
+func checkUpgradeEligible(license *models.License) error {
+	if license == nil {
+		return ErrUpgradeLicenseNotFound
+	}
+
+	if license.UpgradedToID != "" {
+		return ErrAlreadyUpgraded
+	}
+
+	if license.Status != models.StatusActive || license.IsTrial() || license.IsTeam() || license.IsSeat() {
+		return ErrUpgradeNotEligible
+	}
+
+	target := os.Getenv("UPGRADE_LICENSE_VERSION")
+	current := effectiveLicenseVersion(license)
+	if target == "" || current == legacyLicenseVersionAny {
+		return nil
+	}
+
+	targetMajor, err := version.ExtractMajorVersion(target)
+	if err != nil {
+		return fmt.Errorf("invalid UPGRADE_LICENSE_VERSION: %w", err)
+	}
+	currentMajor, err := version.ExtractMajorVersion(current)
+	if err == nil && currentMajor >= targetMajor {
+		return ErrAlreadyLatestVersion
+	}
+
+	return nil
+}
+
+// findUpgradedLicense returns the license an upgrade checkout was bought
+// for, or nil when the checkout is not an upgrade. The token must verify
+// and the session must be the one opened for the license's offer; anything
+// else is logged and the purchase goes through as a regular one, without
+// touching the license named in the token.
+func (s *Server) findUpgradedLicense(ctx context.Context, session *stripe.CheckoutSession) (*models.License, error) {
+	token := session.Metadata[upgradeTokenMetadata]
+	if token == "" {
+		return nil, nil
+	}
+
+	tokenKey, ok := upgradeTokenKey()
+	if !ok {
+		logger.Warn("Ignoring upgrade token, UPGRADE_TOKEN_SECRET not set", map[string]interface{}{
+			"session_id": session.ID,
+		})
+		return nil, nil
+	}
+
+	keyring, err := signing.NewKeyring(tokenKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The session itself stops being payable when the offer expires, so a
+	// webhook retried after that is still honoured
+	var claims UpgradeClaims
+	if err := keyring.VerifyJWT(token, &claims); err != nil || claims.Audience != upgradeTokenAudience {
+		logger.Warn("Ignoring invalid upgrade token", map[string]interface{}{
+			"session_id": session.ID,
+		})
+		return nil, nil
+	}
+
+	license, err := s.Storage.GetLicense(ctx, claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upgraded license: %w", err)
+	}
+
+	if license != nil && license.UpgradeSessionID != session.ID {
+		logger.Warn("Ignoring upgrade token used outside its checkout", map[string]interface{}{
+			"license_id": claims.Subject,
+			"session_id": session.ID,
+		})
+		return nil, nil
+	}
+
+	// The version is not checked again: the offer was valid when issued
+	if err := checkUpgradeEligible(license); err != nil && !errors.Is(err, ErrAlreadyLatestVersion) {
+		logger.Warn("Ignoring upgrade token for ineligible license", map[string]interface{}{
+			"error":      err.Error(),
+			"license_id": claims.Subject,
+		})
+		return nil, nil
+	}
+
+	return license, nil
+}
+
+// completeUpgrade marks previous as replaced by license and, with
+// UPGRADE_RETIRE_PREVIOUS, revokes it.
+func (s *Server) completeUpgrade(ctx context.Context, previous, license *models.License) error {
+	now := time.Now()
+	previous.UpgradedToID = license.ID
+	previous.UpdatedAt = now
+
+	if os.Getenv("UPGRADE_RETIRE_PREVIOUS") == "true" {
+		previous.SetStatus(models.StatusRevoked, models.StatusReasonUpgraded, now)
+	}
+
+	if err := s.Storage.SaveLicense(ctx, previous); err != nil {
+		return fmt.Errorf("failed to save upgraded license: %w", err)
+	}
+
+	logger.Info("License upgraded", map[string]interface{}{
+		"license_id":       license.ID,
+		"upgraded_from_id": previous.ID,
+		"previous_status":  previous.Status,
+	})
+
+	return nil
+}
+
+func upgradeOfferTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("UPGRADE_OFFER_TTL")); err == nil && ttl > 0 {
+		return min(max(ttl, minUpgradeOfferTTL), defaultUpgradeOfferTTL)
+	}
+	return defaultUpgradeOfferTTL
+}
+
+// upgradeTokenKey returns the key upgrade tokens are signed with. Unlike the
+// validation keys it never ships with the app, so tokens cannot be forged
+// from a copy of it; upgrades are unavailable until it is configured.
+func upgradeTokenKey() (signing.Key, bool) {
+	secret := os.Getenv("UPGRADE_TOKEN_SECRET")
+	if secret == "" {
+		return signing.Key{}, false
+	}
+
+	return signing.Key{
+		ID:     upgradeTokenKeyID,
+		Status: signing.StatusActive,
+		Signer: signing.NewHMACSigner([]byte(secret)),
+	}, true
+}
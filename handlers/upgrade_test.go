@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auto-focus.app/cloud/internal/signing"
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+	"github.com/stripe/stripe-go/v82"
+)
+
+// newUpgradeServer returns a server with upgrades configured, opening its
+// checkouts through the returned fake.
+func newUpgradeServer(t *testing.T, storage *storage.MemoryStorage) (*Server, *fakeCheckoutSessions) {
+	t.Setenv("UPGRADE_LICENSE_VERSION", "2.0.0")
+	t.Setenv("UPGRADE_PRICE_ID", "price_upgrade")
+	t.Setenv("UPGRADE_TOKEN_SECRET", "upgrade-secret")
+
+	checkouts := &fakeCheckoutSessions{}
+	server := newTestServer(t, storage)
+	server.CheckoutSessions = checkouts
+	return server, checkouts
+}
+
+func makeUpgradeRequest(server *Server, licenseKey string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(UpgradeRequest{LicenseKey: licenseKey})
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/upgrade", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	server.Mux.ServeHTTP(w, req)
+
+	return w
+}
+
+func requestUpgradeOffer(t *testing.T, server *Server, licenseKey string) UpgradeOfferResponse {
+	w := makeUpgradeRequest(server, licenseKey)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response UpgradeOfferResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+	return response
+}
+
+// completedUpgradeCheckout returns the webhook payload of the last upgrade
+// checkout the server opened, once paid.
+func completedUpgradeCheckout(checkouts *fakeCheckoutSessions) *stripe.CheckoutSession {
+	params := checkouts.created[len(checkouts.created)-1]
+	return &stripe.CheckoutSession{
+		ID:            fmt.Sprintf("cs_fake_%d", len(checkouts.created)),
+		CustomerEmail: "test@example.com",
+		AmountTotal:   999,
+		Currency:      "usd",
+		Metadata:      params.Metadata,
+	}
+}
+
+func TestUpgradeLicense_Offer(t *testing.T) {
+	t.Setenv("UPGRADE_COUPON_ID", "coupon_v1_upgrade")
+
+	storage := createTestStorage()
+	server, checkouts := newUpgradeServer(t, storage)
+	response := requestUpgradeOffer(t, server, "afp-7a11d123")
+
+	if len(checkouts.created) != 1 {
+		t.Fatalf("Expected 1 checkout opened, got %d", len(checkouts.created))
+	}
+	params := checkouts.created[0]
+	if *params.LineItems[0].Price != "price_upgrade" || len(params.Discounts) != 1 || *params.Discounts[0].Coupon != "coupon_v1_upgrade" {
+		t.Errorf("Expected discounted upgrade checkout, got %+v", params)
+	}
+	if response.CheckoutURL == "" || response.LicenseVersion != "2.0.0" {
+		t.Errorf("Expected checkout URL and target version, got %+v", response)
+	}
+	if got := time.Until(time.Unix(response.ExpiresAt, 0)); got <= 23*time.Hour || got > defaultUpgradeOfferTTL {
+		t.Errorf("Expected offer to last %v, got %v", defaultUpgradeOfferTTL, got)
+	}
+	if storage.Licenses["license-1"].UpgradeSessionID != "cs_fake_1" {
+		t.Errorf("Expected checkout recorded on license, got '%s'", storage.Licenses["license-1"].UpgradeSessionID)
+	}
+
+	// The token is signed with the server-only key, not the app's keys
+	token := params.Metadata[upgradeTokenMetadata]
+	var claims UpgradeClaims
+	if err := server.Keyring.VerifyJWT(token, &claims); err == nil {
+		t.Error("Expected upgrade token not to verify with the app keyring")
+	}
+	key, _ := upgradeTokenKey()
+	keyring, _ := signing.NewKeyring(key)
+	if err := keyring.VerifyJWT(token, &claims); err != nil || claims.Subject != "license-1" {
+		t.Errorf("Expected token for license-1, got %+v (%v)", claims, err)
+	}
+
+	// Asking again hands out the open checkout instead of another discount
+	if again := requestUpgradeOffer(t, server, "AFP-7a11d123"); again.CheckoutURL != response.CheckoutURL || len(checkouts.created) != 1 {
+		t.Errorf("Expected the open checkout to be reused, got %d checkouts", len(checkouts.created))
+	}
+
+	checkouts.sessions["cs_fake_1"].Status = stripe.CheckoutSessionStatusExpired
+	if renewed := requestUpgradeOffer(t, server, "AFP-7a11d123"); renewed.CheckoutURL == response.CheckoutURL || len(checkouts.created) != 2 {
+		t.Errorf("Expected a new checkout once the first expired, got %d checkouts", len(checkouts.created))
+	}
+}
+
+func TestUpgradeLicense_NotConfigured(t *testing.T) {
+	t.Setenv("UPGRADE_PRICE_ID", "price_upgrade")
+
+	server := newTestServer(t, createTestStorage())
+	if w := makeUpgradeRequest(server, "AFP-7a11d123"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d without UPGRADE_TOKEN_SECRET, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestUpgradeLicense_Ineligible(t *testing.T) {
+	storage := createTestStorage()
+	storage.Licenses["license-v2"] = models.License{ID: "license-v2", Key: "AFP-000c0de2", Version: "2.1.0", Status: models.StatusActive}
+	storage.Licenses["license-done"] = models.License{ID: "license-done", Key: "AFP-000c0de3", Version: "1.0.0", Status: models.StatusActive, UpgradedToID: "license-v2"}
+	storage.Licenses["license-paid"] = models.License{ID: "license-paid", Key: "AFP-000c0de4", Version: "1.0.0", Status: models.StatusActive, UpgradeSessionID: "cs_paid"}
+	server, checkouts := newUpgradeServer(t, storage)
+	checkouts.sessions = map[string]*stripe.CheckoutSession{
+		"cs_paid": {ID: "cs_paid", Status: stripe.CheckoutSessionStatusComplete},
+	}
+
+	tests := []struct {
+		name       string
+		licenseKey string
+		wantStatus int
+	}{
+		{name: "unknown key", licenseKey: "AFP-000c0de1", wantStatus: http.StatusNotFound},
+		{name: "suspended", licenseKey: "AFP-5a5bed00", wantStatus: http.StatusForbidden},
+		{name: "latest version", licenseKey: "AFP-000c0de2", wantStatus: http.StatusConflict},
+		{name: "already upgraded", licenseKey: "AFP-000c0de3", wantStatus: http.StatusConflict},
+		{name: "upgrade paid", licenseKey: "AFP-000c0de4", wantStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := makeUpgradeRequest(server, tt.licenseKey); w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if len(checkouts.created) != 0 {
+		t.Errorf("Expected no checkout opened for ineligible licenses, got %d", len(checkouts.created))
+	}
+}
+
+func TestHandleCheckoutComplete_Upgrade(t *testing.T) {
+	t.Setenv("UPGRADE_RETIRE_PREVIOUS", "true")
+
+	storage := createTestStorage()
+	server, checkouts := newUpgradeServer(t, storage)
+	requestUpgradeOffer(t, server, "AFP-7a11d123")
+	checkout := completedUpgradeCheckout(checkouts)
+
+	if err := server.handleCheckoutComplete(context.Background(), checkout); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	previous := storage.Licenses["license-1"]
+	upgraded := storage.Licenses[previous.UpgradedToID]
+	if upgraded.ID == "" || upgraded.UpgradedFromID != "license-1" {
+		t.Fatalf("Expected new license linked to license-1, got '%s'", previous.UpgradedToID)
+	}
+	if upgraded.Version != "2.0.0" || upgraded.Status != models.StatusActive {
+		t.Errorf("Expected active 2.0.0 license, got %s/%s", upgraded.Version, upgraded.Status)
+	}
+	if previous.Status != models.StatusRevoked || previous.StatusReason != models.StatusReasonUpgraded {
+		t.Errorf("Expected old license retired, got %s/%s", previous.Status, previous.StatusReason)
+	}
+
+	// The token cannot buy a second upgrade of the same license
+	session := completedUpgradeCheckout(checkouts)
+	session.ID = "cs_upgrade_again"
+	if err := server.handleCheckoutComplete(context.Background(), session); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, license := range storage.Licenses {
+		if license.StripeSessionID == "cs_upgrade_again" && license.UpgradedFromID != "" {
+			t.Errorf("Expected second purchase not to be linked, got '%s'", license.UpgradedFromID)
+		}
+	}
+	if storage.Licenses["license-1"].UpgradedToID != upgraded.ID {
+		t.Errorf("Expected original upgrade link to stay")
+	}
+}
+
+func TestHandleCheckoutComplete_UpgradeKeepsPrevious(t *testing.T) {
+	storage := createTestStorage()
+	server, checkouts := newUpgradeServer(t, storage)
+	requestUpgradeOffer(t, server, "AFP-7a11d123")
+
+	if err := server.handleCheckoutComplete(context.Background(), completedUpgradeCheckout(checkouts)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	previous := storage.Licenses["license-1"]
+	if previous.UpgradedToID == "" || previous.Status != models.StatusActive {
+		t.Errorf("Expected old license linked and still active, got '%s'/%s", previous.UpgradedToID, previous.Status)
+	}
+}
+
+func TestHandleCheckoutComplete_InvalidUpgradeToken(t *testing.T) {
+	t.Setenv("UPGRADE_RETIRE_PREVIOUS", "true")
+
+	storage := createTestStorage()
+	server, checkouts := newUpgradeServer(t, storage)
+	requestUpgradeOffer(t, server, "AFP-7a11d123")
+	offered := completedUpgradeCheckout(checkouts)
+
+	claims := UpgradeClaims{
+		Audience:  upgradeTokenAudience,
+		Subject:   "license-1",
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	// The app's HMAC key ships with the app, so anyone can sign with it
+	forged, _ := server.signingKey(signing.AlgorithmHMAC).JWT(claims)
+	key, _ := upgradeTokenKey()
+	wrongAudience, _ := key.JWT(ValidationClaims{
+		Subject:   "license-1",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+
+	tests := map[string]struct {
+		token     string
+		sessionID string
+	}{
+		"malformed":        {token: "a.b.c", sessionID: offered.ID},
+		"app key":          {token: forged, sessionID: offered.ID},
+		"validation token": {token: wrongAudience, sessionID: offered.ID},
+		"other checkout":   {token: offered.Metadata[upgradeTokenMetadata], sessionID: "cs_not_the_offer"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			before := len(storage.Licenses)
+			session := completedUpgradeCheckout(checkouts)
+			session.ID = tt.sessionID
+			session.Metadata = map[string]string{
+				"product_id":         "prod_v2",
+				"license_version":    "2.0.0",
+				upgradeTokenMetadata: tt.token,
+			}
+			if err := server.handleCheckoutComplete(context.Background(), session); err != nil {
+				t.Fatalf("Expected purchase to go through, got %v", err)
+			}
+			if len(storage.Licenses) != before+1 {
+				t.Errorf("Expected a new license to be created")
+			}
+
+			license := storage.Licenses["license-1"]
+			if license.UpgradedToID != "" || license.Status != models.StatusActive {
+				t.Errorf("Expected license-1 not upgraded or retired, got '%s'/%s", license.UpgradedToID, license.Status)
+			}
+
+			// Each case creates a license for the session, so start the
+			// next one from a clean slate
+			for id, created := range storage.Licenses {
+				if created.StripeSessionID == tt.sessionID {
+					delete(storage.Licenses, id)
+				}
+			}
+		})
+	}
+}
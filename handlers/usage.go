@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"auto-focus.app/cloud/internal/licensekey"
+	"auto-focus.app/cloud/internal/logger"
+	"auto-focus.app/cloud/models"
+	"auto-focus.app/cloud/storage"
+	"github.com/getsentry/sentry-go"
+)
+
+// UsageRecorder collects validation telemetry in memory so validations
+// never wait on a storage write. Flush hands the batched deltas to storage.
+type UsageRecorder struct {
+	mutex   sync.Mutex
+	pending map[string]*models.LicenseUsage
+}
+
+func NewUsageRecorder() *UsageRecorder {
+	return &UsageRecorder{pending: make(map[string]*models.LicenseUsage)}
+}
+
+// Record counts one validation of licenseID.
+func (u *UsageRecorder) Record(licenseID, appVersion, country string, at time.Time) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	usage := u.pending[licenseID]
+	if usage == nil {
+		usage = &models.LicenseUsage{LicenseID: licenseID}
+		u.pending[licenseID] = usage
+	}
+
+	usage.Merge(models.LicenseUsage{
+		ValidationCount: 1,
+		LastValidatedAt: at,
+		LastAppVersion:  appVersion,
+		LastCountry:     country,
+	})
+}
+
+// Flush writes everything recorded since the last flush. Deltas that fail
+// to save are put back so the next flush retries them.
+func (u *UsageRecorder) Flush(ctx context.Context, store storage.Storage) (int, error) {
+	u.mutex.Lock()
+	pending := u.pending
+	u.pending = make(map[string]*models.LicenseUsage)
+	u.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	usage := make([]*models.LicenseUsage, 0, len(pending))
+	for _, delta := range pending {
+		usage = append(usage, delta)
+	}
+
+	if err := store.RecordUsage(ctx, usage); err != nil {
+		u.mutex.Lock()
+		for licenseID, delta := range pending {
+			if newer := u.pending[licenseID]; newer != nil {
+				delta.Merge(*newer)
+			}
+			u.pending[licenseID] = delta
+		}
+		u.mutex.Unlock()
+		return 0, err
+	}
+
+	return len(usage), nil
+}
+
+// RunUsageFlush writes recorded usage to storage every interval until ctx
+// is cancelled.
+func (s *Server) RunUsageFlush(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.FlushUsage(ctx)
+	}
+}
+
+// FlushUsage writes the usage recorded since the last flush, so nothing is
+// lost when the server shuts down between two RunUsageFlush ticks.
+func (s *Server) FlushUsage(ctx context.Context) {
+	if _, err := s.Usage.Flush(ctx, s.Storage); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("License usage flush failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// recordUsage counts a validation of license, whether or not it turns out
+// to be valid.
+func (s *Server) recordUsage(r *http.Request, license *models.License, appVersion string) {
+	if license == nil {
+		return
+	}
+
+	s.Usage.Record(license.ID, appVersion, requestCountry(r), time.Now())
+}
+
+// requestCountry returns the caller's country code from the COUNTRY_HEADER
+// set by the proxy in front of us, or "" when unknown. Without a configured
+// header none is read, as clients could send any country themselves.
+func requestCountry(r *http.Request) string {
+	header := os.Getenv("COUNTRY_HEADER")
+	if header == "" {
+		return ""
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(r.Header.Get(header)))
+	// Cloudflare reports XX and T1 for unknown and Tor traffic
+	if country == "XX" || country == "T1" {
+		return ""
+	}
+	return country
+}
+
+type LicenseUsageResponse struct {
+	LicenseKey      string `json:"license_key"`
+	ValidationCount int64  `json:"validation_count"`
+	LastValidatedAt *int64 `json:"last_validated_at"`
+	LastAppVersion  string `json:"last_app_version,omitempty"`
+	LastCountry     string `json:"last_country,omitempty"`
+}
+
+// LicenseUsage shows admins how a license has been used. Validations since
+// the last flush are not included yet.
+func (s *Server) LicenseUsage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != "GET" {
+		writeErrorResponse(w, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	key := r.URL.Query().Get("license_key")
+	if strings.TrimSpace(key) == "" {
+		writeErrorResponse(w, http.StatusBadRequest, "license_key required")
+		return
+	}
+
+	license, err := s.Storage.FindLicenseByKey(ctx, licensekey.Normalize(key))
+	if err == nil && license == nil {
+		writeErrorResponse(w, http.StatusNotFound, "license not found")
+		return
+	}
+
+	var usage *models.LicenseUsage
+	if err == nil {
+		usage, err = s.Storage.GetLicenseUsage(ctx, license.ID)
+	}
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error while fetching license usage", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeErrorResponse(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	response := LicenseUsageResponse{LicenseKey: license.Key}
+	if usage != nil {
+		lastValidatedAt := usage.LastValidatedAt.Unix()
+		response.ValidationCount = usage.ValidationCount
+		response.LastValidatedAt = &lastValidatedAt
+		response.LastAppVersion = usage.LastAppVersion
+		response.LastCountry = usage.LastCountry
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Failed to encode usage response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
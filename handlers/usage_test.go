@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func makeUsageRequest(server *Server, token, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/licenses/usage?license_key="+key, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	server.Mux.ServeHTTP(w, req)
+
+	return w
+}
+
+func validateFromCountry(server *Server, key, appVersion, country string) {
+	body, _ := json.Marshal(LicenseRequest{LicenseKey: key, AppVersion: appVersion})
+	req := httptest.NewRequest(http.MethodPost, "/v1/licenses/validate", bytes.NewBuffer(body))
+	req.Header.Set("CF-IPCountry", country)
+
+	server.ValidateLicense(httptest.NewRecorder(), req)
+}
+
+func TestLicenseUsage_RecordedOnValidation(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+	t.Setenv("COUNTRY_HEADER", "CF-IPCountry")
+
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	validateFromCountry(server, "AFP-7a11d123", "1.4.10", "de")
+	validateFromCountry(server, "AFP-7a11d123", "1.4.11", "NO")
+	validateFromCountry(server, "AFP-00000404", "1.4.11", "NO")
+
+	// Nothing reaches storage until the recorder is flushed
+	if usage, _ := storage.GetLicenseUsage(context.Background(), "license-1"); usage != nil {
+		t.Fatalf("Expected usage to be buffered, got %+v", usage)
+	}
+
+	flushed, err := server.Usage.Flush(context.Background(), storage)
+	if err != nil || flushed != 1 {
+		t.Fatalf("Expected 1 license flushed, got %d (%v)", flushed, err)
+	}
+
+	w := makeUsageRequest(server, "admin-secret", "AFP-7a11d123")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response LicenseUsageResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if response.ValidationCount != 2 || response.LastAppVersion != "1.4.11" || response.LastCountry != "NO" {
+		t.Errorf("Expected 2 validations, last from 1.4.11 in NO, got %+v", response)
+	}
+
+	if response.LastValidatedAt == nil || time.Since(time.Unix(*response.LastValidatedAt, 0)) > time.Minute {
+		t.Errorf("Expected a recent last_validated_at, got %v", response.LastValidatedAt)
+	}
+}
+
+func TestLicenseUsage_NeverValidated(t *testing.T) {
+	t.Setenv("ADMIN_API_TOKEN", "admin-secret")
+
+	server := newTestServer(t, createTestStorage())
+
+	w := makeUsageRequest(server, "admin-secret", "AFP-5a5bed00")
+	var response LicenseUsageResponse
+	_ = json.NewDecoder(w.Body).Decode(&response)
+
+	if w.Code != http.StatusOK || response.ValidationCount != 0 || response.LastValidatedAt != nil {
+		t.Errorf("Expected empty usage, got %d: %+v", w.Code, response)
+	}
+
+	if w := makeUsageRequest(server, "", "AFP-5a5bed00"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	if w := makeUsageRequest(server, "admin-secret", "AFP-00000404"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for unknown key, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestUsageRecorder_FlushFailureKeepsUsage(t *testing.T) {
+	recorder := NewUsageRecorder()
+	now := time.Now()
+	recorder.Record("license-1", "1.4.10", "DE", now)
+
+	if _, err := recorder.Flush(context.Background(), &mockStorageWithErrors{}); err == nil {
+		t.Fatalf("Expected flush to fail")
+	}
+
+	recorder.Record("license-1", "1.4.11", "NO", now.Add(time.Second))
+
+	storage := createTestStorage()
+	if _, err := recorder.Flush(context.Background(), storage); err != nil {
+		t.Fatalf("Expected flush to succeed, got %v", err)
+	}
+
+	usage, _ := storage.GetLicenseUsage(context.Background(), "license-1")
+	if usage == nil || usage.ValidationCount != 2 || usage.LastAppVersion != "1.4.11" {
+		t.Errorf("Expected both validations to survive the failed flush, got %+v", usage)
+	}
+}
+
+func TestRunUsageFlush_FlushUsageOnShutdown(t *testing.T) {
+	storage := createTestStorage()
+	server := newTestServer(t, storage)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.RunUsageFlush(ctx, time.Hour)
+		close(done)
+	}()
+
+	validateLicenseKey(t, server, "AFP-7a11d123")
+	cancel()
+	<-done
+
+	// The ticker never fired; shutting down flushes what is left
+	server.FlushUsage(context.Background())
+
+	usage, _ := storage.GetLicenseUsage(context.Background(), "license-1")
+	if usage == nil || usage.ValidationCount != 1 {
+		t.Errorf("Expected the pending validation written on shutdown, got %+v", usage)
+	}
+}
@@ -17,6 +17,18 @@ import (
 
 // Integration tests that test complete workflows end-to-end
 
+// newTestServer creates a server on db, failing the test when the signing
+// keys in the environment cannot be loaded.
+func newTestServer(t testing.TB, db storage.Storage) *handlers.Server {
+	t.Helper()
+
+	server, err := handlers.NewHttpServer(db)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return server
+}
+
 func TestFullWorkflow_StripeWebhookToLicenseValidation(t *testing.T) {
 	// Setup storage
 	storage := &storage.MemoryStorage{
@@ -24,7 +36,7 @@ func TestFullWorkflow_StripeWebhookToLicenseValidation(t *testing.T) {
 		Licenses: make(map[string]models.License),
 	}
 
-	server := handlers.NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	// Step 1: Simulate Stripe webhook creating a license
 	checkoutSession := createMockStripeCheckoutSession("customer@example.com", "cs_test123")
@@ -115,7 +127,7 @@ func TestWorkflow_MultipleCustomersAndLicenses(t *testing.T) {
 		Licenses: make(map[string]models.License),
 	}
 
-	server := handlers.NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	// Create multiple customers through webhooks
 	customers := []struct {
@@ -198,7 +210,7 @@ func TestWorkflow_SuspendedLicense(t *testing.T) {
 		Licenses: make(map[string]models.License),
 	}
 
-	server := handlers.NewHttpServer(storage)
+	server := newTestServer(t, storage)
 	ctx := context.Background()
 
 	// Step 1: Create a customer and license manually
@@ -217,7 +229,7 @@ func TestWorkflow_SuspendedLicense(t *testing.T) {
 
 	license := models.License{
 		ID:              "suspended-license",
-		Key:             "AFP-SUSPENDED",
+		Key:             "AFP-5a5bed00",
 		CustomerID:      "suspended-customer",
 		ProductID:       "prod_test",
 		Version:         "1.0.0",
@@ -234,7 +246,7 @@ func TestWorkflow_SuspendedLicense(t *testing.T) {
 
 	// Step 2: Try to validate the suspended license
 	validateReq := handlers.LicenseRequest{
-		LicenseKey: "AFP-SUSPENDED",
+		LicenseKey: "AFP-5a5bed00",
 		AppVersion: "1.0.0",
 	}
 
@@ -270,7 +282,7 @@ func TestWorkflow_ErrorHandling(t *testing.T) {
 		Licenses: make(map[string]models.License),
 	}
 
-	server := handlers.NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	tests := []struct {
 		name            string
@@ -280,7 +292,7 @@ func TestWorkflow_ErrorHandling(t *testing.T) {
 	}{
 		{
 			name:            "nonexistent license",
-			licenseKey:      "AFP-NOTFOUND",
+			licenseKey:      "AFP-00000404",
 			expectedValid:   false,
 			expectedMessage: "license not found",
 		},
@@ -294,14 +306,20 @@ func TestWorkflow_ErrorHandling(t *testing.T) {
 			name:            "malformed license key",
 			licenseKey:      "INVALID-KEY-FORMAT",
 			expectedValid:   false,
-			expectedMessage: "license not found",
+			expectedMessage: "", // Rejected before the license lookup
+		},
+		{
+			name:            "key with bad check character",
+			licenseKey:      "AFP-00000-00000-00000-00001",
+			expectedValid:   false,
+			expectedMessage: "",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.licenseKey == "" {
-				// Test empty license key - should return 400
+			if tt.expectedMessage == "" {
+				// Empty and malformed license keys - should return 400
 				validateReq := handlers.LicenseRequest{
 					LicenseKey: tt.licenseKey,
 					AppVersion: "1.0.0",
@@ -315,7 +333,7 @@ func TestWorkflow_ErrorHandling(t *testing.T) {
 				server.Mux.ServeHTTP(w, req)
 
 				if w.Code != http.StatusBadRequest {
-					t.Errorf("Expected status 400 for empty license key, got %d", w.Code)
+					t.Errorf("Expected status 400 for '%s', got %d", tt.licenseKey, w.Code)
 				}
 				return
 			}
@@ -357,7 +375,7 @@ func TestWorkflow_HealthCheck(t *testing.T) {
 		Licenses: make(map[string]models.License),
 	}
 
-	server := handlers.NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	req := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
 	w := httptest.NewRecorder()
@@ -385,11 +403,11 @@ func TestWorkflow_RateLimiting(t *testing.T) {
 		Licenses: make(map[string]models.License),
 	}
 
-	server := handlers.NewHttpServer(storage)
+	server := newTestServer(t, storage)
 
 	// Make many requests quickly to trigger rate limiting
 	validateReq := handlers.LicenseRequest{
-		LicenseKey: "AFP-RATELIMIT",
+		LicenseKey: "AFP-4a7e1111",
 		AppVersion: "1.0.0",
 	}
 
@@ -433,7 +451,7 @@ func TestWorkflow_ConcurrentRequests(t *testing.T) {
 		Licenses: make(map[string]models.License),
 	}
 
-	server := handlers.NewHttpServer(storage)
+	server := newTestServer(t, storage)
 	ctx := context.Background()
 
 	// Create test data
@@ -449,7 +467,7 @@ func TestWorkflow_ConcurrentRequests(t *testing.T) {
 
 	license := models.License{
 		ID:              "concurrent-license",
-		Key:             "AFP-CONCURRENT",
+		Key:             "AFP-c0cc0000",
 		CustomerID:      "concurrent-customer",
 		ProductID:       "prod_test",
 		Version:         "1.0.0",
@@ -470,7 +488,7 @@ func TestWorkflow_ConcurrentRequests(t *testing.T) {
 		go func(goroutineID int) {
 			for j := 0; j < numRequests; j++ {
 				validateReq := handlers.LicenseRequest{
-					LicenseKey: "AFP-CONCURRENT",
+					LicenseKey: "AFP-c0cc0000",
 					AppVersion: "1.0.0",
 				}
 
@@ -528,12 +546,18 @@ func createMockStripeCheckoutSession(customerEmail, sessionID string) map[string
 			"product_id":      "prod_integration_test",
 			"license_version": "1.0.0",
 		},
+		// Included so the server doesn't fetch them from Stripe
+		"line_items": map[string]interface{}{
+			"data": []interface{}{
+				map[string]interface{}{"quantity": 1},
+			},
+		},
 	}
 }
 
 func createMockStripeWebhookEvent(eventType string, data map[string]interface{}) map[string]interface{} {
 	return map[string]interface{}{
-		"id":   "evt_integration_test",
+		"id":   fmt.Sprintf("evt_integration_%v", data["id"]),
 		"type": eventType,
 		"data": map[string]interface{}{
 			"object": data,
@@ -548,7 +572,7 @@ func BenchmarkFullWorkflow_StripeToValidation(b *testing.B) {
 		Licenses: make(map[string]models.License),
 	}
 
-	server := handlers.NewHttpServer(storage)
+	server := newTestServer(b, storage)
 
 	// Pre-create some licenses to validate against
 	ctx := context.Background()
@@ -565,7 +589,7 @@ func BenchmarkFullWorkflow_StripeToValidation(b *testing.B) {
 
 		license := models.License{
 			ID:              "bench-license-" + string(rune(i)),
-			Key:             "AFP-BENCH" + string(rune(i)),
+			Key:             "AFP-be4c0000" + string(rune(i)),
 			CustomerID:      customer.ID,
 			ProductID:       "prod_bench",
 			Version:         "1.0.0",
@@ -582,7 +606,7 @@ func BenchmarkFullWorkflow_StripeToValidation(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		// Validate a random license
-		licenseKey := "AFP-BENCH" + string(rune(i%100))
+		licenseKey := "AFP-be4c0000" + string(rune(i%100))
 
 		validateReq := handlers.LicenseRequest{
 			LicenseKey: licenseKey,
@@ -0,0 +1,155 @@
+package abuse
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Tracker remembers where each license key has been validated from, so keys
+// that were shared publicly stand out.
+type Tracker interface {
+	// Observe records a validation of key and returns the distinct devices,
+	// IPs and countries seen for it within the window.
+	Observe(key string, sighting Sighting) Counts
+	// Flag marks key as reported and reports whether it had not already been
+	// flagged within the window.
+	Flag(key string) bool
+}
+
+// Sighting is where a single validation came from. Empty fields are not
+// counted.
+type Sighting struct {
+	DeviceID string
+	IP       string
+	Country  string
+}
+
+type Counts struct {
+	Devices   int
+	IPs       int
+	Countries int
+}
+
+// Thresholds are the most distinct devices, IPs and countries a key may be
+// seen from within the window. Zero disables a check.
+type Thresholds struct {
+	MaxDevices   int
+	MaxIPs       int
+	MaxCountries int
+}
+
+// Exceeded describes each threshold counts is over, or returns nil.
+func (t Thresholds) Exceeded(counts Counts) []string {
+	var exceeded []string
+	check := func(name string, count, max int) {
+		if max > 0 && count > max {
+			exceeded = append(exceeded, fmt.Sprintf("%d %s (max %d)", count, name, max))
+		}
+	}
+
+	check("devices", counts.Devices, t.MaxDevices)
+	check("IPs", counts.IPs, t.MaxIPs)
+	check("countries", counts.Countries, t.MaxCountries)
+
+	return exceeded
+}
+
+type history struct {
+	devices   map[string]time.Time
+	ips       map[string]time.Time
+	countries map[string]time.Time
+	flaggedAt time.Time
+	lastSeen  time.Time
+}
+
+type WindowTracker struct {
+	window    time.Duration
+	keys      map[string]*history
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+func New(window time.Duration) Tracker {
+	return &WindowTracker{
+		window:    window,
+		keys:      make(map[string]*history),
+		lastPrune: time.Now(),
+	}
+}
+
+func (t *WindowTracker) Observe(key string, sighting Sighting) Counts {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+
+	h := t.keys[key]
+	if h == nil {
+		h = &history{
+			devices:   make(map[string]time.Time),
+			ips:       make(map[string]time.Time),
+			countries: make(map[string]time.Time),
+		}
+		t.keys[key] = h
+	}
+	h.lastSeen = now
+
+	return Counts{
+		Devices:   t.see(h.devices, sighting.DeviceID, now),
+		IPs:       t.see(h.ips, sighting.IP, now),
+		Countries: t.see(h.countries, sighting.Country, now),
+	}
+}
+
+func (t *WindowTracker) Flag(key string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	h := t.keys[key]
+	if h == nil {
+		h = &history{}
+		t.keys[key] = h
+	}
+
+	if !h.flaggedAt.IsZero() && now.Sub(h.flaggedAt) <= t.window {
+		return false
+	}
+
+	h.flaggedAt = now
+	h.lastSeen = now
+	return true
+}
+
+// see records value and returns how many distinct values were seen within
+// the window, dropping the ones that slid out of it.
+func (t *WindowTracker) see(seen map[string]time.Time, value string, now time.Time) int {
+	if value != "" {
+		seen[value] = now
+	}
+
+	for v, at := range seen {
+		if now.Sub(at) > t.window {
+			delete(seen, v)
+		}
+	}
+
+	return len(seen)
+}
+
+// prune forgets keys not seen for a whole window, once per window, so the
+// map stays bounded without scanning it on every validation.
+func (t *WindowTracker) prune(now time.Time) {
+	if now.Sub(t.lastPrune) <= t.window {
+		return
+	}
+
+	for key, h := range t.keys {
+		if now.Sub(h.lastSeen) > t.window {
+			delete(t.keys, key)
+		}
+	}
+	t.lastPrune = now
+}
@@ -0,0 +1,68 @@
+package abuse
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWindowTracker_Observe_CountsDistinctValues(t *testing.T) {
+	tracker := New(time.Minute)
+
+	tracker.Observe("key-1", Sighting{DeviceID: "device-1", IP: "10.0.0.1", Country: "NO"})
+	tracker.Observe("key-1", Sighting{DeviceID: "device-1", IP: "10.0.0.2", Country: "NO"})
+	counts := tracker.Observe("key-1", Sighting{IP: "10.0.0.3", Country: "DE"})
+
+	if counts.Devices != 1 || counts.IPs != 3 || counts.Countries != 2 {
+		t.Errorf("Expected 1 device, 3 IPs and 2 countries, got %+v", counts)
+	}
+
+	if other := tracker.Observe("key-2", Sighting{IP: "10.0.0.1"}); other.IPs != 1 {
+		t.Errorf("Expected keys to be tracked independently, got %+v", other)
+	}
+}
+
+func TestWindowTracker_Observe_SlidesWindow(t *testing.T) {
+	tracker := New(50 * time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		tracker.Observe("key-1", Sighting{IP: fmt.Sprintf("10.0.0.%d", i)})
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if counts := tracker.Observe("key-1", Sighting{IP: "10.0.1.1"}); counts.IPs != 1 {
+		t.Errorf("Expected IPs outside the window to be dropped, got %d", counts.IPs)
+	}
+}
+
+func TestWindowTracker_Flag_OncePerWindow(t *testing.T) {
+	tracker := New(50 * time.Millisecond)
+
+	if !tracker.Flag("key-1") {
+		t.Errorf("Expected first flag to be reported")
+	}
+
+	if tracker.Flag("key-1") {
+		t.Errorf("Expected repeat flag within the window to be suppressed")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if !tracker.Flag("key-1") {
+		t.Errorf("Expected key to be flagged again after the window")
+	}
+}
+
+func TestThresholds_Exceeded(t *testing.T) {
+	thresholds := Thresholds{MaxIPs: 20, MaxCountries: 3}
+
+	if exceeded := thresholds.Exceeded(Counts{Devices: 100, IPs: 20, Countries: 3}); exceeded != nil {
+		t.Errorf("Expected nothing exceeded at the limits, got %v", exceeded)
+	}
+
+	exceeded := thresholds.Exceeded(Counts{IPs: 40, Countries: 5})
+	if len(exceeded) != 2 || exceeded[0] != "40 IPs (max 20)" || exceeded[1] != "5 countries (max 3)" {
+		t.Errorf("Expected IP and country thresholds exceeded, got %v", exceeded)
+	}
+}
@@ -0,0 +1,138 @@
+package licensekey
+
+import (
+	"crypto/rand"
+	"regexp"
+	"strings"
+)
+
+// Alphabet is Crockford's base32: no I, L, O or U, so keys survive being
+// read aloud or typed from a screenshot.
+const Alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// DefaultPrefix is used for products that don't configure their own.
+const DefaultPrefix = "AFP"
+
+const (
+	groups    = 4
+	groupSize = 5
+)
+
+var (
+	prefixPattern = regexp.MustCompile(`^[A-Z]{2,5}$`)
+	// Keys issued before checksums: AFP- plus 8 hex characters of a UUID
+	legacyPattern = regexp.MustCompile(`^(?i:AFP)-[0-9a-fA-F]{8}$`)
+)
+
+// Generate returns a key such as AFP-7K2QM-X9D4T-B1RZH-W6NE3: the product
+// prefix followed by 19 random characters (95 bits) and a check character.
+func Generate(prefix string) string {
+	if !ValidPrefix(prefix) {
+		prefix = DefaultPrefix
+	}
+
+	random := make([]byte, groups*groupSize-1)
+	if _, err := rand.Read(random); err != nil {
+		panic("licensekey: crypto/rand failed: " + err.Error())
+	}
+
+	body := make([]byte, 0, groups*groupSize)
+	for _, b := range random {
+		// 256 is a multiple of 32, so this stays uniform
+		body = append(body, Alphabet[b%32])
+	}
+	body = append(body, checkCharacter(body))
+
+	var key strings.Builder
+	key.WriteString(prefix)
+	for i := 0; i < groups; i++ {
+		key.WriteByte('-')
+		key.Write(body[i*groupSize : (i+1)*groupSize])
+	}
+
+	return key.String()
+}
+
+// Valid reports whether key is well formed: a legacy key, or a current key
+// whose check character matches. It never needs a storage lookup.
+func Valid(key string) bool {
+	if IsLegacy(key) {
+		return true
+	}
+
+	parts := strings.Split(key, "-")
+	if len(parts) != groups+1 || !ValidPrefix(parts[0]) {
+		return false
+	}
+
+	body := make([]byte, 0, groups*groupSize)
+	for _, group := range parts[1:] {
+		if len(group) != groupSize {
+			return false
+		}
+		for i := 0; i < len(group); i++ {
+			if strings.IndexByte(Alphabet, group[i]) < 0 {
+				return false
+			}
+		}
+		body = append(body, group...)
+	}
+
+	return checkCharacter(body[:len(body)-1]) == body[len(body)-1]
+}
+
+// IsLegacy reports whether key uses the original AFP-xxxxxxxx format.
+func IsLegacy(key string) bool {
+	return legacyPattern.MatchString(key)
+}
+
+// ValidPrefix reports whether prefix can start a key.
+func ValidPrefix(prefix string) bool {
+	return prefixPattern.MatchString(prefix)
+}
+
+// Prefix returns the product prefix of key.
+func Prefix(key string) string {
+	prefix, _, _ := strings.Cut(key, "-")
+	return prefix
+}
+
+// Normalize turns a key as typed by a customer into its stored form: legacy
+// keys keep their lowercase hex, current keys are uppercased with the
+// characters Crockford base32 leaves out mapped to their look-alikes.
+func Normalize(key string) string {
+	key = strings.TrimSpace(key)
+
+	if IsLegacy(key) {
+		return "AFP-" + strings.ToLower(key[4:])
+	}
+
+	prefix, body, found := strings.Cut(strings.ToUpper(key), "-")
+	if !found {
+		return strings.ToUpper(key)
+	}
+
+	body = strings.NewReplacer("O", "0", "I", "1", "L", "1").Replace(body)
+	return prefix + "-" + body
+}
+
+// checkCharacter computes a Luhn mod 32 check character, which catches every
+// single mistyped character and most swapped neighbours.
+func checkCharacter(body []byte) byte {
+	const n = len(Alphabet)
+
+	factor := 2
+	sum := 0
+	for i := len(body) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(Alphabet, body[i])
+		sum += addend/n + addend%n
+
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+	}
+
+	return Alphabet[(n-sum%n)%n]
+}
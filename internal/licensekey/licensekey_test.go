@@ -0,0 +1,109 @@
+package licensekey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	seen := make(map[string]bool)
+
+	for i := 0; i < 1000; i++ {
+		key := Generate("AFP")
+
+		if !strings.HasPrefix(key, "AFP-") || len(key) != len("AFP-XXXXX-XXXXX-XXXXX-XXXXX") {
+			t.Fatalf("Unexpected key format '%s'", key)
+		}
+
+		if !Valid(key) {
+			t.Fatalf("Expected generated key '%s' to be valid", key)
+		}
+
+		if seen[key] {
+			t.Fatalf("Duplicate key generated: %s", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestGenerate_Prefix(t *testing.T) {
+	if key := Generate("AFT"); Prefix(key) != "AFT" {
+		t.Errorf("Expected prefix AFT, got '%s'", key)
+	}
+
+	if key := Generate("not valid"); Prefix(key) != DefaultPrefix {
+		t.Errorf("Expected invalid prefix to fall back to %s, got '%s'", DefaultPrefix, key)
+	}
+}
+
+func TestValid(t *testing.T) {
+	key := Generate("AFP")
+
+	tests := []struct {
+		name string
+		key  string
+		want bool
+	}{
+		{"generated", key, true},
+		{"legacy", "AFP-1a2b3c4d", true},
+		{"legacy uppercase", "AFP-1A2B3C4D", true},
+		{"legacy too short", "AFP-1a2b3c4", false},
+		{"legacy not hex", "AFP-VALID123", false},
+		{"empty", "", false},
+		{"missing group", key[:len(key)-6], false},
+		{"bad prefix", "A-" + key[4:], false},
+		{"excluded character", key[:5] + "U" + key[6:], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Valid(tt.key); got != tt.want {
+				t.Errorf("Valid(%q) = %t, want %t", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValid_DetectsTypos(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		key := []byte(Generate("AFP"))
+
+		for pos := 4; pos < len(key); pos++ {
+			if key[pos] == '-' {
+				continue
+			}
+
+			original := key[pos]
+			for _, c := range []byte(Alphabet) {
+				if c == original {
+					continue
+				}
+				key[pos] = c
+				if Valid(string(key)) {
+					t.Fatalf("Expected single character change at %d to be rejected: %s", pos, key)
+				}
+			}
+			key[pos] = original
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	key := Generate("AFP")
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"  " + strings.ToLower(key) + "\n", key},
+		{"AFP-1A2B3C4D", "AFP-1a2b3c4d"},
+		{"afp-1a2b3c4d", "AFP-1a2b3c4d"},
+		{"AFP-0OIL1-00000-00000-00000", "AFP-00111-00000-00000-00000"},
+	}
+
+	for _, tt := range tests {
+		if got := Normalize(tt.input); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
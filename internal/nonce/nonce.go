@@ -0,0 +1,55 @@
+package nonce
+
+import (
+	"sync"
+	"time"
+)
+
+type Cache interface {
+	// Use records a nonce sent with the given timestamp and reports whether
+	// it had not been seen while that timestamp is within the window.
+	Use(nonce string, timestamp time.Time) bool
+}
+
+type WindowCache struct {
+	window    time.Duration
+	expires   map[string]time.Time
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+func New(window time.Duration) Cache {
+	return &WindowCache{
+		window:    window,
+		expires:   make(map[string]time.Time),
+		lastPrune: time.Now(),
+	}
+}
+
+// Use keeps each nonce until its timestamp falls out of the window rather
+// than for a window after first use: a timestamp up to a window in the
+// future stays acceptable for twice as long.
+func (c *WindowCache) Use(nonce string, timestamp time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	// Drop expired nonces once per window so the map stays bounded without
+	// scanning it on every request
+	if now.Sub(c.lastPrune) > c.window {
+		for n, expiresAt := range c.expires {
+			if now.After(expiresAt) {
+				delete(c.expires, n)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if expiresAt, exists := c.expires[nonce]; exists && !now.After(expiresAt) {
+		return false
+	}
+
+	c.expires[nonce] = timestamp.Add(c.window)
+	return true
+}
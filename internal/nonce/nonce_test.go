@@ -0,0 +1,88 @@
+package nonce
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWindowCache_Use_RejectsReuse(t *testing.T) {
+	cache := New(time.Minute)
+
+	if !cache.Use("nonce-1", time.Now()) {
+		t.Errorf("Expected first use of nonce to be accepted")
+	}
+
+	if cache.Use("nonce-1", time.Now()) {
+		t.Errorf("Expected reused nonce to be rejected")
+	}
+
+	if !cache.Use("nonce-2", time.Now()) {
+		t.Errorf("Expected a different nonce to be accepted")
+	}
+}
+
+func TestWindowCache_Use_AcceptsAfterWindow(t *testing.T) {
+	cache := New(50 * time.Millisecond)
+
+	cache.Use("nonce-1", time.Now())
+	time.Sleep(100 * time.Millisecond)
+
+	if !cache.Use("nonce-1", time.Now()) {
+		t.Errorf("Expected nonce to be accepted again after the window")
+	}
+}
+
+func TestWindowCache_Use_KeepsFutureTimestamps(t *testing.T) {
+	cache := New(50 * time.Millisecond)
+	timestamp := time.Now().Add(50 * time.Millisecond)
+
+	cache.Use("nonce-1", timestamp)
+	time.Sleep(60 * time.Millisecond)
+
+	// The timestamp is still within the window, so this is a replay
+	if cache.Use("nonce-1", timestamp) {
+		t.Errorf("Expected nonce with a future timestamp to be rejected after a window")
+	}
+}
+
+func TestWindowCache_Use_Prunes(t *testing.T) {
+	cache := New(50 * time.Millisecond).(*WindowCache)
+
+	for i := 0; i < 10; i++ {
+		cache.Use(fmt.Sprintf("nonce-%d", i), time.Now())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	cache.Use("fresh", time.Now())
+
+	if len(cache.expires) != 1 {
+		t.Errorf("Expected expired nonces to be pruned, got %d entries", len(cache.expires))
+	}
+}
+
+func TestWindowCache_Use_ConcurrentAccess(t *testing.T) {
+	cache := New(time.Minute)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cache.Use("shared", time.Now()) {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Errorf("Expected exactly one goroutine to use the nonce, got %d", accepted)
+	}
+}
@@ -18,6 +18,7 @@ type FixedWindowLimitter struct {
 	maxRequests int
 	window      time.Duration
 	requests    map[string]*WindowData
+	lastPrune   time.Time
 	mutex       sync.Mutex
 }
 
@@ -34,6 +35,7 @@ func (rl *FixedWindowLimitter) Allow(addr string) bool {
 	defer rl.mutex.Unlock()
 
 	now := time.Now()
+	rl.prune(now)
 	wd := rl.requests[addr]
 
 	// no data or we have requests but 10 minutes have passed
@@ -58,3 +60,18 @@ func (rl *FixedWindowLimitter) Allow(addr string) bool {
 
 	return true
 }
+
+// prune drops windows that have ended, at most once per window, so the map
+// only holds addresses seen recently.
+func (rl *FixedWindowLimitter) prune(now time.Time) {
+	if now.Sub(rl.lastPrune) <= rl.window {
+		return
+	}
+
+	for addr, wd := range rl.requests {
+		if now.Sub(wd.windowStart) > rl.window {
+			delete(rl.requests, addr)
+		}
+	}
+	rl.lastPrune = now
+}
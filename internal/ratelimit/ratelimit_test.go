@@ -247,3 +247,18 @@ func BenchmarkFixedWindowLimiter_Allow_DifferentIPs(b *testing.B) {
 		limiter.Allow(ip)
 	}
 }
+
+func TestFixedWindowLimiter_Allow_PrunesEndedWindows(t *testing.T) {
+	limiter := New(1, 50*time.Millisecond).(*FixedWindowLimitter)
+
+	for i := 0; i < 100; i++ {
+		limiter.Allow(fmt.Sprintf("user%d@example.com", i))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	limiter.Allow("latest@example.com")
+
+	if len(limiter.requests) != 1 {
+		t.Errorf("Expected ended windows to be pruned, %d addresses left", len(limiter.requests))
+	}
+}
@@ -0,0 +1,237 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	StatusActive  = "active"
+	StatusRetired = "retired"
+)
+
+// Key is a signer identified by a key ID. Responses carry the ID so apps
+// know which key to verify with while old and new keys overlap.
+type Key struct {
+	ID     string
+	Status string
+	Signer Signer
+}
+
+// PublicKey returns the key apps verify with, or nil for symmetric keys.
+func (k Key) PublicKey() []byte {
+	if s, ok := k.Signer.(*Ed25519Signer); ok {
+		return s.PublicKey()
+	}
+	return nil
+}
+
+// Token signs payload and returns "<payload>.<signature>", both base64url
+// encoded without padding, for self-contained tokens apps verify offline.
+func (k Key) Token(payload []byte) string {
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	// Signers always return standard base64
+	signature, _ := base64.StdEncoding.DecodeString(k.Signer.Sign([]byte(encoded)))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// jwtAlgorithms maps signer algorithms to their JOSE "alg" names.
+var jwtAlgorithms = map[string]string{
+	AlgorithmHMAC:    "HS256",
+	AlgorithmEd25519: "EdDSA",
+}
+
+// JWT signs claims as a compact JSON Web Token whose header names the key,
+// so apps can verify it with any standard JWT library.
+func (k Key) JWT(claims interface{}) (string, error) {
+	algorithm, ok := jwtAlgorithms[k.Signer.Algorithm()]
+	if !ok {
+		return "", fmt.Errorf("no JWT algorithm for %s", k.Signer.Algorithm())
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": algorithm, "typ": "JWT", "kid": k.ID})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWT header: %w", err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWT claims: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	// Signers always return standard base64
+	signature, _ := base64.StdEncoding.DecodeString(k.Signer.Sign([]byte(signingInput)))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Keyring holds every signing key the server knows about. Only the active
+// key of an algorithm signs new responses; retired keys are kept so their
+// public halves can still be published for verification.
+type Keyring struct {
+	keys []Key
+}
+
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	ids := make(map[string]bool)
+	active := make(map[string]bool)
+
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("key without kid")
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicate kid %s", key.ID)
+		}
+		ids[key.ID] = true
+
+		switch key.Status {
+		case StatusActive:
+			algorithm := key.Signer.Algorithm()
+			if active[algorithm] {
+				return nil, fmt.Errorf("more than one active %s key", algorithm)
+			}
+			active[algorithm] = true
+		case StatusRetired:
+		default:
+			return nil, fmt.Errorf("invalid status %q for kid %s", key.Status, key.ID)
+		}
+	}
+
+	return &Keyring{keys: keys}, nil
+}
+
+// Active returns the key that signs new responses for algorithm.
+func (k *Keyring) Active(algorithm string) (Key, bool) {
+	for _, key := range k.keys {
+		if key.Status == StatusActive && key.Signer.Algorithm() == algorithm {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Lookup returns the key with the given ID, active or retired.
+func (k *Keyring) Lookup(id string) (Key, bool) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+func (k *Keyring) Keys() []Key {
+	return k.keys
+}
+
+// VerifyJWT checks a token made by Key.JWT against the key named in its
+// header, active or retired, and decodes its claims into claims. Checking
+// exp and other claims is left to the caller.
+func (k *Keyring) VerifyJWT(token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed JWT")
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("malformed JWT header: %w", err)
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return fmt.Errorf("malformed JWT header: %w", err)
+	}
+
+	key, ok := k.Lookup(header.KeyID)
+	if !ok {
+		return fmt.Errorf("unknown kid %s", header.KeyID)
+	}
+	if jwtAlgorithms[key.Signer.Algorithm()] != header.Algorithm {
+		return fmt.Errorf("alg %s does not match kid %s", header.Algorithm, header.KeyID)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed JWT signature: %w", err)
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	if publicKey := key.PublicKey(); publicKey != nil {
+		if !ed25519.Verify(publicKey, signingInput, signature) {
+			return fmt.Errorf("invalid JWT signature")
+		}
+	} else {
+		// HMAC is symmetric, so signing again reproduces a genuine signature
+		expected, _ := base64.StdEncoding.DecodeString(key.Signer.Sign(signingInput))
+		if subtle.ConstantTimeCompare(signature, expected) != 1 {
+			return fmt.Errorf("invalid JWT signature")
+		}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("malformed JWT claims: %w", err)
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return fmt.Errorf("malformed JWT claims: %w", err)
+	}
+
+	return nil
+}
+
+type keyConfig struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"algorithm"`
+	Key       string `json:"key"` // raw secret for HMAC, base64 private key for Ed25519
+	Status    string `json:"status"`
+}
+
+// ParseKeyring builds a keyring from a JSON array of keys, e.g.
+//
+//	[{"kid": "2025-06", "algorithm": "ed25519", "key": "<base64>", "status": "active"}]
+func ParseKeyring(data []byte) (*Keyring, error) {
+	var configs []keyConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	keys := make([]Key, 0, len(configs))
+	for _, config := range configs {
+		var signer Signer
+		switch config.Algorithm {
+		case AlgorithmHMAC:
+			if config.Key == "" {
+				return nil, fmt.Errorf("empty secret for kid %s", config.ID)
+			}
+			signer = NewHMACSigner([]byte(config.Key))
+		case AlgorithmEd25519:
+			privateKey, err := ParseEd25519PrivateKey(config.Key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key for kid %s: %w", config.ID, err)
+			}
+			signer = NewEd25519Signer(privateKey)
+		default:
+			return nil, fmt.Errorf("unsupported algorithm %q for kid %s", config.Algorithm, config.ID)
+		}
+
+		keys = append(keys, Key{ID: config.ID, Status: config.Status, Signer: signer})
+	}
+
+	return NewKeyring(keys...)
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+	return ParseKeyring(data)
+}
@@ -0,0 +1,222 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseKeyring(t *testing.T) {
+	_, oldKey, _ := ed25519.GenerateKey(nil)
+	_, newKey, _ := ed25519.GenerateKey(nil)
+
+	data := fmt.Sprintf(`[
+		{"kid": "ed-2024", "algorithm": "ed25519", "key": "%s", "status": "retired"},
+		{"kid": "ed-2025", "algorithm": "ed25519", "key": "%s", "status": "active"},
+		{"kid": "hmac-1", "algorithm": "hmac-sha256", "key": "secret", "status": "active"}
+	]`, base64.StdEncoding.EncodeToString(oldKey.Seed()), base64.StdEncoding.EncodeToString(newKey.Seed()))
+
+	keyring, err := ParseKeyring([]byte(data))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(keyring.Keys()) != 3 {
+		t.Errorf("Expected 3 keys, got %d", len(keyring.Keys()))
+	}
+
+	active, ok := keyring.Active(AlgorithmEd25519)
+	if !ok || active.ID != "ed-2025" {
+		t.Errorf("Expected active Ed25519 key 'ed-2025', got '%s'", active.ID)
+	}
+
+	if !newKey.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(active.PublicKey())) {
+		t.Errorf("Expected active key to expose the new public key")
+	}
+
+	active, ok = keyring.Active(AlgorithmHMAC)
+	if !ok || active.ID != "hmac-1" {
+		t.Errorf("Expected active HMAC key 'hmac-1', got '%s'", active.ID)
+	}
+	if active.PublicKey() != nil {
+		t.Errorf("Expected no public key for HMAC")
+	}
+
+	retired, ok := keyring.Lookup("ed-2024")
+	if !ok || retired.Status != StatusRetired {
+		t.Errorf("Expected retired key 'ed-2024' to be found")
+	}
+}
+
+func TestParseKeyring_Errors(t *testing.T) {
+	seed := base64.StdEncoding.EncodeToString(make([]byte, ed25519.SeedSize))
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"invalid json", `not json`},
+		{"missing kid", `[{"algorithm": "hmac-sha256", "key": "secret", "status": "active"}]`},
+		{"duplicate kid", `[{"kid": "a", "algorithm": "hmac-sha256", "key": "one", "status": "active"}, {"kid": "a", "algorithm": "hmac-sha256", "key": "two", "status": "retired"}]`},
+		{"two active keys", `[{"kid": "a", "algorithm": "hmac-sha256", "key": "one", "status": "active"}, {"kid": "b", "algorithm": "hmac-sha256", "key": "two", "status": "active"}]`},
+		{"unknown status", `[{"kid": "a", "algorithm": "hmac-sha256", "key": "one", "status": "pending"}]`},
+		{"unknown algorithm", `[{"kid": "a", "algorithm": "rsa", "key": "one", "status": "active"}]`},
+		{"empty hmac secret", `[{"kid": "a", "algorithm": "hmac-sha256", "key": "", "status": "active"}]`},
+		{"bad ed25519 key", `[{"kid": "a", "algorithm": "ed25519", "key": "short", "status": "active"}]`},
+		{"valid ed25519 key wrong status", `[{"kid": "a", "algorithm": "ed25519", "key": "` + seed + `", "status": ""}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKeyring([]byte(tt.data)); err == nil {
+				t.Errorf("Expected error for %s", tt.name)
+			}
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `[{"kid": "hmac-1", "algorithm": "hmac-sha256", "key": "secret", "status": "active"}]`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("Failed to write keyring: %v", err)
+	}
+
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if _, ok := keyring.Active(AlgorithmHMAC); !ok {
+		t.Errorf("Expected active HMAC key")
+	}
+
+	if _, err := LoadKeyring(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+}
+
+func TestKey_Token(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	key := Key{ID: "ed-1", Status: StatusActive, Signer: NewEd25519Signer(privateKey)}
+
+	token := key.Token([]byte(`{"sub":"test"}`))
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		t.Fatalf("Expected payload.signature, got '%s'", token)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || string(payload) != `{"sub":"test"}` {
+		t.Errorf("Expected encoded payload, got '%s' (%v)", payload, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("Expected base64url signature, got error: %v", err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key.PublicKey()), []byte(parts[0]), signature) {
+		t.Errorf("Expected token signature to verify over the encoded payload")
+	}
+}
+
+func TestKey_JWT(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	keys := map[string]Key{
+		"EdDSA": {ID: "ed-1", Status: StatusActive, Signer: NewEd25519Signer(privateKey)},
+		"HS256": {ID: "hmac-1", Status: StatusActive, Signer: NewHMACSigner([]byte("secret"))},
+	}
+
+	for alg, key := range keys {
+		t.Run(alg, func(t *testing.T) {
+			token, err := key.JWT(map[string]string{"sub": "test"})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			parts := strings.Split(token, ".")
+			if len(parts) != 3 {
+				t.Fatalf("Expected header.payload.signature, got '%s'", token)
+			}
+
+			headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+			var header map[string]string
+			if err := json.Unmarshal(headerJSON, &header); err != nil {
+				t.Fatalf("Failed to decode header: %v", err)
+			}
+			if header["alg"] != alg || header["typ"] != "JWT" || header["kid"] != key.ID {
+				t.Errorf("Expected %s JWT header for %s, got %v", alg, key.ID, header)
+			}
+
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			if string(payload) != `{"sub":"test"}` {
+				t.Errorf("Expected encoded claims, got '%s'", payload)
+			}
+
+			signingInput := parts[0] + "." + parts[1]
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			if base64.StdEncoding.EncodeToString(signature) != key.Signer.Sign([]byte(signingInput)) {
+				t.Errorf("Expected signature over header and claims")
+			}
+		})
+	}
+}
+
+func TestKeyring_VerifyJWT(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	retired := Key{ID: "hmac-old", Status: StatusRetired, Signer: NewHMACSigner([]byte("old"))}
+	active := Key{ID: "ed-1", Status: StatusActive, Signer: NewEd25519Signer(privateKey)}
+	keyring, err := NewKeyring(retired, active)
+	if err != nil {
+		t.Fatalf("Failed to build keyring: %v", err)
+	}
+
+	for _, key := range []Key{retired, active} {
+		token, _ := key.JWT(map[string]string{"sub": key.ID})
+
+		var claims map[string]string
+		if err := keyring.VerifyJWT(token, &claims); err != nil {
+			t.Errorf("Expected %s token to verify, got %v", key.ID, err)
+		}
+		if claims["sub"] != key.ID {
+			t.Errorf("Expected claims decoded, got %v", claims)
+		}
+	}
+
+	unknown := Key{ID: "other", Status: StatusActive, Signer: NewHMACSigner([]byte("other"))}
+	forged := Key{ID: "hmac-old", Status: StatusActive, Signer: NewHMACSigner([]byte("guess"))}
+	_, otherPrivateKey, _ := ed25519.GenerateKey(nil)
+	forgedEd25519 := Key{ID: "ed-1", Status: StatusActive, Signer: NewEd25519Signer(otherPrivateKey)}
+	token, _ := active.JWT(map[string]string{"sub": "ed-1"})
+	parts := strings.Split(token, ".")
+
+	invalid := map[string]string{
+		"malformed":        "not-a-jwt",
+		"unknown kid":      mustJWT(t, unknown),
+		"forged signature": mustJWT(t, forged),
+		"forged ed25519":   mustJWT(t, forgedEd25519),
+		"tampered claims":  parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2],
+	}
+
+	for name, token := range invalid {
+		var claims map[string]string
+		if err := keyring.VerifyJWT(token, &claims); err == nil {
+			t.Errorf("Expected %s token to be rejected", name)
+		}
+	}
+}
+
+func mustJWT(t *testing.T, key Key) string {
+	token, err := key.JWT(map[string]string{"sub": "test"})
+	if err != nil {
+		t.Fatalf("Failed to sign JWT: %v", err)
+	}
+	return token
+}
@@ -0,0 +1,78 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	AlgorithmHMAC    = "hmac-sha256"
+	AlgorithmEd25519 = "ed25519"
+)
+
+type Signer interface {
+	Algorithm() string
+	Sign(payload []byte) string
+}
+
+// HMACSigner signs with a secret shared between the server and the app.
+type HMACSigner struct {
+	secret []byte
+}
+
+func NewHMACSigner(secret []byte) *HMACSigner {
+	return &HMACSigner{secret: secret}
+}
+
+func (s *HMACSigner) Algorithm() string {
+	return AlgorithmHMAC
+}
+
+func (s *HMACSigner) Sign(payload []byte) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write(payload)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Ed25519Signer signs with a private key that never leaves the server; the
+// app only needs the public key to verify.
+type Ed25519Signer struct {
+	privateKey ed25519.PrivateKey
+}
+
+func NewEd25519Signer(privateKey ed25519.PrivateKey) *Ed25519Signer {
+	return &Ed25519Signer{privateKey: privateKey}
+}
+
+func (s *Ed25519Signer) Algorithm() string {
+	return AlgorithmEd25519
+}
+
+func (s *Ed25519Signer) Sign(payload []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, payload))
+}
+
+func (s *Ed25519Signer) PublicKey() ed25519.PublicKey {
+	return s.privateKey.Public().(ed25519.PublicKey)
+}
+
+// ParseEd25519PrivateKey decodes a base64 encoded 32 byte seed or 64 byte
+// private key.
+func ParseEd25519PrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %v", err)
+	}
+
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	default:
+		return nil, fmt.Errorf("invalid key length %d", len(raw))
+	}
+}
@@ -0,0 +1,92 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+)
+
+func TestHMACSigner_Sign(t *testing.T) {
+	signer := NewHMACSigner([]byte("secret"))
+
+	first := signer.Sign([]byte("true|license valid|1700000000"))
+	second := signer.Sign([]byte("true|license valid|1700000000"))
+	other := signer.Sign([]byte("false|license valid|1700000000"))
+
+	if first != second {
+		t.Errorf("Expected signatures of the same payload to match")
+	}
+
+	if first == other {
+		t.Errorf("Expected signatures of different payloads to differ")
+	}
+
+	if signer.Algorithm() != AlgorithmHMAC {
+		t.Errorf("Expected algorithm '%s', got '%s'", AlgorithmHMAC, signer.Algorithm())
+	}
+}
+
+func TestEd25519Signer_SignVerifies(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	signer := NewEd25519Signer(privateKey)
+	payload := []byte("true|license valid|AFP-TEST|1.0.0|1700000000")
+
+	signature, err := base64.StdEncoding.DecodeString(signer.Sign(payload))
+	if err != nil {
+		t.Fatalf("Expected base64 signature, got error: %v", err)
+	}
+
+	if !ed25519.Verify(signer.PublicKey(), payload, signature) {
+		t.Errorf("Expected signature to verify with the public key")
+	}
+
+	if ed25519.Verify(signer.PublicKey(), []byte("tampered"), signature) {
+		t.Errorf("Expected signature not to verify a different payload")
+	}
+}
+
+func TestParseEd25519PrivateKey(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{
+			name:    "seed",
+			encoded: base64.StdEncoding.EncodeToString(privateKey.Seed()),
+		},
+		{
+			name:    "full private key",
+			encoded: base64.StdEncoding.EncodeToString(privateKey),
+		},
+		{
+			name:    "wrong length",
+			encoded: base64.StdEncoding.EncodeToString([]byte("short")),
+			wantErr: true,
+		},
+		{
+			name:    "not base64",
+			encoded: "not base64!",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseEd25519PrivateKey(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEd25519PrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !parsed.Equal(privateKey) {
+				t.Errorf("Expected parsed key to equal the original")
+			}
+		})
+	}
+}
@@ -177,6 +177,12 @@ func CreateMockCheckoutSession(customerEmail, sessionID string, hasCustomer bool
 			"product_id":      "prod_test123",
 			"license_version": "1.0.0",
 		},
+		// Included so the server doesn't fetch them from Stripe
+		"line_items": map[string]interface{}{
+			"data": []interface{}{
+				map[string]interface{}{"quantity": 1},
+			},
+		},
 	}
 
 	if hasCustomer {
@@ -210,7 +216,7 @@ type BenchmarkHelper struct {
 }
 
 // NewBenchmarkHelper creates a new benchmark helper with pre-populated data
-func NewBenchmarkHelper(numCustomers, licensesPerCustomer int) *BenchmarkHelper {
+func NewBenchmarkHelper(numCustomers, licensesPerCustomer int) (*BenchmarkHelper, error) {
 	storage := TestStorage()
 	ctx := context.Background()
 
@@ -229,12 +235,15 @@ func NewBenchmarkHelper(numCustomers, licensesPerCustomer int) *BenchmarkHelper
 		}
 	}
 
-	server := handlers.NewHttpServer(storage)
+	server, err := handlers.NewHttpServer(storage)
+	if err != nil {
+		return nil, err
+	}
 
 	return &BenchmarkHelper{
 		Storage: storage,
 		Server:  server,
-	}
+	}, nil
 }
 
 // GetRandomLicenseKey returns a random license key for benchmarking
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"auto-focus.app/cloud/handlers"
 	"auto-focus.app/cloud/storage"
@@ -48,7 +53,44 @@ func main() {
 		}
 	}()
 
-	srv := handlers.NewHttpServer(storage)
+	// Refuse to start rather than sign with keys nobody configured
+	srv, err := handlers.NewHttpServer(storage)
+	if err != nil {
+		log.Fatal("Failed to create server: ", err)
+	}
+
+	// Admin subcommands, e.g. `cloud certificate -key AFP-XXXXXXXX`
+	if len(os.Args) > 1 {
+		if err := runCommand(srv, os.Args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Background jobs stop and the server drains on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Expire time-limited licenses in the background
+	sweepInterval := time.Hour
+	if interval, err := time.ParseDuration(os.Getenv("EXPIRY_SWEEP_INTERVAL")); err == nil && interval > 0 {
+		sweepInterval = interval
+	}
+	go srv.RunExpirySweep(ctx, sweepInterval)
+
+	// Send payment reminders and end grace periods of failed subscriptions
+	dunningInterval := time.Hour
+	if interval, err := time.ParseDuration(os.Getenv("DUNNING_INTERVAL")); err == nil && interval > 0 {
+		dunningInterval = interval
+	}
+	go srv.RunDunning(ctx, dunningInterval)
+
+	// Write batched validation telemetry in the background
+	usageFlushInterval := time.Minute
+	if interval, err := time.ParseDuration(os.Getenv("USAGE_FLUSH_INTERVAL")); err == nil && interval > 0 {
+		usageFlushInterval = interval
+	}
+	go srv.RunUsageFlush(ctx, usageFlushInterval)
 
 	// Get port from environment variable, default to 8080
 	port := os.Getenv("PORT")
@@ -68,5 +110,22 @@ func main() {
 	log.Printf("Database: %s", databaseURL)
 	log.Printf("Config file: %s", envFile)
 
-	log.Fatal(http.ListenAndServe(":"+port, srv.Mux))
+	httpServer := &http.Server{Addr: ":" + port, Handler: srv.Mux}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down server: %v", err)
+	}
+
+	// Validations are done now, so this flush has the last of the usage
+	srv.FlushUsage(shutdownCtx)
 }
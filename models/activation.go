@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type Activation struct {
+	ID         string
+	LicenseID  string
+	DeviceID   string
+	DeviceName string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
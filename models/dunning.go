@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Dunning tracks a subscription whose renewal payment failed, from the first
+// failure until the invoice is paid or the grace period runs out. It is
+// stored so reminders keep going out across server restarts, and kept once
+// the grace period has ended so the same invoice failing again does not
+// start another.
+type Dunning struct {
+	SubscriptionID string // Stripe subscription, one dunning at a time
+	LicenseID      string
+	InvoiceID      string // Latest invoice that failed
+	Email          string
+	PaymentURL     string     // Where the customer updates their payment method
+	RemindersSent  int        // Follow-up reminders sent after the first email
+	NextReminderAt *time.Time // nil once every reminder has been sent
+	GraceEndsAt    time.Time  // When the license expires if still unpaid
+	EndedAt        *time.Time // When the grace period ran out, nil while it runs
+	CreatedAt      time.Time  // First failure
+	UpdatedAt      time.Time
+}
+
+// IsDue reports whether a reminder should be sent or the grace period has
+// ended at now.
+func (d Dunning) IsDue(now time.Time) bool {
+	if d.EndedAt != nil {
+		return false
+	}
+	if !now.Before(d.GraceEndsAt) {
+		return true
+	}
+	return d.NextReminderAt != nil && !now.Before(*d.NextReminderAt)
+}
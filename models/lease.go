@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Lease records an offline lease token handed to a device. Renewing a lease
+// for the same device replaces the previous record.
+type Lease struct {
+	ID        string
+	LicenseID string
+	DeviceID  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
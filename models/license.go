@@ -1,24 +1,127 @@
 package models
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+)
 
 const (
 	StatusActive    = "active"
 	StatusSuspended = "suspended"
 	StatusExpired   = "expired"
+	StatusRevoked   = "revoked" // Permanently disabled, never reactivated
+	StatusTrial     = "trial"
+)
+
+// Reasons recorded alongside a status change, so apps can explain why a
+// license stopped working.
+const (
+	StatusReasonKeySharing    = "key_sharing"    // Suspended by the abuse detector
+	StatusReasonSeatReclaimed = "seat_reclaimed" // Seat taken back by the team owner
+	StatusReasonRefunded      = "refunded"       // Payment refunded
+	StatusReasonDisputed      = "disputed"       // Chargeback opened, pending outcome
+	StatusReasonChargeback    = "chargeback"     // Chargeback lost
+	StatusReasonUpgraded      = "upgraded"       // Retired after being upgraded to a new license
 )
 
 type License struct {
-	ID              string
-	Key             string
-	Version         string
-	Status          string
-	CustomerID      string
-	ProductID       string
-	ProductName     string
-	PricePaid       int64 // Price in cents
-	Currency        string
-	StripeSessionID string
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	ID                    string
+	Key                   string
+	Version               string
+	Status                string
+	StatusReason          string     // Why the license was last moved to its status, if known
+	StatusChangedAt       *time.Time // When Status or StatusReason last changed, nil if never
+	CustomerID            string
+	ProductID             string
+	ProductName           string
+	PricePaid             int64 // Price in cents
+	Currency              string
+	StripeSessionID       string
+	StripePaymentIntentID string     // Payment the checkout was paid with, to match refunds and disputes
+	StripeSubscriptionID  string     // Subscription renewing the license, empty for one-time purchases
+	RefundedAmount        int64      // Amount refunded in cents
+	DisputeStatus         string     // Status of the last dispute event applied, empty if never disputed
+	DisputeUpdatedAt      *time.Time // When the last applied dispute event was created in Stripe
+	TrialDeviceID         string     // Device the trial was issued to, kept after conversion
+	Entitlements          []string   // Features unlocked by the license, sorted
+	Seats                 int        // Seats bought with a team license, 0 for individual licenses
+	MaxActivations        int        // Devices the license may be activated on: 0 for its product's limit, -1 for no limit
+	TeamLicenseID         string     // Team license a seat was assigned from
+	SeatEmail             string     // Member a seat is assigned to
+	UpgradedFromID        string     // License this one was bought as an upgrade of
+	UpgradedToID          string     // License that replaced this one through an upgrade
+	UpgradeSessionID      string     // Checkout opened for this license's upgrade offer
+	ExpiresAt             *time.Time // nil for perpetual licenses
+	ExpiredAt             *time.Time // When the license was moved to StatusExpired
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+// IsExpired reports whether the license has passed its expiry at now,
+// regardless of whether its status has been updated yet.
+func (l License) IsExpired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// SetStatus moves the license to status for reason, stamping the change
+// when either differs from before.
+func (l *License) SetStatus(status, reason string, now time.Time) {
+	if l.Status != status || l.StatusReason != reason {
+		l.StatusChangedAt = &now
+	}
+	l.Status = status
+	l.StatusReason = reason
+	l.UpdatedAt = now
+}
+
+// IsTrial reports whether the license is a trial that has not been converted
+// by a purchase or subscription yet, whether or not it has expired.
+func (l License) IsTrial() bool {
+	return l.TrialDeviceID != "" && l.StripeSessionID == "" && l.StripeSubscriptionID == ""
+}
+
+// IsTeam reports whether the license is a team purchase whose key manages
+// seats rather than unlocking the app itself.
+func (l License) IsTeam() bool {
+	return l.Seats > 0
+}
+
+// IsSeat reports whether the license is a member seat of a team license.
+func (l License) IsSeat() bool {
+	return l.TeamLicenseID != ""
+}
+
+// NormalizeEntitlements trims, de-duplicates and sorts entitlements so they
+// are stored and signed in a stable order.
+func NormalizeEntitlements(entitlements []string) []string {
+	seen := make(map[string]bool, len(entitlements))
+	var normalized []string
+	for _, entitlement := range entitlements {
+		entitlement = strings.TrimSpace(entitlement)
+		if entitlement == "" || seen[entitlement] {
+			continue
+		}
+		seen[entitlement] = true
+		normalized = append(normalized, entitlement)
+	}
+
+	sort.Strings(normalized)
+	return normalized
+}
+
+// IsRevokedStatus reports whether status disables a license before its expiry,
+// which offline clients learn about from the revocation list.
+func IsRevokedStatus(status string) bool {
+	return status == StatusSuspended || status == StatusRevoked
+}
+
+// HashLicenseKey identifies a license key in signed payloads returned to
+// the key's holder. It is unsalted, so it is never published: revocation
+// lists name licenses by ID instead.
+func HashLicenseKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
 }
@@ -238,6 +238,32 @@ func TestLicense_VersionFormats(t *testing.T) {
 	}
 }
 
+func TestLicense_IsExpired(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Second)
+	future := now.Add(time.Second)
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		expected  bool
+	}{
+		{"perpetual", nil, false},
+		{"past expiry", &past, true},
+		{"expires now", &now, true},
+		{"future expiry", &future, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			license := License{ExpiresAt: tt.expiresAt}
+			if got := license.IsExpired(now); got != tt.expected {
+				t.Errorf("Expected IsExpired=%v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
 func TestCustomer_Creation(t *testing.T) {
 	now := time.Now()
 
@@ -456,3 +482,87 @@ func BenchmarkCustomer_Creation(b *testing.B) {
 		}
 	}
 }
+
+func TestIsRevokedStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{StatusActive, false},
+		{StatusExpired, false},
+		{StatusSuspended, true},
+		{StatusRevoked, true},
+	}
+
+	for _, tt := range tests {
+		if got := IsRevokedStatus(tt.status); got != tt.want {
+			t.Errorf("IsRevokedStatus(%q) = %t, want %t", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestLicense_IsTrial(t *testing.T) {
+	tests := []struct {
+		name    string
+		license License
+		want    bool
+	}{
+		{"paid license", License{StripeSessionID: "cs_123"}, false},
+		{"trial", License{TrialDeviceID: "device-1"}, true},
+		{"converted trial", License{TrialDeviceID: "device-1", StripeSessionID: "cs_123"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.license.IsTrial(); got != tt.want {
+				t.Errorf("IsTrial() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeEntitlements(t *testing.T) {
+	got := NormalizeEntitlements([]string{" slack_sync", "advanced_stats", "slack_sync", ""})
+	if len(got) != 2 || got[0] != "advanced_stats" || got[1] != "slack_sync" {
+		t.Errorf("Expected sorted, deduplicated entitlements, got %v", got)
+	}
+
+	if got := NormalizeEntitlements([]string{" "}); got != nil {
+		t.Errorf("Expected nil for empty entitlements, got %v", got)
+	}
+}
+
+func TestLicense_TeamAndSeat(t *testing.T) {
+	team := License{ID: "team", Seats: 5}
+	if !team.IsTeam() || team.IsSeat() {
+		t.Errorf("Expected team license to be a team and not a seat")
+	}
+
+	seat := License{ID: "seat", TeamLicenseID: "team"}
+	if seat.IsTeam() || !seat.IsSeat() {
+		t.Errorf("Expected seat license to be a seat and not a team")
+	}
+
+	if individual := (License{ID: "individual"}); individual.IsTeam() || individual.IsSeat() {
+		t.Errorf("Expected individual license to be neither team nor seat")
+	}
+}
+
+func TestLicense_SetStatus(t *testing.T) {
+	license := License{Status: StatusActive}
+	suspendedAt := time.Now()
+
+	license.SetStatus(StatusSuspended, StatusReasonRefunded, suspendedAt)
+	if license.Status != StatusSuspended || license.StatusReason != StatusReasonRefunded {
+		t.Errorf("Expected suspended/refunded, got %s/%s", license.Status, license.StatusReason)
+	}
+	if license.StatusChangedAt == nil || !license.StatusChangedAt.Equal(suspendedAt) {
+		t.Errorf("Expected status change at %v, got %v", suspendedAt, license.StatusChangedAt)
+	}
+
+	// Saving the same status again keeps the original change time
+	license.SetStatus(StatusSuspended, StatusReasonRefunded, suspendedAt.Add(time.Hour))
+	if !license.StatusChangedAt.Equal(suspendedAt) || !license.UpdatedAt.Equal(suspendedAt.Add(time.Hour)) {
+		t.Errorf("Expected unchanged status to keep its change time, got %v", license.StatusChangedAt)
+	}
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Revocation records a license moving into or out of a revoked status.
+// Sequence increases with every entry, so clients fetch only what they have
+// not seen yet and apply entries in order.
+type Revocation struct {
+	Sequence  int64
+	LicenseID string
+	Status    string
+	CreatedAt time.Time
+}
+
+// Revoked reports whether the license was revoked by this entry, as opposed
+// to reinstated.
+func (r Revocation) Revoked() bool {
+	return IsRevokedStatus(r.Status)
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	StripeEventProcessing = "processing"
+	StripeEventProcessed  = "processed"
+	StripeEventFailed     = "failed"
+)
+
+// StripeEvent records a webhook event received from Stripe, so redelivered
+// events that were already processed can be acknowledged without running
+// again.
+type StripeEvent struct {
+	ID          string // Stripe event ID
+	Type        string
+	Status      string
+	Attempts    int        // Deliveries that started processing the event
+	Error       string     // Why the last attempt failed, if it did
+	ReceivedAt  time.Time  // First delivery
+	ProcessedAt *time.Time // When processing succeeded, nil until then
+	UpdatedAt   time.Time
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// LicenseUsage summarises how a license has been validated. Handlers batch
+// validations into deltas; storage adds ValidationCount up and keeps the
+// most recent of the other fields.
+type LicenseUsage struct {
+	LicenseID       string
+	ValidationCount int64
+	LastValidatedAt time.Time
+	LastAppVersion  string
+	LastCountry     string // ISO country code of the last caller, when known
+}
+
+// Merge folds a later delta for the same license into u.
+func (u *LicenseUsage) Merge(delta LicenseUsage) {
+	u.ValidationCount += delta.ValidationCount
+	if delta.LastValidatedAt.Before(u.LastValidatedAt) {
+		return
+	}
+
+	u.LastValidatedAt = delta.LastValidatedAt
+	if delta.LastAppVersion != "" {
+		u.LastAppVersion = delta.LastAppVersion
+	}
+	if delta.LastCountry != "" {
+		u.LastCountry = delta.LastCountry
+	}
+}
@@ -4,14 +4,38 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"auto-focus.app/cloud/models"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// ErrDuplicateLicenseKey is returned by SaveLicense when another license
+// already uses the key.
+var ErrDuplicateLicenseKey = errors.New("license key already exists")
+
+// ErrDuplicateStripeSession is returned by SaveLicense when another license
+// was already created for the same Stripe checkout session.
+var ErrDuplicateStripeSession = errors.New("license already exists for stripe session")
+
+// ErrDuplicateStripeSubscription is returned by SaveLicense when another
+// license was already created for the same Stripe subscription.
+var ErrDuplicateStripeSubscription = errors.New("license already exists for stripe subscription")
+
+// ErrSeatLimitReached is returned by CreateSeat when the team already has
+// as many seats assigned as it bought.
+var ErrSeatLimitReached = errors.New("team has no seats left")
+
+// ErrActivationLimitReached is returned by CreateActivation when the license
+// is already activated on as many devices as it allows.
+var ErrActivationLimitReached = errors.New("license has no activations left")
+
 type Database map[string]models.Customer
 type CustomerList []models.Customer
 
@@ -23,20 +47,79 @@ type Storage interface {
 	GetLicense(ctx context.Context, id string) (*models.License, error)
 	FindLicenseByKey(ctx context.Context, key string) (*models.License, error)
 	FindLicensesByCustomer(ctx context.Context, customerID string) ([]*models.License, error)
+	FindLicensesByKeys(ctx context.Context, keys []string) ([]*models.License, error)
+	FindLicenseByStripeSession(ctx context.Context, sessionID string) (*models.License, error)
+	FindLicenseByPaymentIntent(ctx context.Context, paymentIntentID string) (*models.License, error)
+	FindLicenseBySubscription(ctx context.Context, subscriptionID string) (*models.License, error)
 	SaveLicense(ctx context.Context, license *models.License) error
+	// UpdateLicenseStatus writes the status fields of license (Status,
+	// StatusReason, StatusChangedAt, ExpiredAt and UpdatedAt) only while the
+	// stored license is still in status from with the same ExpiresAt, and
+	// reports whether it did. Unlike SaveLicense it never overwrites changes
+	// made since license was read.
+	UpdateLicenseStatus(ctx context.Context, license *models.License, from string) (bool, error)
+	FindExpiredLicenses(ctx context.Context, now time.Time) ([]*models.License, error)
+	FindTrialByDevice(ctx context.Context, deviceID string) (*models.License, error)
+	FindSeats(ctx context.Context, teamLicenseID string) ([]*models.License, error)
+	// CreateSeat saves a new seat license unless its team already has limit
+	// seats assigned, counted in the same write so concurrent assignments
+	// cannot exceed it.
+	CreateSeat(ctx context.Context, seat *models.License, limit int) error
+
+	FindActivation(ctx context.Context, licenseID, deviceID string) (*models.Activation, error)
+	FindActivationsByLicense(ctx context.Context, licenseID string) ([]*models.Activation, error)
+	SaveActivation(ctx context.Context, activation *models.Activation) error
+	// CreateActivation saves a new activation unless its license already has
+	// limit activations, counted in the same write so concurrent activations
+	// cannot exceed it. A negative limit means no limit.
+	CreateActivation(ctx context.Context, activation *models.Activation, limit int) error
+	DeleteActivation(ctx context.Context, licenseID, deviceID string) error
+
+	FindLease(ctx context.Context, licenseID, deviceID string) (*models.Lease, error)
+	FindLeasesByLicense(ctx context.Context, licenseID string) ([]*models.Lease, error)
+	SaveLease(ctx context.Context, lease *models.Lease) error
+
+	FindRevocationsSince(ctx context.Context, sequence int64, limit int) ([]*models.Revocation, error)
+
+	RecordUsage(ctx context.Context, usage []*models.LicenseUsage) error
+	GetLicenseUsage(ctx context.Context, licenseID string) (*models.LicenseUsage, error)
+
+	GetStripeEvent(ctx context.Context, id string) (*models.StripeEvent, error)
+	SaveStripeEvent(ctx context.Context, event *models.StripeEvent) error
+	// ClaimStripeEvent marks event as processing unless it was already
+	// processed or another delivery claimed it after staleBefore. On success
+	// event holds the claimed record.
+	ClaimStripeEvent(ctx context.Context, event *models.StripeEvent, staleBefore time.Time) (bool, error)
+
+	GetDunning(ctx context.Context, subscriptionID string) (*models.Dunning, error)
+	FindDueDunnings(ctx context.Context, now time.Time) ([]*models.Dunning, error)
+	SaveDunning(ctx context.Context, dunning *models.Dunning) error
+	DeleteDunning(ctx context.Context, subscriptionID string) error
 
 	Close() error
 }
 
 type MemoryStorage struct {
-	Data     Database
-	Licenses map[string]models.License // Store licenses separately by ID
+	Data         Database
+	Licenses     map[string]models.License      // Store licenses separately by ID
+	Activations  map[string]models.Activation   // Store activations by ID
+	Leases       map[string]models.Lease        // Store leases by ID
+	Revocations  []models.Revocation            // Ordered by sequence
+	Usage        map[string]models.LicenseUsage // Store usage by license ID
+	StripeEvents map[string]models.StripeEvent  // Store webhook events by event ID
+	Dunnings     map[string]models.Dunning      // Store dunning by subscription ID
 }
 
 type FileStorage struct {
-	filepath  string
-	customers Database
-	licenses  map[string]models.License // Store licenses separately by ID
+	filepath     string
+	customers    Database
+	licenses     map[string]models.License      // Store licenses separately by ID
+	activations  map[string]models.Activation   // Store activations by ID
+	leases       map[string]models.Lease        // Store leases by ID
+	revocations  []models.Revocation            // Ordered by sequence
+	usage        map[string]models.LicenseUsage // Store usage by license ID
+	stripeEvents map[string]models.StripeEvent  // Store webhook events by event ID
+	dunnings     map[string]models.Dunning      // Store dunning by subscription ID
 }
 
 type SQLiteStorage struct {
@@ -54,7 +137,7 @@ func (m *MemoryStorage) GetCustomer(ctx context.Context, id string) (*models.Cus
 
 func (m *MemoryStorage) FindCustomerByEmailAddress(ctx context.Context, emailAddress string) (*models.Customer, error) {
 	for _, customer := range m.Data {
-		if customer.Email == emailAddress {
+		if strings.EqualFold(customer.Email, emailAddress) {
 			return &customer, nil
 		}
 	}
@@ -105,6 +188,23 @@ func (m *MemoryStorage) FindLicensesByCustomer(ctx context.Context, customerID s
 	return licenses, nil
 }
 
+func (m *MemoryStorage) FindLicensesByKeys(ctx context.Context, keys []string) ([]*models.License, error) {
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+
+	var licenses []*models.License
+	for _, license := range m.Licenses {
+		if wanted[license.Key] {
+			licenseCopy := license
+			licenses = append(licenses, &licenseCopy)
+		}
+	}
+
+	return licenses, nil
+}
+
 func (m *MemoryStorage) SaveLicense(ctx context.Context, license *models.License) error {
 	if m.Licenses == nil {
 		m.Licenses = make(map[string]models.License)
@@ -116,10 +216,311 @@ func (m *MemoryStorage) SaveLicense(ctx context.Context, license *models.License
 		return fmt.Errorf("customer %s not found", license.CustomerID)
 	}
 
+	for id, existing := range m.Licenses {
+		if existing.Key == license.Key && id != license.ID {
+			return ErrDuplicateLicenseKey
+		}
+		if license.StripeSessionID != "" && existing.StripeSessionID == license.StripeSessionID && id != license.ID {
+			return ErrDuplicateStripeSession
+		}
+		if license.StripeSubscriptionID != "" && existing.StripeSubscriptionID == license.StripeSubscriptionID && id != license.ID {
+			return ErrDuplicateStripeSubscription
+		}
+	}
+
+	var seats []models.License
+	if license.IsTeam() {
+		for _, existing := range m.Licenses {
+			if existing.TeamLicenseID == license.ID {
+				seats = append(seats, existing)
+			}
+		}
+	}
+	for _, revocation := range statusRevocations(m.Licenses[license.ID].Status, license, seats) {
+		revocation.Sequence = int64(len(m.Revocations) + 1)
+		m.Revocations = append(m.Revocations, revocation)
+	}
+
 	// Save license by ID
 	m.Licenses[license.ID] = *license
 	return nil
 }
+
+func (m *MemoryStorage) UpdateLicenseStatus(ctx context.Context, license *models.License, from string) (bool, error) {
+	stored, ok := m.Licenses[license.ID]
+	if !ok || !statusUnchanged(stored, license, from) {
+		return false, nil
+	}
+
+	copyStatus(&stored, license)
+	if err := m.SaveLicense(ctx, &stored); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *MemoryStorage) FindExpiredLicenses(ctx context.Context, now time.Time) ([]*models.License, error) {
+	var licenses []*models.License
+	for _, license := range m.Licenses {
+		if expirableStatus(license.Status) && license.IsExpired(now) {
+			licenseCopy := license
+			licenses = append(licenses, &licenseCopy)
+		}
+	}
+
+	return licenses, nil
+}
+
+func (m *MemoryStorage) FindTrialByDevice(ctx context.Context, deviceID string) (*models.License, error) {
+	if deviceID == "" {
+		return nil, nil
+	}
+	for _, license := range m.Licenses {
+		if license.TrialDeviceID == deviceID {
+			return &license, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStorage) FindLicenseByStripeSession(ctx context.Context, sessionID string) (*models.License, error) {
+	for _, license := range m.Licenses {
+		if license.StripeSessionID == sessionID {
+			return &license, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStorage) FindLicenseByPaymentIntent(ctx context.Context, paymentIntentID string) (*models.License, error) {
+	if paymentIntentID == "" {
+		return nil, nil
+	}
+	for _, license := range m.Licenses {
+		if license.StripePaymentIntentID == paymentIntentID {
+			return &license, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStorage) FindLicenseBySubscription(ctx context.Context, subscriptionID string) (*models.License, error) {
+	if subscriptionID == "" {
+		return nil, nil
+	}
+	for _, license := range m.Licenses {
+		if license.StripeSubscriptionID == subscriptionID {
+			return &license, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStorage) FindSeats(ctx context.Context, teamLicenseID string) ([]*models.License, error) {
+	var seats []*models.License
+	for _, license := range m.Licenses {
+		if license.TeamLicenseID == teamLicenseID {
+			licenseCopy := license
+			seats = append(seats, &licenseCopy)
+		}
+	}
+
+	sortSeats(seats)
+	return seats, nil
+}
+
+func (m *MemoryStorage) CreateSeat(ctx context.Context, seat *models.License, limit int) error {
+	assigned := 0
+	for _, license := range m.Licenses {
+		if license.TeamLicenseID == seat.TeamLicenseID && license.Status != models.StatusRevoked {
+			assigned++
+		}
+	}
+	if assigned >= limit {
+		return ErrSeatLimitReached
+	}
+
+	return m.SaveLicense(ctx, seat)
+}
+
+func (m *MemoryStorage) FindActivation(ctx context.Context, licenseID, deviceID string) (*models.Activation, error) {
+	for _, activation := range m.Activations {
+		if activation.LicenseID == licenseID && activation.DeviceID == deviceID {
+			return &activation, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStorage) FindActivationsByLicense(ctx context.Context, licenseID string) ([]*models.Activation, error) {
+	var activations []*models.Activation
+	for _, activation := range m.Activations {
+		if activation.LicenseID == licenseID {
+			activationCopy := activation
+			activations = append(activations, &activationCopy)
+		}
+	}
+
+	return activations, nil
+}
+
+func (m *MemoryStorage) SaveActivation(ctx context.Context, activation *models.Activation) error {
+	if m.Activations == nil {
+		m.Activations = make(map[string]models.Activation)
+	}
+
+	// Verify license exists
+	_, exists := m.Licenses[activation.LicenseID]
+	if !exists {
+		return fmt.Errorf("license %s not found", activation.LicenseID)
+	}
+
+	m.Activations[activation.ID] = *activation
+	return nil
+}
+
+func (m *MemoryStorage) CreateActivation(ctx context.Context, activation *models.Activation, limit int) error {
+	activations, _ := m.FindActivationsByLicense(ctx, activation.LicenseID)
+	if limit >= 0 && len(activations) >= limit {
+		return ErrActivationLimitReached
+	}
+	return m.SaveActivation(ctx, activation)
+}
+
+func (m *MemoryStorage) DeleteActivation(ctx context.Context, licenseID, deviceID string) error {
+	for id, activation := range m.Activations {
+		if activation.LicenseID == licenseID && activation.DeviceID == deviceID {
+			delete(m.Activations, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) FindLease(ctx context.Context, licenseID, deviceID string) (*models.Lease, error) {
+	for _, lease := range m.Leases {
+		if lease.LicenseID == licenseID && lease.DeviceID == deviceID {
+			return &lease, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStorage) FindLeasesByLicense(ctx context.Context, licenseID string) ([]*models.Lease, error) {
+	var leases []*models.Lease
+	for _, lease := range m.Leases {
+		if lease.LicenseID == licenseID {
+			leaseCopy := lease
+			leases = append(leases, &leaseCopy)
+		}
+	}
+
+	return leases, nil
+}
+
+func (m *MemoryStorage) SaveLease(ctx context.Context, lease *models.Lease) error {
+	if m.Leases == nil {
+		m.Leases = make(map[string]models.Lease)
+	}
+
+	// Verify license exists
+	_, exists := m.Licenses[lease.LicenseID]
+	if !exists {
+		return fmt.Errorf("license %s not found", lease.LicenseID)
+	}
+
+	m.Leases[lease.ID] = *lease
+	return nil
+}
+
+func (m *MemoryStorage) FindRevocationsSince(ctx context.Context, sequence int64, limit int) ([]*models.Revocation, error) {
+	return revocationsSince(m.Revocations, sequence, limit), nil
+}
+
+func (m *MemoryStorage) RecordUsage(ctx context.Context, usage []*models.LicenseUsage) error {
+	if m.Usage == nil {
+		m.Usage = make(map[string]models.LicenseUsage)
+	}
+
+	for _, delta := range usage {
+		current := m.Usage[delta.LicenseID]
+		current.LicenseID = delta.LicenseID
+		current.Merge(*delta)
+		m.Usage[delta.LicenseID] = current
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetLicenseUsage(ctx context.Context, licenseID string) (*models.LicenseUsage, error) {
+	usage, exists := m.Usage[licenseID]
+	if !exists {
+		return nil, nil
+	}
+	return &usage, nil
+}
+
+func (m *MemoryStorage) GetStripeEvent(ctx context.Context, id string) (*models.StripeEvent, error) {
+	event, exists := m.StripeEvents[id]
+	if !exists {
+		return nil, nil
+	}
+	return &event, nil
+}
+
+func (m *MemoryStorage) SaveStripeEvent(ctx context.Context, event *models.StripeEvent) error {
+	if m.StripeEvents == nil {
+		m.StripeEvents = make(map[string]models.StripeEvent)
+	}
+
+	m.StripeEvents[event.ID] = *event
+	return nil
+}
+
+func (m *MemoryStorage) ClaimStripeEvent(ctx context.Context, event *models.StripeEvent, staleBefore time.Time) (bool, error) {
+	if m.StripeEvents == nil {
+		m.StripeEvents = make(map[string]models.StripeEvent)
+	}
+
+	record, claimed := claimStripeEvent(m.StripeEvents, event, staleBefore)
+	if claimed {
+		*event = record
+	}
+	return claimed, nil
+}
+
+func (m *MemoryStorage) GetDunning(ctx context.Context, subscriptionID string) (*models.Dunning, error) {
+	dunning, exists := m.Dunnings[subscriptionID]
+	if !exists {
+		return nil, nil
+	}
+	return &dunning, nil
+}
+
+func (m *MemoryStorage) FindDueDunnings(ctx context.Context, now time.Time) ([]*models.Dunning, error) {
+	var dunnings []*models.Dunning
+	for _, dunning := range m.Dunnings {
+		if dunning.IsDue(now) {
+			dunningCopy := dunning
+			dunnings = append(dunnings, &dunningCopy)
+		}
+	}
+
+	return dunnings, nil
+}
+
+func (m *MemoryStorage) SaveDunning(ctx context.Context, dunning *models.Dunning) error {
+	if m.Dunnings == nil {
+		m.Dunnings = make(map[string]models.Dunning)
+	}
+
+	m.Dunnings[dunning.SubscriptionID] = *dunning
+	return nil
+}
+
+func (m *MemoryStorage) DeleteDunning(ctx context.Context, subscriptionID string) error {
+	delete(m.Dunnings, subscriptionID)
+	return nil
+}
+
 func (m *MemoryStorage) Close() error {
 	return nil
 }
@@ -173,7 +574,7 @@ func (f *FileStorage) GetCustomer(ctx context.Context, id string) (*models.Custo
 
 func (f *FileStorage) FindCustomerByEmailAddress(ctx context.Context, emailAddress string) (*models.Customer, error) {
 	for _, customer := range f.customers {
-		if customer.Email == emailAddress {
+		if strings.EqualFold(customer.Email, emailAddress) {
 			return &customer, nil
 		}
 	}
@@ -225,6 +626,23 @@ func (f *FileStorage) FindLicensesByCustomer(ctx context.Context, customerID str
 	return licenses, nil
 }
 
+func (f *FileStorage) FindLicensesByKeys(ctx context.Context, keys []string) ([]*models.License, error) {
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+
+	var licenses []*models.License
+	for _, license := range f.licenses {
+		if wanted[license.Key] {
+			licenseCopy := license
+			licenses = append(licenses, &licenseCopy)
+		}
+	}
+
+	return licenses, nil
+}
+
 func (f *FileStorage) SaveLicense(ctx context.Context, license *models.License) error {
 	if f.licenses == nil {
 		f.licenses = make(map[string]models.License)
@@ -236,45 +654,376 @@ func (f *FileStorage) SaveLicense(ctx context.Context, license *models.License)
 		return fmt.Errorf("customer %s not found", license.CustomerID)
 	}
 
+	for id, existing := range f.licenses {
+		if existing.Key == license.Key && id != license.ID {
+			return ErrDuplicateLicenseKey
+		}
+		if license.StripeSessionID != "" && existing.StripeSessionID == license.StripeSessionID && id != license.ID {
+			return ErrDuplicateStripeSession
+		}
+		if license.StripeSubscriptionID != "" && existing.StripeSubscriptionID == license.StripeSubscriptionID && id != license.ID {
+			return ErrDuplicateStripeSubscription
+		}
+	}
+
+	var seats []models.License
+	if license.IsTeam() {
+		for _, existing := range f.licenses {
+			if existing.TeamLicenseID == license.ID {
+				seats = append(seats, existing)
+			}
+		}
+	}
+	for _, revocation := range statusRevocations(f.licenses[license.ID].Status, license, seats) {
+		revocation.Sequence = int64(len(f.revocations) + 1)
+		f.revocations = append(f.revocations, revocation)
+	}
+
 	// Save license by ID
 	f.licenses[license.ID] = *license
 	// TODO: Write back to file
 	return nil
 }
 
-func (f *FileStorage) Close() error {
-	return nil
+func (f *FileStorage) UpdateLicenseStatus(ctx context.Context, license *models.License, from string) (bool, error) {
+	stored, ok := f.licenses[license.ID]
+	if !ok || !statusUnchanged(stored, license, from) {
+		return false, nil
+	}
+
+	copyStatus(&stored, license)
+	if err := f.SaveLicense(ctx, &stored); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
-	db, err := sql.Open("sqlite3", path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+func (f *FileStorage) FindExpiredLicenses(ctx context.Context, now time.Time) ([]*models.License, error) {
+	var licenses []*models.License
+	for _, license := range f.licenses {
+		if expirableStatus(license.Status) && license.IsExpired(now) {
+			licenseCopy := license
+			licenses = append(licenses, &licenseCopy)
+		}
 	}
-	storage := &SQLiteStorage{
-		db:   db,
-		path: path,
+
+	return licenses, nil
+}
+
+func (f *FileStorage) FindTrialByDevice(ctx context.Context, deviceID string) (*models.License, error) {
+	if deviceID == "" {
+		return nil, nil
+	}
+	for _, license := range f.licenses {
+		if license.TrialDeviceID == deviceID {
+			return &license, nil
+		}
 	}
+	return nil, nil
+}
 
-	ctx := context.Background()
-	err = storage.migrate(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+func (f *FileStorage) FindLicenseByStripeSession(ctx context.Context, sessionID string) (*models.License, error) {
+	for _, license := range f.licenses {
+		if license.StripeSessionID == sessionID {
+			return &license, nil
+		}
 	}
+	return nil, nil
+}
 
-	return storage, nil
+func (f *FileStorage) FindLicenseByPaymentIntent(ctx context.Context, paymentIntentID string) (*models.License, error) {
+	if paymentIntentID == "" {
+		return nil, nil
+	}
+	for _, license := range f.licenses {
+		if license.StripePaymentIntentID == paymentIntentID {
+			return &license, nil
+		}
+	}
+	return nil, nil
 }
 
-func (s *SQLiteStorage) migrate(ctx context.Context) error {
-	schema := `
-      CREATE TABLE IF NOT EXISTS customers (
-          id TEXT PRIMARY KEY,
-          email TEXT UNIQUE NOT NULL,
-          name TEXT,
-          country TEXT,
-          stripe_customer_id TEXT,
-          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-          updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+func (f *FileStorage) FindLicenseBySubscription(ctx context.Context, subscriptionID string) (*models.License, error) {
+	if subscriptionID == "" {
+		return nil, nil
+	}
+	for _, license := range f.licenses {
+		if license.StripeSubscriptionID == subscriptionID {
+			return &license, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *FileStorage) FindSeats(ctx context.Context, teamLicenseID string) ([]*models.License, error) {
+	var seats []*models.License
+	for _, license := range f.licenses {
+		if license.TeamLicenseID == teamLicenseID {
+			licenseCopy := license
+			seats = append(seats, &licenseCopy)
+		}
+	}
+
+	sortSeats(seats)
+	return seats, nil
+}
+
+func (f *FileStorage) CreateSeat(ctx context.Context, seat *models.License, limit int) error {
+	assigned := 0
+	for _, license := range f.licenses {
+		if license.TeamLicenseID == seat.TeamLicenseID && license.Status != models.StatusRevoked {
+			assigned++
+		}
+	}
+	if assigned >= limit {
+		return ErrSeatLimitReached
+	}
+
+	return f.SaveLicense(ctx, seat)
+}
+
+func (f *FileStorage) FindActivation(ctx context.Context, licenseID, deviceID string) (*models.Activation, error) {
+	for _, activation := range f.activations {
+		if activation.LicenseID == licenseID && activation.DeviceID == deviceID {
+			return &activation, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *FileStorage) FindActivationsByLicense(ctx context.Context, licenseID string) ([]*models.Activation, error) {
+	var activations []*models.Activation
+	for _, activation := range f.activations {
+		if activation.LicenseID == licenseID {
+			activationCopy := activation
+			activations = append(activations, &activationCopy)
+		}
+	}
+
+	return activations, nil
+}
+
+func (f *FileStorage) SaveActivation(ctx context.Context, activation *models.Activation) error {
+	if f.activations == nil {
+		f.activations = make(map[string]models.Activation)
+	}
+
+	// Verify license exists
+	_, exists := f.licenses[activation.LicenseID]
+	if !exists {
+		return fmt.Errorf("license %s not found", activation.LicenseID)
+	}
+
+	f.activations[activation.ID] = *activation
+	// TODO: Write back to file
+	return nil
+}
+
+func (f *FileStorage) CreateActivation(ctx context.Context, activation *models.Activation, limit int) error {
+	activations, _ := f.FindActivationsByLicense(ctx, activation.LicenseID)
+	if limit >= 0 && len(activations) >= limit {
+		return ErrActivationLimitReached
+	}
+	return f.SaveActivation(ctx, activation)
+}
+
+func (f *FileStorage) DeleteActivation(ctx context.Context, licenseID, deviceID string) error {
+	for id, activation := range f.activations {
+		if activation.LicenseID == licenseID && activation.DeviceID == deviceID {
+			delete(f.activations, id)
+		}
+	}
+	// TODO: Write back to file
+	return nil
+}
+
+func (f *FileStorage) FindLease(ctx context.Context, licenseID, deviceID string) (*models.Lease, error) {
+	for _, lease := range f.leases {
+		if lease.LicenseID == licenseID && lease.DeviceID == deviceID {
+			return &lease, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *FileStorage) FindLeasesByLicense(ctx context.Context, licenseID string) ([]*models.Lease, error) {
+	var leases []*models.Lease
+	for _, lease := range f.leases {
+		if lease.LicenseID == licenseID {
+			leaseCopy := lease
+			leases = append(leases, &leaseCopy)
+		}
+	}
+
+	return leases, nil
+}
+
+func (f *FileStorage) SaveLease(ctx context.Context, lease *models.Lease) error {
+	if f.leases == nil {
+		f.leases = make(map[string]models.Lease)
+	}
+
+	// Verify license exists
+	_, exists := f.licenses[lease.LicenseID]
+	if !exists {
+		return fmt.Errorf("license %s not found", lease.LicenseID)
+	}
+
+	f.leases[lease.ID] = *lease
+	// TODO: Write back to file
+	return nil
+}
+
+func (f *FileStorage) FindRevocationsSince(ctx context.Context, sequence int64, limit int) ([]*models.Revocation, error) {
+	return revocationsSince(f.revocations, sequence, limit), nil
+}
+
+func (f *FileStorage) RecordUsage(ctx context.Context, usage []*models.LicenseUsage) error {
+	if f.usage == nil {
+		f.usage = make(map[string]models.LicenseUsage)
+	}
+
+	for _, delta := range usage {
+		current := f.usage[delta.LicenseID]
+		current.LicenseID = delta.LicenseID
+		current.Merge(*delta)
+		f.usage[delta.LicenseID] = current
+	}
+	// TODO: Write back to file
+	return nil
+}
+
+func (f *FileStorage) GetLicenseUsage(ctx context.Context, licenseID string) (*models.LicenseUsage, error) {
+	usage, exists := f.usage[licenseID]
+	if !exists {
+		return nil, nil
+	}
+	return &usage, nil
+}
+
+func (f *FileStorage) GetStripeEvent(ctx context.Context, id string) (*models.StripeEvent, error) {
+	event, exists := f.stripeEvents[id]
+	if !exists {
+		return nil, nil
+	}
+	return &event, nil
+}
+
+func (f *FileStorage) SaveStripeEvent(ctx context.Context, event *models.StripeEvent) error {
+	if f.stripeEvents == nil {
+		f.stripeEvents = make(map[string]models.StripeEvent)
+	}
+
+	f.stripeEvents[event.ID] = *event
+	// TODO: Write back to file
+	return nil
+}
+
+func (f *FileStorage) ClaimStripeEvent(ctx context.Context, event *models.StripeEvent, staleBefore time.Time) (bool, error) {
+	if f.stripeEvents == nil {
+		f.stripeEvents = make(map[string]models.StripeEvent)
+	}
+
+	record, claimed := claimStripeEvent(f.stripeEvents, event, staleBefore)
+	if claimed {
+		*event = record
+	}
+	// TODO: Write back to file
+	return claimed, nil
+}
+
+// claimStripeEvent claims event in an in-memory event map.
+func claimStripeEvent(events map[string]models.StripeEvent, event *models.StripeEvent, staleBefore time.Time) (models.StripeEvent, bool) {
+	record, exists := events[event.ID]
+	if !exists {
+		record = models.StripeEvent{ID: event.ID, Type: event.Type, ReceivedAt: event.ReceivedAt}
+	} else if record.Status == models.StripeEventProcessed || (record.Status == models.StripeEventProcessing && !record.UpdatedAt.Before(staleBefore)) {
+		return record, false
+	}
+
+	record.Status = models.StripeEventProcessing
+	record.Attempts++
+	record.Error = ""
+	record.UpdatedAt = event.UpdatedAt
+	events[event.ID] = record
+	return record, true
+}
+
+func (f *FileStorage) GetDunning(ctx context.Context, subscriptionID string) (*models.Dunning, error) {
+	dunning, exists := f.dunnings[subscriptionID]
+	if !exists {
+		return nil, nil
+	}
+	return &dunning, nil
+}
+
+func (f *FileStorage) FindDueDunnings(ctx context.Context, now time.Time) ([]*models.Dunning, error) {
+	var dunnings []*models.Dunning
+	for _, dunning := range f.dunnings {
+		if dunning.IsDue(now) {
+			dunningCopy := dunning
+			dunnings = append(dunnings, &dunningCopy)
+		}
+	}
+
+	return dunnings, nil
+}
+
+func (f *FileStorage) SaveDunning(ctx context.Context, dunning *models.Dunning) error {
+	if f.dunnings == nil {
+		f.dunnings = make(map[string]models.Dunning)
+	}
+
+	f.dunnings[dunning.SubscriptionID] = *dunning
+	// TODO: Write back to file
+	return nil
+}
+
+func (f *FileStorage) DeleteDunning(ctx context.Context, subscriptionID string) error {
+	delete(f.dunnings, subscriptionID)
+	// TODO: Write back to file
+	return nil
+}
+
+func (f *FileStorage) Close() error {
+	return nil
+}
+
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	storage := &SQLiteStorage{
+		db:   db,
+		path: path,
+	}
+
+	ctx := context.Background()
+	err = storage.migrate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return storage, nil
+}
+
+func (s *SQLiteStorage) migrate(ctx context.Context) error {
+	var revocationTables int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'license_revocations'`).Scan(&revocationTables)
+	if err != nil {
+		return err
+	}
+
+	schema := `
+      CREATE TABLE IF NOT EXISTS customers (
+          id TEXT PRIMARY KEY,
+          email TEXT UNIQUE NOT NULL,
+          name TEXT,
+          country TEXT,
+          stripe_customer_id TEXT,
+          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+          updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
       );
 
       CREATE TABLE IF NOT EXISTS licenses (
@@ -287,17 +1036,263 @@ func (s *SQLiteStorage) migrate(ctx context.Context) error {
           currency TEXT,
           version TEXT NOT NULL,
           status TEXT NOT NULL,
+          status_reason TEXT,
+          status_changed_at DATETIME,
           stripe_session_id TEXT NOT NULL,
+          stripe_payment_intent_id TEXT,
+          stripe_subscription_id TEXT,
+          refunded_amount INTEGER NOT NULL DEFAULT 0,
+          dispute_status TEXT,
+          dispute_updated_at DATETIME,
+          trial_device_id TEXT,
+          entitlements TEXT,
+          seats INTEGER NOT NULL DEFAULT 0,
+          max_activations INTEGER NOT NULL DEFAULT 0,
+          team_license_id TEXT,
+          seat_email TEXT,
+          upgraded_from_id TEXT,
+          upgraded_to_id TEXT,
+          upgrade_session_id TEXT,
+          expires_at DATETIME,
+          expired_at DATETIME,
           created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
           updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
           FOREIGN KEY (customer_id) REFERENCES customers(id)
       );
+
+      CREATE TABLE IF NOT EXISTS activations (
+          id TEXT PRIMARY KEY,
+          license_id TEXT NOT NULL,
+          device_id TEXT NOT NULL,
+          device_name TEXT,
+          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+          updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+          UNIQUE (license_id, device_id),
+          FOREIGN KEY (license_id) REFERENCES licenses(id)
+      );
+
+      CREATE TABLE IF NOT EXISTS leases (
+          id TEXT PRIMARY KEY,
+          license_id TEXT NOT NULL,
+          device_id TEXT NOT NULL,
+          issued_at DATETIME NOT NULL,
+          expires_at DATETIME NOT NULL,
+          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+          updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+          UNIQUE (license_id, device_id),
+          FOREIGN KEY (license_id) REFERENCES licenses(id)
+      );
+
+      CREATE TABLE IF NOT EXISTS license_usage (
+          license_id TEXT PRIMARY KEY,
+          validation_count INTEGER NOT NULL DEFAULT 0,
+          last_validated_at DATETIME NOT NULL,
+          last_app_version TEXT,
+          last_country TEXT,
+          FOREIGN KEY (license_id) REFERENCES licenses(id)
+      );
+
+      CREATE TABLE IF NOT EXISTS stripe_events (
+          id TEXT PRIMARY KEY,
+          type TEXT NOT NULL,
+          status TEXT NOT NULL,
+          attempts INTEGER NOT NULL DEFAULT 0,
+          error TEXT,
+          received_at DATETIME NOT NULL,
+          processed_at DATETIME,
+          updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+      );
+
+      CREATE TABLE IF NOT EXISTS dunnings (
+          subscription_id TEXT PRIMARY KEY,
+          license_id TEXT NOT NULL,
+          invoice_id TEXT NOT NULL,
+          email TEXT NOT NULL,
+          payment_url TEXT,
+          reminders_sent INTEGER NOT NULL DEFAULT 0,
+          next_reminder_at DATETIME,
+          grace_ends_at DATETIME NOT NULL,
+          ended_at DATETIME,
+          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+          updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+          FOREIGN KEY (license_id) REFERENCES licenses(id)
+      );
+
+      CREATE TABLE IF NOT EXISTS license_revocations (
+          sequence INTEGER PRIMARY KEY AUTOINCREMENT,
+          license_id TEXT NOT NULL,
+          status TEXT NOT NULL,
+          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+          FOREIGN KEY (license_id) REFERENCES licenses(id)
+      );
       `
 
-	_, err := s.db.ExecContext(ctx, schema)
+	_, err = s.db.ExecContext(ctx, schema)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range columnMigrations {
+		if err := s.addColumn(ctx, m.table, m.column, m.definition); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	// Revocations are listed by license ID; the key hashes they used to
+	// carry are not kept around
+	if err := s.dropColumn(ctx, "license_revocations", "key_hash"); err != nil {
+		return fmt.Errorf("failed to drop license_revocations.key_hash: %w", err)
+	}
+
+	// Licenses disabled before the revocation list existed would otherwise
+	// never be listed
+	if revocationTables == 0 {
+		if err := s.backfillRevocations(ctx); err != nil {
+			return fmt.Errorf("failed to backfill revocations: %w", err)
+		}
+	}
+
+	// Databases from before this index may already hold licenses duplicated
+	// by redelivered webhooks; they keep starting and rely on the handler's
+	// session lookup instead
+	_, err = s.db.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS licenses_stripe_session_id ON licenses (stripe_session_id) WHERE stripe_session_id != ''`)
+	if err != nil {
+		log.Printf("Failed to add unique index on licenses.stripe_session_id: %v", err)
+	}
+
+	// A subscription's first invoice and its checkout session can both try
+	// to create its license
+	_, err = s.db.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS licenses_stripe_subscription_id ON licenses (stripe_subscription_id) WHERE stripe_subscription_id != ''`)
+	if err != nil {
+		log.Printf("Failed to add unique index on licenses.stripe_subscription_id: %v", err)
+	}
+
+	return nil
+}
+
+// backfillRevocations lists every license that is already suspended or
+// revoked, along with the seats of such team licenses.
+func (s *SQLiteStorage) backfillRevocations(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, status, seats FROM licenses WHERE status IN (?, ?) ORDER BY created_at, id`,
+		models.StatusSuspended, models.StatusRevoked)
+	if err != nil {
+		return fmt.Errorf("failed to query revoked licenses: %w", err)
+	}
+
+	var licenses []models.License
+	for rows.Next() {
+		var license models.License
+		if err := rows.Scan(&license.ID, &license.Status, &license.Seats); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to scan license: %w", err)
+		}
+		licenses = append(licenses, license)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, license := range licenses {
+		var seats []models.License
+		if license.IsTeam() {
+			seats, err = findSeatStatuses(ctx, tx, license.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, revocation := range statusRevocations("", &license, seats) {
+			if err := insertRevocation(ctx, tx, revocation); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Columns added after a table was first created. CREATE TABLE IF NOT EXISTS
+// leaves existing tables untouched, so databases from older deployments
+// get these through ALTER TABLE instead.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"licenses", "expires_at", "DATETIME"},
+	{"licenses", "expired_at", "DATETIME"},
+	{"licenses", "trial_device_id", "TEXT"},
+	{"licenses", "entitlements", "TEXT"},
+	{"licenses", "seats", "INTEGER NOT NULL DEFAULT 0"},
+	{"licenses", "team_license_id", "TEXT"},
+	{"licenses", "seat_email", "TEXT"},
+	{"licenses", "status_reason", "TEXT"},
+	{"licenses", "status_changed_at", "DATETIME"},
+	{"licenses", "upgraded_from_id", "TEXT"},
+	{"licenses", "upgraded_to_id", "TEXT"},
+	{"licenses", "upgrade_session_id", "TEXT"},
+	{"licenses", "stripe_payment_intent_id", "TEXT"},
+	{"licenses", "refunded_amount", "INTEGER NOT NULL DEFAULT 0"},
+	{"licenses", "stripe_subscription_id", "TEXT"},
+	{"licenses", "dispute_status", "TEXT"},
+	{"licenses", "dispute_updated_at", "DATETIME"},
+	{"licenses", "max_activations", "INTEGER NOT NULL DEFAULT 0"},
+	{"dunnings", "ended_at", "DATETIME"},
+}
+
+func (s *SQLiteStorage) addColumn(ctx context.Context, table, column, definition string) error {
+	exists, err := s.hasColumn(ctx, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (s *SQLiteStorage) dropColumn(ctx context.Context, table, column string) error {
+	exists, err := s.hasColumn(ctx, table, column)
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column))
 	return err
 }
 
+func (s *SQLiteStorage) hasColumn(ctx context.Context, table, column string) (bool, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
 func (s *SQLiteStorage) GetCustomer(ctx context.Context, id string) (*models.Customer, error) {
 	query := `SELECT id, email, name, country, stripe_customer_id, created_at, updated_at FROM customers WHERE id = ?`
 
@@ -330,7 +1325,7 @@ func (s *SQLiteStorage) GetCustomer(ctx context.Context, id string) (*models.Cus
 }
 
 func (s *SQLiteStorage) FindCustomerByEmailAddress(ctx context.Context, emailAddress string) (*models.Customer, error) {
-	query := `SELECT id, email, name, country, stripe_customer_id, created_at, updated_at FROM customers WHERE email = ?`
+	query := `SELECT id, email, name, country, stripe_customer_id, created_at, updated_at FROM customers WHERE email = ? COLLATE NOCASE`
 
 	var customer models.Customer
 	var name, country, stripeCustomerID sql.NullString
@@ -380,14 +1375,19 @@ func (s *SQLiteStorage) SaveCustomer(ctx context.Context, customer *models.Custo
 	return nil
 }
 
-func (s *SQLiteStorage) GetLicense(ctx context.Context, id string) (*models.License, error) {
-	query := `SELECT id, key, customer_id, product_id, product_name, price_paid, currency, version, status, stripe_session_id, created_at, updated_at FROM licenses WHERE id = ?`
+const licenseColumns = `id, key, customer_id, product_id, product_name, price_paid, currency, version, status, status_reason, status_changed_at, stripe_session_id, stripe_payment_intent_id, stripe_subscription_id, refunded_amount, dispute_status, dispute_updated_at, trial_device_id, entitlements, seats, max_activations, team_license_id, seat_email, upgraded_from_id, upgraded_to_id, upgrade_session_id, expires_at, expired_at, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
+func scanLicense(row rowScanner) (*models.License, error) {
 	var license models.License
-	var productName, currency sql.NullString
+	var productName, currency, statusReason, paymentIntentID, subscriptionID, trialDeviceID, entitlements, teamLicenseID, seatEmail, upgradedFromID, upgradedToID, upgradeSessionID, disputeStatus sql.NullString
 	var pricePaid sql.NullInt64
+	var statusChangedAt, expiresAt, expiredAt, disputeUpdatedAt sql.NullTime
 
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
+	err := row.Scan(
 		&license.ID,
 		&license.Key,
 		&license.CustomerID,
@@ -397,53 +1397,84 @@ func (s *SQLiteStorage) GetLicense(ctx context.Context, id string) (*models.Lice
 		&currency,
 		&license.Version,
 		&license.Status,
+		&statusReason,
+		&statusChangedAt,
 		&license.StripeSessionID,
+		&paymentIntentID,
+		&subscriptionID,
+		&license.RefundedAmount,
+		&disputeStatus,
+		&disputeUpdatedAt,
+		&trialDeviceID,
+		&entitlements,
+		&license.Seats,
+		&license.MaxActivations,
+		&teamLicenseID,
+		&seatEmail,
+		&upgradedFromID,
+		&upgradedToID,
+		&upgradeSessionID,
+		&expiresAt,
+		&expiredAt,
 		&license.CreatedAt,
 		&license.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
 
 	// Handle nullable fields
 	license.ProductName = productName.String
 	license.Currency = currency.String
 	license.PricePaid = pricePaid.Int64
-
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
+	license.ExpiresAt = nullTimePtr(expiresAt)
+	license.ExpiredAt = nullTimePtr(expiredAt)
+	license.StatusReason = statusReason.String
+	license.StatusChangedAt = nullTimePtr(statusChangedAt)
+	license.StripePaymentIntentID = paymentIntentID.String
+	license.StripeSubscriptionID = subscriptionID.String
+	license.TrialDeviceID = trialDeviceID.String
+	license.TeamLicenseID = teamLicenseID.String
+	license.SeatEmail = seatEmail.String
+	license.UpgradedFromID = upgradedFromID.String
+	license.UpgradedToID = upgradedToID.String
+	license.UpgradeSessionID = upgradeSessionID.String
+	license.DisputeStatus = disputeStatus.String
+	license.DisputeUpdatedAt = nullTimePtr(disputeUpdatedAt)
+
+	if entitlements.Valid {
+		if err := json.Unmarshal([]byte(entitlements.String), &license.Entitlements); err != nil {
+			return nil, fmt.Errorf("failed to decode entitlements: %w", err)
+		}
 	}
 
 	return &license, nil
 }
 
-func (s *SQLiteStorage) FindLicenseByKey(ctx context.Context, key string) (*models.License, error) {
-	query := `SELECT id, key, customer_id, product_id, product_name, price_paid, currency, version, status, stripe_session_id, created_at, updated_at FROM licenses WHERE key = ?`
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
 
-	var license models.License
-	var productName, currency sql.NullString
-	var pricePaid sql.NullInt64
+// isUniqueViolation reports whether err is a UNIQUE constraint failure on
+// column, given as "table.column".
+func isUniqueViolation(err error, column string) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique &&
+		strings.Contains(sqliteErr.Error(), column)
+}
 
-	err := s.db.QueryRowContext(ctx, query, key).Scan(
-		&license.ID,
-		&license.Key,
-		&license.CustomerID,
-		&license.ProductID,
-		&productName,
-		&pricePaid,
-		&currency,
-		&license.Version,
-		&license.Status,
-		&license.StripeSessionID,
-		&license.CreatedAt,
-		&license.UpdatedAt,
-	)
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
 
-	// Handle nullable fields
-	license.ProductName = productName.String
-	license.Currency = currency.String
-	license.PricePaid = pricePaid.Int64
+func (s *SQLiteStorage) findLicense(ctx context.Context, where string, args ...interface{}) (*models.License, error) {
+	query := `SELECT ` + licenseColumns + ` FROM licenses WHERE ` + where
 
+	license, err := scanLicense(s.db.QueryRowContext(ctx, query, args...))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -451,13 +1482,13 @@ func (s *SQLiteStorage) FindLicenseByKey(ctx context.Context, key string) (*mode
 		return nil, err
 	}
 
-	return &license, nil
+	return license, nil
 }
 
-func (s *SQLiteStorage) FindLicensesByCustomer(ctx context.Context, customerID string) ([]*models.License, error) {
-	query := `SELECT id, key, customer_id, product_id, product_name, price_paid, currency, version, status, stripe_session_id, created_at, updated_at FROM licenses WHERE customer_id = ?`
+func (s *SQLiteStorage) findLicenses(ctx context.Context, where string, args ...interface{}) ([]*models.License, error) {
+	query := `SELECT ` + licenseColumns + ` FROM licenses WHERE ` + where
 
-	rows, err := s.db.QueryContext(ctx, query, customerID)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query licenses: %w", err)
 	}
@@ -470,35 +1501,12 @@ func (s *SQLiteStorage) FindLicensesByCustomer(ctx context.Context, customerID s
 	var licenses []*models.License
 
 	for rows.Next() {
-		var license models.License
-		var productName, currency sql.NullString
-		var pricePaid sql.NullInt64
-
-		err := rows.Scan(
-			&license.ID,
-			&license.Key,
-			&license.CustomerID,
-			&license.ProductID,
-			&productName,
-			&pricePaid,
-			&currency,
-			&license.Version,
-			&license.Status,
-			&license.StripeSessionID,
-			&license.CreatedAt,
-			&license.UpdatedAt,
-		)
-
-		// Handle nullable fields
-		license.ProductName = productName.String
-		license.Currency = currency.String
-		license.PricePaid = pricePaid.Int64
-
+		license, err := scanLicense(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan license: %w", err)
 		}
 
-		licenses = append(licenses, &license)
+		licenses = append(licenses, license)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -508,32 +1516,892 @@ func (s *SQLiteStorage) FindLicensesByCustomer(ctx context.Context, customerID s
 	return licenses, nil
 }
 
+func (s *SQLiteStorage) GetLicense(ctx context.Context, id string) (*models.License, error) {
+	return s.findLicense(ctx, `id = ?`, id)
+}
+
+func (s *SQLiteStorage) FindLicenseByKey(ctx context.Context, key string) (*models.License, error) {
+	return s.findLicense(ctx, `key = ?`, key)
+}
+
+func (s *SQLiteStorage) FindLicensesByCustomer(ctx context.Context, customerID string) ([]*models.License, error) {
+	return s.findLicenses(ctx, `customer_id = ?`, customerID)
+}
+
+func (s *SQLiteStorage) FindLicensesByKeys(ctx context.Context, keys []string) ([]*models.License, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+	return s.findLicenses(ctx, `key IN (`+placeholders+`)`, args...)
+}
+
+func (s *SQLiteStorage) FindExpiredLicenses(ctx context.Context, now time.Time) ([]*models.License, error) {
+	// SQLite compares DATETIME values as text, which breaks across time
+	// zones, so the expiry itself is checked in Go.
+	licenses, err := s.findLicenses(ctx, `status IN (?, ?) AND expires_at IS NOT NULL`, models.StatusActive, models.StatusTrial)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []*models.License
+	for _, license := range licenses {
+		if license.IsExpired(now) {
+			expired = append(expired, license)
+		}
+	}
+
+	return expired, nil
+}
+
 func (s *SQLiteStorage) SaveLicense(ctx context.Context, license *models.License) error {
-	query := `INSERT OR REPLACE INTO licenses (id, key, version, status, customer_id, product_id, product_name, price_paid, currency, stripe_session_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	return s.saveLicense(ctx, license, 0)
+}
 
-	_, err := s.db.ExecContext(ctx, query,
+func (s *SQLiteStorage) CreateSeat(ctx context.Context, seat *models.License, limit int) error {
+	if limit <= 0 {
+		return ErrSeatLimitReached
+	}
+	return s.saveLicense(ctx, seat, limit)
+}
+
+// saveLicense upserts license. With a seatLimit it only inserts while the
+// license's team has fewer seats assigned, in the same statement.
+func (s *SQLiteStorage) saveLicense(ctx context.Context, license *models.License, seatLimit int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var entitlements sql.NullString
+	if len(license.Entitlements) > 0 {
+		data, err := json.Marshal(license.Entitlements)
+		if err != nil {
+			return fmt.Errorf("failed to encode entitlements: %w", err)
+		}
+		entitlements = nullString(string(data))
+	}
+
+	var previousStatus sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT status FROM licenses WHERE id = ?`, license.ID).Scan(&previousStatus)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get license status: %w", err)
+	}
+
+	// Upsert on id only: OR REPLACE would also resolve a key collision by
+	// deleting the other license
+	query := `INSERT INTO licenses (id, key, version, status, status_reason, status_changed_at, customer_id, product_id, product_name, price_paid, currency, stripe_session_id, stripe_payment_intent_id, stripe_subscription_id, refunded_amount, dispute_status, dispute_updated_at, trial_device_id, entitlements, seats, max_activations, team_license_id, seat_email, upgraded_from_id, upgraded_to_id, upgrade_session_id, expires_at, expired_at, created_at, updated_at) SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE ? = 0 OR (SELECT COUNT(*) FROM licenses WHERE team_license_id = ? AND status != 'revoked') < ?
+		ON CONFLICT (id) DO UPDATE SET key = excluded.key, version = excluded.version, status = excluded.status, status_reason = excluded.status_reason, status_changed_at = excluded.status_changed_at, customer_id = excluded.customer_id, product_id = excluded.product_id, product_name = excluded.product_name, price_paid = excluded.price_paid, currency = excluded.currency, stripe_session_id = excluded.stripe_session_id, stripe_payment_intent_id = excluded.stripe_payment_intent_id, stripe_subscription_id = excluded.stripe_subscription_id, refunded_amount = excluded.refunded_amount, dispute_status = excluded.dispute_status, dispute_updated_at = excluded.dispute_updated_at, trial_device_id = excluded.trial_device_id, entitlements = excluded.entitlements, seats = excluded.seats, max_activations = excluded.max_activations, team_license_id = excluded.team_license_id, seat_email = excluded.seat_email, upgraded_from_id = excluded.upgraded_from_id, upgraded_to_id = excluded.upgraded_to_id, upgrade_session_id = excluded.upgrade_session_id, expires_at = excluded.expires_at, expired_at = excluded.expired_at, created_at = excluded.created_at, updated_at = excluded.updated_at`
+
+	result, err := tx.ExecContext(ctx, query,
 		license.ID,
 		license.Key,
 		license.Version,
 		license.Status,
+		nullString(license.StatusReason),
+		license.StatusChangedAt,
 		license.CustomerID,
 		license.ProductID,
 		license.ProductName,
 		license.PricePaid,
 		license.Currency,
 		license.StripeSessionID,
+		nullString(license.StripePaymentIntentID),
+		nullString(license.StripeSubscriptionID),
+		license.RefundedAmount,
+		nullString(license.DisputeStatus),
+		license.DisputeUpdatedAt,
+		nullString(license.TrialDeviceID),
+		entitlements,
+		license.Seats,
+		license.MaxActivations,
+		nullString(license.TeamLicenseID),
+		nullString(license.SeatEmail),
+		nullString(license.UpgradedFromID),
+		nullString(license.UpgradedToID),
+		nullString(license.UpgradeSessionID),
+		license.ExpiresAt,
+		license.ExpiredAt,
 		license.CreatedAt,
 		license.UpdatedAt,
+		seatLimit,
+		license.TeamLicenseID,
+		seatLimit,
 	)
 
+	if isUniqueViolation(err, "licenses.key") {
+		return ErrDuplicateLicenseKey
+	}
+	if isUniqueViolation(err, "licenses.stripe_session_id") {
+		return ErrDuplicateStripeSession
+	}
+	if isUniqueViolation(err, "licenses.stripe_subscription_id") {
+		return ErrDuplicateStripeSubscription
+	}
 	if err != nil {
 		return fmt.Errorf("failed to save customer: %w", err)
 	}
+	if seatLimit > 0 {
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to check seat insert: %w", err)
+		}
+		if inserted == 0 {
+			return ErrSeatLimitReached
+		}
+	}
 
+	var seats []models.License
+	if license.IsTeam() && previousStatus.String != license.Status {
+		seats, err = findSeatStatuses(ctx, tx, license.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, revocation := range statusRevocations(previousStatus.String, license, seats) {
+		if err := insertRevocation(ctx, tx, revocation); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) UpdateLicenseStatus(ctx context.Context, license *models.License, from string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// IS also matches a perpetual license's NULL expiry
+	result, err := tx.ExecContext(ctx, `UPDATE licenses SET status = ?, status_reason = ?, status_changed_at = ?, expired_at = ?, updated_at = ?
+		WHERE id = ? AND status = ? AND expires_at IS ?`,
+		license.Status,
+		nullString(license.StatusReason),
+		license.StatusChangedAt,
+		license.ExpiredAt,
+		license.UpdatedAt,
+		license.ID,
+		from,
+		license.ExpiresAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update license status: %w", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check license status update: %w", err)
+	}
+	if updated == 0 {
+		return false, nil
+	}
+
+	var seats []models.License
+	if license.IsTeam() && from != license.Status {
+		seats, err = findSeatStatuses(ctx, tx, license.ID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for _, revocation := range statusRevocations(from, license, seats) {
+		if err := insertRevocation(ctx, tx, revocation); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit license status update: %w", err)
+	}
+	return true, nil
+}
+
+// findSeatStatuses returns the ID, key and status of each seat of a team.
+func findSeatStatuses(ctx context.Context, tx *sql.Tx, teamLicenseID string) ([]models.License, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, status FROM licenses WHERE team_license_id = ?`, teamLicenseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query seats: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}()
+
+	var seats []models.License
+	for rows.Next() {
+		var seat models.License
+		if err := rows.Scan(&seat.ID, &seat.Status); err != nil {
+			return nil, fmt.Errorf("failed to scan seat: %w", err)
+		}
+		seats = append(seats, seat)
+	}
+
+	return seats, rows.Err()
+}
+
+func insertRevocation(ctx context.Context, tx *sql.Tx, revocation models.Revocation) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO license_revocations (license_id, status, created_at) VALUES (?, ?, ?)`,
+		revocation.LicenseID,
+		revocation.Status,
+		revocation.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save revocation: %w", err)
+	}
 	return nil
+}
 
+func (s *SQLiteStorage) FindTrialByDevice(ctx context.Context, deviceID string) (*models.License, error) {
+	return s.findLicense(ctx, `trial_device_id = ?`, deviceID)
+}
+
+func (s *SQLiteStorage) FindLicenseByStripeSession(ctx context.Context, sessionID string) (*models.License, error) {
+	return s.findLicense(ctx, `stripe_session_id = ?`, sessionID)
+}
+
+func (s *SQLiteStorage) FindLicenseByPaymentIntent(ctx context.Context, paymentIntentID string) (*models.License, error) {
+	return s.findLicense(ctx, `stripe_payment_intent_id = ?`, paymentIntentID)
+}
+
+func (s *SQLiteStorage) FindLicenseBySubscription(ctx context.Context, subscriptionID string) (*models.License, error) {
+	return s.findLicense(ctx, `stripe_subscription_id = ?`, subscriptionID)
+}
+
+func (s *SQLiteStorage) FindSeats(ctx context.Context, teamLicenseID string) ([]*models.License, error) {
+	return s.findLicenses(ctx, `team_license_id = ? ORDER BY created_at, id`, teamLicenseID)
+}
+
+func (s *SQLiteStorage) FindActivation(ctx context.Context, licenseID, deviceID string) (*models.Activation, error) {
+	query := `SELECT id, license_id, device_id, device_name, created_at, updated_at FROM activations WHERE license_id = ? AND device_id = ?`
+
+	var activation models.Activation
+	var deviceName sql.NullString
+
+	err := s.db.QueryRowContext(ctx, query, licenseID, deviceID).Scan(
+		&activation.ID,
+		&activation.LicenseID,
+		&activation.DeviceID,
+		&deviceName,
+		&activation.CreatedAt,
+		&activation.UpdatedAt,
+	)
+
+	// Handle nullable fields
+	activation.DeviceName = deviceName.String
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &activation, nil
+}
+
+func (s *SQLiteStorage) FindActivationsByLicense(ctx context.Context, licenseID string) ([]*models.Activation, error) {
+	query := `SELECT id, license_id, device_id, device_name, created_at, updated_at FROM activations WHERE license_id = ?`
+
+	rows, err := s.db.QueryContext(ctx, query, licenseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query activations: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}()
+
+	var activations []*models.Activation
+
+	for rows.Next() {
+		var activation models.Activation
+		var deviceName sql.NullString
+
+		err := rows.Scan(
+			&activation.ID,
+			&activation.LicenseID,
+			&activation.DeviceID,
+			&deviceName,
+			&activation.CreatedAt,
+			&activation.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan activation: %w", err)
+		}
+
+		// Handle nullable fields
+		activation.DeviceName = deviceName.String
+
+		activations = append(activations, &activation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating activations: %w", err)
+	}
+
+	return activations, nil
+}
+
+func (s *SQLiteStorage) SaveActivation(ctx context.Context, activation *models.Activation) error {
+	query := `INSERT OR REPLACE INTO activations (id, license_id, device_id, device_name, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := s.db.ExecContext(ctx, query,
+		activation.ID,
+		activation.LicenseID,
+		activation.DeviceID,
+		activation.DeviceName,
+		activation.CreatedAt,
+		activation.UpdatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to save activation: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) CreateActivation(ctx context.Context, activation *models.Activation, limit int) error {
+	// A device activated concurrently just has its name and time refreshed
+	query := `INSERT INTO activations (id, license_id, device_id, device_name, created_at, updated_at) SELECT ?, ?, ?, ?, ?, ?
+		WHERE ? < 0 OR (SELECT COUNT(*) FROM activations WHERE license_id = ?) < ?
+		ON CONFLICT (license_id, device_id) DO UPDATE SET device_name = excluded.device_name, updated_at = excluded.updated_at`
+
+	result, err := s.db.ExecContext(ctx, query,
+		activation.ID,
+		activation.LicenseID,
+		activation.DeviceID,
+		activation.DeviceName,
+		activation.CreatedAt,
+		activation.UpdatedAt,
+		limit,
+		activation.LicenseID,
+		limit,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create activation: %w", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check activation insert: %w", err)
+	}
+	if inserted == 0 {
+		return ErrActivationLimitReached
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteActivation(ctx context.Context, licenseID, deviceID string) error {
+	query := `DELETE FROM activations WHERE license_id = ? AND device_id = ?`
+
+	_, err := s.db.ExecContext(ctx, query, licenseID, deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to delete activation: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) FindLease(ctx context.Context, licenseID, deviceID string) (*models.Lease, error) {
+	query := `SELECT id, license_id, device_id, issued_at, expires_at, created_at, updated_at FROM leases WHERE license_id = ? AND device_id = ?`
+
+	var lease models.Lease
+
+	err := s.db.QueryRowContext(ctx, query, licenseID, deviceID).Scan(
+		&lease.ID,
+		&lease.LicenseID,
+		&lease.DeviceID,
+		&lease.IssuedAt,
+		&lease.ExpiresAt,
+		&lease.CreatedAt,
+		&lease.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &lease, nil
+}
+
+func (s *SQLiteStorage) FindLeasesByLicense(ctx context.Context, licenseID string) ([]*models.Lease, error) {
+	query := `SELECT id, license_id, device_id, issued_at, expires_at, created_at, updated_at FROM leases WHERE license_id = ?`
+
+	rows, err := s.db.QueryContext(ctx, query, licenseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query leases: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}()
+
+	var leases []*models.Lease
+
+	for rows.Next() {
+		var lease models.Lease
+
+		err := rows.Scan(
+			&lease.ID,
+			&lease.LicenseID,
+			&lease.DeviceID,
+			&lease.IssuedAt,
+			&lease.ExpiresAt,
+			&lease.CreatedAt,
+			&lease.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan lease: %w", err)
+		}
+
+		leases = append(leases, &lease)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating leases: %w", err)
+	}
+
+	return leases, nil
+}
+
+func (s *SQLiteStorage) SaveLease(ctx context.Context, lease *models.Lease) error {
+	query := `INSERT OR REPLACE INTO leases (id, license_id, device_id, issued_at, expires_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := s.db.ExecContext(ctx, query,
+		lease.ID,
+		lease.LicenseID,
+		lease.DeviceID,
+		lease.IssuedAt,
+		lease.ExpiresAt,
+		lease.CreatedAt,
+		lease.UpdatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to save lease: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) FindRevocationsSince(ctx context.Context, sequence int64, limit int) ([]*models.Revocation, error) {
+	query := `SELECT sequence, license_id, status, created_at FROM license_revocations WHERE sequence > ? ORDER BY sequence LIMIT ?`
+
+	rows, err := s.db.QueryContext(ctx, query, sequence, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revocations: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}()
+
+	var revocations []*models.Revocation
+
+	for rows.Next() {
+		var revocation models.Revocation
+
+		err := rows.Scan(
+			&revocation.Sequence,
+			&revocation.LicenseID,
+			&revocation.Status,
+			&revocation.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan revocation: %w", err)
+		}
+
+		revocations = append(revocations, &revocation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating revocations: %w", err)
+	}
+
+	return revocations, nil
+}
+
+// RecordUsage applies a batch of usage deltas in one transaction so a flush
+// costs a single commit however many licenses it covers.
+func (s *SQLiteStorage) RecordUsage(ctx context.Context, usage []*models.LicenseUsage) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO license_usage (license_id, validation_count, last_validated_at, last_app_version, last_country) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (license_id) DO UPDATE SET
+			validation_count = validation_count + excluded.validation_count,
+			last_app_version = CASE WHEN excluded.last_validated_at >= last_validated_at THEN COALESCE(excluded.last_app_version, last_app_version) ELSE last_app_version END,
+			last_country = CASE WHEN excluded.last_validated_at >= last_validated_at THEN COALESCE(excluded.last_country, last_country) ELSE last_country END,
+			last_validated_at = MAX(last_validated_at, excluded.last_validated_at)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare usage statement: %w", err)
+	}
+	defer func() { _ = stmt.Close() }()
+
+	for _, delta := range usage {
+		_, err := stmt.ExecContext(ctx,
+			delta.LicenseID,
+			delta.ValidationCount,
+			delta.LastValidatedAt.UTC(),
+			nullString(delta.LastAppVersion),
+			nullString(delta.LastCountry),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record usage: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) GetLicenseUsage(ctx context.Context, licenseID string) (*models.LicenseUsage, error) {
+	query := `SELECT license_id, validation_count, last_validated_at, last_app_version, last_country FROM license_usage WHERE license_id = ?`
+
+	var usage models.LicenseUsage
+	var appVersion, country sql.NullString
+
+	err := s.db.QueryRowContext(ctx, query, licenseID).Scan(
+		&usage.LicenseID,
+		&usage.ValidationCount,
+		&usage.LastValidatedAt,
+		&appVersion,
+		&country,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	usage.LastAppVersion = appVersion.String
+	usage.LastCountry = country.String
+
+	return &usage, nil
+}
+
+func (s *SQLiteStorage) GetStripeEvent(ctx context.Context, id string) (*models.StripeEvent, error) {
+	query := `SELECT id, type, status, attempts, error, received_at, processed_at, updated_at FROM stripe_events WHERE id = ?`
+
+	var event models.StripeEvent
+	var eventError sql.NullString
+	var processedAt sql.NullTime
+
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&event.ID,
+		&event.Type,
+		&event.Status,
+		&event.Attempts,
+		&eventError,
+		&event.ReceivedAt,
+		&processedAt,
+		&event.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	event.Error = eventError.String
+	event.ProcessedAt = nullTimePtr(processedAt)
+
+	return &event, nil
+}
+
+func (s *SQLiteStorage) SaveStripeEvent(ctx context.Context, event *models.StripeEvent) error {
+	query := `INSERT INTO stripe_events (id, type, status, attempts, error, received_at, processed_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET type = excluded.type, status = excluded.status, attempts = excluded.attempts, error = excluded.error, received_at = excluded.received_at, processed_at = excluded.processed_at, updated_at = excluded.updated_at`
+
+	_, err := s.db.ExecContext(ctx, query,
+		event.ID,
+		event.Type,
+		event.Status,
+		event.Attempts,
+		nullString(event.Error),
+		event.ReceivedAt,
+		event.ProcessedAt,
+		event.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save stripe event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) ClaimStripeEvent(ctx context.Context, event *models.StripeEvent, staleBefore time.Time) (bool, error) {
+	// Claim and check in one statement so two deliveries cannot both see
+	// the event as free. julianday compares the instants, whatever zone
+	// they were written in
+	query := `INSERT INTO stripe_events (id, type, status, attempts, error, received_at, updated_at) VALUES (?, ?, ?, 1, NULL, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, attempts = stripe_events.attempts + 1, error = NULL, updated_at = excluded.updated_at
+		WHERE stripe_events.status != ? AND (stripe_events.status != ? OR julianday(stripe_events.updated_at) < julianday(?))`
+
+	result, err := s.db.ExecContext(ctx, query,
+		event.ID,
+		event.Type,
+		models.StripeEventProcessing,
+		event.ReceivedAt,
+		event.UpdatedAt,
+		models.StripeEventProcessed,
+		models.StripeEventProcessing,
+		staleBefore,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim stripe event: %w", err)
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check stripe event claim: %w", err)
+	}
+	if claimed == 0 {
+		return false, nil
+	}
+
+	record, err := s.GetStripeEvent(ctx, event.ID)
+	if err != nil {
+		return false, err
+	}
+	*event = *record
+
+	return true, nil
+}
+
+const dunningColumns = `subscription_id, license_id, invoice_id, email, payment_url, reminders_sent, next_reminder_at, grace_ends_at, ended_at, created_at, updated_at`
+
+func scanDunning(row rowScanner) (*models.Dunning, error) {
+	var dunning models.Dunning
+	var paymentURL sql.NullString
+	var nextReminderAt sql.NullTime
+	var endedAt sql.NullTime
+
+	err := row.Scan(
+		&dunning.SubscriptionID,
+		&dunning.LicenseID,
+		&dunning.InvoiceID,
+		&dunning.Email,
+		&paymentURL,
+		&dunning.RemindersSent,
+		&nextReminderAt,
+		&dunning.GraceEndsAt,
+		&endedAt,
+		&dunning.CreatedAt,
+		&dunning.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	dunning.PaymentURL = paymentURL.String
+	dunning.NextReminderAt = nullTimePtr(nextReminderAt)
+	dunning.EndedAt = nullTimePtr(endedAt)
+
+	return &dunning, nil
+}
+
+func (s *SQLiteStorage) GetDunning(ctx context.Context, subscriptionID string) (*models.Dunning, error) {
+	query := `SELECT ` + dunningColumns + ` FROM dunnings WHERE subscription_id = ?`
+
+	dunning, err := scanDunning(s.db.QueryRowContext(ctx, query, subscriptionID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return dunning, nil
+}
+
+func (s *SQLiteStorage) FindDueDunnings(ctx context.Context, now time.Time) ([]*models.Dunning, error) {
+	// julianday compares the instants, whatever zone they were written in,
+	// where comparing the DATETIME text would not
+	query := `SELECT ` + dunningColumns + ` FROM dunnings
+		WHERE ended_at IS NULL AND (julianday(grace_ends_at) <= julianday(?)
+		OR (next_reminder_at IS NOT NULL AND julianday(next_reminder_at) <= julianday(?)))`
+
+	rows, err := s.db.QueryContext(ctx, query, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dunnings: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}()
+
+	var dunnings []*models.Dunning
+
+	for rows.Next() {
+		dunning, err := scanDunning(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dunning: %w", err)
+		}
+
+		dunnings = append(dunnings, dunning)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dunnings: %w", err)
+	}
+
+	return dunnings, nil
+}
+
+func (s *SQLiteStorage) SaveDunning(ctx context.Context, dunning *models.Dunning) error {
+	query := `INSERT INTO dunnings (` + dunningColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (subscription_id) DO UPDATE SET license_id = excluded.license_id, invoice_id = excluded.invoice_id, email = excluded.email, payment_url = excluded.payment_url, reminders_sent = excluded.reminders_sent, next_reminder_at = excluded.next_reminder_at, grace_ends_at = excluded.grace_ends_at, ended_at = excluded.ended_at, created_at = excluded.created_at, updated_at = excluded.updated_at`
+
+	_, err := s.db.ExecContext(ctx, query,
+		dunning.SubscriptionID,
+		dunning.LicenseID,
+		dunning.InvoiceID,
+		dunning.Email,
+		nullString(dunning.PaymentURL),
+		dunning.RemindersSent,
+		dunning.NextReminderAt,
+		dunning.GraceEndsAt,
+		dunning.EndedAt,
+		dunning.CreatedAt,
+		dunning.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save dunning: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteDunning(ctx context.Context, subscriptionID string) error {
+	query := `DELETE FROM dunnings WHERE subscription_id = ?`
+
+	_, err := s.db.ExecContext(ctx, query, subscriptionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete dunning: %w", err)
+	}
+
+	return nil
 }
 
 func (s *SQLiteStorage) Close() error {
 	return s.db.Close()
 }
+
+// expirableStatus reports whether licenses in status are moved to expired
+// once they pass their expiry.
+func expirableStatus(status string) bool {
+	return status == models.StatusActive || status == models.StatusTrial
+}
+
+// statusUnchanged reports whether stored is still in status from with the
+// expiry license was read with.
+func statusUnchanged(stored models.License, license *models.License, from string) bool {
+	if stored.Status != from {
+		return false
+	}
+	if stored.ExpiresAt == nil || license.ExpiresAt == nil {
+		return stored.ExpiresAt == nil && license.ExpiresAt == nil
+	}
+	return stored.ExpiresAt.Equal(*license.ExpiresAt)
+}
+
+// copyStatus copies the fields UpdateLicenseStatus writes from license.
+func copyStatus(stored *models.License, license *models.License) {
+	stored.Status = license.Status
+	stored.StatusReason = license.StatusReason
+	stored.StatusChangedAt = license.StatusChangedAt
+	stored.ExpiredAt = license.ExpiredAt
+	stored.UpdatedAt = license.UpdatedAt
+}
+
+// statusRevocations returns the revocation list entries for saving license
+// over a license with previousStatus, or none when the change doesn't revoke
+// or reinstate it. Only a return to active reinstates a key, so a suspended
+// license that expires stays listed. A team license takes its seats, given
+// as seats, along with it.
+func statusRevocations(previousStatus string, license *models.License, seats []models.License) []models.Revocation {
+	if previousStatus == license.Status {
+		return nil
+	}
+
+	reinstated := models.IsRevokedStatus(previousStatus) && license.Status == models.StatusActive
+	if !models.IsRevokedStatus(license.Status) && !reinstated {
+		return nil
+	}
+
+	now := time.Now()
+	revocations := []models.Revocation{{
+		LicenseID: license.ID,
+		Status:    license.Status,
+		CreatedAt: now,
+	}}
+
+	// Seats revoked in their own right, like reclaimed ones, are already
+	// listed and stay that way
+	for _, seat := range seats {
+		if models.IsRevokedStatus(seat.Status) {
+			continue
+		}
+		revocations = append(revocations, models.Revocation{
+			LicenseID: seat.ID,
+			Status:    license.Status,
+			CreatedAt: now,
+		})
+	}
+
+	return revocations
+}
+
+func revocationsSince(all []models.Revocation, sequence int64, limit int) []*models.Revocation {
+	var revocations []*models.Revocation
+	for _, revocation := range all {
+		if revocation.Sequence <= sequence {
+			continue
+		}
+		if len(revocations) == limit {
+			break
+		}
+		revocationCopy := revocation
+		revocations = append(revocations, &revocationCopy)
+	}
+
+	return revocations
+}
+
+// sortSeats orders seats by assignment time, matching the SQLite query.
+func sortSeats(seats []*models.License) {
+	sort.Slice(seats, func(i, j int) bool {
+		if !seats[i].CreatedAt.Equal(seats[j].CreatedAt) {
+			return seats[i].CreatedAt.Before(seats[j].CreatedAt)
+		}
+		return seats[i].ID < seats[j].ID
+	})
+}
@@ -2,8 +2,13 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -30,7 +35,7 @@ func createTestLicense(id, key, customerID string) models.License {
 		ProductID:       "prod_test",
 		Version:         "1.0.0",
 		Status:          models.StatusActive,
-		StripeSessionID: "cs_test",
+		StripeSessionID: "cs_" + id,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
 	}
@@ -84,6 +89,11 @@ func TestMemoryStorage_CustomerOperations(t *testing.T) {
 		t.Errorf("Expected ID 'test1', got '%s'", customer.ID)
 	}
 
+	customer, err = storage.FindCustomerByEmailAddress(ctx, "Test@Example.com")
+	if err != nil || customer == nil || customer.ID != "test1" {
+		t.Errorf("Expected case-insensitive match, got %v (%v)", customer, err)
+	}
+
 	// Test FindCustomerByEmailAddress - not found
 	customer, err = storage.FindCustomerByEmailAddress(ctx, "notfound@example.com")
 	if err != nil {
@@ -378,6 +388,12 @@ func TestSQLiteStorage_CustomerOperations(t *testing.T) {
 		t.Errorf("Expected ID 'sqlite1', got '%s'", customer.ID)
 	}
 
+	// Addresses match however they are capitalised
+	customer, err = storage.FindCustomerByEmailAddress(ctx, "SQLite@Example.com")
+	if err != nil || customer == nil || customer.ID != "sqlite1" {
+		t.Errorf("Expected case-insensitive match, got %v (%v)", customer, err)
+	}
+
 	// Test not found
 	customer, err = storage.GetCustomer(ctx, "notfound")
 	if err != nil {
@@ -459,221 +475,1301 @@ func TestSQLiteStorage_LicenseOperations(t *testing.T) {
 	}
 }
 
-func TestSQLiteStorage_InvalidPath(t *testing.T) {
-	// Test with invalid path
-	_, err := NewSQLiteStorage("/invalid/path/test.db")
-	if err == nil {
-		t.Errorf("Expected error for invalid path, got nil")
+func TestSQLiteStorage_ActivationOperations(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "activations.db")
+
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
 	}
+	defer func() { _ = storage.Close() }()
+
+	runActivationOperations(t, storage)
 }
 
-// Integration tests for all storage types
-func TestAllStorageTypes_Compatibility(t *testing.T) {
-	storageTypes := []struct {
-		name    string
-		storage Storage
-	}{
-		{
-			name: "MemoryStorage",
-			storage: &MemoryStorage{
-				Data:     make(Database),
-				Licenses: make(map[string]models.License),
-			},
-		},
+func TestMemoryStorage_ActivationOperations(t *testing.T) {
+	storage := &MemoryStorage{
+		Data:     make(Database),
+		Licenses: make(map[string]models.License),
 	}
 
-	// Add FileStorage
-	tempDir := t.TempDir()
-	fileStorage, err := NewFileStorage(filepath.Join(tempDir, "file_compat.json"))
-	if err == nil {
-		storageTypes = append(storageTypes, struct {
-			name    string
-			storage Storage
-		}{
-			name:    "FileStorage",
-			storage: fileStorage,
-		})
+	runActivationOperations(t, storage)
+}
+
+func runActivationOperations(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	testCustomer := createTestCustomer("customer1", "test@example.com")
+	if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+		t.Fatalf("Failed to save customer: %v", err)
 	}
 
-	// Add SQLiteStorage
-	sqliteStorage, err := NewSQLiteStorage(filepath.Join(tempDir, "sqlite_compat.db"))
-	if err == nil {
-		storageTypes = append(storageTypes, struct {
-			name    string
-			storage Storage
-		}{
-			name:    "SQLiteStorage",
-			storage: sqliteStorage,
-		})
+	testLicense := createTestLicense("license1", "AFP-ACTIVATE", "customer1")
+	if err := storage.SaveLicense(ctx, &testLicense); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
 	}
 
-	// Run same tests on all storage types
-	for _, st := range storageTypes {
-		t.Run(st.name, func(t *testing.T) {
-			defer func() { _ = st.storage.Close() }()
-			ctx := context.Background()
+	for _, deviceID := range []string{"device-1", "device-2"} {
+		activation := models.Activation{
+			ID:         "activation-" + deviceID,
+			LicenseID:  "license1",
+			DeviceID:   deviceID,
+			DeviceName: "Mac " + deviceID,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		if err := storage.SaveActivation(ctx, &activation); err != nil {
+			t.Errorf("Expected no error saving activation, got %v", err)
+		}
+	}
 
-			// Test customer operations
-			customer := createTestCustomer("compat1", "compat@example.com")
-			err := st.storage.SaveCustomer(ctx, &customer)
-			if err != nil {
-				t.Errorf("%s: Failed to save customer: %v", st.name, err)
-			}
+	activation, err := storage.FindActivation(ctx, "license1", "device-1")
+	if err != nil {
+		t.Errorf("Expected no error finding activation, got %v", err)
+	}
+	if activation == nil {
+		t.Fatalf("Expected activation, got nil")
+	}
+	if activation.DeviceName != "Mac device-1" {
+		t.Errorf("Expected device name 'Mac device-1', got '%s'", activation.DeviceName)
+	}
 
-			retrievedCustomer, err := st.storage.GetCustomer(ctx, "compat1")
-			if err != nil {
-				t.Errorf("%s: Failed to get customer: %v", st.name, err)
-			}
-			if retrievedCustomer == nil {
-				t.Errorf("%s: Expected customer, got nil", st.name)
-			}
+	activations, err := storage.FindActivationsByLicense(ctx, "license1")
+	if err != nil {
+		t.Errorf("Expected no error finding activations, got %v", err)
+	}
+	if len(activations) != 2 {
+		t.Errorf("Expected 2 activations, got %d", len(activations))
+	}
 
-			// Test license operations
-			license := createTestLicense("compat_license", "AFP-COMPAT123", "compat1")
-			err = st.storage.SaveLicense(ctx, &license)
-			if err != nil {
-				t.Errorf("%s: Failed to save license: %v", st.name, err)
-			}
+	if err := storage.DeleteActivation(ctx, "license1", "device-1"); err != nil {
+		t.Errorf("Expected no error deleting activation, got %v", err)
+	}
 
-			retrievedLicense, err := st.storage.FindLicenseByKey(ctx, "AFP-COMPAT123")
-			if err != nil {
-				t.Errorf("%s: Failed to find license: %v", st.name, err)
-			}
-			if retrievedLicense == nil {
-				t.Errorf("%s: Expected license, got nil", st.name)
-			}
-		})
+	activation, err = storage.FindActivation(ctx, "license1", "device-1")
+	if err != nil {
+		t.Errorf("Expected no error for not found, got %v", err)
+	}
+	if activation != nil {
+		t.Errorf("Expected nil activation after delete, got %v", activation)
 	}
 }
 
-// Performance tests
-func BenchmarkMemoryStorage_SaveCustomer(b *testing.B) {
-	storage := &MemoryStorage{
-		Data:     make(Database),
-		Licenses: make(map[string]models.License),
-	}
-	ctx := context.Background()
+func TestSQLiteStorage_LeaseOperations(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "leases.db")
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		customer := createTestCustomer("bench", "bench@example.com")
-		_ = storage.SaveCustomer(ctx, &customer)
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
 	}
+	defer func() { _ = storage.Close() }()
+
+	runLeaseOperations(t, storage)
 }
 
-func BenchmarkMemoryStorage_GetCustomer(b *testing.B) {
+func TestMemoryStorage_LeaseOperations(t *testing.T) {
 	storage := &MemoryStorage{
 		Data:     make(Database),
 		Licenses: make(map[string]models.License),
 	}
+
+	runLeaseOperations(t, storage)
+}
+
+func runLeaseOperations(t *testing.T, storage Storage) {
 	ctx := context.Background()
 
-	// Setup
-	customer := createTestCustomer("bench", "bench@example.com")
-	_ = storage.SaveCustomer(ctx, &customer)
+	testCustomer := createTestCustomer("customer1", "test@example.com")
+	if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+		t.Fatalf("Failed to save customer: %v", err)
+	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, _ = storage.GetCustomer(ctx, "bench")
+	testLicense := createTestLicense("license1", "AFP-LEASE", "customer1")
+	if err := storage.SaveLicense(ctx, &testLicense); err != nil {
+		t.Fatalf("Failed to save license: %v", err)
 	}
-}
 
-func BenchmarkMemoryStorage_FindLicenseByKey(b *testing.B) {
-	storage := &MemoryStorage{
-		Data:     make(Database),
-		Licenses: make(map[string]models.License),
+	issuedAt := time.Now().Truncate(time.Second)
+	lease := models.Lease{
+		ID:        "lease1",
+		LicenseID: "license1",
+		DeviceID:  "device-1",
+		IssuedAt:  issuedAt,
+		ExpiresAt: issuedAt.Add(time.Hour),
+		CreatedAt: issuedAt,
+		UpdatedAt: issuedAt,
+	}
+	if err := storage.SaveLease(ctx, &lease); err != nil {
+		t.Fatalf("Expected no error saving lease, got %v", err)
 	}
-	ctx := context.Background()
 
-	// Setup
-	customer := createTestCustomer("bench", "bench@example.com")
-	_ = storage.SaveCustomer(ctx, &customer)
+	// Renewing overwrites the device's lease instead of adding another
+	lease.ExpiresAt = issuedAt.Add(2 * time.Hour)
+	if err := storage.SaveLease(ctx, &lease); err != nil {
+		t.Errorf("Expected no error renewing lease, got %v", err)
+	}
 
-	license := createTestLicense("bench_license", "AFP-BENCH123", "bench")
-	_ = storage.SaveLicense(ctx, &license)
+	found, err := storage.FindLease(ctx, "license1", "device-1")
+	if err != nil {
+		t.Errorf("Expected no error finding lease, got %v", err)
+	}
+	if found == nil {
+		t.Fatalf("Expected lease, got nil")
+	}
+	if !found.ExpiresAt.Equal(lease.ExpiresAt) {
+		t.Errorf("Expected renewed expiry %v, got %v", lease.ExpiresAt, found.ExpiresAt)
+	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, _ = storage.FindLicenseByKey(ctx, "AFP-BENCH123")
+	leases, err := storage.FindLeasesByLicense(ctx, "license1")
+	if err != nil {
+		t.Errorf("Expected no error finding leases, got %v", err)
+	}
+	if len(leases) != 1 {
+		t.Errorf("Expected 1 lease, got %d", len(leases))
+	}
+
+	found, err = storage.FindLease(ctx, "license1", "device-2")
+	if err != nil {
+		t.Errorf("Expected no error for not found, got %v", err)
+	}
+	if found != nil {
+		t.Errorf("Expected nil lease for unknown device, got %v", found)
 	}
 }
 
-func BenchmarkSQLiteStorage_SaveCustomer(b *testing.B) {
-	tempDir := b.TempDir()
-	storage, err := NewSQLiteStorage(filepath.Join(tempDir, "bench.db"))
+func TestSQLiteStorage_RevocationOperations(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "revocations.db")
+
+	storage, err := NewSQLiteStorage(dbPath)
 	if err != nil {
-		b.Fatalf("Failed to create SQLite storage: %v", err)
+		t.Fatalf("Failed to create SQLite storage: %v", err)
 	}
 	defer func() { _ = storage.Close() }()
 
+	runRevocationOperations(t, storage)
+}
+
+func TestSQLiteStorage_BackfillsRevocations(t *testing.T) {
 	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "backfill.db")
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		customer := createTestCustomer("bench", "bench@example.com")
-		_ = storage.SaveCustomer(ctx, &customer)
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
 	}
-}
 
-// Stress tests
-func TestMemoryStorage_ConcurrentAccess(t *testing.T) {
-	storage := &MemoryStorage{
-		Data:     make(Database),
-		Licenses: make(map[string]models.License),
+	testCustomer := createTestCustomer("customer1", "test@example.com")
+	if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+		t.Fatalf("Failed to save customer: %v", err)
+	}
+	for _, license := range []models.License{
+		createTestLicense("license1", "AFP-ACTIVE", "customer1"),
+		createTestLicense("license2", "AFP-SUSPENDED", "customer1"),
+	} {
+		if err := storage.SaveLicense(ctx, &license); err != nil {
+			t.Fatalf("Failed to save license: %v", err)
+		}
+	}
+
+	// Simulate a database from before the revocation list existed
+	if _, err := storage.db.ExecContext(ctx, "UPDATE licenses SET status = ? WHERE id = ?", models.StatusSuspended, "license2"); err != nil {
+		t.Fatalf("Failed to suspend license: %v", err)
+	}
+	if _, err := storage.db.ExecContext(ctx, "DROP TABLE license_revocations"); err != nil {
+		t.Fatalf("Failed to drop revocations: %v", err)
 	}
+	_ = storage.Close()
+
+	storage, err = NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen SQLite storage: %v", err)
+	}
+	defer func() { _ = storage.Close() }()
+
+	revocations, err := storage.FindRevocationsSince(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("Expected no error finding revocations, got %v", err)
+	}
+	if len(revocations) != 1 || revocations[0].LicenseID != "license2" || !revocations[0].Revoked() {
+		t.Errorf("Expected the suspended license backfilled, got %+v", revocations)
+	}
+}
+
+func TestSQLiteStorage_DropsRevocationKeyHashes(t *testing.T) {
 	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "key_hash.db")
 
-	// Create test customer
-	customer := createTestCustomer("concurrent", "concurrent@example.com")
-	_ = storage.SaveCustomer(ctx, &customer)
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
 
-	// Run concurrent reads
-	done := make(chan bool, 10)
-	for i := 0; i < 10; i++ {
-		go func() {
-			for j := 0; j < 100; j++ {
-				_, err := storage.GetCustomer(ctx, "concurrent")
-				if err != nil {
-					t.Errorf("Concurrent read failed: %v", err)
-				}
-			}
-			done <- true
-		}()
+	// Simulate a database whose revocations still carry key hashes
+	if _, err := storage.db.ExecContext(ctx, "ALTER TABLE license_revocations ADD COLUMN key_hash TEXT NOT NULL DEFAULT ''"); err != nil {
+		t.Fatalf("Failed to add key_hash: %v", err)
 	}
+	_ = storage.Close()
 
-	// Wait for all goroutines
-	for i := 0; i < 10; i++ {
-		<-done
+	storage, err = NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen SQLite storage: %v", err)
+	}
+	defer func() { _ = storage.Close() }()
+
+	if exists, err := storage.hasColumn(ctx, "license_revocations", "key_hash"); err != nil || exists {
+		t.Errorf("Expected key_hash to be dropped, got %v (%v)", exists, err)
 	}
+
+	runRevocationOperations(t, storage)
 }
 
-func TestMemoryStorage_LargeDataset(t *testing.T) {
+func TestMemoryStorage_RevocationOperations(t *testing.T) {
 	storage := &MemoryStorage{
 		Data:     make(Database),
 		Licenses: make(map[string]models.License),
 	}
+
+	runRevocationOperations(t, storage)
+}
+
+func runRevocationOperations(t *testing.T, storage Storage) {
 	ctx := context.Background()
 
-	// Create many customers and licenses
-	numCustomers := 1000
-	for i := 0; i < numCustomers; i++ {
-		customer := createTestCustomer(
-			time.Now().Format("20060102150405")+string(rune(i)),
-			time.Now().Format("20060102150405")+string(rune(i))+"@example.com",
-		)
-		err := storage.SaveCustomer(ctx, &customer)
-		if err != nil {
-			t.Errorf("Failed to save customer %d: %v", i, err)
+	testCustomer := createTestCustomer("customer1", "test@example.com")
+	if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+		t.Fatalf("Failed to save customer: %v", err)
+	}
+
+	testLicense := createTestLicense("license1", "AFP-REVOKE", "customer1")
+	for _, status := range []string{models.StatusActive, models.StatusActive, models.StatusSuspended, models.StatusActive, models.StatusRevoked} {
+		testLicense.Status = status
+		if err := storage.SaveLicense(ctx, &testLicense); err != nil {
+			t.Fatalf("Failed to save license: %v", err)
 		}
+	}
 
-		// Add licenses for each customer
-		for j := 0; j < 5; j++ {
-			license := createTestLicense(
-				time.Now().Format("20060102150405")+string(rune(i))+string(rune(j)),
-				"AFP-"+time.Now().Format("20060102150405")+string(rune(i))+string(rune(j)),
-				customer.ID,
-			)
-			err := storage.SaveLicense(ctx, &license)
+	revocations, err := storage.FindRevocationsSince(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("Expected no error finding revocations, got %v", err)
+	}
+
+	// Saves that keep the status or never touch a revoked status add nothing
+	wantStatuses := []string{models.StatusSuspended, models.StatusActive, models.StatusRevoked}
+	if len(revocations) != len(wantStatuses) {
+		t.Fatalf("Expected %d revocations, got %d", len(wantStatuses), len(revocations))
+	}
+
+	for i, revocation := range revocations {
+		if revocation.Status != wantStatuses[i] {
+			t.Errorf("Expected revocation %d status '%s', got '%s'", i, wantStatuses[i], revocation.Status)
+		}
+		if revocation.LicenseID != "license1" {
+			t.Errorf("Expected revocation of license1, got '%s'", revocation.LicenseID)
+		}
+		if i > 0 && revocation.Sequence <= revocations[i-1].Sequence {
+			t.Errorf("Expected increasing sequence, got %d after %d", revocation.Sequence, revocations[i-1].Sequence)
+		}
+	}
+
+	page, err := storage.FindRevocationsSince(ctx, revocations[0].Sequence, 1)
+	if err != nil {
+		t.Fatalf("Expected no error finding revocations, got %v", err)
+	}
+	if len(page) != 1 || page[0].Sequence != revocations[1].Sequence {
+		t.Errorf("Expected one entry after seq %d, got %v", revocations[0].Sequence, page)
+	}
+}
+
+func TestStorage_FindLicensesByKeys(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+
+			for i, key := range []string{"AFP-BATCH1", "AFP-BATCH2", "AFP-BATCH3"} {
+				license := createTestLicense(fmt.Sprintf("license%d", i), key, "customer1")
+				if err := storage.SaveLicense(ctx, &license); err != nil {
+					t.Fatalf("Failed to save license: %v", err)
+				}
+			}
+
+			licenses, err := storage.FindLicensesByKeys(ctx, []string{"AFP-BATCH1", "AFP-BATCH3", "AFP-MISSING"})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			found := map[string]bool{}
+			for _, license := range licenses {
+				found[license.Key] = true
+			}
+			if len(licenses) != 2 || !found["AFP-BATCH1"] || !found["AFP-BATCH3"] {
+				t.Errorf("Expected AFP-BATCH1 and AFP-BATCH3, got %v", found)
+			}
+
+			licenses, err = storage.FindLicensesByKeys(ctx, nil)
+			if err != nil || len(licenses) != 0 {
+				t.Errorf("Expected no licenses for no keys, got %d (%v)", len(licenses), err)
+			}
+		})
+	}
+}
+
+func TestStorage_Trials(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "trials.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+
+			paid := createTestLicense("paid", "AFP-PAID", "customer1")
+			if err := storage.SaveLicense(ctx, &paid); err != nil {
+				t.Fatalf("Failed to save license: %v", err)
+			}
+
+			past := time.Now().Add(-time.Hour)
+			trial := createTestLicense("trial", "AFP-TRIAL", "customer1")
+			trial.Status = models.StatusTrial
+			trial.StripeSessionID = ""
+			trial.TrialDeviceID = "device-trial"
+			trial.ExpiresAt = &past
+			if err := storage.SaveLicense(ctx, &trial); err != nil {
+				t.Fatalf("Failed to save trial: %v", err)
+			}
+
+			found, err := storage.FindTrialByDevice(ctx, "device-trial")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if found == nil || found.ID != "trial" || !found.IsTrial() {
+				t.Errorf("Expected trial for device-trial, got %+v", found)
+			}
+
+			found, err = storage.FindTrialByDevice(ctx, "device-other")
+			if err != nil || found != nil {
+				t.Errorf("Expected no trial for device-other, got %+v (%v)", found, err)
+			}
+
+			expired, err := storage.FindExpiredLicenses(ctx, time.Now())
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(expired) != 1 || expired[0].ID != "trial" {
+				t.Errorf("Expected the lapsed trial to be due for expiry, got %d licenses", len(expired))
+			}
+		})
+	}
+}
+
+func TestStorage_DuplicateLicenseKey(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "duplicate.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+
+			first := createTestLicense("license1", "AFP-DUPLICATE", "customer1")
+			if err := storage.SaveLicense(ctx, &first); err != nil {
+				t.Fatalf("Failed to save license: %v", err)
+			}
+
+			// Updating a license keeps its key without colliding with itself
+			changedAt := time.Now().Truncate(time.Second)
+			first.SetStatus(models.StatusSuspended, models.StatusReasonRefunded, changedAt)
+			first.UpgradedFromID = "license0"
+			first.UpgradedToID = "license3"
+			if err := storage.SaveLicense(ctx, &first); err != nil {
+				t.Errorf("Expected update to succeed, got %v", err)
+			}
+
+			second := createTestLicense("license2", "AFP-DUPLICATE", "customer1")
+			if err := storage.SaveLicense(ctx, &second); !errors.Is(err, ErrDuplicateLicenseKey) {
+				t.Errorf("Expected ErrDuplicateLicenseKey, got %v", err)
+			}
+
+			existing, err := storage.GetLicense(ctx, "license1")
+			if err != nil || existing == nil {
+				t.Fatalf("Expected original license to survive the collision, got %v (%v)", existing, err)
+			}
+
+			if existing.StatusReason != models.StatusReasonRefunded || existing.StatusChangedAt == nil || !existing.StatusChangedAt.Equal(changedAt) {
+				t.Errorf("Expected status reason and change time to round-trip, got %s at %v", existing.StatusReason, existing.StatusChangedAt)
+			}
+
+			if existing.UpgradedFromID != "license0" || existing.UpgradedToID != "license3" {
+				t.Errorf("Expected upgrade links to round-trip, got '%s'/'%s'", existing.UpgradedFromID, existing.UpgradedToID)
+			}
+		})
+	}
+}
+
+func TestStorage_Entitlements(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "entitlements.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+
+			license := createTestLicense("license1", "AFP-ENTITLED", "customer1")
+			license.Entitlements = []string{"advanced_stats", "slack_sync"}
+			if err := storage.SaveLicense(ctx, &license); err != nil {
+				t.Fatalf("Failed to save license: %v", err)
+			}
+
+			found, err := storage.FindLicenseByKey(ctx, "AFP-ENTITLED")
+			if err != nil || found == nil {
+				t.Fatalf("Expected license, got %v (%v)", found, err)
+			}
+			if len(found.Entitlements) != 2 || found.Entitlements[0] != "advanced_stats" || found.Entitlements[1] != "slack_sync" {
+				t.Errorf("Expected entitlements to round-trip, got %v", found.Entitlements)
+			}
+
+			license.Entitlements = nil
+			if err := storage.SaveLicense(ctx, &license); err != nil {
+				t.Fatalf("Failed to save license: %v", err)
+			}
+
+			found, _ = storage.GetLicense(ctx, "license1")
+			if found == nil || len(found.Entitlements) != 0 {
+				t.Errorf("Expected entitlements to be cleared, got %+v", found)
+			}
+		})
+	}
+}
+
+func TestStorage_FindSeats(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "seats.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+
+			team := createTestLicense("team", "AFP-TEAM", "customer1")
+			team.Seats = 5
+			if err := storage.SaveLicense(ctx, &team); err != nil {
+				t.Fatalf("Failed to save team license: %v", err)
+			}
+
+			for i, email := range []string{"first@example.com", "second@example.com"} {
+				seat := createTestLicense(fmt.Sprintf("seat%d", i), fmt.Sprintf("AFP-SEAT%d", i), "customer1")
+				seat.TeamLicenseID = "team"
+				seat.SeatEmail = email
+				seat.CreatedAt = time.Now().Add(time.Duration(i) * time.Minute)
+				if err := storage.SaveLicense(ctx, &seat); err != nil {
+					t.Fatalf("Failed to save seat: %v", err)
+				}
+			}
+
+			seats, err := storage.FindSeats(ctx, "team")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(seats) != 2 || seats[0].SeatEmail != "first@example.com" || seats[1].SeatEmail != "second@example.com" {
+				t.Fatalf("Expected both seats in assignment order, got %d", len(seats))
+			}
+			if !seats[0].IsSeat() {
+				t.Errorf("Expected seat to keep its team license ID")
+			}
+
+			found, _ := storage.GetLicense(ctx, "team")
+			if found == nil || found.Seats != 5 || !found.IsTeam() {
+				t.Errorf("Expected team license with 5 seats, got %+v", found)
+			}
+		})
+	}
+}
+
+func TestStorage_RecordUsage(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "usage.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+
+			license := createTestLicense("license1", "AFP-USAGE", "customer1")
+			if err := storage.SaveLicense(ctx, &license); err != nil {
+				t.Fatalf("Failed to save license: %v", err)
+			}
+
+			usage, err := storage.GetLicenseUsage(ctx, "license1")
+			if err != nil || usage != nil {
+				t.Fatalf("Expected no usage before any validation, got %+v (%v)", usage, err)
+			}
+
+			now := time.Now().Truncate(time.Second)
+			err = storage.RecordUsage(ctx, []*models.LicenseUsage{
+				{LicenseID: "license1", ValidationCount: 3, LastValidatedAt: now, LastAppVersion: "1.4.11", LastCountry: "NO"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to record usage: %v", err)
+			}
+
+			// A delayed, older batch adds to the count but doesn't roll back
+			// the last seen values
+			err = storage.RecordUsage(ctx, []*models.LicenseUsage{
+				{LicenseID: "license1", ValidationCount: 2, LastValidatedAt: now.Add(-time.Hour), LastAppVersion: "1.3.0", LastCountry: "DE"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to record usage: %v", err)
+			}
+
+			usage, err = storage.GetLicenseUsage(ctx, "license1")
+			if err != nil || usage == nil {
+				t.Fatalf("Expected usage, got %v (%v)", usage, err)
+			}
+			if usage.ValidationCount != 5 || usage.LastAppVersion != "1.4.11" || usage.LastCountry != "NO" || !usage.LastValidatedAt.Equal(now) {
+				t.Errorf("Expected 5 validations last seen at %v from 1.4.11 in NO, got %+v", now, usage)
+			}
+		})
+	}
+}
+
+func TestStorage_StripeEvents(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if event, err := storage.GetStripeEvent(ctx, "evt_1"); err != nil || event != nil {
+				t.Fatalf("Expected no event yet, got %v (%v)", event, err)
+			}
+
+			receivedAt := time.Now().Truncate(time.Second)
+			event := models.StripeEvent{
+				ID:         "evt_1",
+				Type:       "checkout.session.completed",
+				Status:     models.StripeEventFailed,
+				Attempts:   1,
+				Error:      "database is locked",
+				ReceivedAt: receivedAt,
+				UpdatedAt:  receivedAt,
+			}
+			if err := storage.SaveStripeEvent(ctx, &event); err != nil {
+				t.Fatalf("Failed to save event: %v", err)
+			}
+
+			processedAt := receivedAt.Add(time.Minute)
+			event.Status = models.StripeEventProcessed
+			event.Attempts = 2
+			event.Error = ""
+			event.ProcessedAt = &processedAt
+			event.UpdatedAt = processedAt
+			if err := storage.SaveStripeEvent(ctx, &event); err != nil {
+				t.Fatalf("Failed to update event: %v", err)
+			}
+
+			stored, err := storage.GetStripeEvent(ctx, "evt_1")
+			if err != nil || stored == nil {
+				t.Fatalf("Expected stored event, got %v (%v)", stored, err)
+			}
+			if stored.Status != models.StripeEventProcessed || stored.Attempts != 2 || stored.Error != "" {
+				t.Errorf("Expected processed event after 2 attempts, got %+v", stored)
+			}
+			if !stored.ReceivedAt.Equal(receivedAt) || stored.ProcessedAt == nil || !stored.ProcessedAt.Equal(processedAt) {
+				t.Errorf("Expected timestamps to round-trip, got %v/%v", stored.ReceivedAt, stored.ProcessedAt)
+			}
+		})
+	}
+}
+
+func TestStorage_ClaimStripeEvent(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "claims.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now().Truncate(time.Second)
+			staleBefore := now.Add(-5 * time.Minute)
+
+			claim := func(at time.Time) (*models.StripeEvent, bool) {
+				event := &models.StripeEvent{ID: "evt_1", Type: "invoice.paid", ReceivedAt: at, UpdatedAt: at}
+				claimed, err := storage.ClaimStripeEvent(ctx, event, staleBefore)
+				if err != nil {
+					t.Fatalf("Failed to claim event: %v", err)
+				}
+				return event, claimed
+			}
+
+			event, claimed := claim(now)
+			if !claimed || event.Status != models.StripeEventProcessing || event.Attempts != 1 {
+				t.Fatalf("Expected first delivery to claim the event, got %t/%+v", claimed, event)
+			}
+
+			if _, claimed := claim(now); claimed {
+				t.Errorf("Expected a fresh claim to hold off other deliveries")
+			}
+
+			// A claim older than staleBefore is assumed to have crashed, even
+			// when written in a zone whose local time reads later
+			event.UpdatedAt = staleBefore.Add(-time.Second).In(time.FixedZone("AEST", 10*60*60))
+			if err := storage.SaveStripeEvent(ctx, event); err != nil {
+				t.Fatalf("Failed to save event: %v", err)
+			}
+			event, claimed = claim(now)
+			if !claimed || event.Attempts != 2 || !event.ReceivedAt.Equal(now) {
+				t.Fatalf("Expected a stale claim to be taken over, got %t/%+v", claimed, event)
+			}
+
+			event.Status = models.StripeEventFailed
+			event.Error = "boom"
+			if err := storage.SaveStripeEvent(ctx, event); err != nil {
+				t.Fatalf("Failed to save event: %v", err)
+			}
+			event, claimed = claim(now)
+			if !claimed || event.Attempts != 3 || event.Error != "" {
+				t.Fatalf("Expected a failed event to be claimed again, got %t/%+v", claimed, event)
+			}
+
+			event.Status = models.StripeEventProcessed
+			event.UpdatedAt = staleBefore.Add(-time.Hour)
+			if err := storage.SaveStripeEvent(ctx, event); err != nil {
+				t.Fatalf("Failed to save event: %v", err)
+			}
+			if _, claimed := claim(now); claimed {
+				t.Errorf("Expected a processed event never to be claimed again")
+			}
+		})
+	}
+}
+
+func TestSQLiteStorage_ClaimStripeEventOnce(t *testing.T) {
+	storage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "race.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = storage.Close() }()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var claims atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			event := &models.StripeEvent{ID: "evt_race", Type: "invoice.paid", ReceivedAt: now, UpdatedAt: now}
+			claimed, err := storage.ClaimStripeEvent(ctx, event, now.Add(-5*time.Minute))
+			if err != nil {
+				t.Errorf("Failed to claim event: %v", err)
+			}
+			if claimed {
+				claims.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if claims.Load() != 1 {
+		t.Errorf("Expected exactly one delivery to claim the event, got %d", claims.Load())
+	}
+}
+
+func TestStorage_Dunnings(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "dunnings.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if dunning, err := storage.GetDunning(ctx, "sub_1"); err != nil || dunning != nil {
+				t.Fatalf("Expected no dunning yet, got %v (%v)", dunning, err)
+			}
+
+			start := time.Now().Truncate(time.Second)
+			reminderAt := start.Add(72 * time.Hour)
+			for _, dunning := range []models.Dunning{
+				{SubscriptionID: "sub_1", LicenseID: "license1", InvoiceID: "in_1", Email: "a@example.com", PaymentURL: "https://invoice.stripe.com/i/in_1", NextReminderAt: &reminderAt, GraceEndsAt: start.Add(336 * time.Hour), CreatedAt: start, UpdatedAt: start},
+				{SubscriptionID: "sub_2", LicenseID: "license2", InvoiceID: "in_2", Email: "b@example.com", GraceEndsAt: start.Add(24 * time.Hour), CreatedAt: start, UpdatedAt: start},
+			} {
+				if err := storage.SaveDunning(ctx, &dunning); err != nil {
+					t.Fatalf("Failed to save dunning: %v", err)
+				}
+			}
+
+			stored, err := storage.GetDunning(ctx, "sub_1")
+			if err != nil || stored == nil {
+				t.Fatalf("Expected stored dunning, got %v (%v)", stored, err)
+			}
+			if stored.PaymentURL != "https://invoice.stripe.com/i/in_1" || stored.NextReminderAt == nil || !stored.NextReminderAt.Equal(reminderAt) {
+				t.Errorf("Expected dunning to round-trip, got %+v", stored)
+			}
+
+			due, err := storage.FindDueDunnings(ctx, start.Add(48*time.Hour))
+			if err != nil || len(due) != 1 || due[0].SubscriptionID != "sub_2" {
+				t.Errorf("Expected only sub_2 past its grace period, got %v (%v)", due, err)
+			}
+
+			stored.RemindersSent = 1
+			stored.NextReminderAt = nil
+			if err := storage.SaveDunning(ctx, stored); err != nil {
+				t.Fatalf("Failed to update dunning: %v", err)
+			}
+			if err := storage.DeleteDunning(ctx, "sub_2"); err != nil {
+				t.Fatalf("Failed to delete dunning: %v", err)
+			}
+
+			due, err = storage.FindDueDunnings(ctx, start.Add(100*time.Hour))
+			if err != nil || len(due) != 0 {
+				t.Errorf("Expected nothing due once the reminder was sent, got %v (%v)", due, err)
+			}
+			if updated, _ := storage.GetDunning(ctx, "sub_1"); updated == nil || updated.RemindersSent != 1 || updated.NextReminderAt != nil {
+				t.Errorf("Expected updated dunning, got %+v", updated)
+			}
+
+			// Written in another zone, its local time reads later than now
+			graceEndsAt := start.Add(99 * time.Hour).In(time.FixedZone("AEST", 10*60*60))
+			zoned := models.Dunning{SubscriptionID: "sub_3", LicenseID: "license3", InvoiceID: "in_3", Email: "c@example.com", GraceEndsAt: graceEndsAt, CreatedAt: start, UpdatedAt: start}
+			if err := storage.SaveDunning(ctx, &zoned); err != nil {
+				t.Fatalf("Failed to save dunning: %v", err)
+			}
+			due, err = storage.FindDueDunnings(ctx, start.Add(100*time.Hour).UTC())
+			if err != nil || len(due) != 1 || due[0].SubscriptionID != "sub_3" {
+				t.Errorf("Expected sub_3 due regardless of its zone, got %v (%v)", due, err)
+			}
+
+			// An ended dunning is kept but never due again
+			endedAt := start.Add(100 * time.Hour)
+			zoned.EndedAt = &endedAt
+			if err := storage.SaveDunning(ctx, &zoned); err != nil {
+				t.Fatalf("Failed to save dunning: %v", err)
+			}
+			due, err = storage.FindDueDunnings(ctx, start.Add(200*time.Hour))
+			if err != nil || len(due) != 0 {
+				t.Errorf("Expected ended dunning not due, got %v (%v)", due, err)
+			}
+			if ended, _ := storage.GetDunning(ctx, "sub_3"); ended == nil || ended.EndedAt == nil || !ended.EndedAt.Equal(endedAt) {
+				t.Errorf("Expected ended dunning to round-trip, got %+v", ended)
+			}
+		})
+	}
+}
+
+func TestStorage_DuplicateStripeSession(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+
+			first := createTestLicense("license1", "AFP-SESSION1", "customer1")
+			first.StripeSessionID = "cs_shared"
+			first.StripePaymentIntentID = "pi_shared"
+			first.StripeSubscriptionID = "sub_shared"
+			first.RefundedAmount = 500
+			if err := storage.SaveLicense(ctx, &first); err != nil {
+				t.Fatalf("Failed to save license: %v", err)
+			}
+
+			second := createTestLicense("license2", "AFP-SESSION2", "customer1")
+			second.StripeSessionID = "cs_shared"
+			if err := storage.SaveLicense(ctx, &second); !errors.Is(err, ErrDuplicateStripeSession) {
+				t.Errorf("Expected ErrDuplicateStripeSession, got %v", err)
+			}
+
+			second.StripeSessionID = "cs_second"
+			second.StripeSubscriptionID = "sub_shared"
+			if err := storage.SaveLicense(ctx, &second); !errors.Is(err, ErrDuplicateStripeSubscription) {
+				t.Errorf("Expected ErrDuplicateStripeSubscription, got %v", err)
+			}
+
+			// Licenses without a checkout, like trials and seats, never collide
+			for _, id := range []string{"trial1", "trial2"} {
+				trial := createTestLicense(id, "AFP-"+id, "customer1")
+				trial.StripeSessionID = ""
+				if err := storage.SaveLicense(ctx, &trial); err != nil {
+					t.Errorf("Expected license without session to save, got %v", err)
+				}
+			}
+
+			found, err := storage.FindLicenseByStripeSession(ctx, "cs_shared")
+			if err != nil || found == nil || found.ID != "license1" {
+				t.Errorf("Expected license1 for cs_shared, got %v (%v)", found, err)
+			}
+
+			found, err = storage.FindLicenseByPaymentIntent(ctx, "pi_shared")
+			if err != nil || found == nil || found.ID != "license1" || found.RefundedAmount != 500 {
+				t.Errorf("Expected license1 with its refund for pi_shared, got %v (%v)", found, err)
+			}
+
+			found, err = storage.FindLicenseBySubscription(ctx, "sub_shared")
+			if err != nil || found == nil || found.ID != "license1" {
+				t.Errorf("Expected license1 for sub_shared, got %v (%v)", found, err)
+			}
+
+			// Licenses without a subscription must not match an empty ID
+			found, err = storage.FindLicenseBySubscription(ctx, "")
+			if err != nil || found != nil {
+				t.Errorf("Expected no license for an empty subscription ID, got %v (%v)", found, err)
+			}
+
+			found, err = storage.FindTrialByDevice(ctx, "")
+			if err != nil || found != nil {
+				t.Errorf("Expected no trial for an empty device ID, got %v (%v)", found, err)
+			}
+		})
+	}
+}
+
+func TestSQLiteStorage_ExpiredLicenses(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "expiry.db")
+
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = storage.Close() }()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	testCustomer := createTestCustomer("customer1", "test@example.com")
+	if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+		t.Fatalf("Failed to save customer: %v", err)
+	}
+
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	expired := createTestLicense("expired", "AFP-EXPIRED", "customer1")
+	expired.ExpiresAt = &past
+	running := createTestLicense("running", "AFP-RUNNING", "customer1")
+	running.ExpiresAt = &future
+	perpetual := createTestLicense("perpetual", "AFP-PERPETUAL", "customer1")
+
+	for _, license := range []models.License{expired, running, perpetual} {
+		if err := storage.SaveLicense(ctx, &license); err != nil {
+			t.Fatalf("Failed to save license %s: %v", license.ID, err)
+		}
+	}
+
+	licenses, err := storage.FindExpiredLicenses(ctx, now)
+	if err != nil {
+		t.Fatalf("Expected no error finding expired licenses, got %v", err)
+	}
+	if len(licenses) != 1 || licenses[0].ID != "expired" {
+		t.Fatalf("Expected only the expired license, got %v", licenses)
+	}
+	if licenses[0].ExpiresAt == nil || !licenses[0].ExpiresAt.Equal(past) {
+		t.Errorf("Expected ExpiresAt %v, got %v", past, licenses[0].ExpiresAt)
+	}
+
+	license, err := storage.GetLicense(ctx, "perpetual")
+	if err != nil {
+		t.Fatalf("Expected no error getting license, got %v", err)
+	}
+	if license.ExpiresAt != nil {
+		t.Errorf("Expected nil ExpiresAt for perpetual license, got %v", license.ExpiresAt)
+	}
+}
+
+func TestSQLiteStorage_MigratesExistingDatabase(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "legacy.db")
+
+	// Create the schema as it was before licenses could expire
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE licenses (
+          id TEXT PRIMARY KEY,
+          key TEXT UNIQUE NOT NULL,
+          customer_id TEXT NOT NULL,
+          product_id TEXT NOT NULL,
+          product_name TEXT,
+          price_paid INTEGER,
+          currency TEXT,
+          version TEXT NOT NULL,
+          status TEXT NOT NULL,
+          stripe_session_id TEXT NOT NULL,
+          created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+          updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+      )`)
+	if err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+	_ = db.Close()
+
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate legacy database: %v", err)
+	}
+	defer func() { _ = storage.Close() }()
+
+	ctx := context.Background()
+
+	testCustomer := createTestCustomer("customer1", "test@example.com")
+	if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+		t.Fatalf("Failed to save customer: %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	testLicense := createTestLicense("license1", "AFP-LEGACY", "customer1")
+	testLicense.ExpiresAt = &expiresAt
+	if err := storage.SaveLicense(ctx, &testLicense); err != nil {
+		t.Fatalf("Expected no error saving license, got %v", err)
+	}
+
+	license, err := storage.FindLicenseByKey(ctx, "AFP-LEGACY")
+	if err != nil {
+		t.Fatalf("Expected no error finding license, got %v", err)
+	}
+	if license == nil || license.ExpiresAt == nil {
+		t.Fatalf("Expected license with expiry after migration, got %v", license)
+	}
+
+	// Migrating an up-to-date database is a no-op
+	if err := storage.migrate(ctx); err != nil {
+		t.Errorf("Expected repeated migration to succeed, got %v", err)
+	}
+}
+
+func TestSQLiteStorage_InvalidPath(t *testing.T) {
+	// Test with invalid path
+	_, err := NewSQLiteStorage("/invalid/path/test.db")
+	if err == nil {
+		t.Errorf("Expected error for invalid path, got nil")
+	}
+}
+
+// Integration tests for all storage types
+func TestAllStorageTypes_Compatibility(t *testing.T) {
+	storageTypes := []struct {
+		name    string
+		storage Storage
+	}{
+		{
+			name: "MemoryStorage",
+			storage: &MemoryStorage{
+				Data:     make(Database),
+				Licenses: make(map[string]models.License),
+			},
+		},
+	}
+
+	// Add FileStorage
+	tempDir := t.TempDir()
+	fileStorage, err := NewFileStorage(filepath.Join(tempDir, "file_compat.json"))
+	if err == nil {
+		storageTypes = append(storageTypes, struct {
+			name    string
+			storage Storage
+		}{
+			name:    "FileStorage",
+			storage: fileStorage,
+		})
+	}
+
+	// Add SQLiteStorage
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(tempDir, "sqlite_compat.db"))
+	if err == nil {
+		storageTypes = append(storageTypes, struct {
+			name    string
+			storage Storage
+		}{
+			name:    "SQLiteStorage",
+			storage: sqliteStorage,
+		})
+	}
+
+	// Run same tests on all storage types
+	for _, st := range storageTypes {
+		t.Run(st.name, func(t *testing.T) {
+			defer func() { _ = st.storage.Close() }()
+			ctx := context.Background()
+
+			// Test customer operations
+			customer := createTestCustomer("compat1", "compat@example.com")
+			err := st.storage.SaveCustomer(ctx, &customer)
+			if err != nil {
+				t.Errorf("%s: Failed to save customer: %v", st.name, err)
+			}
+
+			retrievedCustomer, err := st.storage.GetCustomer(ctx, "compat1")
+			if err != nil {
+				t.Errorf("%s: Failed to get customer: %v", st.name, err)
+			}
+			if retrievedCustomer == nil {
+				t.Errorf("%s: Expected customer, got nil", st.name)
+			}
+
+			// Test license operations
+			license := createTestLicense("compat_license", "AFP-COMPAT123", "compat1")
+			err = st.storage.SaveLicense(ctx, &license)
+			if err != nil {
+				t.Errorf("%s: Failed to save license: %v", st.name, err)
+			}
+
+			retrievedLicense, err := st.storage.FindLicenseByKey(ctx, "AFP-COMPAT123")
+			if err != nil {
+				t.Errorf("%s: Failed to find license: %v", st.name, err)
+			}
+			if retrievedLicense == nil {
+				t.Errorf("%s: Expected license, got nil", st.name)
+			}
+		})
+	}
+}
+
+// Performance tests
+func BenchmarkMemoryStorage_SaveCustomer(b *testing.B) {
+	storage := &MemoryStorage{
+		Data:     make(Database),
+		Licenses: make(map[string]models.License),
+	}
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		customer := createTestCustomer("bench", "bench@example.com")
+		_ = storage.SaveCustomer(ctx, &customer)
+	}
+}
+
+func BenchmarkMemoryStorage_GetCustomer(b *testing.B) {
+	storage := &MemoryStorage{
+		Data:     make(Database),
+		Licenses: make(map[string]models.License),
+	}
+	ctx := context.Background()
+
+	// Setup
+	customer := createTestCustomer("bench", "bench@example.com")
+	_ = storage.SaveCustomer(ctx, &customer)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = storage.GetCustomer(ctx, "bench")
+	}
+}
+
+func BenchmarkMemoryStorage_FindLicenseByKey(b *testing.B) {
+	storage := &MemoryStorage{
+		Data:     make(Database),
+		Licenses: make(map[string]models.License),
+	}
+	ctx := context.Background()
+
+	// Setup
+	customer := createTestCustomer("bench", "bench@example.com")
+	_ = storage.SaveCustomer(ctx, &customer)
+
+	license := createTestLicense("bench_license", "AFP-BENCH123", "bench")
+	_ = storage.SaveLicense(ctx, &license)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = storage.FindLicenseByKey(ctx, "AFP-BENCH123")
+	}
+}
+
+func BenchmarkSQLiteStorage_SaveCustomer(b *testing.B) {
+	tempDir := b.TempDir()
+	storage, err := NewSQLiteStorage(filepath.Join(tempDir, "bench.db"))
+	if err != nil {
+		b.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = storage.Close() }()
+
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		customer := createTestCustomer("bench", "bench@example.com")
+		_ = storage.SaveCustomer(ctx, &customer)
+	}
+}
+
+// Stress tests
+func TestMemoryStorage_ConcurrentAccess(t *testing.T) {
+	storage := &MemoryStorage{
+		Data:     make(Database),
+		Licenses: make(map[string]models.License),
+	}
+	ctx := context.Background()
+
+	// Create test customer
+	customer := createTestCustomer("concurrent", "concurrent@example.com")
+	_ = storage.SaveCustomer(ctx, &customer)
+
+	// Run concurrent reads
+	done := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				_, err := storage.GetCustomer(ctx, "concurrent")
+				if err != nil {
+					t.Errorf("Concurrent read failed: %v", err)
+				}
+			}
+			done <- true
+		}()
+	}
+
+	// Wait for all goroutines
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+}
+
+func TestMemoryStorage_LargeDataset(t *testing.T) {
+	storage := &MemoryStorage{
+		Data:     make(Database),
+		Licenses: make(map[string]models.License),
+	}
+	ctx := context.Background()
+
+	// Create many customers and licenses
+	numCustomers := 1000
+	for i := 0; i < numCustomers; i++ {
+		customer := createTestCustomer(
+			time.Now().Format("20060102150405")+string(rune(i)),
+			time.Now().Format("20060102150405")+string(rune(i))+"@example.com",
+		)
+		err := storage.SaveCustomer(ctx, &customer)
+		if err != nil {
+			t.Errorf("Failed to save customer %d: %v", i, err)
+		}
+
+		// Add licenses for each customer
+		for j := 0; j < 5; j++ {
+			license := createTestLicense(
+				time.Now().Format("20060102150405")+string(rune(i))+string(rune(j)),
+				"AFP-"+time.Now().Format("20060102150405")+string(rune(i))+string(rune(j)),
+				customer.ID,
+			)
+			err := storage.SaveLicense(ctx, &license)
 			if err != nil {
 				t.Errorf("Failed to save license %d-%d: %v", i, j, err)
 			}
@@ -689,3 +1785,191 @@ func TestMemoryStorage_LargeDataset(t *testing.T) {
 		t.Errorf("Expected %d licenses, got %d", numCustomers*5, len(storage.Licenses))
 	}
 }
+
+func TestStorage_CreateSeat(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "seats.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+
+			team := createTestLicense("team1", "AFP-TEAM1", "customer1")
+			team.Seats = 2
+			if err := storage.SaveLicense(ctx, &team); err != nil {
+				t.Fatalf("Failed to save team license: %v", err)
+			}
+
+			for i, want := range []error{nil, nil, ErrSeatLimitReached} {
+				seat := createTestLicense(fmt.Sprintf("seat%d", i), fmt.Sprintf("AFP-SEAT%d", i), "customer1")
+				seat.TeamLicenseID = "team1"
+				if err := storage.CreateSeat(ctx, &seat, team.Seats); !errors.Is(err, want) {
+					t.Errorf("Expected %v for seat %d, got %v", want, i, err)
+				}
+			}
+
+			// A reclaimed seat frees its place
+			reclaimed, _ := storage.GetLicense(ctx, "seat0")
+			reclaimed.Status = models.StatusRevoked
+			if err := storage.SaveLicense(ctx, reclaimed); err != nil {
+				t.Fatalf("Failed to reclaim seat: %v", err)
+			}
+			seat := createTestLicense("seat3", "AFP-SEAT3", "customer1")
+			seat.TeamLicenseID = "team1"
+			if err := storage.CreateSeat(ctx, &seat, team.Seats); err != nil {
+				t.Errorf("Expected seat to fit after a reclaim, got %v", err)
+			}
+
+			seats, _ := storage.FindSeats(ctx, "team1")
+			if len(seats) != 3 {
+				t.Errorf("Expected 3 seats saved, got %d", len(seats))
+			}
+		})
+	}
+}
+
+func TestStorage_UpdateLicenseStatus(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "status.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+
+			expiresAt := time.Now().Add(-time.Hour)
+			testLicense := createTestLicense("license1", "AFP-STATUS", "customer1")
+			testLicense.ExpiresAt = &expiresAt
+			if err := storage.SaveLicense(ctx, &testLicense); err != nil {
+				t.Fatalf("Failed to save license: %v", err)
+			}
+
+			read, _ := storage.GetLicense(ctx, "license1")
+
+			// Renewed after the license was read
+			renewed := *read
+			renewedAt := time.Now().Add(24 * time.Hour)
+			renewed.ExpiresAt = &renewedAt
+			renewed.ProductName = "renewed"
+			if err := storage.SaveLicense(ctx, &renewed); err != nil {
+				t.Fatalf("Failed to save license: %v", err)
+			}
+
+			stale := *read
+			stale.SetStatus(models.StatusExpired, "", time.Now())
+			if updated, err := storage.UpdateLicenseStatus(ctx, &stale, models.StatusActive); err != nil || updated {
+				t.Errorf("Expected no update over a changed expiry, got %t, %v", updated, err)
+			}
+
+			current, _ := storage.GetLicense(ctx, "license1")
+			current.SetStatus(models.StatusSuspended, "key_sharing", time.Now())
+			if updated, err := storage.UpdateLicenseStatus(ctx, current, models.StatusActive); err != nil || !updated {
+				t.Fatalf("Expected update, got %t, %v", updated, err)
+			}
+
+			if updated, _ := storage.UpdateLicenseStatus(ctx, current, models.StatusActive); updated {
+				t.Errorf("Expected no update once the status changed")
+			}
+
+			stored, _ := storage.GetLicense(ctx, "license1")
+			if stored.Status != models.StatusSuspended || stored.StatusReason != "key_sharing" || stored.ProductName != "renewed" {
+				t.Errorf("Expected status written over the renewal, got %s/%s/%s", stored.Status, stored.StatusReason, stored.ProductName)
+			}
+
+			revocations, _ := storage.FindRevocationsSince(ctx, 0, 10)
+			if len(revocations) != 1 || revocations[0].Status != models.StatusSuspended {
+				t.Errorf("Expected the suspension listed, got %+v", revocations)
+			}
+		})
+	}
+}
+
+func TestStorage_CreateActivation(t *testing.T) {
+	sqliteStorage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "activations.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	defer func() { _ = sqliteStorage.Close() }()
+
+	storages := map[string]Storage{
+		"memory": &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)},
+		"sqlite": sqliteStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			testCustomer := createTestCustomer("customer1", "test@example.com")
+			if err := storage.SaveCustomer(ctx, &testCustomer); err != nil {
+				t.Fatalf("Failed to save customer: %v", err)
+			}
+			testLicense := createTestLicense("license1", "AFP-ACTIVATE", "customer1")
+			if err := storage.SaveLicense(ctx, &testLicense); err != nil {
+				t.Fatalf("Failed to save license: %v", err)
+			}
+
+			for i, deviceID := range []string{"device-1", "device-2", "device-3"} {
+				activation := &models.Activation{
+					ID:        "activation-" + deviceID,
+					LicenseID: "license1",
+					DeviceID:  deviceID,
+					CreatedAt: time.Now(),
+					UpdatedAt: time.Now(),
+				}
+
+				err := storage.CreateActivation(ctx, activation, 2)
+				if i < 2 && err != nil {
+					t.Errorf("Expected %s to be activated, got %v", deviceID, err)
+				}
+				if i == 2 && err != ErrActivationLimitReached {
+					t.Errorf("Expected ErrActivationLimitReached for %s, got %v", deviceID, err)
+				}
+			}
+
+			unlimited := &models.Activation{ID: "activation-unlimited", LicenseID: "license1", DeviceID: "device-4"}
+			if err := storage.CreateActivation(ctx, unlimited, -1); err != nil {
+				t.Errorf("Expected no limit for a negative limit, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStorage_FindLicenseByPaymentIntent_Empty(t *testing.T) {
+	storage := &MemoryStorage{Data: make(Database), Licenses: make(map[string]models.License)}
+	ctx := context.Background()
+
+	testCustomer := createTestCustomer("customer1", "test@example.com")
+	_ = storage.SaveCustomer(ctx, &testCustomer)
+	testLicense := createTestLicense("license1", "AFP-NOPAYMENT", "customer1")
+	_ = storage.SaveLicense(ctx, &testLicense)
+
+	if license, err := storage.FindLicenseByPaymentIntent(ctx, ""); err != nil || license != nil {
+		t.Errorf("Expected no license for an empty payment intent, got %v (%v)", license, err)
+	}
+}